@@ -1,20 +1,34 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/mailpost"
+	"github.com/Olegnemlii/test123/internal/repository"
 	"github.com/Olegnemlii/test123/internal/service"
 	"github.com/Olegnemlii/test123/internal/transport/grpc/handler"
 	"github.com/Olegnemlii/test123/internal/transport/grpc/server"
+	"github.com/Olegnemlii/test123/migrations"
 	"github.com/Olegnemlii/test123/pkg/db"
+	"github.com/Olegnemlii/test123/pkg/migrate"
+	"github.com/Olegnemlii/test123/pkg/password"
+	"github.com/Olegnemlii/test123/pkg/token"
 
 	"github.com/Olegnemlii/test123/internal/repository/postgres"
+	"github.com/Olegnemlii/test123/internal/repository/redis"
 
+	"github.com/google/uuid"
 	_ "github.com/lib/pq"
+	goredis "github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -32,6 +46,14 @@ func main() {
 	defer dbConnection.Close()
 	database := dbConnection.GetDB() // Use GetDB to get *sql.DB
 
+	// `migrate up|down [N]|status` subcommand
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(database, os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
 	// Run migrations
 	if err := runMigrations(database); err != nil {
 		log.Fatalf("failed to run migrations: %v", err)
@@ -40,32 +62,284 @@ func main() {
 	// Repository
 	userRepo := postgres.NewPostgresUserRepository(database)
 
+	// Redis keeps codes, refresh tokens and the access token denylist when configured
+	if cfg.RedisURL != "" {
+		redisOpts, err := goredis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("invalid REDIS_URL: %v", err)
+		}
+		redisClient := goredis.NewClient(redisOpts)
+		defer redisClient.Close()
+		if err := redisClient.Ping(context.Background()).Err(); err != nil {
+			log.Fatalf("failed to connect to redis: %v", err)
+		}
+
+		userRepo = redis.NewUserRepository(redisClient, userRepo)
+		if cfg.UserCacheTTL > 0 {
+			userRepo = redis.NewCachedUserRepository(redisClient, userRepo, cfg.UserCacheTTL)
+		}
+	}
+
+	// `admin grant|revoke EMAIL ROLE`, `admin roles` and `admin audit [N]` subcommands
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := adminCommand(service.NewRoleService(userRepo), userRepo, os.Args[2:]); err != nil {
+			log.Fatalf("admin: %v", err)
+		}
+		return
+	}
+
+	// Mail
+	mailer, err := mailpost.New(*cfg)
+	if err != nil {
+		log.Fatalf("failed to create mailer: %v", err)
+	}
+	outboxWorker := service.NewOutboxWorker(userRepo, mailer, *cfg)
+
+	// `outbox dead [N]|requeue ID...` subcommand
+	if len(os.Args) > 1 && os.Args[1] == "outbox" {
+		if err := outboxCommand(outboxWorker, os.Args[2:]); err != nil {
+			log.Fatalf("outbox: %v", err)
+		}
+		return
+	}
+
+	// Token issuer
+	keyPEM, err := os.ReadFile(cfg.JWTPrivateKeyPath)
+	if err != nil {
+		log.Fatalf("failed to read JWT private key: %v", err)
+	}
+	signingKey, err := token.ParsePrivateKey(keyPEM)
+	if err != nil {
+		log.Fatalf("failed to load JWT private key: %v", err)
+	}
+	tokenIssuer := service.NewTokenIssuer(signingKey, *cfg)
+
+	// Emails are queued in the outbox and delivered in the background
+	mailService, err := service.NewMailService(service.NewOutboxMailer(userRepo))
+	if err != nil {
+		log.Fatalf("failed to create mail service: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go outboxWorker.Run(ctx)
+
+	// Deleted accounts are purged once their grace period is over
+	go service.NewAccountPurger(userRepo, *cfg).Run(ctx)
+
+	// Passwords from known breaches are rejected when a list is configured
+	if cfg.BreachedPasswordsFile != "" {
+		breached, err := password.LoadBreachedList(cfg.BreachedPasswordsFile)
+		if err != nil {
+			log.Fatalf("failed to load breached passwords: %v", err)
+		}
+		cfg.PasswordPolicy.Breached = breached
+		log.Printf("loaded %d breached password hashes", breached.Len())
+	}
+
 	// Service
-	authService := service.NewUserService(userRepo)
+	authService := service.NewUserService(userRepo, tokenIssuer, mailService, *cfg)
+	roleService := service.NewRoleService(userRepo)
+	adminService := service.NewAdminService(authService, roleService, userRepo, mailService, *cfg)
 
 	// gRPC Handler
-	authHandler := handler.NewAuthHandler(authService)
+	authHandler := handler.NewAuthHandler(authService, mailService, *cfg)
+	orgHandler := handler.NewOrganizationHandler(authService)
+	adminHandler := handler.NewAdminHandler(adminService)
 
 	// Start gRPC server
-	if err := server.StartGRPCServer(cfg, authHandler); err != nil {
+	if err := server.StartGRPCServer(cfg, tokenIssuer.Verifier(), authHandler, orgHandler, adminHandler); err != nil {
 		log.Fatalf("failed to start gRPC server: %v", err)
 	}
 }
 
 func runMigrations(db *sql.DB) error {
-	// Read the migration file
-	migrationFile := "migrations/12312312_users.sql"
-	migrationSQL, err := ioutil.ReadFile(migrationFile)
+	migrator, err := migrate.New(db, migrations.FS)
 	if err != nil {
-		return fmt.Errorf("failed to read migration file %s: %w", migrationFile, err)
+		return err
 	}
 
-	// Execute the migration
-	_, err = db.Exec(string(migrationSQL))
-	if err != nil {
-		return fmt.Errorf("failed to execute migration: %w", err)
+	if err := migrator.Up(context.Background()); err != nil {
+		return err
 	}
 
 	log.Println("Migrations ran successfully")
 	return nil
 }
+
+func migrateCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [N]|status")
+	}
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %q", args[1])
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if st.Drifted {
+				state += " (modified since applied)"
+			}
+			fmt.Printf("%d_%s\t%s\n", st.Version, st.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q, expected up, down or status", args[0])
+	}
+}
+
+func outboxCommand(worker *service.OutboxWorker, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: outbox dead [N]|requeue ID...")
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "dead":
+		limit := 100
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid limit: %q", args[1])
+			}
+			limit = n
+		}
+		emails, err := worker.ListDeadLetters(ctx, limit)
+		if err != nil {
+			return err
+		}
+		for _, e := range emails {
+			fmt.Printf("%d\t%s\t%s\t%d attempts\t%s\n", e.ID, e.Recipient, e.Subject, e.Attempts, e.LastError.String)
+		}
+		return nil
+	case "requeue":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: outbox requeue ID...")
+		}
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid id: %q", arg)
+			}
+			ok, err := worker.RequeueDeadLetter(ctx, id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("no dead letter with id %d", id)
+			}
+			fmt.Printf("requeued %d\n", id)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q, expected dead or requeue", args[0])
+	}
+}
+
+func adminCommand(roles *service.RoleService, userRepo repository.UserRepository, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: admin grant|revoke EMAIL ROLE|roles|audit [N]")
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "grant", "revoke":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: admin %s EMAIL ROLE", args[0])
+		}
+		user, err := userRepo.GetUserByEmail(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		action := "AssignRole"
+		if args[0] == "grant" {
+			err = roles.AssignRole(ctx, user.ID, args[2])
+		} else {
+			action = "RevokeRole"
+			err = roles.RevokeRole(ctx, user.ID, args[2])
+		}
+		// Changes made here are audited like the Admin service calls
+		entry := &domain.AuditEntry{
+			ActorID:  domain.SystemActorID,
+			Action:   action,
+			TargetID: uuid.NullUUID{UUID: user.ID, Valid: true},
+			Details:  args[2],
+		}
+		if err != nil {
+			entry.Error = sql.NullString{String: err.Error(), Valid: true}
+		}
+		if auditErr := userRepo.AppendAuditEntry(ctx, entry); auditErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to write audit entry: %w", auditErr))
+		}
+		if err != nil {
+			return err
+		}
+		current, err := roles.UserRoles(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s has roles: %s\n", args[1], strings.Join(current, ", "))
+		return nil
+	case "roles":
+		list, err := roles.ListRoles(ctx)
+		if err != nil {
+			return err
+		}
+		for _, role := range list {
+			fmt.Printf("%s\t%s\t%s\n", role.Name, strings.Join(role.Permissions, " "), role.Description)
+		}
+		return nil
+	case "audit":
+		limit := 100
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid limit: %q", args[1])
+			}
+			limit = n
+		}
+		entries, err := userRepo.ListAuditEntries(ctx, limit)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			actor, target, result := e.ActorID.String(), "-", "ok"
+			if e.ActorID == domain.SystemActorID {
+				actor = "system"
+			}
+			if e.TargetID.Valid {
+				target = e.TargetID.UUID.String()
+			}
+			if e.Error.Valid {
+				result = e.Error.String
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", e.CreatedAt.Format("2006-01-02 15:04:05"), actor, e.Action, target, result, e.Details)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q, expected grant, revoke, roles or audit", args[0])
+	}
+}
@@ -1,19 +1,69 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Olegnemlii/test123/pkg/password"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Config stores the application configuration
 type Config struct {
-	Port            string
-	DatabaseURL     string
-	MailopostApiKey string
-	MailopostURL    string
+	Port              string
+	DatabaseURL       string
+	MailBackend       string
+	MailFrom          string
+	MailopostApiKey   string
+	MailopostURL      string
+	SMTPHost          string
+	SMTPPort          string
+	SMTPUsername      string
+	SMTPPassword      string
+	SMTPStartTLS      bool
+	MailDir           string
+	AppURL            string
+	JWTPrivateKeyPath string
+	JWTIssuer         string
+	JWTAudience       string
+	AccessTokenTTL    time.Duration
+	RefreshTokenTTL   time.Duration
+	// RedisURL enables the Redis store for codes and tokens when set
+	RedisURL string
+	// UserCacheTTL is how long users stay in the Redis cache; 0 disables the cache
+	UserCacheTTL time.Duration
+	// Email outbox worker settings
+	OutboxPollInterval time.Duration
+	OutboxMaxAttempts  int
+	// AccountDeletionGrace is how long a deleted account can be restored before it is purged
+	AccountDeletionGrace time.Duration
+	AccountPurgeInterval time.Duration
+	// TOTPEncryptionKey encrypts TOTP secrets at rest (AES-256)
+	TOTPEncryptionKey []byte
+	// TOTPIssuer is the name authenticator apps show next to the account
+	TOTPIssuer string
+	// WebAuthn relying party: passkeys are bound to WebAuthnRPID and accepted
+	// only from WebAuthnOrigins
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins []string
+	// PasswordHashAlgorithm hashes new passwords; stored hashes of the other
+	// algorithm or older parameters are upgraded on login
+	PasswordHashAlgorithm string
+	BcryptCost            int
+	Argon2id              password.Argon2id
+	// PasswordPolicy is checked for every new password. Its breached list
+	// is loaded from BreachedPasswordsFile at startup.
+	PasswordPolicy        password.Policy
+	BreachedPasswordsFile string
 }
 
 // LoadConfig loads the configuration from environment variables or .env file
@@ -33,20 +83,319 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("DATABASE_URL is not set")
 	}
 
-	mailopostApiKey := os.Getenv("MAILOPOST_API_KEY")
-	if mailopostApiKey == "" {
-		return nil, fmt.Errorf("MAILOPOST_API_KEY is not set")
+	mailBackend := os.Getenv("MAIL_BACKEND")
+	if mailBackend == "" {
+		mailBackend = "mailopost" // default mail backend
 	}
 
+	mailFrom := os.Getenv("MAIL_FROM")
+
+	mailopostApiKey := os.Getenv("MAILOPOST_API_KEY")
 	mailopostURL := os.Getenv("MAILOPOST_URL")
-	if mailopostURL == "" {
-		return nil, fmt.Errorf("MAILOPOST_URL is not set")
+
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpPort := os.Getenv("SMTP_PORT")
+	if smtpPort == "" {
+		smtpPort = "587" // default submission port
+	}
+	smtpUsername := os.Getenv("SMTP_USERNAME")
+	smtpPassword := os.Getenv("SMTP_PASSWORD")
+	smtpStartTLS, err := boolEnv("SMTP_STARTTLS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	mailDir := os.Getenv("MAIL_DIR")
+
+	switch mailBackend {
+	case "mailopost":
+		if mailopostApiKey == "" {
+			return nil, fmt.Errorf("MAILOPOST_API_KEY is not set")
+		}
+		if mailopostURL == "" {
+			return nil, fmt.Errorf("MAILOPOST_URL is not set")
+		}
+	case "smtp":
+		if smtpHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is not set")
+		}
+		if mailFrom == "" {
+			return nil, fmt.Errorf("MAIL_FROM is not set")
+		}
+	case "file":
+		if mailDir == "" {
+			return nil, fmt.Errorf("MAIL_DIR is not set")
+		}
+	default:
+		return nil, fmt.Errorf("MAIL_BACKEND must be one of mailopost, smtp, file, got %q", mailBackend)
+	}
+
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:3000" // default frontend URL used in emailed links
+	}
+
+	jwtPrivateKeyPath := os.Getenv("JWT_PRIVATE_KEY_PATH")
+	if jwtPrivateKeyPath == "" {
+		return nil, fmt.Errorf("JWT_PRIVATE_KEY_PATH is not set")
+	}
+
+	jwtIssuer := os.Getenv("JWT_ISSUER")
+	if jwtIssuer == "" {
+		jwtIssuer = "auth" // default issuer
+	}
+
+	jwtAudience := os.Getenv("JWT_AUDIENCE")
+	if jwtAudience == "" {
+		jwtAudience = "api" // default audience
+	}
+
+	accessTokenTTL, err := durationEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTokenTTL, err := durationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	redisURL := os.Getenv("REDIS_URL")
+
+	userCacheTTL, err := durationEnv("USER_CACHE_TTL", 5*time.Minute)
+	if err != nil {
+		return nil, err
 	}
 
+	outboxPollInterval, err := durationEnv("OUTBOX_POLL_INTERVAL", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	outboxMaxAttempts, err := intEnv("OUTBOX_MAX_ATTEMPTS", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	accountDeletionGrace, err := durationEnv("ACCOUNT_DELETION_GRACE", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	accountPurgeInterval, err := durationEnv("ACCOUNT_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	totpEncryptionKey, err := keyEnv("TOTP_ENCRYPTION_KEY", 32)
+	if err != nil {
+		return nil, err
+	}
+
+	totpIssuer := os.Getenv("TOTP_ISSUER")
+	if totpIssuer == "" {
+		totpIssuer = jwtIssuer // default to the service name used in tokens
+	}
+
+	webAuthnOrigins := listEnv("WEBAUTHN_ORIGINS")
+	if len(webAuthnOrigins) == 0 {
+		webAuthnOrigins = []string{strings.TrimRight(appURL, "/")} // passkeys are used from the frontend
+	}
+
+	webAuthnRPID := os.Getenv("WEBAUTHN_RP_ID")
+	if webAuthnRPID == "" {
+		u, err := url.Parse(appURL)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("WEBAUTHN_RP_ID is not set and cannot be derived from APP_URL %q", appURL)
+		}
+		webAuthnRPID = u.Hostname()
+	}
+
+	webAuthnRPName := os.Getenv("WEBAUTHN_RP_NAME")
+	if webAuthnRPName == "" {
+		webAuthnRPName = totpIssuer // the same name users see in authenticator apps
+	}
+
+	passwordHashAlgorithm := os.Getenv("PASSWORD_HASH_ALGORITHM")
+	if passwordHashAlgorithm == "" {
+		passwordHashAlgorithm = password.AlgorithmArgon2id // default password hashing algorithm
+	}
+	if passwordHashAlgorithm != password.AlgorithmArgon2id && passwordHashAlgorithm != password.AlgorithmBcrypt {
+		return nil, fmt.Errorf("PASSWORD_HASH_ALGORITHM must be one of argon2id, bcrypt, got %q", passwordHashAlgorithm)
+	}
+
+	bcryptCost, err := intEnv("BCRYPT_COST", bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("BCRYPT_COST must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, bcryptCost)
+	}
+
+	argon2id := password.DefaultArgon2id
+	argon2Memory, err := intEnv("ARGON2_MEMORY_KIB", int(argon2id.Memory))
+	if err != nil {
+		return nil, err
+	}
+	argon2Iterations, err := intEnv("ARGON2_ITERATIONS", int(argon2id.Iterations))
+	if err != nil {
+		return nil, err
+	}
+	argon2Parallelism, err := intEnv("ARGON2_PARALLELISM", int(argon2id.Parallelism))
+	if err != nil {
+		return nil, err
+	}
+	if argon2Iterations < 1 {
+		return nil, fmt.Errorf("ARGON2_ITERATIONS must be at least 1, got %d", argon2Iterations)
+	}
+	if argon2Parallelism < 1 || argon2Parallelism > 255 {
+		return nil, fmt.Errorf("ARGON2_PARALLELISM must be between 1 and 255, got %d", argon2Parallelism)
+	}
+	if argon2Memory < 8*argon2Parallelism {
+		return nil, fmt.Errorf("ARGON2_MEMORY_KIB must be at least 8 per thread, got %d", argon2Memory)
+	}
+	argon2id.Memory = uint32(argon2Memory)
+	argon2id.Iterations = uint32(argon2Iterations)
+	argon2id.Parallelism = uint8(argon2Parallelism)
+
+	passwordMinLength, err := intEnv("PASSWORD_MIN_LENGTH", 8)
+	if err != nil {
+		return nil, err
+	}
+	passwordMaxLength, err := intEnv("PASSWORD_MAX_LENGTH", password.BcryptMaxLength)
+	if err != nil {
+		return nil, err
+	}
+	if passwordMaxLength < passwordMinLength {
+		return nil, fmt.Errorf("PASSWORD_MAX_LENGTH must not be less than PASSWORD_MIN_LENGTH, got %d", passwordMaxLength)
+	}
+	if passwordHashAlgorithm == password.AlgorithmBcrypt && passwordMaxLength > password.BcryptMaxLength {
+		return nil, fmt.Errorf("PASSWORD_MAX_LENGTH must be at most %d bytes with bcrypt, got %d", password.BcryptMaxLength, passwordMaxLength)
+	}
+
+	passwordRequireClasses := listEnv("PASSWORD_REQUIRE_CLASSES")
+	for _, class := range passwordRequireClasses {
+		if !password.ValidClass(class) {
+			return nil, fmt.Errorf("PASSWORD_REQUIRE_CLASSES must list lower, upper, digit, symbol, got %q", class)
+		}
+	}
+
+	passwordRejectEmail, err := boolEnv("PASSWORD_REJECT_EMAIL", true)
+	if err != nil {
+		return nil, err
+	}
+
+	breachedPasswordsFile := os.Getenv("BREACHED_PASSWORDS_FILE")
+
 	return &Config{
-		Port:            port,
-		DatabaseURL:     databaseURL,
-		MailopostApiKey: mailopostApiKey,
-		MailopostURL:    mailopostURL,
+		Port:                  port,
+		DatabaseURL:           databaseURL,
+		MailBackend:           mailBackend,
+		MailFrom:              mailFrom,
+		MailopostApiKey:       mailopostApiKey,
+		MailopostURL:          mailopostURL,
+		SMTPHost:              smtpHost,
+		SMTPPort:              smtpPort,
+		SMTPUsername:          smtpUsername,
+		SMTPPassword:          smtpPassword,
+		SMTPStartTLS:          smtpStartTLS,
+		MailDir:               mailDir,
+		AppURL:                appURL,
+		JWTPrivateKeyPath:     jwtPrivateKeyPath,
+		JWTIssuer:             jwtIssuer,
+		JWTAudience:           jwtAudience,
+		AccessTokenTTL:        accessTokenTTL,
+		RefreshTokenTTL:       refreshTokenTTL,
+		RedisURL:              redisURL,
+		UserCacheTTL:          userCacheTTL,
+		OutboxPollInterval:    outboxPollInterval,
+		OutboxMaxAttempts:     outboxMaxAttempts,
+		AccountDeletionGrace:  accountDeletionGrace,
+		AccountPurgeInterval:  accountPurgeInterval,
+		TOTPEncryptionKey:     totpEncryptionKey,
+		TOTPIssuer:            totpIssuer,
+		WebAuthnRPID:          webAuthnRPID,
+		WebAuthnRPName:        webAuthnRPName,
+		WebAuthnOrigins:       webAuthnOrigins,
+		PasswordHashAlgorithm: passwordHashAlgorithm,
+		BcryptCost:            bcryptCost,
+		Argon2id:              argon2id,
+		PasswordPolicy: password.Policy{
+			MinLength:       passwordMinLength,
+			MaxLength:       passwordMaxLength,
+			RequiredClasses: passwordRequireClasses,
+			RejectEmail:     passwordRejectEmail,
+		},
+		BreachedPasswordsFile: breachedPasswordsFile,
 	}, nil
 }
+
+// keyEnv reads a required base64-encoded key of size bytes from the environment
+func keyEnv(name string, size int) ([]byte, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("%s is not set", name)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %w", name, err)
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("%s must be %d bytes, got %d", name, size, len(key))
+	}
+	return key, nil
+}
+
+// listEnv reads a comma-separated list from the environment, skipping empty items
+func listEnv(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// durationEnv reads a time.Duration from the environment, falling back to def when unset
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid duration: %w", name, err)
+	}
+	return d, nil
+}
+
+// boolEnv reads a boolean from the environment, falling back to def when unset
+func boolEnv(name string, def bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s is not a valid boolean: %w", name, err)
+	}
+	return b, nil
+}
+
+// intEnv reads an integer from the environment, falling back to def when unset
+func intEnv(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid integer: %w", name, err)
+	}
+	return n, nil
+}
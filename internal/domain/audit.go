@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SystemActorID is the actor of entries for changes made with the command-line
+// tools, which have no signed-in user
+var SystemActorID = uuid.Nil
+
+// AuditEntry records a call to the Admin service. Error is empty for calls
+// that succeeded. Entries outlive the users they mention.
+type AuditEntry struct {
+	ID        int64
+	ActorID   uuid.UUID
+	Action    string
+	TargetID  uuid.NullUUID
+	Details   string
+	Error     sql.NullString
+	CreatedAt time.Time
+}
@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors returned by repositories and services. The gRPC layer maps them to
+// status codes, so callers should wrap them with %w rather than replace them.
+var (
+	// ErrNotFound is returned by repositories when a record other than a user or session does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrUserNotFound is returned when a user does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrSessionNotFound is returned when a session does not exist or belongs to another user
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrEmailTaken is returned when the email belongs to another user
+	ErrEmailTaken = errors.New("email already taken")
+	// ErrInvalidCode is returned when a verification code is unknown, already used or does not match
+	ErrInvalidCode = errors.New("invalid verification code")
+	// ErrCodeExpired is returned when a verification code is past its expiry
+	ErrCodeExpired = errors.New("verification code expired")
+	// ErrInvalidCredentials is returned when an email/password pair does not match
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrInvalidRefreshToken is returned when the presented refresh token is unknown, expired or revoked
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrTokenRevoked is returned when an access token or its session was revoked
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrInvalidResetToken is returned when a password reset token is unknown, used or expired
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	// ErrRestoreExpired is returned when a deleted account is past its grace period
+	ErrRestoreExpired = errors.New("account can no longer be restored")
+	// ErrUserDisabled is returned when a disabled user tries to sign in
+	ErrUserDisabled = errors.New("user is disabled")
+	// ErrPermissionDenied is returned when the caller lacks the permission required for an operation
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrAlreadyConfirmed is returned when a verification email is requested for a confirmed user
+	ErrAlreadyConfirmed = errors.New("email already confirmed")
+	// ErrRoleNotFound is returned when a role does not exist
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrOrganizationNotFound is returned when an organization does not exist or the caller is not its member
+	ErrOrganizationNotFound = errors.New("organization not found")
+	// ErrAlreadyMember is returned when a user is added to an organization it already belongs to
+	ErrAlreadyMember = errors.New("already a member of the organization")
+	// ErrInvalidInvitation is returned when an invitation is unknown, accepted, expired or addressed to another email
+	ErrInvalidInvitation = errors.New("invalid invitation")
+	// ErrLastOwner is returned when the only owner of an organization would be removed
+	ErrLastOwner = errors.New("organization must keep an owner")
+	// ErrTOTPAlreadyEnabled is returned when a user with confirmed TOTP enrolls again
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	// ErrTOTPNotEnabled is returned when TOTP is confirmed or disabled before it was enrolled
+	ErrTOTPNotEnabled = errors.New("two-factor authentication not enabled")
+	// ErrInvalidMFACode is returned when a TOTP or recovery code does not match or was already used
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+	// ErrInvalidMFAChallenge is returned when a login challenge is unknown, completed, expired or out of attempts
+	ErrInvalidMFAChallenge = errors.New("invalid two-factor challenge")
+	// ErrInvalidPasskey is returned when a WebAuthn response fails verification
+	// or belongs to an unknown, used or expired ceremony
+	ErrInvalidPasskey = errors.New("invalid passkey")
+	// ErrPasskeyExists is returned when a credential ID is registered twice
+	ErrPasskeyExists = errors.New("passkey already registered")
+	// ErrPasskeyCloned is returned when the signature counter of a passkey goes
+	// backwards, which means the authenticator may have been cloned
+	ErrPasskeyCloned = errors.New("passkey signature counter went backwards")
+)
+
+// FieldViolation describes a single invalid request field
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// ValidationError collects invalid request fields
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.Field + ": " + v.Description
+	}
+	return "invalid argument: " + strings.Join(parts, "; ")
+}
+
+// Add records a violation of field
+func (e *ValidationError) Add(field, description string) {
+	e.Violations = append(e.Violations, FieldViolation{Field: field, Description: description})
+}
+
+// Require records a violation if value is empty
+func (e *ValidationError) Require(field, value string) {
+	if value == "" {
+		e.Add(field, "is required")
+	}
+}
+
+// Err returns e if it has violations and nil otherwise
+func (e *ValidationError) Err() error {
+	if len(e.Violations) == 0 {
+		return nil
+	}
+	return e
+}
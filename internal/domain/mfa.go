@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTPCredential is the authenticator app enrolled by a user. The secret is
+// stored encrypted and only takes part in login once it is confirmed.
+type TOTPCredential struct {
+	UserID          uuid.UUID
+	EncryptedSecret []byte
+	ConfirmedAt     sql.NullTime
+	// LastUsedStep is the time-step of the last accepted code; codes of this
+	// or an earlier step are rejected
+	LastUsedStep int64
+	// Attempts counts second-factor codes entered in a row at login and when
+	// managing TOTP; a correct code or a pause after LastAttemptAt resets it
+	Attempts      int
+	LastAttemptAt sql.NullTime
+	CreatedAt     time.Time
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the
+// authenticator is lost. Only its hash is stored.
+type RecoveryCode struct {
+	ID        int64
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+	CreatedAt time.Time
+}
+
+// MFAChallenge is returned by a password login of a user with TOTP enabled
+// and is completed with a second factor. Only the hash of the token is stored.
+type MFAChallenge struct {
+	ID          int64
+	TokenHash   string
+	UserID      uuid.UUID
+	Attempts    int
+	ExpiresAt   time.Time
+	CompletedAt sql.NullTime
+	CreatedAt   time.Time
+}
@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Roles of a member within an organization. They are separate from the
+// service-wide roles in role.go.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// ValidOrgRole reports whether role is one of the organization roles
+func ValidOrgRole(role string) bool {
+	switch role {
+	case OrgRoleOwner, OrgRoleAdmin, OrgRoleMember:
+		return true
+	}
+	return false
+}
+
+// Organization is a team account shared by its members
+type Organization struct {
+	ID        uuid.UUID
+	Name      string
+	CreatedAt time.Time
+}
+
+// Membership ties a user to an organization with a role in it
+type Membership struct {
+	OrganizationID uuid.UUID
+	UserID         uuid.UUID
+	Role           string
+	CreatedAt      time.Time
+}
+
+// Invitation is an emailed offer to join an organization. Only the hash of
+// the token sent in the link is stored.
+type Invitation struct {
+	ID             int64
+	OrganizationID uuid.UUID
+	Email          string
+	Role           string
+	TokenHash      string
+	InvitedBy      uuid.UUID
+	ExpiresAt      time.Time
+	AcceptedAt     sql.NullTime
+	CreatedAt      time.Time
+}
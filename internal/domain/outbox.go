@@ -0,0 +1,30 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Statuses of an OutboxEmail
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+// OutboxEmail is a rendered email waiting in email_outbox to be delivered by
+// the background worker. Dead messages exhausted their attempts and stay
+// there until an operator requeues them.
+type OutboxEmail struct {
+	ID            int64
+	Recipient     string
+	Subject       string
+	Body          string
+	HTML          string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	CreatedAt     time.Time
+	SentAt        sql.NullTime
+}
@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ceremonies a WebAuthn challenge can be issued for
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+// PasskeyCredential is a WebAuthn public key registered by a user
+type PasskeyCredential struct {
+	// ID is the credential ID chosen by the authenticator
+	ID     []byte
+	UserID uuid.UUID
+	// UserHandle is the WebAuthn user ID the credential was created for; the
+	// authenticator returns it on a discoverable login
+	UserHandle []byte
+	// PublicKey is the COSE-encoded credential public key
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	Transports      []string
+	// SignCount is the last signature counter seen; an authenticator that
+	// reports a counter not above it may have been cloned
+	SignCount  uint32
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+// WebAuthnChallenge is the server side of a registration or login ceremony.
+// It is looked up by the challenge the client signed and can be used once.
+type WebAuthnChallenge struct {
+	Challenge string
+	Ceremony  string
+	// UserID is set for registration; a discoverable login starts without a user
+	UserID uuid.NullUUID
+	// SessionData is the ceremony state kept by the WebAuthn library, as JSON
+	SessionData []byte
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+}
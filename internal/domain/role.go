@@ -0,0 +1,21 @@
+package domain
+
+// Permissions granted through roles. Every RPC declares the one it needs.
+const (
+	PermUsersRead   = "users.read"
+	PermUsersWrite  = "users.write"
+	PermRolesManage = "roles.manage"
+)
+
+// Built-in roles, seeded by the rbac migration
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+)
+
+// Role is a named set of permissions. Permissions are sorted.
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+}
@@ -7,7 +7,8 @@ import (
 	"github.com/google/uuid"
 )
 
-// User represents a user in the database
+// User represents a user in the database. A disabled user keeps its data but
+// cannot sign in until an admin enables it again.
 type User struct {
 	ID          uuid.UUID
 	Email       string
@@ -15,22 +16,118 @@ type User struct {
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	DeletedAt   sql.NullTime
+	DisabledAt  sql.NullTime
 	IsConfirmed bool
 }
 
-// Token represents a token in the database
+// UserCursor is the position after which ListUsers continues
+type UserCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// UserFilter selects users for ListUsers. Zero fields do not filter; users
+// are returned ordered by CreatedAt and ID.
+type UserFilter struct {
+	EmailPrefix   string
+	Confirmed     *bool
+	Deleted       *bool
+	CreatedAfter  time.Time // inclusive
+	CreatedBefore time.Time // exclusive
+	After         *UserCursor
+	Limit         int
+}
+
+// Token represents a refresh token in the database. Tokens issued by rotating
+// one another share a FamilyID, which is the ID of the Session they belong to.
+// AccessToken is the jti of the access token issued together with the refresh token.
 type Token struct {
-	ID           int
-	AccessToken  string
-	RefreshToken string
-	UserID       uuid.UUID
+	ID               int
+	AccessToken      string
+	RefreshTokenHash string
+	UserID           uuid.UUID
+	FamilyID         uuid.UUID
+	ExpiresAt        time.Time
+	ConsumedAt       sql.NullTime
+	RevokedAt        sql.NullTime
+	CreatedAt        time.Time
+}
+
+// ClientInfo describes the client a session was started from
+type ClientInfo struct {
+	UserAgent  string
+	ClientIP   string
+	DeviceName string
+}
+
+// Session represents a signed-in device in the database
+type Session struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	ClientInfo
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  sql.NullTime
+	// OrganizationID is the organization the session acts in, if any
+	OrganizationID uuid.NullUUID
+}
+
+// PasswordResetToken represents a one-time password reset token in the database
+type PasswordResetToken struct {
+	ID        int
+	TokenHash string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	IsUsed    bool
+	CreatedAt time.Time
+}
+
+// EmailChange represents a requested email change awaiting confirmation from the new address
+type EmailChange struct {
+	UserID   uuid.UUID
+	NewEmail string
+	CodeHash string
+	// Attempts counts entered codes; the change is dead after too many
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time
 }
 
 // CodeSignature represents a code and signature in the database
 type CodeSignature struct {
-	Code      uuid.UUID
+	Code      string
 	Signature uuid.UUID
 	UserID    uuid.UUID
+	// Attempts counts the codes entered for the signature, right or wrong
+	Attempts  int
 	IsUsed    bool
 	ExpiresAt time.Time
 }
+
+// LoginCode is a passwordless sign-in sent by email: a short code and a
+// magic link that share one row, so using either consumes both. Like
+// CodeSignature it is single-use and expiring; only hashes are stored.
+type LoginCode struct {
+	Signature uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	LinkHash  string
+	// Attempts counts entered codes; the code is dead after too many
+	Attempts  int
+	IsUsed    bool
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// IssuedToken represents a token handed out to a client together with its expiry
+type IssuedToken struct {
+	ID        string // jti of an access token, empty for opaque tokens
+	Data      string
+	ExpiresAt time.Time
+}
+
+// TokenPair represents an access token and the refresh token issued with it
+type TokenPair struct {
+	AccessToken  IssuedToken
+	RefreshToken IssuedToken
+}
@@ -0,0 +1,69 @@
+package mailpost
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultFileSender = "no-reply@localhost"
+
+// FileMailer writes each message as an .eml file into a directory instead of
+// delivering it. Meant for local development and tests.
+type FileMailer struct {
+	dir  string
+	from string
+	now  func() time.Time
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("mail directory is not set")
+	}
+	if from == "" {
+		from = defaultFileSender
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileMailer{dir: dir, from: from, now: time.Now}, nil
+}
+
+// Dir returns the directory the messages are written to
+func (m *FileMailer) Dir() string {
+	return m.dir
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	now := m.now()
+	data, err := buildMIME(m.from, msg, now)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	// Timestamp prefix keeps the files sorted in sending order
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	// Write to a temp file first so readers never see a partial message
+	tmp, err := os.CreateTemp(m.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(m.dir, name))
+}
@@ -0,0 +1,46 @@
+package mailpost
+
+import (
+	"fmt"
+
+	"github.com/Olegnemlii/test123/internal/config"
+)
+
+// Supported values of config.Config.MailBackend
+const (
+	BackendMailopost = "mailopost"
+	BackendSMTP      = "smtp"
+	BackendFile      = "file"
+)
+
+// Mailer delivers a single email message
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var (
+	_ Mailer = (*Client)(nil)
+	_ Mailer = (*SMTPMailer)(nil)
+	_ Mailer = (*FileMailer)(nil)
+)
+
+// New builds the Mailer selected by cfg.MailBackend
+func New(cfg config.Config) (Mailer, error) {
+	switch cfg.MailBackend {
+	case BackendMailopost, "":
+		return NewClient(cfg.MailopostURL, cfg.MailopostApiKey), nil
+	case BackendSMTP:
+		return NewSMTPMailer(SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			StartTLS: cfg.SMTPStartTLS,
+			From:     cfg.MailFrom,
+		}), nil
+	case BackendFile:
+		return NewFileMailer(cfg.MailDir, cfg.MailFrom)
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", cfg.MailBackend)
+	}
+}
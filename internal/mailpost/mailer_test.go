@@ -0,0 +1,223 @@
+package mailpost
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readParts(t *testing.T, raw io.Reader) (*mail.Message, map[string]string) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(raw)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", mediaType)
+	}
+
+	parts := make(map[string]string)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[ct] = string(body)
+	}
+	return msg, parts
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(dir, "Auth <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+
+	for _, subject := range []string{"Первое письмо", "Second"} {
+		err := m.Send(Message{To: "user@example.com", Subject: subject, Body: "code: 123456", HTML: "<p>code: <b>123456</b></p>"})
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d .eml files, want 2", len(files))
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	msg, parts := readParts(t, f)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("decode subject: %v", err)
+	}
+	if subject != "Первое письмо" {
+		t.Errorf("Subject = %q, want the first message", subject)
+	}
+	if got := msg.Header.Get("To"); got != "user@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got := parts["text/plain"]; got != "code: 123456" {
+		t.Errorf("text part = %q", got)
+	}
+	if got := parts["text/html"]; got != "<p>code: <b>123456</b></p>" {
+		t.Errorf("html part = %q", got)
+	}
+}
+
+// fakeSMTP accepts a single session on a local listener and records the envelope
+type fakeSMTP struct {
+	addr string
+	done chan struct{}
+
+	auth string
+	from string
+	rcpt string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{addr: ln.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch cmd {
+			case "EHLO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				s.auth = line
+				reply("235 Authentication successful")
+			case "MAIL":
+				s.from = line
+				reply("250 OK")
+			case "RCPT":
+				s.rcpt = line
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(l, "."))
+				}
+				s.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	return s
+}
+
+func TestSMTPMailer(t *testing.T) {
+	srv := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(srv.addr)
+
+	m := NewSMTPMailer(SMTPConfig{
+		Host:     host,
+		Port:     port,
+		Username: "user",
+		Password: "secret",
+		From:     "Auth <no-reply@example.com>",
+	})
+
+	err := m.Send(Message{To: "user@example.com", Subject: "Hello", Body: "plain", HTML: "<p>rich</p>"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-srv.done
+
+	if !strings.HasPrefix(srv.auth, "AUTH PLAIN") {
+		t.Errorf("auth = %q, want AUTH PLAIN", srv.auth)
+	}
+	if srv.from != "MAIL FROM:<no-reply@example.com>" {
+		t.Errorf("MAIL FROM = %q", srv.from)
+	}
+	if srv.rcpt != "RCPT TO:<user@example.com>" {
+		t.Errorf("RCPT TO = %q", srv.rcpt)
+	}
+
+	_, parts := readParts(t, strings.NewReader(srv.data))
+	if parts["text/plain"] != "plain" || parts["text/html"] != "<p>rich</p>" {
+		t.Errorf("unexpected parts: %q", parts)
+	}
+}
+
+func TestSMTPMailerRequiresStartTLS(t *testing.T) {
+	srv := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(srv.addr)
+
+	m := NewSMTPMailer(SMTPConfig{Host: host, Port: port, StartTLS: true, From: "no-reply@example.com"})
+	if err := m.Send(Message{To: "user@example.com", Subject: "Hello", Body: "plain"}); err == nil {
+		t.Fatal("expected an error when the server does not offer STARTTLS")
+	}
+}
@@ -5,34 +5,46 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const (
-	apiURL = "https://api.mailopost.ru/v1/"
+	defaultAPIURL = "https://api.mailopost.ru/v1/"
 )
 
 type Client struct {
-	APIKey    string
-	APISecret string
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
 }
 
-func NewClient(apiKey, apiSecret string) *Client {
-	return &Client{APIKey: apiKey, APISecret: apiSecret}
+func NewClient(baseURL, apiKey string) *Client {
+	if baseURL == "" {
+		baseURL = defaultAPIURL
+	}
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/") + "/",
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
 }
 
 func (c *Client) SendMessage(to, subject, body string) error {
-	msg := Message{
+	return c.Send(Message{
 		To:      to,
 		Subject: subject,
 		Body:    body,
-	}
+	})
+}
 
+func (c *Client) Send(msg Message) error {
 	jsonMsg, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", apiURL+"messages", bytes.NewBuffer(jsonMsg))
+	req, err := http.NewRequest("POST", c.BaseURL+"messages", bytes.NewBuffer(jsonMsg))
 	if err != nil {
 		return err
 	}
@@ -40,14 +52,14 @@ func (c *Client) SendMessage(to, subject, body string) error {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return err
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("invalid status code: %d", resp.StatusCode)
 	}
 
@@ -58,4 +70,5 @@ type Message struct {
 	To      string `json:"to"`
 	Subject string `json:"subject"`
 	Body    string `json:"body"`
+	HTML    string `json:"html,omitempty"`
 }
@@ -0,0 +1,31 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+)
+
+func (r *UserRepository) AppendAuditEntry(ctx context.Context, entry *domain.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.ID = int64(len(r.data.audit) + 1)
+	entry.CreatedAt = time.Now()
+	r.data.audit = append(r.data.audit, *entry)
+
+	return nil
+}
+
+func (r *UserRepository) ListAuditEntries(ctx context.Context, limit int) ([]*domain.AuditEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []*domain.AuditEntry
+	for i := len(r.data.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entry := r.data.audit[i]
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
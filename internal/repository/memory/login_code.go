@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+func (r *UserRepository) StoreLoginCode(ctx context.Context, code *domain.LoginCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code.Attempts = 0
+	for _, c := range r.data.loginCodes {
+		if c.UserID == code.UserID && !c.IsUsed && time.Now().Before(c.ExpiresAt) {
+			code.Attempts = max(code.Attempts, c.Attempts)
+		}
+	}
+
+	r.deleteLoginCodes(code.UserID)
+	code.IsUsed = false
+	code.CreatedAt = time.Now()
+	r.data.loginCodes[code.Signature] = *code
+	return nil
+}
+
+func (r *UserRepository) GetLoginCode(ctx context.Context, userID uuid.UUID) (*domain.LoginCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// StoreLoginCode keeps at most one code per user
+	for _, c := range r.data.loginCodes {
+		if c.UserID == userID {
+			return &c, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (r *UserRepository) GetLoginCodeByLink(ctx context.Context, linkHash string) (*domain.LoginCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.data.loginCodes {
+		if c.LinkHash == linkHash {
+			return &c, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (r *UserRepository) AddLoginCodeAttempt(ctx context.Context, signature uuid.UUID) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.loginCodes[signature]
+	if !ok {
+		return 0, domain.ErrNotFound
+	}
+	c.Attempts++
+	r.data.loginCodes[signature] = c
+	return c.Attempts, nil
+}
+
+func (r *UserRepository) UseLoginCode(ctx context.Context, signature uuid.UUID, maxAttempts int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.loginCodes[signature]
+	if !ok || c.IsUsed || c.Attempts > maxAttempts || !time.Now().Before(c.ExpiresAt) {
+		return false, nil
+	}
+	c.IsUsed = true
+	r.data.loginCodes[signature] = c
+	return true, nil
+}
+
+// deleteLoginCodes removes all login codes of the user; mu must be held
+func (r *UserRepository) deleteLoginCodes(userID uuid.UUID) {
+	for signature, c := range r.data.loginCodes {
+		if c.UserID == userID {
+			delete(r.data.loginCodes, signature)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+func (r *UserRepository) StoreTOTP(ctx context.Context, credential *domain.TOTPCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.data.totp[credential.UserID]
+	if ok && stored.ConfirmedAt.Valid {
+		return domain.ErrTOTPAlreadyEnabled
+	}
+
+	credential.Attempts, credential.LastAttemptAt = stored.Attempts, stored.LastAttemptAt
+	credential.ConfirmedAt = sql.NullTime{}
+	credential.LastUsedStep = 0
+	r.data.totp[credential.UserID] = *credential
+	return nil
+}
+
+func (r *UserRepository) GetTOTP(ctx context.Context, userID uuid.UUID) (*domain.TOTPCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.totp[userID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &c, nil
+}
+
+func (r *UserRepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.totp[userID]
+	if !ok || c.ConfirmedAt.Valid {
+		return false, nil
+	}
+	c.ConfirmedAt.Time, c.ConfirmedAt.Valid = time.Now(), true
+	c.LastUsedStep = step
+	r.data.totp[userID] = c
+	return true, nil
+}
+
+func (r *UserRepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.totp[userID]
+	if !ok || !c.ConfirmedAt.Valid || c.LastUsedStep >= step {
+		return false, nil
+	}
+	c.LastUsedStep = step
+	r.data.totp[userID] = c
+	return true, nil
+}
+
+func (r *UserRepository) AddTOTPAttempt(ctx context.Context, userID uuid.UUID, since time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.totp[userID]
+	if !ok {
+		return 0, domain.ErrNotFound
+	}
+	if c.LastAttemptAt.Valid && !c.LastAttemptAt.Time.Before(since) {
+		c.Attempts++
+	} else {
+		c.Attempts = 1
+	}
+	c.LastAttemptAt.Time, c.LastAttemptAt.Valid = time.Now(), true
+	r.data.totp[userID] = c
+	return c.Attempts, nil
+}
+
+func (r *UserRepository) ResetTOTPAttempts(ctx context.Context, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.data.totp[userID]; ok {
+		c.Attempts = 0
+		r.data.totp[userID] = c
+	}
+	return nil
+}
+
+func (r *UserRepository) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.data.totp, userID)
+	r.deleteRecoveryCodes(userID)
+	return nil
+}
+
+func (r *UserRepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deleteRecoveryCodes(userID)
+	for _, hash := range codeHashes {
+		r.data.recoverySeq++
+		r.data.recoveryCodes[r.data.recoverySeq] = domain.RecoveryCode{
+			ID:        r.data.recoverySeq,
+			UserID:    userID,
+			CodeHash:  hash,
+			CreatedAt: time.Now(),
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, code := range r.data.recoveryCodes {
+		if code.UserID == userID && code.CodeHash == codeHash && !code.UsedAt.Valid {
+			code.UsedAt.Time, code.UsedAt.Valid = time.Now(), true
+			r.data.recoveryCodes[id] = code
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteRecoveryCodes removes all recovery codes of the user; mu must be held
+func (r *UserRepository) deleteRecoveryCodes(userID uuid.UUID) {
+	for id, code := range r.data.recoveryCodes {
+		if code.UserID == userID {
+			delete(r.data.recoveryCodes, id)
+		}
+	}
+}
+
+func (r *UserRepository) StoreMFAChallenge(ctx context.Context, challenge *domain.MFAChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.data.challengeSeq++
+	challenge.ID = r.data.challengeSeq
+	challenge.CreatedAt = time.Now()
+	r.data.challenges[challenge.ID] = *challenge
+	return nil
+}
+
+func (r *UserRepository) GetMFAChallenge(ctx context.Context, tokenHash string) (*domain.MFAChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.data.challenges {
+		if c.TokenHash == tokenHash {
+			return &c, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (r *UserRepository) AddMFAChallengeAttempt(ctx context.Context, id int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.challenges[id]
+	if !ok {
+		return 0, domain.ErrNotFound
+	}
+	c.Attempts++
+	r.data.challenges[id] = c
+	return c.Attempts, nil
+}
+
+func (r *UserRepository) CompleteMFAChallenge(ctx context.Context, id int64, maxAttempts int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.challenges[id]
+	if !ok || c.CompletedAt.Valid || c.Attempts > maxAttempts || !time.Now().Before(c.ExpiresAt) {
+		return false, nil
+	}
+	c.CompletedAt.Time, c.CompletedAt.Valid = time.Now(), true
+	r.data.challenges[id] = c
+	return true, nil
+}
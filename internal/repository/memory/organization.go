@@ -0,0 +1,171 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// orgMember is the key of a membership
+type orgMember struct {
+	OrganizationID uuid.UUID
+	UserID         uuid.UUID
+}
+
+func (r *UserRepository) CreateOrganization(ctx context.Context, org *domain.Organization) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	org.ID = uuid.New()
+	r.data.orgs[org.ID] = *org
+	return nil
+}
+
+func (r *UserRepository) GetOrganization(ctx context.Context, id uuid.UUID) (*domain.Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	org, ok := r.data.orgs[id]
+	if !ok {
+		return nil, domain.ErrOrganizationNotFound
+	}
+	return &org, nil
+}
+
+func (r *UserRepository) AddMember(ctx context.Context, member *domain.Membership) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.data.orgs[member.OrganizationID]; !ok {
+		return domain.ErrOrganizationNotFound
+	}
+	if _, ok := r.data.users[member.UserID]; !ok {
+		return domain.ErrUserNotFound
+	}
+
+	key := orgMember{OrganizationID: member.OrganizationID, UserID: member.UserID}
+	if _, ok := r.data.members[key]; ok {
+		return domain.ErrAlreadyMember
+	}
+	r.data.members[key] = *member
+	return nil
+}
+
+func (r *UserRepository) GetMembership(ctx context.Context, orgID, userID uuid.UUID) (*domain.Membership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.data.members[orgMember{OrganizationID: orgID, UserID: userID}]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &m, nil
+}
+
+func (r *UserRepository) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*domain.Membership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.memberships(func(m domain.Membership) bool { return m.OrganizationID == orgID }), nil
+}
+
+func (r *UserRepository) ListMemberships(ctx context.Context, userID uuid.UUID) ([]*domain.Membership, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.memberships(func(m domain.Membership) bool { return m.UserID == userID }), nil
+}
+
+// memberships returns the matching memberships, oldest first; mu must be held
+func (r *UserRepository) memberships(match func(domain.Membership) bool) []*domain.Membership {
+	var list []*domain.Membership
+	for _, m := range r.data.members {
+		if match(m) {
+			m := m
+			list = append(list, &m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		if a.OrganizationID != b.OrganizationID {
+			return bytes.Compare(a.OrganizationID[:], b.OrganizationID[:]) < 0
+		}
+		return bytes.Compare(a.UserID[:], b.UserID[:]) < 0
+	})
+	return list
+}
+
+func (r *UserRepository) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.data.members, orgMember{OrganizationID: orgID, UserID: userID})
+	for id, session := range r.data.sessions {
+		if session.UserID == userID && session.OrganizationID.Valid && session.OrganizationID.UUID == orgID {
+			session.OrganizationID = uuid.NullUUID{}
+			r.data.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) StoreInvitation(ctx context.Context, invitation *domain.Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, inv := range r.data.invitations {
+		if inv.OrganizationID == invitation.OrganizationID && inv.Email == invitation.Email && !inv.AcceptedAt.Valid {
+			delete(r.data.invitations, id)
+		}
+	}
+
+	r.data.invSeq++
+	invitation.ID = r.data.invSeq
+	invitation.CreatedAt = time.Now()
+	r.data.invitations[invitation.ID] = *invitation
+	return nil
+}
+
+func (r *UserRepository) GetInvitation(ctx context.Context, tokenHash string) (*domain.Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, inv := range r.data.invitations {
+		if inv.TokenHash == tokenHash {
+			return &inv, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (r *UserRepository) MarkInvitationAccepted(ctx context.Context, id int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	inv, ok := r.data.invitations[id]
+	if !ok || inv.AcceptedAt.Valid {
+		return false, nil
+	}
+	inv.AcceptedAt.Time, inv.AcceptedAt.Valid = time.Now(), true
+	r.data.invitations[id] = inv
+	return true, nil
+}
+
+func (r *UserRepository) SetSessionOrganization(ctx context.Context, sessionID uuid.UUID, orgID uuid.NullUUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if session, ok := r.data.sessions[sessionID]; ok {
+		session.OrganizationID = orgID
+		r.data.sessions[sessionID] = session
+	}
+	return nil
+}
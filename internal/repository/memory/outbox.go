@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+)
+
+func (r *UserRepository) EnqueueEmail(ctx context.Context, email *domain.OutboxEmail) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.data.outboxSeq++
+	email.ID = r.data.outboxSeq
+	email.Status = domain.OutboxPending
+	email.Attempts = 0
+	email.NextAttemptAt = now
+	email.CreatedAt = now
+	r.data.outbox[email.ID] = *email
+
+	return nil
+}
+
+func (r *UserRepository) ClaimOutboxEmails(ctx context.Context, limit int, leaseUntil time.Time) ([]*domain.OutboxEmail, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	due := r.outboxWhere(func(e domain.OutboxEmail) bool {
+		return e.Status == domain.OutboxPending && !e.NextAttemptAt.After(now)
+	})
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, e := range due {
+		e.Attempts++
+		e.NextAttemptAt = leaseUntil
+		r.data.outbox[e.ID] = *e
+	}
+
+	return due, nil
+}
+
+func (r *UserRepository) MarkOutboxEmailSent(ctx context.Context, id int64) error {
+	r.updateOutbox(id, func(e *domain.OutboxEmail) {
+		e.Status = domain.OutboxSent
+		e.SentAt.Time, e.SentAt.Valid = time.Now(), true
+		e.LastError.String, e.LastError.Valid = "", false
+	})
+	return nil
+}
+
+func (r *UserRepository) RetryOutboxEmail(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	r.updateOutbox(id, func(e *domain.OutboxEmail) {
+		e.LastError.String, e.LastError.Valid = lastError, true
+		e.NextAttemptAt = nextAttemptAt
+	})
+	return nil
+}
+
+func (r *UserRepository) DeadLetterOutboxEmail(ctx context.Context, id int64, lastError string) error {
+	r.updateOutbox(id, func(e *domain.OutboxEmail) {
+		e.Status = domain.OutboxDead
+		e.LastError.String, e.LastError.Valid = lastError, true
+	})
+	return nil
+}
+
+func (r *UserRepository) ListDeadLetters(ctx context.Context, limit int) ([]*domain.OutboxEmail, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dead := r.outboxWhere(func(e domain.OutboxEmail) bool { return e.Status == domain.OutboxDead })
+	if len(dead) > limit {
+		dead = dead[:limit]
+	}
+	return dead, nil
+}
+
+func (r *UserRepository) RequeueDeadLetter(ctx context.Context, id int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.data.outbox[id]
+	if !ok || e.Status != domain.OutboxDead {
+		return false, nil
+	}
+	e.Status = domain.OutboxPending
+	e.Attempts = 0
+	e.NextAttemptAt = time.Now()
+	r.data.outbox[id] = e
+
+	return true, nil
+}
+
+func (r *UserRepository) updateOutbox(id int64, update func(e *domain.OutboxEmail)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.data.outbox[id]; ok {
+		update(&e)
+		r.data.outbox[id] = e
+	}
+}
+
+// outboxWhere returns copies of the matching emails ordered by ID; mu must be held
+func (r *UserRepository) outboxWhere(match func(domain.OutboxEmail) bool) []*domain.OutboxEmail {
+	var emails []*domain.OutboxEmail
+	for _, e := range r.data.outbox {
+		if match(e) {
+			e := e
+			emails = append(emails, &e)
+		}
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].ID < emails[j].ID })
+	return emails
+}
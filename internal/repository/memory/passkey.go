@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+func (r *UserRepository) CreatePasskey(ctx context.Context, credential *domain.PasskeyCredential) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.data.passkeys[string(credential.ID)]; ok {
+		return domain.ErrPasskeyExists
+	}
+
+	credential.CreatedAt = time.Now()
+	credential.LastUsedAt = sql.NullTime{}
+	r.data.passkeys[string(credential.ID)] = copyPasskey(*credential)
+	return nil
+}
+
+func (r *UserRepository) GetPasskey(ctx context.Context, credentialID []byte) (*domain.PasskeyCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.passkeys[string(credentialID)]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	c = copyPasskey(c)
+	return &c, nil
+}
+
+func (r *UserRepository) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]*domain.PasskeyCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var credentials []*domain.PasskeyCredential
+	for _, c := range r.data.passkeys {
+		if c.UserID == userID {
+			c = copyPasskey(c)
+			credentials = append(credentials, &c)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		if !credentials[i].CreatedAt.Equal(credentials[j].CreatedAt) {
+			return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+		}
+		return bytes.Compare(credentials[i].ID, credentials[j].ID) < 0
+	})
+	return credentials, nil
+}
+
+func (r *UserRepository) UsePasskey(ctx context.Context, credentialID []byte, signCount uint32) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.passkeys[string(credentialID)]
+	if !ok || (c.SignCount >= signCount && (c.SignCount != 0 || signCount != 0)) {
+		return false, nil
+	}
+	c.SignCount = signCount
+	c.LastUsedAt.Time, c.LastUsedAt.Valid = time.Now(), true
+	r.data.passkeys[string(credentialID)] = c
+	return true, nil
+}
+
+func (r *UserRepository) StoreWebAuthnChallenge(ctx context.Context, challenge *domain.WebAuthnChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge.CreatedAt = time.Now()
+	c := *challenge
+	c.SessionData = bytes.Clone(c.SessionData)
+	r.data.webauthnChallenges[c.Challenge] = c
+	return nil
+}
+
+func (r *UserRepository) TakeWebAuthnChallenge(ctx context.Context, challenge string) (*domain.WebAuthnChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.webauthnChallenges[challenge]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	delete(r.data.webauthnChallenges, challenge)
+	return &c, nil
+}
+
+// copyPasskey returns c with its own copies of the byte slices
+func copyPasskey(c domain.PasskeyCredential) domain.PasskeyCredential {
+	c.ID = bytes.Clone(c.ID)
+	c.UserHandle = bytes.Clone(c.UserHandle)
+	c.PublicKey = bytes.Clone(c.PublicKey)
+	c.AAGUID = bytes.Clone(c.AAGUID)
+	c.Transports = slices.Clone(c.Transports)
+	return c
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// userRole is the key of a role granted to a user
+type userRole struct {
+	UserID uuid.UUID
+	Role   string
+}
+
+// builtinRoles returns the roles seeded by the rbac migration
+func builtinRoles() map[string]domain.Role {
+	return map[string]domain.Role{
+		domain.RoleAdmin: {
+			Name:        domain.RoleAdmin,
+			Description: "Full access to the Admin service",
+			Permissions: []string{domain.PermRolesManage, domain.PermUsersRead, domain.PermUsersWrite},
+		},
+		domain.RoleSupport: {
+			Name:        domain.RoleSupport,
+			Description: "Manages user accounts but not roles",
+			Permissions: []string{domain.PermUsersRead, domain.PermUsersWrite},
+		},
+	}
+}
+
+func (r *UserRepository) ListRoles(ctx context.Context) ([]*domain.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var roles []*domain.Role
+	for _, role := range r.data.roles {
+		role.Permissions = slices.Clone(role.Permissions)
+		roles = append(roles, &role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (r *UserRepository) AssignRole(ctx context.Context, userID uuid.UUID, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.data.roles[role]; !ok {
+		return domain.ErrRoleNotFound
+	}
+	if _, ok := r.data.users[userID]; !ok {
+		return domain.ErrUserNotFound
+	}
+
+	key := userRole{UserID: userID, Role: role}
+	if _, ok := r.data.userRoles[key]; !ok {
+		r.data.userRoles[key] = time.Now()
+	}
+	return nil
+}
+
+func (r *UserRepository) RevokeRole(ctx context.Context, userID uuid.UUID, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.data.userRoles, userRole{UserID: userID, Role: role})
+	return nil
+}
+
+func (r *UserRepository) GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var roles []string
+	for ur := range r.data.userRoles {
+		if ur.UserID == userID {
+			roles = append(roles, ur.Role)
+		}
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+func (r *UserRepository) GetUserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var permissions []string
+	for ur := range r.data.userRoles {
+		if ur.UserID == userID {
+			permissions = append(permissions, r.data.roles[ur.Role].Permissions...)
+		}
+	}
+	slices.Sort(permissions)
+	return slices.Compact(permissions), nil
+}
@@ -0,0 +1,778 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// UserRepository keeps everything in process memory. It mirrors the
+// semantics of the Postgres repository and is meant for tests and local
+// development. It is safe for concurrent use; transactions are serialized.
+type UserRepository struct {
+	mu   *sync.Mutex
+	data *store
+	// inTx is set for a repository bound to a transaction
+	inTx bool
+}
+
+// store holds copies of the rows, so callers never share memory with it
+type store struct {
+	users         map[uuid.UUID]domain.User
+	codes         map[uuid.UUID]domain.CodeSignature
+	loginCodes    map[uuid.UUID]domain.LoginCode
+	tokens        map[int]domain.Token
+	tokenSeq      int
+	sessions      map[uuid.UUID]domain.Session
+	resetTokens   map[int]domain.PasswordResetToken
+	resetSeq      int
+	emailChanges  map[uuid.UUID]domain.EmailChange
+	revoked       map[string]time.Time
+	outbox        map[int64]domain.OutboxEmail
+	outboxSeq     int64
+	audit         []domain.AuditEntry
+	roles         map[string]domain.Role
+	userRoles     map[userRole]time.Time
+	orgs          map[uuid.UUID]domain.Organization
+	members       map[orgMember]domain.Membership
+	invitations   map[int64]domain.Invitation
+	invSeq        int64
+	totp          map[uuid.UUID]domain.TOTPCredential
+	recoveryCodes map[int64]domain.RecoveryCode
+	recoverySeq   int64
+	challenges    map[int64]domain.MFAChallenge
+	challengeSeq  int64
+	// passkeys and webauthnChallenges are keyed by credential ID and challenge
+	passkeys           map[string]domain.PasskeyCredential
+	webauthnChallenges map[string]domain.WebAuthnChallenge
+}
+
+func NewUserRepository() repository.UserRepository {
+	return &UserRepository{
+		mu: &sync.Mutex{},
+		data: &store{
+			users:              make(map[uuid.UUID]domain.User),
+			codes:              make(map[uuid.UUID]domain.CodeSignature),
+			loginCodes:         make(map[uuid.UUID]domain.LoginCode),
+			tokens:             make(map[int]domain.Token),
+			sessions:           make(map[uuid.UUID]domain.Session),
+			resetTokens:        make(map[int]domain.PasswordResetToken),
+			emailChanges:       make(map[uuid.UUID]domain.EmailChange),
+			revoked:            make(map[string]time.Time),
+			outbox:             make(map[int64]domain.OutboxEmail),
+			roles:              builtinRoles(),
+			userRoles:          make(map[userRole]time.Time),
+			orgs:               make(map[uuid.UUID]domain.Organization),
+			members:            make(map[orgMember]domain.Membership),
+			invitations:        make(map[int64]domain.Invitation),
+			totp:               make(map[uuid.UUID]domain.TOTPCredential),
+			recoveryCodes:      make(map[int64]domain.RecoveryCode),
+			challenges:         make(map[int64]domain.MFAChallenge),
+			passkeys:           make(map[string]domain.PasskeyCredential),
+			webauthnChallenges: make(map[string]domain.WebAuthnChallenge),
+		},
+	}
+}
+
+func (s *store) clone() *store {
+	c := *s
+	c.users = maps.Clone(s.users)
+	c.codes = maps.Clone(s.codes)
+	c.loginCodes = maps.Clone(s.loginCodes)
+	c.tokens = maps.Clone(s.tokens)
+	c.sessions = maps.Clone(s.sessions)
+	c.resetTokens = maps.Clone(s.resetTokens)
+	c.emailChanges = maps.Clone(s.emailChanges)
+	c.revoked = maps.Clone(s.revoked)
+	c.outbox = maps.Clone(s.outbox)
+	c.audit = slices.Clone(s.audit)
+	c.roles = maps.Clone(s.roles)
+	c.userRoles = maps.Clone(s.userRoles)
+	c.orgs = maps.Clone(s.orgs)
+	c.members = maps.Clone(s.members)
+	c.invitations = maps.Clone(s.invitations)
+	c.totp = maps.Clone(s.totp)
+	c.recoveryCodes = maps.Clone(s.recoveryCodes)
+	c.challenges = maps.Clone(s.challenges)
+	c.passkeys = maps.Clone(s.passkeys)
+	c.webauthnChallenges = maps.Clone(s.webauthnChallenges)
+	return &c
+}
+
+// WithTx runs fn against a copy of the data and keeps the copy only if fn
+// succeeds. The repository stays locked for the whole transaction.
+func (r *UserRepository) WithTx(ctx context.Context, fn func(repo repository.UserRepository) error, opts ...repository.TxOption) error {
+	if r.inTx {
+		return fn(r)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := r.data.clone()
+	if err := fn(&UserRepository{mu: &sync.Mutex{}, data: snapshot, inTx: true}); err != nil {
+		return err
+	}
+	r.data = snapshot
+	return nil
+}
+
+func (r *UserRepository) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.userByEmail(user.Email); ok {
+		return nil, domain.ErrEmailTaken
+	}
+
+	user.ID = uuid.New()
+	r.data.users[user.ID] = *user
+
+	return user, nil
+}
+
+func (r *UserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.data.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return nil, domain.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.userByEmail(email)
+	if !ok || user.DeletedAt.Valid {
+		return nil, domain.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetDeletedUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.userByEmail(email)
+	if !ok || !user.DeletedAt.Valid {
+		return nil, domain.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) FindUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.data.users[id]
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.data.users[id]
+	if !ok {
+		return "", domain.ErrUserNotFound
+	}
+	return user.Password, nil
+}
+
+func (r *UserRepository) ListUsers(ctx context.Context, filter domain.UserFilter) ([]*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []*domain.User
+	for _, user := range r.data.users {
+		if matchUser(user, filter) {
+			user := user
+			users = append(users, &user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return userBefore(users[i].CreatedAt, users[i].ID, users[j].CreatedAt, users[j].ID)
+	})
+	if len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+
+	return users, nil
+}
+
+func (r *UserRepository) UpdateUser(ctx context.Context, user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.data.users[user.ID]
+	if !ok {
+		return nil
+	}
+	if other, ok := r.userByEmail(user.Email); ok && other.ID != user.ID {
+		return domain.ErrEmailTaken
+	}
+
+	current.Email = user.Email
+	if user.Password != "" {
+		current.Password = user.Password
+	}
+	current.UpdatedAt = user.UpdatedAt
+	current.DisabledAt = user.DisabledAt
+	current.IsConfirmed = user.IsConfirmed
+	r.data.users[user.ID] = current
+
+	return nil
+}
+
+func (r *UserRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.data.users[id]; ok && !user.DeletedAt.Valid {
+		user.DeletedAt.Time, user.DeletedAt.Valid = time.Now(), true
+		r.data.users[id] = user
+	}
+	return nil
+}
+
+func (r *UserRepository) RestoreUser(ctx context.Context, id uuid.UUID, deletedAfter time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.data.users[id]
+	if !ok || !user.DeletedAt.Valid || !user.DeletedAt.Time.After(deletedAfter) {
+		return false, nil
+	}
+	user.DeletedAt = sql.NullTime{}
+	r.data.users[id] = user
+
+	return true, nil
+}
+
+func (r *UserRepository) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purge []domain.User
+	for _, user := range r.data.users {
+		if user.DeletedAt.Valid && user.DeletedAt.Time.Before(deletedBefore) {
+			purge = append(purge, user)
+		}
+	}
+	sort.Slice(purge, func(i, j int) bool {
+		return purge[i].DeletedAt.Time.Before(purge[j].DeletedAt.Time)
+	})
+	if len(purge) > limit {
+		purge = purge[:limit]
+	}
+
+	for _, user := range purge {
+		r.deleteUserRows(user.ID)
+	}
+	return len(purge), nil
+}
+
+func (r *UserRepository) PurgeExpired(ctx context.Context, expiredBefore time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for key, c := range r.data.webauthnChallenges {
+		if c.ExpiresAt.Before(expiredBefore) {
+			delete(r.data.webauthnChallenges, key)
+			purged++
+		}
+	}
+	for id, c := range r.data.challenges {
+		if c.ExpiresAt.Before(expiredBefore) {
+			delete(r.data.challenges, id)
+			purged++
+		}
+	}
+	for signature, c := range r.data.loginCodes {
+		if c.ExpiresAt.Before(expiredBefore) {
+			delete(r.data.loginCodes, signature)
+			purged++
+		}
+	}
+	for jti, expiresAt := range r.data.revoked {
+		if expiresAt.Before(expiredBefore) {
+			delete(r.data.revoked, jti)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (r *UserRepository) GetEmailBySignature(ctx context.Context, signature uuid.UUID) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.data.codes[signature]
+	if !ok {
+		return "", domain.ErrNotFound
+	}
+	user, ok := r.data.users[code.UserID]
+	if !ok || user.DeletedAt.Valid {
+		return "", domain.ErrNotFound
+	}
+	return user.Email, nil
+}
+
+func (r *UserRepository) StoreVerificationCode(ctx context.Context, code *domain.CodeSignature) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.data.codes[code.Signature]; ok {
+		return errors.New("failed to store verification code: duplicate signature")
+	}
+	r.data.codes[code.Signature] = *code
+	return nil
+}
+
+func (r *UserRepository) GetVerificationCode(ctx context.Context, signature uuid.UUID) (*domain.CodeSignature, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.data.codes[signature]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &code, nil
+}
+
+func (r *UserRepository) AddVerificationCodeAttempt(ctx context.Context, signature uuid.UUID) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.data.codes[signature]
+	if !ok {
+		return 0, domain.ErrNotFound
+	}
+	code.Attempts++
+	r.data.codes[signature] = code
+	return code.Attempts, nil
+}
+
+func (r *UserRepository) MarkVerificationCodeUsed(ctx context.Context, signature uuid.UUID, maxAttempts int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.data.codes[signature]
+	if !ok || code.IsUsed || code.Attempts > maxAttempts || !time.Now().Before(code.ExpiresAt) {
+		return false, nil
+	}
+	code.IsUsed = true
+	r.data.codes[signature] = code
+	return true, nil
+}
+
+func (r *UserRepository) StoreRefreshToken(ctx context.Context, token *domain.Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tokenByHash(token.RefreshTokenHash); ok {
+		return errors.New("failed to store refresh token: duplicate hash")
+	}
+
+	r.data.tokenSeq++
+	token.ID = r.data.tokenSeq
+	token.CreatedAt = time.Now()
+	r.data.tokens[token.ID] = *token
+
+	return nil
+}
+
+func (r *UserRepository) GetRefreshToken(ctx context.Context, refreshTokenHash string) (*domain.Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokenByHash(refreshTokenHash)
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &token, nil
+}
+
+func (r *UserRepository) ConsumeRefreshToken(ctx context.Context, id int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.data.tokens[id]
+	if !ok || token.ConsumedAt.Valid || token.RevokedAt.Valid {
+		return false, nil
+	}
+	token.ConsumedAt.Time, token.ConsumedAt.Valid = time.Now(), true
+	r.data.tokens[id] = token
+
+	return true, nil
+}
+
+func (r *UserRepository) RebindRefreshToken(ctx context.Context, familyID uuid.UUID, oldAccessTokenID, newAccessTokenID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.data.tokens {
+		if token.FamilyID == familyID && token.AccessToken == oldAccessTokenID {
+			token.AccessToken = newAccessTokenID
+			r.data.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) DeleteRefreshToken(ctx context.Context, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.userByEmail(email)
+	if !ok {
+		return nil
+	}
+	for id, token := range r.data.tokens {
+		if token.UserID == user.ID {
+			delete(r.data.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) CreateSession(ctx context.Context, session *domain.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.data.sessions[session.ID]; ok {
+		return errors.New("failed to create session: duplicate id")
+	}
+	r.data.sessions[session.ID] = *session
+	return nil
+}
+
+func (r *UserRepository) GetSession(ctx context.Context, id uuid.UUID) (*domain.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.data.sessions[id]
+	if !ok {
+		return nil, domain.ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (r *UserRepository) ListSessions(ctx context.Context, userID uuid.UUID) ([]*domain.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sessions []*domain.Session
+	for _, session := range r.data.sessions {
+		if session.UserID == userID && !session.RevokedAt.Valid {
+			session := session
+			sessions = append(sessions, &session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func (r *UserRepository) TouchSession(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if session, ok := r.data.sessions[id]; ok {
+		session.LastUsedAt = lastUsedAt
+		r.data.sessions[id] = session
+	}
+	return nil
+}
+
+func (r *UserRepository) RevokeSession(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if session, ok := r.data.sessions[id]; ok {
+		r.revokeSessions(session.UserID, func(s domain.Session) bool { return s.ID == id })
+	}
+	r.revokeTokens(func(t domain.Token) bool { return t.FamilyID == id })
+	return nil
+}
+
+func (r *UserRepository) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revokeSessions(userID, func(s domain.Session) bool { return s.ID != keepID })
+	r.revokeTokens(func(t domain.Token) bool { return t.UserID == userID && t.FamilyID != keepID })
+	return nil
+}
+
+func (r *UserRepository) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revokeSessions(userID, func(domain.Session) bool { return true })
+	r.revokeTokens(func(t domain.Token) bool { return t.UserID == userID })
+	return nil
+}
+
+func (r *UserRepository) StorePasswordResetToken(ctx context.Context, token *domain.PasswordResetToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.data.resetTokens {
+		if t.TokenHash == token.TokenHash {
+			return errors.New("failed to store password reset token: duplicate hash")
+		}
+	}
+
+	r.data.resetSeq++
+	token.ID = r.data.resetSeq
+	token.IsUsed = false
+	token.CreatedAt = time.Now()
+	r.data.resetTokens[token.ID] = *token
+
+	return nil
+}
+
+func (r *UserRepository) GetPasswordResetToken(ctx context.Context, tokenHash string) (*domain.PasswordResetToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.data.resetTokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (r *UserRepository) MarkPasswordResetTokenUsed(ctx context.Context, id int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.data.resetTokens[id]
+	if !ok || token.IsUsed {
+		return false, nil
+	}
+	token.IsUsed = true
+	r.data.resetTokens[id] = token
+
+	return true, nil
+}
+
+func (r *UserRepository) StorePendingEmailChange(ctx context.Context, change *domain.EmailChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	change.Attempts = 0
+	if c, ok := r.data.emailChanges[change.UserID]; ok && time.Now().Before(c.ExpiresAt) {
+		change.Attempts = c.Attempts
+	}
+
+	change.CreatedAt = time.Now()
+	r.data.emailChanges[change.UserID] = *change
+	return nil
+}
+
+func (r *UserRepository) GetPendingEmailChange(ctx context.Context, userID uuid.UUID) (*domain.EmailChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	change, ok := r.data.emailChanges[userID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &change, nil
+}
+
+func (r *UserRepository) AddEmailChangeAttempt(ctx context.Context, userID uuid.UUID) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.emailChanges[userID]
+	if !ok {
+		return 0, domain.ErrNotFound
+	}
+	c.Attempts++
+	r.data.emailChanges[userID] = c
+	return c.Attempts, nil
+}
+
+func (r *UserRepository) UsePendingEmailChange(ctx context.Context, userID uuid.UUID, codeHash string, maxAttempts int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.data.emailChanges[userID]
+	if !ok || c.CodeHash != codeHash || c.Attempts > maxAttempts || !time.Now().Before(c.ExpiresAt) {
+		return false, nil
+	}
+	delete(r.data.emailChanges, userID)
+	return true, nil
+}
+
+func (r *UserRepository) RevokeAccessToken(ctx context.Context, accessTokenID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.data.revoked[accessTokenID]; !ok {
+		r.data.revoked[accessTokenID] = expiresAt
+	}
+	return nil
+}
+
+func (r *UserRepository) IsAccessTokenRevoked(ctx context.Context, accessTokenID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiresAt, ok := r.data.revoked[accessTokenID]
+	return ok && expiresAt.After(time.Now()), nil
+}
+
+// deleteUserRows removes the user and everything that references it, like
+// the ON DELETE CASCADE foreign keys in Postgres; mu must be held
+func (r *UserRepository) deleteUserRows(id uuid.UUID) {
+	delete(r.data.users, id)
+	delete(r.data.emailChanges, id)
+	for signature, code := range r.data.codes {
+		if code.UserID == id {
+			delete(r.data.codes, signature)
+		}
+	}
+	r.deleteLoginCodes(id)
+	for tokenID, token := range r.data.tokens {
+		if token.UserID == id {
+			delete(r.data.tokens, tokenID)
+		}
+	}
+	for sessionID, session := range r.data.sessions {
+		if session.UserID == id {
+			delete(r.data.sessions, sessionID)
+		}
+	}
+	for tokenID, token := range r.data.resetTokens {
+		if token.UserID == id {
+			delete(r.data.resetTokens, tokenID)
+		}
+	}
+	for ur := range r.data.userRoles {
+		if ur.UserID == id {
+			delete(r.data.userRoles, ur)
+		}
+	}
+	for key := range r.data.members {
+		if key.UserID == id {
+			delete(r.data.members, key)
+		}
+	}
+	for invID, inv := range r.data.invitations {
+		if inv.InvitedBy == id {
+			delete(r.data.invitations, invID)
+		}
+	}
+	delete(r.data.totp, id)
+	r.deleteRecoveryCodes(id)
+	for challengeID, c := range r.data.challenges {
+		if c.UserID == id {
+			delete(r.data.challenges, challengeID)
+		}
+	}
+	for key, c := range r.data.passkeys {
+		if c.UserID == id {
+			delete(r.data.passkeys, key)
+		}
+	}
+	for key, c := range r.data.webauthnChallenges {
+		if c.UserID.Valid && c.UserID.UUID == id {
+			delete(r.data.webauthnChallenges, key)
+		}
+	}
+}
+
+func matchUser(user domain.User, f domain.UserFilter) bool {
+	switch {
+	case !strings.HasPrefix(user.Email, f.EmailPrefix):
+		return false
+	case f.Confirmed != nil && user.IsConfirmed != *f.Confirmed:
+		return false
+	case f.Deleted != nil && user.DeletedAt.Valid != *f.Deleted:
+		return false
+	case !f.CreatedAfter.IsZero() && user.CreatedAt.Before(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !user.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case f.After != nil && !userBefore(f.After.CreatedAt, f.After.ID, user.CreatedAt, user.ID):
+		return false
+	}
+	return true
+}
+
+// userBefore orders users like ORDER BY created_at, id in Postgres
+func userBefore(createdA time.Time, idA uuid.UUID, createdB time.Time, idB uuid.UUID) bool {
+	if !createdA.Equal(createdB) {
+		return createdA.Before(createdB)
+	}
+	return bytes.Compare(idA[:], idB[:]) < 0
+}
+
+// userByEmail finds soft-deleted users too; mu must be held
+func (r *UserRepository) userByEmail(email string) (domain.User, bool) {
+	for _, user := range r.data.users {
+		if user.Email == email {
+			return user, true
+		}
+	}
+	return domain.User{}, false
+}
+
+// tokenByHash must be called with mu held
+func (r *UserRepository) tokenByHash(hash string) (domain.Token, bool) {
+	for _, token := range r.data.tokens {
+		if token.RefreshTokenHash == hash {
+			return token, true
+		}
+	}
+	return domain.Token{}, false
+}
+
+// revokeSessions revokes the active sessions of userID that match; mu must be held
+func (r *UserRepository) revokeSessions(userID uuid.UUID, match func(domain.Session) bool) {
+	now := time.Now()
+	for id, session := range r.data.sessions {
+		if session.UserID == userID && !session.RevokedAt.Valid && match(session) {
+			session.RevokedAt.Time, session.RevokedAt.Valid = now, true
+			r.data.sessions[id] = session
+		}
+	}
+}
+
+// revokeTokens revokes the unrevoked refresh tokens that match; mu must be held
+func (r *UserRepository) revokeTokens(match func(domain.Token) bool) {
+	now := time.Now()
+	for id, token := range r.data.tokens {
+		if !token.RevokedAt.Valid && match(token) {
+			token.RevokedAt.Time, token.RevokedAt.Valid = now, true
+			r.data.tokens[id] = token
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+	"github.com/Olegnemlii/test123/internal/repository/repositorytest"
+)
+
+func TestUserRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.UserRepository {
+		return NewUserRepository()
+	})
+}
+
+func TestConcurrentCreateUser(t *testing.T) {
+	repo := NewUserRepository()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.WithTx(ctx, func(tx repository.UserRepository) error {
+				_, err := tx.CreateUser(ctx, &domain.User{Email: "user@example.com"})
+				return err
+			})
+			if err == nil {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created.Load() != 1 {
+		t.Fatalf("%d users created with the same email, want 1", created.Load())
+	}
+}
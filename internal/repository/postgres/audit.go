@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+)
+
+func (r *PostgresUserRepository) AppendAuditEntry(ctx context.Context, entry *domain.AuditEntry) error {
+	// SQL для записи вызова Admin сервиса в журнал аудита
+	appendSQL := `
+		INSERT INTO admin_audit_log (actor_id, action, target_id, details, error)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRowContext(ctx, appendSQL, entry.ActorID, entry.Action, entry.TargetID, entry.Details, entry.Error).
+		Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to append audit entry: %v", err)
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) ListAuditEntries(ctx context.Context, limit int) ([]*domain.AuditEntry, error) {
+	// SQL для получения последних записей журнала аудита
+	listSQL := `
+		SELECT id, actor_id, action, target_id, details, error, created_at
+		FROM admin_audit_log
+		ORDER BY id DESC
+		LIMIT $1
+	`
+	rows, err := r.db.QueryContext(ctx, listSQL, limit)
+	if err != nil {
+		log.Printf("Failed to list audit entries: %v", err)
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*domain.AuditEntry
+	for rows.Next() {
+		var e domain.AuditEntry
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetID, &e.Details, &e.Error, &e.CreatedAt); err != nil {
+			log.Printf("Failed to scan audit entry: %v", err)
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list audit entries: %v", err)
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,39 @@
+package postgres
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// isRetryable reports whether err is a serialization failure or a deadlock,
+// after which the whole transaction can safely be run again
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "40001", // serialization_failure
+		"40P01": // deadlock_detected
+		return true
+	}
+	return false
+}
+
+// isUniqueViolation reports whether err is a unique_violation. Callers can
+// collide on users.email and on the primary key of organization_members.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// violatedForeignKey returns the name of the constraint if err is a
+// foreign_key_violation
+func violatedForeignKey(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return pqErr.Constraint, true
+	}
+	return "", false
+}
@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+func (r *PostgresUserRepository) StoreLoginCode(ctx context.Context, code *domain.LoginCode) error {
+	// SQL для сохранения кода входа; прежние коды пользователя удаляются,
+	// попытки действующего кода переносятся на новый
+	storeCodeSQL := `
+		WITH replaced AS (
+			DELETE FROM login_codes WHERE user_id = $2
+			RETURNING attempts, is_used, expires_at
+		)
+		INSERT INTO login_codes (signature, user_id, code_hash, link_hash, attempts, expires_at)
+		VALUES ($1, $2, $3, $4, (
+			SELECT COALESCE(MAX(attempts), 0) FROM replaced WHERE NOT is_used AND expires_at > NOW()
+		), $5)
+		RETURNING attempts, is_used, created_at
+	`
+	err := r.db.QueryRowContext(ctx, storeCodeSQL, code.Signature, code.UserID, code.CodeHash, code.LinkHash, code.ExpiresAt).
+		Scan(&code.Attempts, &code.IsUsed, &code.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to store login code: %v", err)
+		return fmt.Errorf("failed to store login code: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) GetLoginCode(ctx context.Context, userID uuid.UUID) (*domain.LoginCode, error) {
+	// SQL для получения текущего кода входа пользователя
+	getCodeSQL := `
+		SELECT signature, user_id, code_hash, link_hash, attempts, is_used, expires_at, created_at
+		FROM login_codes
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	return r.getLoginCode(ctx, getCodeSQL, userID)
+}
+
+func (r *PostgresUserRepository) GetLoginCodeByLink(ctx context.Context, linkHash string) (*domain.LoginCode, error) {
+	// SQL для получения кода входа по хэшу ссылки из письма
+	getCodeSQL := `
+		SELECT signature, user_id, code_hash, link_hash, attempts, is_used, expires_at, created_at
+		FROM login_codes
+		WHERE link_hash = $1
+	`
+	return r.getLoginCode(ctx, getCodeSQL, linkHash)
+}
+
+func (r *PostgresUserRepository) getLoginCode(ctx context.Context, query string, arg any) (*domain.LoginCode, error) {
+	var c domain.LoginCode
+	err := r.db.QueryRowContext(ctx, query, arg).
+		Scan(&c.Signature, &c.UserID, &c.CodeHash, &c.LinkHash, &c.Attempts, &c.IsUsed, &c.ExpiresAt, &c.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get login code: %v", err)
+		return nil, fmt.Errorf("failed to get login code: %w", err)
+	}
+
+	return &c, nil
+}
+
+func (r *PostgresUserRepository) AddLoginCodeAttempt(ctx context.Context, signature uuid.UUID) (int, error) {
+	// SQL для учёта введённого кода входа
+	addAttemptSQL := `
+		UPDATE login_codes
+		SET attempts = attempts + 1
+		WHERE signature = $1
+		RETURNING attempts
+	`
+	var attempts int
+	err := r.db.QueryRowContext(ctx, addAttemptSQL, signature).Scan(&attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.ErrNotFound
+		}
+		log.Printf("Failed to add login code attempt: %v", err)
+		return 0, fmt.Errorf("failed to add login code attempt: %w", err)
+	}
+
+	return attempts, nil
+}
+
+func (r *PostgresUserRepository) UseLoginCode(ctx context.Context, signature uuid.UUID, maxAttempts int) (bool, error) {
+	// SQL для погашения кода входа; использовать его можно один раз
+	useCodeSQL := `
+		UPDATE login_codes
+		SET is_used = true
+		WHERE signature = $1 AND NOT is_used AND attempts <= $2 AND expires_at > NOW()
+	`
+	return r.execUpdated(ctx, "use login code", useCodeSQL, signature, maxAttempts)
+}
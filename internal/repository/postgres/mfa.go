@@ -0,0 +1,249 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func (r *PostgresUserRepository) StoreTOTP(ctx context.Context, credential *domain.TOTPCredential) error {
+	// SQL для сохранения нового TOTP секрета; подтверждённый секрет не заменяется,
+	// попытки заменённого сохраняются
+	storeTOTPSQL := `
+		INSERT INTO user_totp (user_id, encrypted_secret, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET encrypted_secret = EXCLUDED.encrypted_secret, last_used_step = 0, created_at = EXCLUDED.created_at
+		WHERE user_totp.confirmed_at IS NULL
+		RETURNING attempts, last_attempt_at
+	`
+	err := r.db.QueryRowContext(ctx, storeTOTPSQL, credential.UserID, credential.EncryptedSecret, credential.CreatedAt).
+		Scan(&credential.Attempts, &credential.LastAttemptAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrTOTPAlreadyEnabled
+		}
+		log.Printf("Failed to store TOTP: %v", err)
+		return fmt.Errorf("failed to store TOTP: %w", err)
+	}
+
+	credential.ConfirmedAt = sql.NullTime{}
+	credential.LastUsedStep = 0
+	return nil
+}
+
+func (r *PostgresUserRepository) GetTOTP(ctx context.Context, userID uuid.UUID) (*domain.TOTPCredential, error) {
+	// SQL для получения TOTP секрета пользователя
+	getTOTPSQL := `
+		SELECT user_id, encrypted_secret, confirmed_at, last_used_step, attempts, last_attempt_at, created_at
+		FROM user_totp
+		WHERE user_id = $1
+	`
+	var c domain.TOTPCredential
+	err := r.db.QueryRowContext(ctx, getTOTPSQL, userID).
+		Scan(&c.UserID, &c.EncryptedSecret, &c.ConfirmedAt, &c.LastUsedStep, &c.Attempts, &c.LastAttemptAt, &c.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get TOTP: %v", err)
+		return nil, fmt.Errorf("failed to get TOTP: %w", err)
+	}
+
+	return &c, nil
+}
+
+func (r *PostgresUserRepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	// SQL для подтверждения TOTP первым кодом из приложения
+	confirmTOTPSQL := `
+		UPDATE user_totp
+		SET confirmed_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL
+	`
+	return r.execUpdated(ctx, "confirm TOTP", confirmTOTPSQL, userID, step)
+}
+
+func (r *PostgresUserRepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	// SQL для отметки шага TOTP использованным; повтор того же или более раннего шага отклоняется
+	useStepSQL := `
+		UPDATE user_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+	`
+	return r.execUpdated(ctx, "use TOTP step", useStepSQL, userID, step)
+}
+
+func (r *PostgresUserRepository) AddTOTPAttempt(ctx context.Context, userID uuid.UUID, since time.Time) (int, error) {
+	// SQL для учёта введённого кода второго фактора; после паузы счёт начинается заново
+	addAttemptSQL := `
+		UPDATE user_totp
+		SET attempts = CASE WHEN last_attempt_at >= $2 THEN attempts + 1 ELSE 1 END, last_attempt_at = NOW()
+		WHERE user_id = $1
+		RETURNING attempts
+	`
+	var attempts int
+	err := r.db.QueryRowContext(ctx, addAttemptSQL, userID, since).Scan(&attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.ErrNotFound
+		}
+		log.Printf("Failed to add TOTP attempt: %v", err)
+		return 0, fmt.Errorf("failed to add TOTP attempt: %w", err)
+	}
+
+	return attempts, nil
+}
+
+func (r *PostgresUserRepository) ResetTOTPAttempts(ctx context.Context, userID uuid.UUID) error {
+	// SQL для сброса попыток после верного кода второго фактора
+	resetAttemptsSQL := `
+		UPDATE user_totp
+		SET attempts = 0
+		WHERE user_id = $1
+	`
+	_, err := r.db.ExecContext(ctx, resetAttemptsSQL, userID)
+	if err != nil {
+		log.Printf("Failed to reset TOTP attempts: %v", err)
+		return fmt.Errorf("failed to reset TOTP attempts: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	// SQL для отключения TOTP вместе с кодами восстановления
+	deleteTOTPSQL := `
+		WITH codes AS (
+			DELETE FROM recovery_codes WHERE user_id = $1
+		)
+		DELETE FROM user_totp WHERE user_id = $1
+	`
+	_, err := r.db.ExecContext(ctx, deleteTOTPSQL, userID)
+	if err != nil {
+		log.Printf("Failed to delete TOTP: %v", err)
+		return fmt.Errorf("failed to delete TOTP: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	// SQL для замены кодов восстановления пользователя
+	replaceCodesSQL := `
+		WITH replaced AS (
+			DELETE FROM recovery_codes WHERE user_id = $1
+		)
+		INSERT INTO recovery_codes (user_id, code_hash)
+		SELECT $1::uuid, unnest($2::varchar[])
+	`
+	_, err := r.db.ExecContext(ctx, replaceCodesSQL, userID, pq.Array(codeHashes))
+	if err != nil {
+		log.Printf("Failed to replace recovery codes: %v", err)
+		return fmt.Errorf("failed to replace recovery codes: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	// SQL для погашения кода восстановления
+	useCodeSQL := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	return r.execUpdated(ctx, "use recovery code", useCodeSQL, userID, codeHash)
+}
+
+func (r *PostgresUserRepository) StoreMFAChallenge(ctx context.Context, challenge *domain.MFAChallenge) error {
+	// SQL для сохранения второго шага входа
+	storeChallengeSQL := `
+		INSERT INTO mfa_challenges (token_hash, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRowContext(ctx, storeChallengeSQL, challenge.TokenHash, challenge.UserID, challenge.ExpiresAt).
+		Scan(&challenge.ID, &challenge.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to store MFA challenge: %v", err)
+		return fmt.Errorf("failed to store MFA challenge: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) GetMFAChallenge(ctx context.Context, tokenHash string) (*domain.MFAChallenge, error) {
+	// SQL для получения второго шага входа по хэшу токена
+	getChallengeSQL := `
+		SELECT id, token_hash, user_id, attempts, expires_at, completed_at, created_at
+		FROM mfa_challenges
+		WHERE token_hash = $1
+	`
+	var c domain.MFAChallenge
+	err := r.db.QueryRowContext(ctx, getChallengeSQL, tokenHash).
+		Scan(&c.ID, &c.TokenHash, &c.UserID, &c.Attempts, &c.ExpiresAt, &c.CompletedAt, &c.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get MFA challenge: %v", err)
+		return nil, fmt.Errorf("failed to get MFA challenge: %w", err)
+	}
+
+	return &c, nil
+}
+
+func (r *PostgresUserRepository) AddMFAChallengeAttempt(ctx context.Context, id int64) (int, error) {
+	// SQL для учёта попытки второго шага
+	addAttemptSQL := `
+		UPDATE mfa_challenges
+		SET attempts = attempts + 1
+		WHERE id = $1
+		RETURNING attempts
+	`
+	var attempts int
+	err := r.db.QueryRowContext(ctx, addAttemptSQL, id).Scan(&attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.ErrNotFound
+		}
+		log.Printf("Failed to add MFA challenge attempt: %v", err)
+		return 0, fmt.Errorf("failed to add MFA challenge attempt: %w", err)
+	}
+
+	return attempts, nil
+}
+
+func (r *PostgresUserRepository) CompleteMFAChallenge(ctx context.Context, id int64, maxAttempts int) (bool, error) {
+	// SQL для завершения второго шага входа; завершить его можно один раз
+	completeChallengeSQL := `
+		UPDATE mfa_challenges
+		SET completed_at = NOW()
+		WHERE id = $1 AND completed_at IS NULL AND attempts <= $2 AND expires_at > NOW()
+	`
+	return r.execUpdated(ctx, "complete MFA challenge", completeChallengeSQL, id, maxAttempts)
+}
+
+// execUpdated runs an UPDATE and reports whether it changed a row
+func (r *PostgresUserRepository) execUpdated(ctx context.Context, action, query string, args ...any) (bool, error) {
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Failed to %s: %v", action, err)
+		return false, fmt.Errorf("failed to %s: %w", action, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to %s: %w", action, err)
+	}
+
+	return affected == 1, nil
+}
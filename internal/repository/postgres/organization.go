@@ -0,0 +1,240 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+func (r *PostgresUserRepository) CreateOrganization(ctx context.Context, org *domain.Organization) error {
+	// SQL для создания организации
+	createOrganizationSQL := `
+		INSERT INTO organizations (id, name, created_at)
+		VALUES ($1, $2, $3)
+	`
+	id := uuid.New()
+	_, err := r.db.ExecContext(ctx, createOrganizationSQL, id, org.Name, org.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to create organization: %v", err)
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	org.ID = id
+	return nil
+}
+
+func (r *PostgresUserRepository) GetOrganization(ctx context.Context, id uuid.UUID) (*domain.Organization, error) {
+	// SQL для получения организации по ID
+	getOrganizationSQL := `
+		SELECT id, name, created_at
+		FROM organizations
+		WHERE id = $1
+	`
+	var org domain.Organization
+	err := r.db.QueryRowContext(ctx, getOrganizationSQL, id).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrOrganizationNotFound
+		}
+		log.Printf("Failed to get organization: %v", err)
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	return &org, nil
+}
+
+func (r *PostgresUserRepository) AddMember(ctx context.Context, member *domain.Membership) error {
+	// SQL для добавления участника в организацию
+	addMemberSQL := `
+		INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := r.db.ExecContext(ctx, addMemberSQL, member.OrganizationID, member.UserID, member.Role, member.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrAlreadyMember
+		}
+		if constraint, ok := violatedForeignKey(err); ok {
+			if constraint == "organization_members_user_id_fkey" {
+				return domain.ErrUserNotFound
+			}
+			return domain.ErrOrganizationNotFound
+		}
+		log.Printf("Failed to add member: %v", err)
+		return fmt.Errorf("failed to add member: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) GetMembership(ctx context.Context, orgID, userID uuid.UUID) (*domain.Membership, error) {
+	// SQL для получения участия пользователя в организации
+	getMembershipSQL := `
+		SELECT organization_id, user_id, role, created_at
+		FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+	`
+	var m domain.Membership
+	err := r.db.QueryRowContext(ctx, getMembershipSQL, orgID, userID).Scan(&m.OrganizationID, &m.UserID, &m.Role, &m.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get membership: %v", err)
+		return nil, fmt.Errorf("failed to get membership: %w", err)
+	}
+
+	return &m, nil
+}
+
+func (r *PostgresUserRepository) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*domain.Membership, error) {
+	// SQL для получения участников организации
+	listMembersSQL := `
+		SELECT organization_id, user_id, role, created_at
+		FROM organization_members
+		WHERE organization_id = $1
+		ORDER BY created_at, user_id
+	`
+	return r.queryMemberships(ctx, listMembersSQL, orgID)
+}
+
+func (r *PostgresUserRepository) ListMemberships(ctx context.Context, userID uuid.UUID) ([]*domain.Membership, error) {
+	// SQL для получения организаций пользователя
+	listMembershipsSQL := `
+		SELECT organization_id, user_id, role, created_at
+		FROM organization_members
+		WHERE user_id = $1
+		ORDER BY created_at, organization_id
+	`
+	return r.queryMemberships(ctx, listMembershipsSQL, userID)
+}
+
+func (r *PostgresUserRepository) queryMemberships(ctx context.Context, query string, args ...any) ([]*domain.Membership, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Failed to list memberships: %v", err)
+		return nil, fmt.Errorf("failed to list memberships: %w", err)
+	}
+	defer rows.Close()
+
+	var memberships []*domain.Membership
+	for rows.Next() {
+		var m domain.Membership
+		if err := rows.Scan(&m.OrganizationID, &m.UserID, &m.Role, &m.CreatedAt); err != nil {
+			log.Printf("Failed to scan membership: %v", err)
+			return nil, fmt.Errorf("failed to scan membership: %w", err)
+		}
+		memberships = append(memberships, &m)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list memberships: %v", err)
+		return nil, fmt.Errorf("failed to list memberships: %w", err)
+	}
+
+	return memberships, nil
+}
+
+func (r *PostgresUserRepository) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	// SQL для удаления участника; сессии, работающие в организации, из неё выходят
+	removeMemberSQL := `
+		WITH cleared_sessions AS (
+			UPDATE sessions SET organization_id = NULL
+			WHERE user_id = $2 AND organization_id = $1
+		)
+		DELETE FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2
+	`
+	_, err := r.db.ExecContext(ctx, removeMemberSQL, orgID, userID)
+	if err != nil {
+		log.Printf("Failed to remove member: %v", err)
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) StoreInvitation(ctx context.Context, invitation *domain.Invitation) error {
+	// SQL для сохранения приглашения; прежние неиспользованные приглашения
+	// того же адреса в ту же организацию удаляются
+	storeInvitationSQL := `
+		WITH replaced AS (
+			DELETE FROM organization_invitations
+			WHERE organization_id = $1 AND email = $2 AND accepted_at IS NULL
+		)
+		INSERT INTO organization_invitations (organization_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRowContext(ctx, storeInvitationSQL, invitation.OrganizationID, invitation.Email, invitation.Role, invitation.TokenHash, invitation.InvitedBy, invitation.ExpiresAt).
+		Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to store invitation: %v", err)
+		return fmt.Errorf("failed to store invitation: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) GetInvitation(ctx context.Context, tokenHash string) (*domain.Invitation, error) {
+	// SQL для получения приглашения по хэшу токена
+	getInvitationSQL := `
+		SELECT id, organization_id, email, role, token_hash, invited_by, expires_at, accepted_at, created_at
+		FROM organization_invitations
+		WHERE token_hash = $1
+	`
+	var inv domain.Invitation
+	err := r.db.QueryRowContext(ctx, getInvitationSQL, tokenHash).
+		Scan(&inv.ID, &inv.OrganizationID, &inv.Email, &inv.Role, &inv.TokenHash, &inv.InvitedBy, &inv.ExpiresAt, &inv.AcceptedAt, &inv.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get invitation: %v", err)
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+
+	return &inv, nil
+}
+
+func (r *PostgresUserRepository) MarkInvitationAccepted(ctx context.Context, id int64) (bool, error) {
+	// SQL для отметки приглашения принятым; принятое приглашение не меняется
+	markAcceptedSQL := `
+		UPDATE organization_invitations
+		SET accepted_at = NOW()
+		WHERE id = $1 AND accepted_at IS NULL
+	`
+	res, err := r.db.ExecContext(ctx, markAcceptedSQL, id)
+	if err != nil {
+		log.Printf("Failed to mark invitation accepted: %v", err)
+		return false, fmt.Errorf("failed to mark invitation accepted: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark invitation accepted: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (r *PostgresUserRepository) SetSessionOrganization(ctx context.Context, sessionID uuid.UUID, orgID uuid.NullUUID) error {
+	// SQL для смены организации, в которой работает сессия
+	setOrganizationSQL := `
+		UPDATE sessions
+		SET organization_id = $2
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, setOrganizationSQL, sessionID, orgID)
+	if err != nil {
+		log.Printf("Failed to set session organization: %v", err)
+		return fmt.Errorf("failed to set session organization: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+)
+
+const outboxColumns = `id, recipient, subject, body, html, status, attempts, next_attempt_at, last_error, created_at, sent_at`
+
+func (r *PostgresUserRepository) EnqueueEmail(ctx context.Context, email *domain.OutboxEmail) error {
+	// SQL для постановки письма в очередь
+	enqueueSQL := `
+		INSERT INTO email_outbox (recipient, subject, body, html)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, status, attempts, next_attempt_at, created_at
+	`
+	err := r.db.QueryRowContext(ctx, enqueueSQL, email.Recipient, email.Subject, email.Body, email.HTML).
+		Scan(&email.ID, &email.Status, &email.Attempts, &email.NextAttemptAt, &email.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to enqueue email: %v", err)
+		return fmt.Errorf("failed to enqueue email: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) ClaimOutboxEmails(ctx context.Context, limit int, leaseUntil time.Time) ([]*domain.OutboxEmail, error) {
+	// SQL для захвата писем, готовых к отправке. SKIP LOCKED позволяет
+	// нескольким воркерам разбирать очередь, не блокируя друг друга
+	claimSQL := `
+		UPDATE email_outbox
+		SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns
+
+	return r.queryOutbox(ctx, "claim outbox emails", claimSQL, limit, leaseUntil)
+}
+
+func (r *PostgresUserRepository) MarkOutboxEmailSent(ctx context.Context, id int64) error {
+	// SQL для пометки письма как отправленного
+	markSentSQL := `
+		UPDATE email_outbox
+		SET status = 'sent', sent_at = NOW(), last_error = NULL
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, markSentSQL, id)
+	if err != nil {
+		log.Printf("Failed to mark outbox email as sent: %v", err)
+		return fmt.Errorf("failed to mark outbox email as sent: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) RetryOutboxEmail(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	// SQL для переноса отправки письма на более позднее время
+	retrySQL := `
+		UPDATE email_outbox
+		SET last_error = $2, next_attempt_at = $3
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, retrySQL, id, lastError, nextAttemptAt)
+	if err != nil {
+		log.Printf("Failed to reschedule outbox email: %v", err)
+		return fmt.Errorf("failed to reschedule outbox email: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) DeadLetterOutboxEmail(ctx context.Context, id int64, lastError string) error {
+	// SQL для перевода письма в dead letter
+	deadLetterSQL := `
+		UPDATE email_outbox
+		SET status = 'dead', last_error = $2
+		WHERE id = $1
+	`
+	_, err := r.db.ExecContext(ctx, deadLetterSQL, id, lastError)
+	if err != nil {
+		log.Printf("Failed to dead-letter outbox email: %v", err)
+		return fmt.Errorf("failed to dead-letter outbox email: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) ListDeadLetters(ctx context.Context, limit int) ([]*domain.OutboxEmail, error) {
+	// SQL для получения писем, которые не удалось доставить
+	listSQL := `
+		SELECT ` + outboxColumns + `
+		FROM email_outbox
+		WHERE status = 'dead'
+		ORDER BY id
+		LIMIT $1
+	`
+
+	return r.queryOutbox(ctx, "list dead letters", listSQL, limit)
+}
+
+func (r *PostgresUserRepository) RequeueDeadLetter(ctx context.Context, id int64) (bool, error) {
+	// SQL для возврата письма в очередь со сбросом счётчика попыток
+	requeueSQL := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+	`
+	res, err := r.db.ExecContext(ctx, requeueSQL, id)
+	if err != nil {
+		log.Printf("Failed to requeue dead letter: %v", err)
+		return false, fmt.Errorf("failed to requeue dead letter: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to requeue dead letter: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (r *PostgresUserRepository) queryOutbox(ctx context.Context, action, query string, args ...any) ([]*domain.OutboxEmail, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Failed to %s: %v", action, err)
+		return nil, fmt.Errorf("failed to %s: %w", action, err)
+	}
+	defer rows.Close()
+
+	var emails []*domain.OutboxEmail
+	for rows.Next() {
+		var e domain.OutboxEmail
+		err := rows.Scan(&e.ID, &e.Recipient, &e.Subject, &e.Body, &e.HTML, &e.Status, &e.Attempts, &e.NextAttemptAt, &e.LastError, &e.CreatedAt, &e.SentAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to %s: %w", action, err)
+		}
+		emails = append(emails, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to %s: %w", action, err)
+	}
+
+	return emails, nil
+}
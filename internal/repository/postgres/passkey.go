@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func (r *PostgresUserRepository) CreatePasskey(ctx context.Context, credential *domain.PasskeyCredential) error {
+	// SQL для сохранения нового ключа доступа
+	createPasskeySQL := `
+		INSERT INTO credentials (id, user_id, user_handle, public_key, attestation_type, aaguid, transports, sign_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at
+	`
+	err := r.db.QueryRowContext(ctx, createPasskeySQL,
+		credential.ID, credential.UserID, credential.UserHandle, credential.PublicKey,
+		credential.AttestationType, credential.AAGUID, pq.Array(credential.Transports), int64(credential.SignCount),
+	).Scan(&credential.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrPasskeyExists
+		}
+		log.Printf("Failed to create passkey: %v", err)
+		return fmt.Errorf("failed to create passkey: %w", err)
+	}
+
+	credential.LastUsedAt = sql.NullTime{}
+	return nil
+}
+
+const passkeyColumns = `id, user_id, user_handle, public_key, attestation_type, aaguid, transports, sign_count, created_at, last_used_at`
+
+func (r *PostgresUserRepository) GetPasskey(ctx context.Context, credentialID []byte) (*domain.PasskeyCredential, error) {
+	// SQL для получения ключа доступа по идентификатору
+	getPasskeySQL := `SELECT ` + passkeyColumns + ` FROM credentials WHERE id = $1`
+
+	c, err := scanPasskey(r.db.QueryRowContext(ctx, getPasskeySQL, credentialID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get passkey: %v", err)
+		return nil, fmt.Errorf("failed to get passkey: %w", err)
+	}
+
+	return c, nil
+}
+
+func (r *PostgresUserRepository) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]*domain.PasskeyCredential, error) {
+	// SQL для получения ключей доступа пользователя
+	listPasskeysSQL := `SELECT ` + passkeyColumns + ` FROM credentials WHERE user_id = $1 ORDER BY created_at, id`
+
+	rows, err := r.db.QueryContext(ctx, listPasskeysSQL, userID)
+	if err != nil {
+		log.Printf("Failed to list passkeys: %v", err)
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var credentials []*domain.PasskeyCredential
+	for rows.Next() {
+		c, err := scanPasskey(rows)
+		if err != nil {
+			log.Printf("Failed to scan passkey: %v", err)
+			return nil, fmt.Errorf("failed to scan passkey: %w", err)
+		}
+		credentials = append(credentials, c)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list passkeys: %v", err)
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+
+	return credentials, nil
+}
+
+func (r *PostgresUserRepository) UsePasskey(ctx context.Context, credentialID []byte, signCount uint32) (bool, error) {
+	// SQL для сохранения счётчика подписей после входа; счётчик не может уменьшаться
+	usePasskeySQL := `
+		UPDATE credentials
+		SET sign_count = $2, last_used_at = NOW()
+		WHERE id = $1 AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0))
+	`
+	return r.execUpdated(ctx, "use passkey", usePasskeySQL, credentialID, int64(signCount))
+}
+
+func (r *PostgresUserRepository) StoreWebAuthnChallenge(ctx context.Context, challenge *domain.WebAuthnChallenge) error {
+	// SQL для сохранения состояния церемонии WebAuthn
+	storeChallengeSQL := `
+		INSERT INTO webauthn_challenges (challenge, ceremony, user_id, session_data, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at
+	`
+	err := r.db.QueryRowContext(ctx, storeChallengeSQL,
+		challenge.Challenge, challenge.Ceremony, challenge.UserID, challenge.SessionData, challenge.ExpiresAt,
+	).Scan(&challenge.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to store WebAuthn challenge: %v", err)
+		return fmt.Errorf("failed to store WebAuthn challenge: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) TakeWebAuthnChallenge(ctx context.Context, challenge string) (*domain.WebAuthnChallenge, error) {
+	// SQL для одноразового получения состояния церемонии WebAuthn
+	takeChallengeSQL := `
+		DELETE FROM webauthn_challenges
+		WHERE challenge = $1
+		RETURNING challenge, ceremony, user_id, session_data, expires_at, created_at
+	`
+	var c domain.WebAuthnChallenge
+	err := r.db.QueryRowContext(ctx, takeChallengeSQL, challenge).
+		Scan(&c.Challenge, &c.Ceremony, &c.UserID, &c.SessionData, &c.ExpiresAt, &c.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to take WebAuthn challenge: %v", err)
+		return nil, fmt.Errorf("failed to take WebAuthn challenge: %w", err)
+	}
+
+	return &c, nil
+}
+
+// scanPasskey reads a row selected with passkeyColumns
+func scanPasskey(row interface{ Scan(...any) error }) (*domain.PasskeyCredential, error) {
+	var (
+		c         domain.PasskeyCredential
+		signCount int64
+	)
+	err := row.Scan(&c.ID, &c.UserID, &c.UserHandle, &c.PublicKey, &c.AttestationType, &c.AAGUID,
+		pq.Array(&c.Transports), &signCount, &c.CreatedAt, &c.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.SignCount = uint32(signCount)
+	return &c, nil
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+func (r *PostgresUserRepository) ListRoles(ctx context.Context) ([]*domain.Role, error) {
+	// SQL для получения ролей вместе с их правами
+	listRolesSQL := `
+		SELECT r.name, r.description, rp.permission
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		ORDER BY r.name, rp.permission
+	`
+	rows, err := r.db.QueryContext(ctx, listRolesSQL)
+	if err != nil {
+		log.Printf("Failed to list roles: %v", err)
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []*domain.Role
+	for rows.Next() {
+		var name, description string
+		var permission sql.NullString
+		if err := rows.Scan(&name, &description, &permission); err != nil {
+			log.Printf("Failed to scan role: %v", err)
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, &domain.Role{Name: name, Description: description})
+		}
+		if permission.Valid {
+			role := roles[len(roles)-1]
+			role.Permissions = append(role.Permissions, permission.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list roles: %v", err)
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+func (r *PostgresUserRepository) AssignRole(ctx context.Context, userID uuid.UUID, role string) error {
+	// SQL для назначения роли пользователю
+	assignRoleSQL := `
+		INSERT INTO user_roles (user_id, role)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, role) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, assignRoleSQL, userID, role)
+	if err != nil {
+		if constraint, ok := violatedForeignKey(err); ok {
+			if constraint == "user_roles_role_fkey" {
+				return domain.ErrRoleNotFound
+			}
+			return domain.ErrUserNotFound
+		}
+		log.Printf("Failed to assign role: %v", err)
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) RevokeRole(ctx context.Context, userID uuid.UUID, role string) error {
+	// SQL для отзыва роли у пользователя
+	revokeRoleSQL := `
+		DELETE FROM user_roles
+		WHERE user_id = $1 AND role = $2
+	`
+	_, err := r.db.ExecContext(ctx, revokeRoleSQL, userID, role)
+	if err != nil {
+		log.Printf("Failed to revoke role: %v", err)
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	// SQL для получения ролей пользователя
+	getUserRolesSQL := `
+		SELECT role
+		FROM user_roles
+		WHERE user_id = $1
+		ORDER BY role
+	`
+	return r.queryStrings(ctx, "user roles", getUserRolesSQL, userID)
+}
+
+func (r *PostgresUserRepository) GetUserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	// SQL для получения прав пользователя по всем его ролям
+	getUserPermissionsSQL := `
+		SELECT DISTINCT rp.permission
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role = ur.role
+		WHERE ur.user_id = $1
+		ORDER BY rp.permission
+	`
+	return r.queryStrings(ctx, "user permissions", getUserPermissionsSQL, userID)
+}
+
+// queryStrings runs a query that selects a single text column; what names
+// the rows in errors
+func (r *PostgresUserRepository) queryStrings(ctx context.Context, what, query string, args ...any) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Failed to get %s: %v", what, err)
+		return nil, fmt.Errorf("failed to get %s: %w", what, err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			log.Printf("Failed to scan %s: %v", what, err)
+			return nil, fmt.Errorf("failed to scan %s: %w", what, err)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to get %s: %v", what, err)
+		return nil, fmt.Errorf("failed to get %s: %w", what, err)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/repository"
+)
+
+const (
+	// maxTxAttempts bounds how often a transaction is retried after a serialization failure
+	maxTxAttempts = 3
+	txRetryDelay  = 20 * time.Millisecond
+)
+
+func (r *PostgresUserRepository) WithTx(ctx context.Context, fn func(repo repository.UserRepository) error, opts ...repository.TxOption) error {
+	// Already inside a transaction: join it
+	if r.conn == nil {
+		return fn(r)
+	}
+
+	txOpts := repository.NewTxOptions(opts...)
+	for attempt := 1; ; attempt++ {
+		err := r.runTx(ctx, txOpts, fn)
+		if err == nil || !isRetryable(err) || attempt == maxTxAttempts {
+			return err
+		}
+
+		log.Printf("retrying transaction after serialization failure (attempt %d): %v", attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * txRetryDelay):
+		}
+	}
+}
+
+func (r *PostgresUserRepository) runTx(ctx context.Context, opts *sql.TxOptions, fn func(repo repository.UserRepository) error) error {
+	tx, err := r.conn.BeginTx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(&PostgresUserRepository{db: tx}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("Failed to roll back transaction: %v", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -3,8 +3,10 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/Olegnemlii/test123/internal/domain"
@@ -13,12 +15,21 @@ import (
 	"github.com/google/uuid"
 )
 
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type PostgresUserRepository struct {
-	db *sql.DB
+	db querier
+	// conn is nil for a repository bound to a transaction
+	conn *sql.DB
 }
 
 func NewPostgresUserRepository(db *sql.DB) repository.UserRepository {
-	return &PostgresUserRepository{db: db}
+	return &PostgresUserRepository{db: db, conn: db}
 }
 
 func (r *PostgresUserRepository) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
@@ -33,6 +44,9 @@ func (r *PostgresUserRepository) CreateUser(ctx context.Context, user *domain.Us
 
 	_, err := r.db.ExecContext(ctx, insertUserSQL, id, user.Email, user.Password, user.CreatedAt, user.UpdatedAt, user.IsConfirmed)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, domain.ErrEmailTaken
+		}
 		log.Printf("Failed to insert user: %v", err)
 		return nil, fmt.Errorf("failed to insert user: %w", err)
 	}
@@ -45,13 +59,16 @@ func (r *PostgresUserRepository) CreateUser(ctx context.Context, user *domain.Us
 func (r *PostgresUserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
 	// SQL для получения пользователя по ID
 	getUserSQL := `
-		SELECT id, email, password, created_at, updated_at, deleted_at, is_confirmed
+		SELECT id, email, password, created_at, updated_at, deleted_at, disabled_at, is_confirmed
 		FROM users
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 	var user domain.User
-	err := r.db.QueryRowContext(ctx, getUserSQL, id).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.IsConfirmed)
+	err := r.db.QueryRowContext(ctx, getUserSQL, id).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.DisabledAt, &user.IsConfirmed)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrUserNotFound
+		}
 		log.Printf("Failed to get user by ID: %v", err)
 		return nil, fmt.Errorf("failed to get user by ID: %w", err)
 	}
@@ -62,13 +79,16 @@ func (r *PostgresUserRepository) GetUserByID(ctx context.Context, id uuid.UUID)
 func (r *PostgresUserRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
 	// SQL для получения пользователя по email
 	getUserSQL := `
-		SELECT id, email, password, created_at, updated_at, deleted_at, is_confirmed
+		SELECT id, email, password, created_at, updated_at, deleted_at, disabled_at, is_confirmed
 		FROM users
-		WHERE email = $1
+		WHERE email = $1 AND deleted_at IS NULL
 	`
 	var user domain.User
-	err := r.db.QueryRowContext(ctx, getUserSQL, email).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.IsConfirmed)
+	err := r.db.QueryRowContext(ctx, getUserSQL, email).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.DisabledAt, &user.IsConfirmed)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrUserNotFound
+		}
 		log.Printf("Failed to get user by email: %v", err)
 		return nil, fmt.Errorf("failed to get user by email: %w", err)
 	}
@@ -76,15 +96,148 @@ func (r *PostgresUserRepository) GetUserByEmail(ctx context.Context, email strin
 	return &user, nil
 }
 
+func (r *PostgresUserRepository) GetDeletedUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	// SQL для получения удалённого пользователя по email
+	getUserSQL := `
+		SELECT id, email, password, created_at, updated_at, deleted_at, disabled_at, is_confirmed
+		FROM users
+		WHERE email = $1 AND deleted_at IS NOT NULL
+	`
+	var user domain.User
+	err := r.db.QueryRowContext(ctx, getUserSQL, email).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.DisabledAt, &user.IsConfirmed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrUserNotFound
+		}
+		log.Printf("Failed to get deleted user by email: %v", err)
+		return nil, fmt.Errorf("failed to get deleted user by email: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *PostgresUserRepository) FindUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	// SQL для получения пользователя по ID, в том числе удалённого
+	getUserSQL := `
+		SELECT id, email, password, created_at, updated_at, deleted_at, disabled_at, is_confirmed
+		FROM users
+		WHERE id = $1
+	`
+	var user domain.User
+	err := r.db.QueryRowContext(ctx, getUserSQL, id).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.DisabledAt, &user.IsConfirmed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrUserNotFound
+		}
+		log.Printf("Failed to find user by ID: %v", err)
+		return nil, fmt.Errorf("failed to find user by ID: %w", err)
+	}
+
+	return &user, nil
+}
+
+func (r *PostgresUserRepository) GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error) {
+	// SQL для получения хэша пароля пользователя, в том числе удалённого
+	getHashSQL := `
+		SELECT password
+		FROM users
+		WHERE id = $1
+	`
+	var hash string
+	err := r.db.QueryRowContext(ctx, getHashSQL, id).Scan(&hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", domain.ErrUserNotFound
+		}
+		log.Printf("Failed to get password hash: %v", err)
+		return "", fmt.Errorf("failed to get password hash: %w", err)
+	}
+
+	return hash, nil
+}
+
+func (r *PostgresUserRepository) ListUsers(ctx context.Context, filter domain.UserFilter) ([]*domain.User, error) {
+	// SQL для постраничного списка пользователей; условия добавляются по фильтру
+	var conds []string
+	var args []any
+	where := func(cond string, values ...any) {
+		for _, v := range values {
+			args = append(args, v)
+			cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(args)), 1)
+		}
+		conds = append(conds, cond)
+	}
+
+	if filter.EmailPrefix != "" {
+		where("email LIKE ?", escapeLike(filter.EmailPrefix)+"%")
+	}
+	if filter.Confirmed != nil {
+		where("is_confirmed = ?", *filter.Confirmed)
+	}
+	if filter.Deleted != nil {
+		if *filter.Deleted {
+			where("deleted_at IS NOT NULL")
+		} else {
+			where("deleted_at IS NULL")
+		}
+	}
+	if !filter.CreatedAfter.IsZero() {
+		where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		where("created_at < ?", filter.CreatedBefore)
+	}
+	if filter.After != nil {
+		where("(created_at, id) > (?, ?)", filter.After.CreatedAt, filter.After.ID)
+	}
+
+	listUsersSQL := `
+		SELECT id, email, password, created_at, updated_at, deleted_at, disabled_at, is_confirmed
+		FROM users
+	`
+	if len(conds) > 0 {
+		listUsersSQL += "WHERE " + strings.Join(conds, " AND ") + "\n"
+	}
+	args = append(args, filter.Limit)
+	listUsersSQL += fmt.Sprintf("ORDER BY created_at, id LIMIT $%d", len(args))
+
+	rows, err := r.db.QueryContext(ctx, listUsersSQL, args...)
+	if err != nil {
+		log.Printf("Failed to list users: %v", err)
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*domain.User
+	for rows.Next() {
+		var user domain.User
+		err := rows.Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt, &user.DisabledAt, &user.IsConfirmed)
+		if err != nil {
+			log.Printf("Failed to scan user: %v", err)
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, &user)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list users: %v", err)
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return users, nil
+}
+
 func (r *PostgresUserRepository) UpdateUser(ctx context.Context, user *domain.User) error {
-	// SQL для обновления пользователя
+	// SQL для обновления пользователя; пустой пароль не меняет хэш
 	updateUserSQL := `
 		UPDATE users
-		SET email = $2, password = $3, updated_at = $4, is_confirmed = $5
+		SET email = $2, password = COALESCE(NULLIF($3, ''), password), updated_at = $4, disabled_at = $5, is_confirmed = $6
 		WHERE id = $1
 	`
-	_, err := r.db.ExecContext(ctx, updateUserSQL, user.ID, user.Email, user.Password, user.UpdatedAt, user.IsConfirmed)
+	_, err := r.db.ExecContext(ctx, updateUserSQL, user.ID, user.Email, user.Password, user.UpdatedAt, user.DisabledAt, user.IsConfirmed)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrEmailTaken
+		}
 		log.Printf("Failed to update user: %v", err)
 		return fmt.Errorf("failed to update user: %w", err)
 	}
@@ -93,10 +246,11 @@ func (r *PostgresUserRepository) UpdateUser(ctx context.Context, user *domain.Us
 }
 
 func (r *PostgresUserRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	// SQL для удаления пользователя
+	// SQL для мягкого удаления пользователя
 	deleteUserSQL := `
-		DELETE FROM users
-		WHERE id = $1
+		UPDATE users
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 	_, err := r.db.ExecContext(ctx, deleteUserSQL, id)
 	if err != nil {
@@ -107,13 +261,85 @@ func (r *PostgresUserRepository) DeleteUser(ctx context.Context, id uuid.UUID) e
 	return nil
 }
 
+func (r *PostgresUserRepository) RestoreUser(ctx context.Context, id uuid.UUID, deletedAfter time.Time) (bool, error) {
+	// SQL для восстановления пользователя, удалённого не раньше deletedAfter
+	restoreUserSQL := `
+		UPDATE users
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at > $2
+	`
+	res, err := r.db.ExecContext(ctx, restoreUserSQL, id, deletedAfter)
+	if err != nil {
+		log.Printf("Failed to restore user: %v", err)
+		return false, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (r *PostgresUserRepository) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	// SQL для окончательного удаления пользователей; связанные строки
+	// удаляются каскадно
+	purgeUsersSQL := `
+		DELETE FROM users
+		WHERE id IN (
+			SELECT id FROM users
+			WHERE deleted_at < $1
+			ORDER BY deleted_at
+			LIMIT $2
+		)
+	`
+	res, err := r.db.ExecContext(ctx, purgeUsersSQL, deletedBefore, limit)
+	if err != nil {
+		log.Printf("Failed to purge deleted users: %v", err)
+		return 0, fmt.Errorf("failed to purge deleted users: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted users: %w", err)
+	}
+
+	return int(affected), nil
+}
+
+func (r *PostgresUserRepository) PurgeExpired(ctx context.Context, expiredBefore time.Time) (int, error) {
+	// SQL для удаления истёкших одноразовых записей одним запросом
+	purgeExpiredSQL := `
+		WITH webauthn AS (
+			DELETE FROM webauthn_challenges WHERE expires_at < $1 RETURNING 1
+		), mfa AS (
+			DELETE FROM mfa_challenges WHERE expires_at < $1 RETURNING 1
+		), login AS (
+			DELETE FROM login_codes WHERE expires_at < $1 RETURNING 1
+		), revoked AS (
+			DELETE FROM revoked_access_tokens WHERE expires_at < $1 RETURNING 1
+		)
+		SELECT (SELECT COUNT(*) FROM webauthn) + (SELECT COUNT(*) FROM mfa) +
+			(SELECT COUNT(*) FROM login) + (SELECT COUNT(*) FROM revoked)
+	`
+	var purged int
+	err := r.db.QueryRowContext(ctx, purgeExpiredSQL, expiredBefore).Scan(&purged)
+	if err != nil {
+		log.Printf("Failed to purge expired rows: %v", err)
+		return 0, fmt.Errorf("failed to purge expired rows: %w", err)
+	}
+
+	return purged, nil
+}
+
 func (r *PostgresUserRepository) GetEmailBySignature(ctx context.Context, signature uuid.UUID) (string, error) {
 	// SQL для получения email по подписи
 	getEmailSQL := `
 		SELECT u.email
 		FROM users u
 		JOIN codes_signatures cs ON u.id = cs.user_id
-		WHERE cs.signature = $1
+		WHERE cs.signature = $1 AND u.deleted_at IS NULL
 	`
 
 	var email string
@@ -121,6 +347,9 @@ func (r *PostgresUserRepository) GetEmailBySignature(ctx context.Context, signat
 	err := r.db.QueryRowContext(ctx, getEmailSQL, signature).Scan(&email)
 
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", domain.ErrNotFound
+		}
 		log.Printf("Failed to get email by signature: %v", err)
 		return "", fmt.Errorf("failed to get email by signature: %w", err)
 	}
@@ -128,165 +357,470 @@ func (r *PostgresUserRepository) GetEmailBySignature(ctx context.Context, signat
 	return email, nil
 }
 
-func (r *PostgresUserRepository) UpdateUserSignature(ctx context.Context, userID uuid.UUID, signature uuid.UUID) error {
-	// SQL для обновления подписи пользователя
-	updateSignatureSQL := `
-		UPDATE codes_signatures
-		SET signature = $2
-		WHERE user_id = $1
+func (r *PostgresUserRepository) StoreVerificationCode(ctx context.Context, code *domain.CodeSignature) error {
+	// SQL для сохранения кода подтверждения в codes_signatures
+	storeCodeSQL := `
+		INSERT INTO codes_signatures (code, signature, user_id, is_used, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
 	`
-	_, err := r.db.ExecContext(ctx, updateSignatureSQL, userID, signature)
+	_, err := r.db.ExecContext(ctx, storeCodeSQL, code.Code, code.Signature, code.UserID, code.IsUsed, code.ExpiresAt)
 	if err != nil {
-		log.Printf("Failed to update user signature: %v", err)
-		return fmt.Errorf("failed to update user signature: %w", err)
+		log.Printf("Failed to store verification code: %v", err)
+		return fmt.Errorf("failed to store verification code: %w", err)
 	}
 
 	return nil
 }
 
-func (r *PostgresUserRepository) StoreVerificationCode(ctx context.Context, email string, code string) error {
-	userID, err := r.getUserIDByEmail(ctx, email)
+func (r *PostgresUserRepository) GetVerificationCode(ctx context.Context, signature uuid.UUID) (*domain.CodeSignature, error) {
+	// SQL для получения кода подтверждения по подписи
+	getCodeSQL := `
+		SELECT code, signature, user_id, attempts, is_used, expires_at
+		FROM codes_signatures
+		WHERE signature = $1
+	`
+
+	var code domain.CodeSignature
+	err := r.db.QueryRowContext(ctx, getCodeSQL, signature).Scan(&code.Code, &code.Signature, &code.UserID, &code.Attempts, &code.IsUsed, &code.ExpiresAt)
 	if err != nil {
-		return fmt.Errorf("failed to get user ID by email: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get verification code: %v", err)
+		return nil, fmt.Errorf("failed to get verification code: %w", err)
 	}
 
-	codeUUID, err := uuid.Parse(code)
+	return &code, nil
+}
+
+func (r *PostgresUserRepository) AddVerificationCodeAttempt(ctx context.Context, signature uuid.UUID) (int, error) {
+	// SQL для учёта введённого кода подтверждения
+	addAttemptSQL := `
+		UPDATE codes_signatures
+		SET attempts = attempts + 1
+		WHERE signature = $1
+		RETURNING attempts
+	`
+	var attempts int
+	err := r.db.QueryRowContext(ctx, addAttemptSQL, signature).Scan(&attempts)
 	if err != nil {
-		return fmt.Errorf("failed to parse verification code as UUID: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.ErrNotFound
+		}
+		log.Printf("Failed to add verification code attempt: %v", err)
+		return 0, fmt.Errorf("failed to add verification code attempt: %w", err)
 	}
 
-	signatureUUID := uuid.New()
+	return attempts, nil
+}
 
-	expiresAt := time.Now().UTC().Add(time.Hour * 24)
+func (r *PostgresUserRepository) MarkVerificationCodeUsed(ctx context.Context, signature uuid.UUID, maxAttempts int) (bool, error) {
+	// SQL для пометки кода подтверждения как использованного; код используется один раз
+	markCodeSQL := `
+		UPDATE codes_signatures
+		SET is_used = true
+		WHERE signature = $1 AND NOT is_used AND attempts <= $2 AND expires_at > NOW()
+	`
+	return r.execUpdated(ctx, "mark verification code as used", markCodeSQL, signature, maxAttempts)
+}
 
-	// SQL для сохранения кода подтверждения в codes_signatures
-	storeCodeSQL := `
-		INSERT INTO codes_signatures (code, signature, user_id, is_used, expires_at)
+func (r *PostgresUserRepository) StoreRefreshToken(ctx context.Context, token *domain.Token) error {
+	// SQL для сохранения refresh токена в таблице tokens
+	storeTokenSQL := `
+		INSERT INTO tokens (access_token, refresh_token_hash, user_id, family_id, expires_at)
 		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
 	`
-	_, err = r.db.ExecContext(ctx, storeCodeSQL, codeUUID, signatureUUID, userID, false, expiresAt)
+	err := r.db.QueryRowContext(ctx, storeTokenSQL, token.AccessToken, token.RefreshTokenHash, token.UserID, token.FamilyID, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
 	if err != nil {
-		log.Printf("Failed to store verification code: %v", err)
-		return fmt.Errorf("failed to store verification code: %w", err)
+		log.Printf("Failed to store refresh token: %v", err)
+		return fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) GetRefreshToken(ctx context.Context, refreshTokenHash string) (*domain.Token, error) {
+	// SQL для получения refresh токена по его хэшу
+	getTokenSQL := `
+		SELECT id, access_token, refresh_token_hash, user_id, family_id, expires_at, consumed_at, revoked_at, created_at
+		FROM tokens
+		WHERE refresh_token_hash = $1
+	`
+
+	var token domain.Token
+	err := r.db.QueryRowContext(ctx, getTokenSQL, refreshTokenHash).Scan(&token.ID, &token.AccessToken, &token.RefreshTokenHash, &token.UserID, &token.FamilyID, &token.ExpiresAt, &token.ConsumedAt, &token.RevokedAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get refresh token: %v", err)
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (r *PostgresUserRepository) ConsumeRefreshToken(ctx context.Context, id int) (bool, error) {
+	// SQL для пометки refresh токена как использованного; повторная пометка не проходит
+	consumeTokenSQL := `
+		UPDATE tokens
+		SET consumed_at = NOW()
+		WHERE id = $1 AND consumed_at IS NULL AND revoked_at IS NULL
+	`
+	res, err := r.db.ExecContext(ctx, consumeTokenSQL, id)
+	if err != nil {
+		log.Printf("Failed to consume refresh token: %v", err)
+		return false, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (r *PostgresUserRepository) RebindRefreshToken(ctx context.Context, familyID uuid.UUID, oldAccessTokenID, newAccessTokenID string) error {
+	// SQL для привязки refresh токенов сессии к новому access токену
+	rebindTokenSQL := `
+		UPDATE tokens
+		SET access_token = $3
+		WHERE family_id = $1 AND access_token = $2
+	`
+	_, err := r.db.ExecContext(ctx, rebindTokenSQL, familyID, oldAccessTokenID, newAccessTokenID)
+	if err != nil {
+		log.Printf("Failed to rebind refresh token: %v", err)
+		return fmt.Errorf("failed to rebind refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) DeleteRefreshToken(ctx context.Context, email string) error {
+	// SQL для удаления refresh токена из таблицы tokens
+	deleteTokenSQL := `
+		DELETE FROM tokens
+		WHERE user_id = (SELECT id FROM users WHERE email = $1)
+	`
+	_, err := r.db.ExecContext(ctx, deleteTokenSQL, email)
+	if err != nil {
+		log.Printf("Failed to delete refresh token: %v", err)
+		return fmt.Errorf("failed to delete refresh token: %w", err)
 	}
 
 	return nil
 }
 
-func (r *PostgresUserRepository) GetVerificationCode(ctx context.Context, email string) (string, error) {
-	userID, err := r.getUserIDByEmail(ctx, email)
+func (r *PostgresUserRepository) CreateSession(ctx context.Context, session *domain.Session) error {
+	// SQL для создания сессии
+	createSessionSQL := `
+		INSERT INTO sessions (id, user_id, user_agent, client_ip, device_name, created_at, last_used_at, organization_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := r.db.ExecContext(ctx, createSessionSQL, session.ID, session.UserID, session.UserAgent, session.ClientIP, session.DeviceName, session.CreatedAt, session.LastUsedAt, session.OrganizationID)
 	if err != nil {
-		return "", fmt.Errorf("failed to get user ID by email: %w", err)
+		log.Printf("Failed to create session: %v", err)
+		return fmt.Errorf("failed to create session: %w", err)
 	}
 
-	// SQL для получения кода подтверждения из codes_signatures
-	getCodeSQL := `
-		SELECT code
-		FROM codes_signatures
-		WHERE user_id = $1 AND is_used = false AND expires_at > NOW()
+	return nil
+}
+
+func (r *PostgresUserRepository) GetSession(ctx context.Context, id uuid.UUID) (*domain.Session, error) {
+	// SQL для получения сессии по ID
+	getSessionSQL := `
+		SELECT id, user_id, user_agent, client_ip, device_name, created_at, last_used_at, revoked_at, organization_id
+		FROM sessions
+		WHERE id = $1
 	`
 
-	var code uuid.UUID
-	err = r.db.QueryRowContext(ctx, getCodeSQL, userID).Scan(&code)
+	var session domain.Session
+	err := r.db.QueryRowContext(ctx, getSessionSQL, id).Scan(&session.ID, &session.UserID, &session.UserAgent, &session.ClientIP, &session.DeviceName, &session.CreatedAt, &session.LastUsedAt, &session.RevokedAt, &session.OrganizationID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("verification code not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrSessionNotFound
 		}
-		log.Printf("Failed to get verification code: %v", err)
-		return "", fmt.Errorf("failed to get verification code: %w", err)
+		log.Printf("Failed to get session: %v", err)
+		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
 
-	return code.String(), nil
+	return &session, nil
 }
 
-func (r *PostgresUserRepository) DeleteVerificationCode(ctx context.Context, email string) error {
-	userID, err := r.getUserIDByEmail(ctx, email)
+func (r *PostgresUserRepository) ListSessions(ctx context.Context, userID uuid.UUID) ([]*domain.Session, error) {
+	// SQL для получения активных сессий пользователя
+	listSessionsSQL := `
+		SELECT id, user_id, user_agent, client_ip, device_name, created_at, last_used_at, revoked_at, organization_id
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY last_used_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, listSessionsSQL, userID)
 	if err != nil {
-		return fmt.Errorf("failed to get user ID by email: %w", err)
+		log.Printf("Failed to list sessions: %v", err)
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
 	}
+	defer rows.Close()
+
+	var sessions []*domain.Session
+	for rows.Next() {
+		var session domain.Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.ClientIP, &session.DeviceName, &session.CreatedAt, &session.LastUsedAt, &session.RevokedAt, &session.OrganizationID)
+		if err != nil {
+			log.Printf("Failed to scan session: %v", err)
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, &session)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
 
-	// SQL для удаления кода подтверждения из codes_signatures
-	deleteCodeSQL := `
-		UPDATE codes_signatures SET is_used = true WHERE user_id = $1
+func (r *PostgresUserRepository) TouchSession(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error {
+	// SQL для обновления времени последнего использования сессии
+	touchSessionSQL := `
+		UPDATE sessions
+		SET last_used_at = $2
+		WHERE id = $1
 	`
-	_, err = r.db.ExecContext(ctx, deleteCodeSQL, userID)
+	_, err := r.db.ExecContext(ctx, touchSessionSQL, id, lastUsedAt)
 	if err != nil {
-		log.Printf("Failed to delete verification code: %v", err)
-		return fmt.Errorf("failed to delete verification code: %w", err)
+		log.Printf("Failed to touch session: %v", err)
+		return fmt.Errorf("failed to touch session: %w", err)
 	}
 
 	return nil
 }
 
-func (r *PostgresUserRepository) StoreRefreshToken(ctx context.Context, email string, refreshToken string) error {
-	// SQL для сохранения refresh токена в таблице tokens
-	storeTokenSQL := `
-		INSERT INTO tokens (access_token, refresh_token, user_id)
-		VALUES ($1, $2, (SELECT id FROM users WHERE email = $3))
+func (r *PostgresUserRepository) RevokeSession(ctx context.Context, id uuid.UUID) error {
+	// SQL для отзыва сессии вместе с её refresh токенами
+	revokeSessionSQL := `
+		WITH revoked_sessions AS (
+			UPDATE sessions SET revoked_at = NOW()
+			WHERE id = $1 AND revoked_at IS NULL
+		)
+		UPDATE tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
 	`
-	accessToken := uuid.New().String()
+	_, err := r.db.ExecContext(ctx, revokeSessionSQL, id)
+	if err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	return nil
+}
 
-	_, err := r.db.ExecContext(ctx, storeTokenSQL, accessToken, refreshToken, email)
+func (r *PostgresUserRepository) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) error {
+	// SQL для отзыва всех сессий пользователя, кроме текущей
+	revokeSessionsSQL := `
+		WITH revoked_sessions AS (
+			UPDATE sessions SET revoked_at = NOW()
+			WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+		)
+		UPDATE tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+	`
+	_, err := r.db.ExecContext(ctx, revokeSessionsSQL, userID, keepID)
 	if err != nil {
-		log.Printf("Failed to store refresh token: %v", err)
-		return fmt.Errorf("failed to store refresh token: %w", err)
+		log.Printf("Failed to revoke other sessions: %v", err)
+		return fmt.Errorf("failed to revoke other sessions: %w", err)
 	}
 
 	return nil
 }
 
-func (r *PostgresUserRepository) GetRefreshToken(ctx context.Context, email string) (string, error) {
-	// SQL для получения refresh токена из таблицы tokens
+func (r *PostgresUserRepository) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	// SQL для отзыва всех сессий пользователя
+	revokeSessionsSQL := `
+		WITH revoked_sessions AS (
+			UPDATE sessions SET revoked_at = NOW()
+			WHERE user_id = $1 AND revoked_at IS NULL
+		)
+		UPDATE tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+	_, err := r.db.ExecContext(ctx, revokeSessionsSQL, userID)
+	if err != nil {
+		log.Printf("Failed to revoke all sessions: %v", err)
+		return fmt.Errorf("failed to revoke all sessions: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) RevokeAccessToken(ctx context.Context, accessTokenID string, expiresAt time.Time) error {
+	// SQL для добавления access токена в чёрный список
+	revokeTokenSQL := `
+		INSERT INTO revoked_access_tokens (token_id, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (token_id) DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, revokeTokenSQL, accessTokenID, expiresAt)
+	if err != nil {
+		log.Printf("Failed to revoke access token: %v", err)
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) IsAccessTokenRevoked(ctx context.Context, accessTokenID string) (bool, error) {
+	// SQL для проверки access токена по чёрному списку
+	isRevokedSQL := `
+		SELECT EXISTS (
+			SELECT 1 FROM revoked_access_tokens
+			WHERE token_id = $1 AND expires_at > NOW()
+		)
+	`
+
+	var revoked bool
+	err := r.db.QueryRowContext(ctx, isRevokedSQL, accessTokenID).Scan(&revoked)
+	if err != nil {
+		log.Printf("Failed to check revoked access token: %v", err)
+		return false, fmt.Errorf("failed to check revoked access token: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (r *PostgresUserRepository) StorePasswordResetToken(ctx context.Context, token *domain.PasswordResetToken) error {
+	// SQL для сохранения токена сброса пароля
+	storeTokenSQL := `
+		INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRowContext(ctx, storeTokenSQL, token.TokenHash, token.UserID, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to store password reset token: %v", err)
+		return fmt.Errorf("failed to store password reset token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresUserRepository) GetPasswordResetToken(ctx context.Context, tokenHash string) (*domain.PasswordResetToken, error) {
+	// SQL для получения токена сброса пароля по хэшу
 	getTokenSQL := `
-		SELECT refresh_token
-		FROM tokens
-		WHERE user_id = (SELECT id FROM users WHERE email = $1)
+		SELECT id, token_hash, user_id, expires_at, is_used, created_at
+		FROM password_reset_tokens
+		WHERE token_hash = $1
 	`
 
-	var refreshToken string
-	err := r.db.QueryRowContext(ctx, getTokenSQL, email).Scan(&refreshToken)
+	var token domain.PasswordResetToken
+	err := r.db.QueryRowContext(ctx, getTokenSQL, tokenHash).Scan(&token.ID, &token.TokenHash, &token.UserID, &token.ExpiresAt, &token.IsUsed, &token.CreatedAt)
 	if err != nil {
-		// Если refresh токен не найден, возвращаем nil без ошибки
-		if err == sql.ErrNoRows {
-			return "", nil
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
 		}
+		log.Printf("Failed to get password reset token: %v", err)
+		return nil, fmt.Errorf("failed to get password reset token: %w", err)
+	}
 
-		log.Printf("Failed to get refresh token: %v", err)
-		return "", fmt.Errorf("failed to get refresh token: %w", err)
+	return &token, nil
+}
+
+func (r *PostgresUserRepository) MarkPasswordResetTokenUsed(ctx context.Context, id int) (bool, error) {
+	// SQL для пометки токена сброса пароля как использованного
+	markTokenSQL := `
+		UPDATE password_reset_tokens
+		SET is_used = true
+		WHERE id = $1 AND is_used = false
+	`
+	res, err := r.db.ExecContext(ctx, markTokenSQL, id)
+	if err != nil {
+		log.Printf("Failed to mark password reset token as used: %v", err)
+		return false, fmt.Errorf("failed to mark password reset token as used: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark password reset token as used: %w", err)
 	}
 
-	return refreshToken, nil
+	return affected == 1, nil
 }
 
-func (r *PostgresUserRepository) DeleteRefreshToken(ctx context.Context, email string) error {
-	// SQL для удаления refresh токена из таблицы tokens
-	deleteTokenSQL := `
-		DELETE FROM tokens
-		WHERE user_id = (SELECT id FROM users WHERE email = $1)
+func (r *PostgresUserRepository) StorePendingEmailChange(ctx context.Context, change *domain.EmailChange) error {
+	// SQL для сохранения запроса на смену почты; попытки действующего
+	// запроса переносятся на новый
+	storeChangeSQL := `
+		INSERT INTO pending_email_changes (user_id, new_email, code_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET new_email = EXCLUDED.new_email, code_hash = EXCLUDED.code_hash, expires_at = EXCLUDED.expires_at, created_at = NOW(),
+			attempts = CASE WHEN pending_email_changes.expires_at > NOW() THEN pending_email_changes.attempts ELSE 0 END
+		RETURNING attempts, created_at
 	`
-	_, err := r.db.ExecContext(ctx, deleteTokenSQL, email)
+	err := r.db.QueryRowContext(ctx, storeChangeSQL, change.UserID, change.NewEmail, change.CodeHash, change.ExpiresAt).Scan(&change.Attempts, &change.CreatedAt)
 	if err != nil {
-		log.Printf("Failed to delete refresh token: %v", err)
-		return fmt.Errorf("failed to delete refresh token: %w", err)
+		log.Printf("Failed to store pending email change: %v", err)
+		return fmt.Errorf("failed to store pending email change: %w", err)
 	}
 
 	return nil
 }
 
-func (r *PostgresUserRepository) getUserIDByEmail(ctx context.Context, email string) (uuid.UUID, error) {
-	// SQL для получения ID пользователя по email
-	getUserIDSQL := `
-		SELECT id
-		FROM users
-		WHERE email = $1
+func (r *PostgresUserRepository) GetPendingEmailChange(ctx context.Context, userID uuid.UUID) (*domain.EmailChange, error) {
+	// SQL для получения запроса на смену почты
+	getChangeSQL := `
+		SELECT user_id, new_email, code_hash, attempts, expires_at, created_at
+		FROM pending_email_changes
+		WHERE user_id = $1
 	`
 
-	var userID uuid.UUID
-	err := r.db.QueryRowContext(ctx, getUserIDSQL, email).Scan(&userID)
+	var change domain.EmailChange
+	err := r.db.QueryRowContext(ctx, getChangeSQL, userID).Scan(&change.UserID, &change.NewEmail, &change.CodeHash, &change.Attempts, &change.ExpiresAt, &change.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrNotFound
+		}
+		log.Printf("Failed to get pending email change: %v", err)
+		return nil, fmt.Errorf("failed to get pending email change: %w", err)
+	}
+
+	return &change, nil
+}
+
+func (r *PostgresUserRepository) AddEmailChangeAttempt(ctx context.Context, userID uuid.UUID) (int, error) {
+	// SQL для учёта введённого кода смены почты
+	addAttemptSQL := `
+		UPDATE pending_email_changes
+		SET attempts = attempts + 1
+		WHERE user_id = $1
+		RETURNING attempts
+	`
+	var attempts int
+	err := r.db.QueryRowContext(ctx, addAttemptSQL, userID).Scan(&attempts)
 	if err != nil {
-		log.Printf("Failed to get user ID by email: %v", err)
-		return uuid.Nil, fmt.Errorf("failed to get user ID by email: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, domain.ErrNotFound
+		}
+		log.Printf("Failed to add email change attempt: %v", err)
+		return 0, fmt.Errorf("failed to add email change attempt: %w", err)
 	}
 
-	return userID, nil
+	return attempts, nil
+}
+
+func (r *PostgresUserRepository) UsePendingEmailChange(ctx context.Context, userID uuid.UUID, codeHash string, maxAttempts int) (bool, error) {
+	// SQL для погашения запроса на смену почты; использовать его можно один раз
+	useChangeSQL := `
+		DELETE FROM pending_email_changes
+		WHERE user_id = $1 AND code_hash = $2 AND attempts <= $3 AND expires_at > NOW()
+	`
+	return r.execUpdated(ctx, "use pending email change", useChangeSQL, userID, codeHash, maxAttempts)
+}
+
+// escapeLike escapes the LIKE wildcards in s, so it only matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/repository"
+	"github.com/Olegnemlii/test123/internal/repository/repositorytest"
+	"github.com/Olegnemlii/test123/migrations"
+	"github.com/Olegnemlii/test123/pkg/migrate"
+
+	_ "github.com/lib/pq"
+)
+
+// TestUserRepository needs a disposable database in DATABASE_URL. Migrations
+// are applied first; the suite only adds rows and never truncates tables.
+func TestUserRepository(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatalf("apply migrations: %v", err)
+	}
+
+	repositorytest.Run(t, func(t *testing.T) repository.UserRepository {
+		return NewPostgresUserRepository(db)
+	})
+}
@@ -0,0 +1,210 @@
+package redis
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Key layout
+//
+//	user:<id>            string JSON-encoded cachedUser
+//	user:email:<email>   string user ID
+const (
+	userPrefix      = "user:"
+	userEmailPrefix = "user:email:"
+)
+
+// CachedUserRepository is a read-through cache for users in front of another
+// repository. Redis failures are logged and fall back to the wrapped repository.
+type CachedUserRepository struct {
+	repository.UserRepository
+	client *goredis.Client
+	ttl    time.Duration
+	// tx is set for a repository bound to a transaction
+	tx *cacheTx
+}
+
+// cachedUser is a domain.User without the password hash, which is never
+// written to Redis. Users read from the cache have an empty Password;
+// password checks load it with GetPasswordHash.
+type cachedUser struct {
+	ID          uuid.UUID
+	Email       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   sql.NullTime
+	DisabledAt  sql.NullTime
+	IsConfirmed bool
+}
+
+// cacheTx collects users changed in a transaction so they can be evicted
+// again once it commits
+type cacheTx struct {
+	changed []uuid.UUID
+}
+
+func NewCachedUserRepository(client *goredis.Client, next repository.UserRepository, ttl time.Duration) repository.UserRepository {
+	return &CachedUserRepository{UserRepository: next, client: client, ttl: ttl}
+}
+
+func (r *CachedUserRepository) WithTx(ctx context.Context, fn func(repo repository.UserRepository) error, opts ...repository.TxOption) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx := &cacheTx{}
+	err := r.UserRepository.WithTx(ctx, func(repo repository.UserRepository) error {
+		tx.changed = tx.changed[:0]
+		return fn(&CachedUserRepository{UserRepository: repo, client: r.client, ttl: r.ttl, tx: tx})
+	}, opts...)
+	if err != nil {
+		return err
+	}
+
+	// A concurrent read may have cached the old row before the commit
+	r.evict(ctx, tx.changed...)
+	return nil
+}
+
+func (r *CachedUserRepository) GetUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	// Inside a transaction the caller must see its own uncommitted writes
+	if r.tx != nil {
+		return r.UserRepository.GetUserByID(ctx, id)
+	}
+
+	if user, ok := r.cached(ctx, id); ok {
+		return user, nil
+	}
+
+	user, err := r.UserRepository.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.store(ctx, user)
+
+	return user, nil
+}
+
+func (r *CachedUserRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	if r.tx != nil {
+		return r.UserRepository.GetUserByEmail(ctx, email)
+	}
+
+	id, err := r.client.Get(ctx, userEmailPrefix+email).Result()
+	if err == nil {
+		// The index is not evicted on email changes, so check that it still points to this email
+		if userID, err := uuid.Parse(id); err == nil {
+			if user, ok := r.cached(ctx, userID); ok && user.Email == email {
+				return user, nil
+			}
+		}
+	} else if !errors.Is(err, goredis.Nil) {
+		log.Printf("user cache: %v", err)
+	}
+
+	user, err := r.UserRepository.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	r.store(ctx, user)
+
+	return user, nil
+}
+
+func (r *CachedUserRepository) UpdateUser(ctx context.Context, user *domain.User) error {
+	if err := r.UserRepository.UpdateUser(ctx, user); err != nil {
+		return err
+	}
+	r.changed(ctx, user.ID)
+	return nil
+}
+
+func (r *CachedUserRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	if err := r.UserRepository.DeleteUser(ctx, id); err != nil {
+		return err
+	}
+	r.changed(ctx, id)
+	return nil
+}
+
+// changed evicts the user now and, inside a transaction, once more after commit
+func (r *CachedUserRepository) changed(ctx context.Context, id uuid.UUID) {
+	r.evict(ctx, id)
+	if r.tx != nil {
+		r.tx.changed = append(r.tx.changed, id)
+	}
+}
+
+func (r *CachedUserRepository) cached(ctx context.Context, id uuid.UUID) (*domain.User, bool) {
+	data, err := r.client.Get(ctx, userPrefix+id.String()).Bytes()
+	if err != nil {
+		if !errors.Is(err, goredis.Nil) {
+			log.Printf("user cache: %v", err)
+		}
+		return nil, false
+	}
+
+	var user cachedUser
+	if err := json.Unmarshal(data, &user); err != nil {
+		log.Printf("user cache: failed to decode user %s: %v", id, err)
+		return nil, false
+	}
+	return &domain.User{
+		ID:          user.ID,
+		Email:       user.Email,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		DeletedAt:   user.DeletedAt,
+		DisabledAt:  user.DisabledAt,
+		IsConfirmed: user.IsConfirmed,
+	}, true
+}
+
+func (r *CachedUserRepository) store(ctx context.Context, user *domain.User) {
+	data, err := json.Marshal(cachedUser{
+		ID:          user.ID,
+		Email:       user.Email,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		DeletedAt:   user.DeletedAt,
+		DisabledAt:  user.DisabledAt,
+		IsConfirmed: user.IsConfirmed,
+	})
+	if err != nil {
+		log.Printf("user cache: failed to encode user %s: %v", user.ID, err)
+		return
+	}
+
+	_, err = r.client.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		p.Set(ctx, userPrefix+user.ID.String(), data, r.ttl)
+		p.Set(ctx, userEmailPrefix+user.Email, user.ID.String(), r.ttl)
+		return nil
+	})
+	if err != nil {
+		log.Printf("user cache: %v", err)
+	}
+}
+
+func (r *CachedUserRepository) evict(ctx context.Context, ids ...uuid.UUID) {
+	if len(ids) == 0 {
+		return
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = userPrefix + id.String()
+	}
+	if err := r.client.Del(ctx, keys...).Err(); err != nil {
+		log.Printf("user cache: %v", err)
+	}
+}
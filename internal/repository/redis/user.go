@@ -1,77 +1,542 @@
 package redis
 
-// import (
-// 	"context"
-// 	"encoding/json"
-// 	"errors"
-// 	"time"
-
-// 	"github.com/Olegnemlii/test123/internal/domain"
-
-// 	"github.com/redis/go-redis/v9"
-// )
-
-// // UserRepository хранит пользователей в Redis.
-// type UserRepository struct {
-// 	client *redis.Client
-// }
-
-// // NewUserRepository создает новый экземпляр UserRepository для Redis.
-// func NewUserRepository(client *redis.Client) *UserRepository {
-// 	return &UserRepository{client: client}
-// }
-
-// // SaveToken сохраняет refresh-токен в Redis с заданным временем жизни.
-// func (r *UserRepository) SaveToken(ctx context.Context, userID string, token string, expiration time.Duration) error {
-// 	return r.client.Set(ctx, userID, token, expiration).Err()
-// }
-
-// // GetToken получает refresh-токен пользователя по его ID.
-// func (r *UserRepository) GetToken(ctx context.Context, userID string) (string, error) {
-// 	token, err := r.client.Get(ctx, userID).Result()
-// 	if err == redis.Nil {
-// 		return "", nil // Токен не найден
-// 	}
-// 	if err != nil {
-// 		return "", err
-// 	}
-// 	return token, nil
-// }
-
-// // DeleteToken удаляет refresh-токен пользователя.
-// func (r *UserRepository) DeleteToken(ctx context.Context, userID string) error {
-// 	return r.client.Del(ctx, userID).Err()
-// }
-
-// // SaveUser кэширует данные пользователя в Redis.
-// func (r *UserRepository) SaveUser(ctx context.Context, user *domain.User, expiration time.Duration) error {
-// 	data, err := json.Marshal(user)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	return r.client.Set(ctx, "user:"+user.ID, data, expiration).Err()
-// }
-
-// // GetUser получает пользователя из кэша Redis.
-// func (r *UserRepository) GetUser(ctx context.Context, userID string) (*domain.User, error) {
-// 	data, err := r.client.Get(ctx, "user:"+userID).Result()
-// 	if err == redis.Nil {
-// 		return nil, nil // Пользователь не найден
-// 	}
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	var user domain.User
-// 	err = json.Unmarshal([]byte(data), &user)
-// 	if err != nil {
-// 		return nil, errors.New("ошибка декодирования данных пользователя")
-// 	}
-
-// 	return &user, nil
-// }
-
-// // DeleteUser удаляет пользователя из Redis.
-// func (r *UserRepository) DeleteUser(ctx context.Context, userID string) error {
-// 	return r.client.Del(ctx, "user:"+userID).Err()
-// }
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Key layout
+//
+//	code:<signature>        hash   verification code, expires with the code
+//	rt:<hash>               hash   refresh token, expires with the token
+//	rt:id:<id>              string refresh token hash by numeric ID
+//	rt:seq                  string refresh token ID sequence
+//	rt:family:<session id>  set    refresh token hashes of a session
+//	rt:user:<user id>       set    session IDs that have refresh tokens
+//	revoked:<jti>           string access token denylist entry
+const (
+	codePrefix       = "code:"
+	tokenPrefix      = "rt:"
+	tokenIDPrefix    = "rt:id:"
+	tokenSeqKey      = "rt:seq"
+	tokenFamilyKey   = "rt:family:"
+	tokenUserKey     = "rt:user:"
+	revokedJTIPrefix = "revoked:"
+)
+
+// setFieldOnce sets a hash field only if the key exists and the field is not
+// set yet, so it never resurrects an expired key. Returns 1 if it was set.
+var setFieldOnce = goredis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2])
+`)
+
+// setFieldIfExists sets a hash field only if the key exists, so it never
+// resurrects an expired key
+var setFieldIfExists = goredis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+`)
+
+// consumeToken sets consumed_at only on an existing token that is neither
+// consumed nor revoked. Returns 1 if it was set.
+var consumeToken = goredis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 or redis.call('HEXISTS', KEYS[1], 'revoked_at') == 1 then
+	return 0
+end
+return redis.call('HSETNX', KEYS[1], 'consumed_at', ARGV[1])
+`)
+
+// rebindToken replaces the access token of an existing refresh token that
+// was issued with ARGV[1]
+var rebindToken = goredis.NewScript(`
+if redis.call('HGET', KEYS[1], 'access_token') ~= ARGV[1] then
+	return 0
+end
+return redis.call('HSET', KEYS[1], 'access_token', ARGV[2])
+`)
+
+// addCodeAttempt increments the attempts of an existing code. Returns the
+// attempts made so far, or -1 if there is no such code.
+var addCodeAttempt = goredis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+`)
+
+// useCode marks an existing code as used unless it already is or had more
+// than ARGV[1] attempts. Returns 1 if it was marked.
+var useCode = goredis.NewScript(`
+local code = redis.call('HMGET', KEYS[1], 'is_used', 'attempts')
+if code[1] == false or code[1] == '1' or tonumber(code[2] or '0') > tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'is_used', 1)
+return 1
+`)
+
+// UserRepository keeps short-lived data (verification codes, refresh tokens
+// and the access token denylist) in Redis with native TTLs and delegates
+// everything else to the wrapped repository.
+type UserRepository struct {
+	repository.UserRepository
+	client *goredis.Client
+	// tx is set for a repository bound to a transaction
+	tx *redisTx
+}
+
+// redisTx holds the Redis side of a transaction. Redis cannot roll back, so
+// plain writes are buffered until the wrapped transaction commits, and
+// conditional updates whose result is needed right away are reverted if it
+// rolls back.
+type redisTx struct {
+	afterCommit []func(ctx context.Context) error
+	onRollback  []func(ctx context.Context) error
+}
+
+func NewUserRepository(client *goredis.Client, next repository.UserRepository) repository.UserRepository {
+	return &UserRepository{UserRepository: next, client: client}
+}
+
+// WithTx applies the buffered Redis writes after the commit. If one of them
+// fails the error is returned although the wrapped transaction is committed;
+// verification code attempts are never reverted.
+func (r *UserRepository) WithTx(ctx context.Context, fn func(repo repository.UserRepository) error, opts ...repository.TxOption) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	// The writes must not be lost when the request is cancelled after the commit
+	ctx = context.WithoutCancel(ctx)
+
+	tx := &redisTx{}
+	err := r.UserRepository.WithTx(ctx, func(repo repository.UserRepository) error {
+		// The wrapped repository may retry fn after a serialization failure
+		tx.rollback(ctx)
+		return fn(&UserRepository{UserRepository: repo, client: r.client, tx: tx})
+	}, opts...)
+	if err != nil {
+		tx.rollback(ctx)
+		return err
+	}
+
+	var errs []error
+	for _, write := range tx.afterCommit {
+		errs = append(errs, write(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// rollback reverts the conditional updates and drops the buffered writes
+func (tx *redisTx) rollback(ctx context.Context) {
+	for i := len(tx.onRollback) - 1; i >= 0; i-- {
+		if err := tx.onRollback[i](ctx); err != nil {
+			log.Printf("Failed to revert redis update: %v", err)
+		}
+	}
+	tx.afterCommit = nil
+	tx.onRollback = nil
+}
+
+// write runs fn now, or after the commit inside a transaction
+func (r *UserRepository) write(ctx context.Context, fn func(ctx context.Context) error) error {
+	if r.tx != nil {
+		r.tx.afterCommit = append(r.tx.afterCommit, fn)
+		return nil
+	}
+	return fn(ctx)
+}
+
+// revertOnRollback registers fn to undo an update made inside a transaction
+func (r *UserRepository) revertOnRollback(fn func(ctx context.Context) error) {
+	if r.tx != nil {
+		r.tx.onRollback = append(r.tx.onRollback, fn)
+	}
+}
+
+func (r *UserRepository) StoreVerificationCode(ctx context.Context, code *domain.CodeSignature) error {
+	key := codePrefix + code.Signature.String()
+	fields := []any{
+		"code", code.Code,
+		"user_id", code.UserID.String(),
+		"attempts", code.Attempts,
+		"is_used", code.IsUsed,
+		"expires_at", formatTime(code.ExpiresAt),
+	}
+
+	return r.write(ctx, func(ctx context.Context) error {
+		_, err := r.client.TxPipelined(ctx, func(p goredis.Pipeliner) error {
+			p.HSet(ctx, key, fields...)
+			p.ExpireAt(ctx, key, code.ExpiresAt)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store verification code: %w", err)
+		}
+		return nil
+	})
+}
+
+func (r *UserRepository) GetVerificationCode(ctx context.Context, signature uuid.UUID) (*domain.CodeSignature, error) {
+	fields, err := r.client.HGetAll(ctx, codePrefix+signature.String()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get verification code: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, domain.ErrNotFound
+	}
+
+	code := &domain.CodeSignature{
+		Code:      fields["code"],
+		Signature: signature,
+		IsUsed:    fields["is_used"] == "1",
+	}
+	if code.UserID, err = uuid.Parse(fields["user_id"]); err != nil {
+		return nil, fmt.Errorf("failed to get verification code: %w", err)
+	}
+	if attempts, ok := fields["attempts"]; ok {
+		if code.Attempts, err = strconv.Atoi(attempts); err != nil {
+			return nil, fmt.Errorf("failed to get verification code: %w", err)
+		}
+	}
+	if code.ExpiresAt, err = parseTime(fields["expires_at"]); err != nil {
+		return nil, fmt.Errorf("failed to get verification code: %w", err)
+	}
+
+	return code, nil
+}
+
+func (r *UserRepository) AddVerificationCodeAttempt(ctx context.Context, signature uuid.UUID) (int, error) {
+	attempts, err := addCodeAttempt.Run(ctx, r.client, []string{codePrefix + signature.String()}).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to add verification code attempt: %w", err)
+	}
+	if attempts < 0 {
+		return 0, domain.ErrNotFound
+	}
+
+	return attempts, nil
+}
+
+// MarkVerificationCodeUsed relies on the key expiring with the code
+func (r *UserRepository) MarkVerificationCodeUsed(ctx context.Context, signature uuid.UUID, maxAttempts int) (bool, error) {
+	key := codePrefix + signature.String()
+	used, err := useCode.Run(ctx, r.client, []string{key}, maxAttempts).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark verification code as used: %w", err)
+	}
+
+	if used == 1 {
+		r.revertOnRollback(func(ctx context.Context) error {
+			return setFieldIfExists.Run(ctx, r.client, []string{key}, "is_used", 0).Err()
+		})
+	}
+	return used == 1, nil
+}
+
+func (r *UserRepository) GetEmailBySignature(ctx context.Context, signature uuid.UUID) (string, error) {
+	code, err := r.GetVerificationCode(ctx, signature)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := r.UserRepository.GetUserByID(ctx, code.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return "", domain.ErrNotFound
+		}
+		return "", err
+	}
+
+	return user.Email, nil
+}
+
+func (r *UserRepository) StoreRefreshToken(ctx context.Context, token *domain.Token) error {
+	id, err := r.client.Incr(ctx, tokenSeqKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	token.ID = int(id)
+	token.CreatedAt = time.Now().UTC()
+
+	key := tokenPrefix + token.RefreshTokenHash
+	idKey := tokenIDPrefix + strconv.Itoa(token.ID)
+	familyKey := tokenFamilyKey + token.FamilyID.String()
+	userKey := tokenUserKey + token.UserID.String()
+	fields := []any{
+		"id", token.ID,
+		"access_token", token.AccessToken,
+		"user_id", token.UserID.String(),
+		"family_id", token.FamilyID.String(),
+		"expires_at", formatTime(token.ExpiresAt),
+		"created_at", formatTime(token.CreatedAt),
+	}
+
+	// Tokens share one TTL, so the newest token always expires last and the
+	// indexes can simply follow it
+	return r.write(ctx, func(ctx context.Context) error {
+		_, err := r.client.TxPipelined(ctx, func(p goredis.Pipeliner) error {
+			p.HSet(ctx, key, fields...)
+			p.ExpireAt(ctx, key, token.ExpiresAt)
+			p.Set(ctx, idKey, token.RefreshTokenHash, 0)
+			p.ExpireAt(ctx, idKey, token.ExpiresAt)
+			p.SAdd(ctx, familyKey, token.RefreshTokenHash)
+			p.ExpireAt(ctx, familyKey, token.ExpiresAt)
+			p.SAdd(ctx, userKey, token.FamilyID.String())
+			p.ExpireAt(ctx, userKey, token.ExpiresAt)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store refresh token: %w", err)
+		}
+		return nil
+	})
+}
+
+func (r *UserRepository) GetRefreshToken(ctx context.Context, refreshTokenHash string) (*domain.Token, error) {
+	fields, err := r.client.HGetAll(ctx, tokenPrefix+refreshTokenHash).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, domain.ErrNotFound
+	}
+
+	token, err := parseToken(refreshTokenHash, fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+func (r *UserRepository) ConsumeRefreshToken(ctx context.Context, id int) (bool, error) {
+	hash, err := r.client.Get(ctx, tokenIDPrefix+strconv.Itoa(id)).Result()
+	if errors.Is(err, goredis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	key := tokenPrefix + hash
+	set, err := consumeToken.Run(ctx, r.client, []string{key}, formatTime(time.Now().UTC())).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	if set == 1 {
+		r.revertOnRollback(func(ctx context.Context) error {
+			return r.client.HDel(ctx, key, "consumed_at").Err()
+		})
+	}
+	return set == 1, nil
+}
+
+func (r *UserRepository) RebindRefreshToken(ctx context.Context, familyID uuid.UUID, oldAccessTokenID, newAccessTokenID string) error {
+	return r.write(ctx, func(ctx context.Context) error {
+		hashes, err := r.client.SMembers(ctx, tokenFamilyKey+familyID.String()).Result()
+		if err != nil {
+			return fmt.Errorf("failed to rebind refresh token: %w", err)
+		}
+		for _, hash := range hashes {
+			if err := rebindToken.Run(ctx, r.client, []string{tokenPrefix + hash}, oldAccessTokenID, newAccessTokenID).Err(); err != nil {
+				return fmt.Errorf("failed to rebind refresh token: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *UserRepository) DeleteRefreshToken(ctx context.Context, email string) error {
+	if err := r.UserRepository.DeleteRefreshToken(ctx, email); err != nil {
+		return err
+	}
+
+	user, err := r.UserRepository.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return r.write(ctx, func(ctx context.Context) error {
+		return r.deleteRefreshTokens(ctx, user.ID)
+	})
+}
+
+func (r *UserRepository) deleteRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	userKey := tokenUserKey + userID.String()
+	families, err := r.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", err)
+	}
+
+	for _, family := range families {
+		hashes, err := r.client.SMembers(ctx, tokenFamilyKey+family).Result()
+		if err != nil {
+			return fmt.Errorf("failed to delete refresh tokens: %w", err)
+		}
+		keys := []string{tokenFamilyKey + family}
+		for _, hash := range hashes {
+			keys = append(keys, tokenPrefix+hash)
+		}
+		if err := r.client.Del(ctx, keys...).Err(); err != nil {
+			return fmt.Errorf("failed to delete refresh tokens: %w", err)
+		}
+	}
+
+	if err := r.client.Del(ctx, userKey).Err(); err != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) RevokeSession(ctx context.Context, id uuid.UUID) error {
+	if err := r.UserRepository.RevokeSession(ctx, id); err != nil {
+		return err
+	}
+	return r.write(ctx, func(ctx context.Context) error {
+		return r.revokeFamilies(ctx, []string{id.String()})
+	})
+}
+
+func (r *UserRepository) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) error {
+	if err := r.UserRepository.RevokeOtherSessions(ctx, userID, keepID); err != nil {
+		return err
+	}
+
+	return r.write(ctx, func(ctx context.Context) error {
+		families, err := r.client.SMembers(ctx, tokenUserKey+userID.String()).Result()
+		if err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+
+		others := families[:0]
+		for _, family := range families {
+			if family != keepID.String() {
+				others = append(others, family)
+			}
+		}
+		return r.revokeFamilies(ctx, others)
+	})
+}
+
+func (r *UserRepository) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	if err := r.UserRepository.RevokeAllSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	return r.write(ctx, func(ctx context.Context) error {
+		families, err := r.client.SMembers(ctx, tokenUserKey+userID.String()).Result()
+		if err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+		return r.revokeFamilies(ctx, families)
+	})
+}
+
+// revokeFamilies marks every refresh token of the given sessions as revoked
+func (r *UserRepository) revokeFamilies(ctx context.Context, families []string) error {
+	now := formatTime(time.Now().UTC())
+	for _, family := range families {
+		hashes, err := r.client.SMembers(ctx, tokenFamilyKey+family).Result()
+		if err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+		for _, hash := range hashes {
+			if err := setFieldOnce.Run(ctx, r.client, []string{tokenPrefix + hash}, "revoked_at", now).Err(); err != nil {
+				return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) RevokeAccessToken(ctx context.Context, accessTokenID string, expiresAt time.Time) error {
+	// An expired token is rejected by signature validation anyway
+	if !expiresAt.After(time.Now()) {
+		return nil
+	}
+
+	return r.write(ctx, func(ctx context.Context) error {
+		err := r.client.SetArgs(ctx, revokedJTIPrefix+accessTokenID, 1, goredis.SetArgs{ExpireAt: expiresAt}).Err()
+		if err != nil {
+			return fmt.Errorf("failed to revoke access token: %w", err)
+		}
+		return nil
+	})
+}
+
+func (r *UserRepository) IsAccessTokenRevoked(ctx context.Context, accessTokenID string) (bool, error) {
+	n, err := r.client.Exists(ctx, revokedJTIPrefix+accessTokenID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoked access token: %w", err)
+	}
+
+	return n == 1, nil
+}
+
+func parseToken(hash string, fields map[string]string) (*domain.Token, error) {
+	token := &domain.Token{
+		RefreshTokenHash: hash,
+		AccessToken:      fields["access_token"],
+	}
+
+	var err error
+	if token.ID, err = strconv.Atoi(fields["id"]); err != nil {
+		return nil, err
+	}
+	if token.UserID, err = uuid.Parse(fields["user_id"]); err != nil {
+		return nil, err
+	}
+	if token.FamilyID, err = uuid.Parse(fields["family_id"]); err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt, err = parseTime(fields["expires_at"]); err != nil {
+		return nil, err
+	}
+	if token.CreatedAt, err = parseTime(fields["created_at"]); err != nil {
+		return nil, err
+	}
+	if v, ok := fields["consumed_at"]; ok {
+		if token.ConsumedAt.Time, err = parseTime(v); err != nil {
+			return nil, err
+		}
+		token.ConsumedAt.Valid = true
+	}
+	if v, ok := fields["revoked_at"]; ok {
+		if token.RevokedAt.Time, err = parseTime(v); err != nil {
+			return nil, err
+		}
+		token.RevokedAt.Valid = true
+	}
+
+	return token, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,441 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+	"github.com/Olegnemlii/test123/internal/repository/memory"
+	"github.com/Olegnemlii/test123/internal/repository/repositorytest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// fakeRepo stands in for Postgres behind the Redis decorators
+type fakeRepo struct {
+	repository.UserRepository
+
+	users map[uuid.UUID]*domain.User
+	reads int
+	// retries is how many times WithTx runs fn again as if the transaction
+	// had hit a serialization failure
+	retries int
+}
+
+func newFakeRepo(users ...*domain.User) *fakeRepo {
+	r := &fakeRepo{users: make(map[uuid.UUID]*domain.User)}
+	for _, u := range users {
+		r.users[u.ID] = u
+	}
+	return r
+}
+
+func (r *fakeRepo) WithTx(ctx context.Context, fn func(repo repository.UserRepository) error, opts ...repository.TxOption) error {
+	for ; r.retries > 0; r.retries-- {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return fn(r)
+}
+
+func (r *fakeRepo) GetUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
+	r.reads++
+	u, ok := r.users[id]
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (r *fakeRepo) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	r.reads++
+	for _, u := range r.users {
+		if u.Email == email {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, domain.ErrUserNotFound
+}
+
+func (r *fakeRepo) UpdateUser(ctx context.Context, user *domain.User) error {
+	c := *user
+	r.users[user.ID] = &c
+	return nil
+}
+
+func (r *fakeRepo) DeleteRefreshToken(ctx context.Context, email string) error    { return nil }
+func (r *fakeRepo) RevokeSession(ctx context.Context, id uuid.UUID) error         { return nil }
+func (r *fakeRepo) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error { return nil }
+func (r *fakeRepo) RevokeOtherSessions(ctx context.Context, userID, keepID uuid.UUID) error {
+	return nil
+}
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *goredis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestVerificationCodes(t *testing.T) {
+	mr, client := newTestRedis(t)
+	user := &domain.User{ID: uuid.New(), Email: "user@example.com"}
+	repo := NewUserRepository(client, newFakeRepo(user))
+	ctx := context.Background()
+
+	code := &domain.CodeSignature{
+		Code:      "123456",
+		Signature: uuid.New(),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Microsecond),
+	}
+	if err := repo.StoreVerificationCode(ctx, code); err != nil {
+		t.Fatalf("StoreVerificationCode: %v", err)
+	}
+
+	got, err := repo.GetVerificationCode(ctx, code.Signature)
+	if err != nil {
+		t.Fatalf("GetVerificationCode: %v", err)
+	}
+	if got.Code != code.Code || got.UserID != user.ID || got.IsUsed || !got.ExpiresAt.Equal(code.ExpiresAt) {
+		t.Fatalf("got %+v, want %+v", got, code)
+	}
+
+	email, err := repo.GetEmailBySignature(ctx, code.Signature)
+	if err != nil || email != user.Email {
+		t.Fatalf("GetEmailBySignature = %q, %v", email, err)
+	}
+
+	if attempts, err := repo.AddVerificationCodeAttempt(ctx, code.Signature); err != nil || attempts != 1 {
+		t.Fatalf("AddVerificationCodeAttempt = %d, %v, want 1", attempts, err)
+	}
+	if ok, err := repo.MarkVerificationCodeUsed(ctx, code.Signature, 0); err != nil || ok {
+		t.Fatalf("MarkVerificationCodeUsed over the attempt limit = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.MarkVerificationCodeUsed(ctx, code.Signature, 1); err != nil || !ok {
+		t.Fatalf("MarkVerificationCodeUsed = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.MarkVerificationCodeUsed(ctx, code.Signature, 1); err != nil || ok {
+		t.Fatalf("MarkVerificationCodeUsed twice = %v, %v, want false", ok, err)
+	}
+	if got, _ := repo.GetVerificationCode(ctx, code.Signature); !got.IsUsed || got.Attempts != 1 {
+		t.Fatalf("GetVerificationCode after use = %+v", got)
+	}
+
+	mr.FastForward(time.Hour + time.Second)
+	if _, err := repo.GetVerificationCode(ctx, code.Signature); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expired code: err = %v, want ErrNotFound", err)
+	}
+	if ok, err := repo.MarkVerificationCodeUsed(ctx, code.Signature, 1); err != nil || ok {
+		t.Fatalf("marking expired code = %v, %v, want false", ok, err)
+	}
+	if _, err := repo.AddVerificationCodeAttempt(ctx, code.Signature); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("counting an attempt of an expired code: err = %v, want ErrNotFound", err)
+	}
+	if mr.Exists(codePrefix + code.Signature.String()) {
+		t.Fatal("marking an expired code recreated the key")
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	mr, client := newTestRedis(t)
+	repo := NewUserRepository(client, newFakeRepo())
+	ctx := context.Background()
+
+	userID := uuid.New()
+	current, other := uuid.New(), uuid.New()
+	expires := time.Now().Add(24 * time.Hour)
+
+	tokens := map[string]*domain.Token{
+		"current": {AccessToken: "jti-1", RefreshTokenHash: "hash-1", UserID: userID, FamilyID: current, ExpiresAt: expires},
+		"other":   {AccessToken: "jti-2", RefreshTokenHash: "hash-2", UserID: userID, FamilyID: other, ExpiresAt: expires},
+	}
+	for name, tok := range tokens {
+		if err := repo.StoreRefreshToken(ctx, tok); err != nil {
+			t.Fatalf("StoreRefreshToken(%s): %v", name, err)
+		}
+		if tok.ID == 0 {
+			t.Fatalf("StoreRefreshToken(%s) did not assign an ID", name)
+		}
+	}
+
+	got, err := repo.GetRefreshToken(ctx, "hash-1")
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if got.ID != tokens["current"].ID || got.FamilyID != current || got.AccessToken != "jti-1" || got.ConsumedAt.Valid {
+		t.Fatalf("unexpected token %+v", got)
+	}
+
+	// Consumption succeeds exactly once
+	if ok, err := repo.ConsumeRefreshToken(ctx, got.ID); err != nil || !ok {
+		t.Fatalf("first ConsumeRefreshToken = %v, %v", ok, err)
+	}
+	if ok, err := repo.ConsumeRefreshToken(ctx, got.ID); err != nil || ok {
+		t.Fatalf("second ConsumeRefreshToken = %v, %v", ok, err)
+	}
+	if got, _ := repo.GetRefreshToken(ctx, "hash-1"); !got.ConsumedAt.Valid {
+		t.Fatal("consumed token has no consumed_at")
+	}
+
+	if err := repo.RevokeOtherSessions(ctx, userID, current); err != nil {
+		t.Fatalf("RevokeOtherSessions: %v", err)
+	}
+	if got, _ := repo.GetRefreshToken(ctx, "hash-1"); got.RevokedAt.Valid {
+		t.Fatal("current session token was revoked")
+	}
+	if got, _ := repo.GetRefreshToken(ctx, "hash-2"); !got.RevokedAt.Valid {
+		t.Fatal("other session token was not revoked")
+	}
+
+	if err := repo.RevokeSession(ctx, current); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if got, _ := repo.GetRefreshToken(ctx, "hash-1"); !got.RevokedAt.Valid {
+		t.Fatal("revoked session token is still valid")
+	}
+
+	mr.FastForward(25 * time.Hour)
+	if _, err := repo.GetRefreshToken(ctx, "hash-1"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expired token: err = %v, want ErrNotFound", err)
+	}
+	if ok, _ := repo.ConsumeRefreshToken(ctx, tokens["current"].ID); ok {
+		t.Fatal("consumed an expired token")
+	}
+}
+
+func TestAccessTokenDenylist(t *testing.T) {
+	mr, client := newTestRedis(t)
+	repo := NewUserRepository(client, newFakeRepo())
+	ctx := context.Background()
+
+	if err := repo.RevokeAccessToken(ctx, "jti", time.Now().Add(15*time.Minute)); err != nil {
+		t.Fatalf("RevokeAccessToken: %v", err)
+	}
+	if revoked, err := repo.IsAccessTokenRevoked(ctx, "jti"); err != nil || !revoked {
+		t.Fatalf("IsAccessTokenRevoked = %v, %v", revoked, err)
+	}
+	if revoked, _ := repo.IsAccessTokenRevoked(ctx, "other"); revoked {
+		t.Fatal("unknown jti reported as revoked")
+	}
+
+	// The entry disappears together with the token it denies
+	mr.FastForward(16 * time.Minute)
+	if revoked, _ := repo.IsAccessTokenRevoked(ctx, "jti"); revoked {
+		t.Fatal("denylist entry outlived the token")
+	}
+}
+
+func TestWithTxRollback(t *testing.T) {
+	_, client := newTestRedis(t)
+	user := &domain.User{ID: uuid.New(), Email: "user@example.com"}
+	repo := NewUserRepository(client, newFakeRepo(user))
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	code := &domain.CodeSignature{Code: "123456", Signature: uuid.New(), UserID: user.ID, ExpiresAt: expires}
+	token := &domain.Token{AccessToken: "jti-1", RefreshTokenHash: "hash-1", UserID: user.ID, FamilyID: uuid.New(), ExpiresAt: expires}
+	if err := repo.StoreVerificationCode(ctx, code); err != nil {
+		t.Fatalf("StoreVerificationCode: %v", err)
+	}
+	if err := repo.StoreRefreshToken(ctx, token); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+
+	errRollback := errors.New("rollback")
+	newCode := &domain.CodeSignature{Code: "654321", Signature: uuid.New(), UserID: user.ID, ExpiresAt: expires}
+	err := repo.WithTx(ctx, func(tx repository.UserRepository) error {
+		if ok, err := tx.MarkVerificationCodeUsed(ctx, code.Signature, 1); err != nil || !ok {
+			t.Fatalf("MarkVerificationCodeUsed = %v, %v", ok, err)
+		}
+		if ok, err := tx.ConsumeRefreshToken(ctx, token.ID); err != nil || !ok {
+			t.Fatalf("ConsumeRefreshToken = %v, %v", ok, err)
+		}
+		if err := tx.StoreVerificationCode(ctx, newCode); err != nil {
+			t.Fatalf("StoreVerificationCode: %v", err)
+		}
+		if err := tx.RevokeAllSessions(ctx, user.ID); err != nil {
+			t.Fatalf("RevokeAllSessions: %v", err)
+		}
+		if err := tx.RevokeAccessToken(ctx, "jti-1", expires); err != nil {
+			t.Fatalf("RevokeAccessToken: %v", err)
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx err = %v, want the callback error", err)
+	}
+
+	// The conditional updates are reverted and the other writes never happen
+	if got, _ := repo.GetVerificationCode(ctx, code.Signature); got.IsUsed {
+		t.Fatal("verification code stayed used after rollback")
+	}
+	if got, _ := repo.GetRefreshToken(ctx, "hash-1"); got.ConsumedAt.Valid || got.RevokedAt.Valid {
+		t.Fatalf("refresh token changed after rollback: %+v", got)
+	}
+	if _, err := repo.GetVerificationCode(ctx, newCode.Signature); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("code stored in a rolled back transaction: err = %v", err)
+	}
+	if revoked, _ := repo.IsAccessTokenRevoked(ctx, "jti-1"); revoked {
+		t.Fatal("access token revoked in a rolled back transaction")
+	}
+}
+
+func TestWithTxCommit(t *testing.T) {
+	_, client := newTestRedis(t)
+	user := &domain.User{ID: uuid.New(), Email: "user@example.com"}
+	next := newFakeRepo(user)
+	repo := NewUserRepository(client, next)
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	code := &domain.CodeSignature{Code: "123456", Signature: uuid.New(), UserID: user.ID, ExpiresAt: expires}
+	if err := repo.StoreVerificationCode(ctx, code); err != nil {
+		t.Fatalf("StoreVerificationCode: %v", err)
+	}
+
+	// The first run of the callback is retried and must not leave the code
+	// used, otherwise the second run could not redeem it
+	next.retries = 1
+	runs := 0
+	newCode := &domain.CodeSignature{Code: "654321", Signature: uuid.New(), UserID: user.ID, ExpiresAt: expires}
+	err := repo.WithTx(ctx, func(tx repository.UserRepository) error {
+		runs++
+		ok, err := tx.MarkVerificationCodeUsed(ctx, code.Signature, 1)
+		if err != nil || !ok {
+			return fmt.Errorf("run %d: MarkVerificationCodeUsed = %v, %v", runs, ok, err)
+		}
+		if err := tx.StoreVerificationCode(ctx, newCode); err != nil {
+			return err
+		}
+		if _, err := repo.GetVerificationCode(ctx, newCode.Signature); !errors.Is(err, domain.ErrNotFound) {
+			return fmt.Errorf("code visible before the commit: err = %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if runs != 2 {
+		t.Fatalf("callback ran %d times, want 2", runs)
+	}
+
+	if got, _ := repo.GetVerificationCode(ctx, code.Signature); !got.IsUsed {
+		t.Fatal("verification code is not used after the commit")
+	}
+	if _, err := repo.GetVerificationCode(ctx, newCode.Signature); err != nil {
+		t.Fatalf("code stored in the transaction: %v", err)
+	}
+}
+
+func TestCachedUserRepository(t *testing.T) {
+	_, client := newTestRedis(t)
+	user := &domain.User{ID: uuid.New(), Email: "old@example.com", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	next := newFakeRepo(user)
+	repo := NewCachedUserRepository(client, next, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		got, err := repo.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if got.Email != user.Email || !got.CreatedAt.Equal(user.CreatedAt) {
+			t.Fatalf("got %+v", got)
+		}
+	}
+	if next.reads != 1 {
+		t.Fatalf("repository was read %d times, want 1", next.reads)
+	}
+
+	if _, err := repo.GetUserByEmail(ctx, "old@example.com"); err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if next.reads != 1 {
+		t.Fatalf("email lookup missed the cache")
+	}
+
+	// Changing the email inside a transaction evicts the cached user
+	err := repo.WithTx(ctx, func(tx repository.UserRepository) error {
+		u, err := tx.GetUserByID(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		u.Email = "new@example.com"
+		return tx.UpdateUser(ctx, u)
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	got, err := repo.GetUserByID(ctx, user.ID)
+	if err != nil || got.Email != "new@example.com" {
+		t.Fatalf("after update GetUserByID = %+v, %v", got, err)
+	}
+	if _, err := repo.GetUserByEmail(ctx, "old@example.com"); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("old email still resolves from cache: err = %v", err)
+	}
+}
+
+func TestCachedUserRepositoryWithoutPasswordHash(t *testing.T) {
+	mr, client := newTestRedis(t)
+	next := memory.NewUserRepository()
+	repo := NewCachedUserRepository(client, next, time.Minute)
+	ctx := context.Background()
+
+	const hash = "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$aGFzaA"
+	user, err := next.CreateUser(ctx, &domain.User{Email: "user@example.com", Password: hash, CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if _, err := repo.GetUserByID(ctx, user.ID); err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	cached, err := mr.Get(userPrefix + user.ID.String())
+	if err != nil {
+		t.Fatalf("user was not cached: %v", err)
+	}
+	if strings.Contains(cached, hash) || strings.Contains(cached, "Password") {
+		t.Fatalf("cached user contains the password hash: %s", cached)
+	}
+
+	// A user served from the cache has no hash; it is read from the wrapped repository
+	got, err := repo.GetUserByID(ctx, user.ID)
+	if err != nil || got.Password != "" || got.Email != user.Email {
+		t.Fatalf("cached GetUserByID = %+v, %v", got, err)
+	}
+	if got, err := repo.GetPasswordHash(ctx, user.ID); err != nil || got != hash {
+		t.Fatalf("GetPasswordHash = %q, %v, want %q", got, err, hash)
+	}
+}
+
+func TestCachedUserRepositoryRedisDown(t *testing.T) {
+	mr, client := newTestRedis(t)
+	user := &domain.User{ID: uuid.New(), Email: "user@example.com"}
+	repo := NewCachedUserRepository(client, newFakeRepo(user), time.Minute)
+
+	mr.Close()
+	if _, err := repo.GetUserByID(context.Background(), user.ID); err != nil {
+		t.Fatalf("GetUserByID with Redis down: %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.UserRepository {
+		_, client := newTestRedis(t)
+		return NewCachedUserRepository(client, NewUserRepository(client, memory.NewUserRepository()), time.Minute)
+	})
+}
@@ -0,0 +1,1720 @@
+// Package repositorytest is a conformance suite for repository.UserRepository
+// implementations. Every implementation runs it from its own tests, so they
+// all agree on not-found errors, uniqueness and one-time semantics.
+package repositorytest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// Factory returns the repository under test. It is called once per subtest.
+// The suite only relies on rows it created itself, so a factory may share a
+// database between calls.
+type Factory func(t *testing.T) repository.UserRepository
+
+// claimAll is large enough to claim every due email in a shared database
+const claimAll = 1000
+
+// Run runs the suite against repositories returned by newRepo
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo repository.UserRepository)
+	}{
+		{"Users", testUsers},
+		{"UserEmailUnique", testUserEmailUnique},
+		{"SoftDelete", testSoftDelete},
+		{"PurgeDeletedUsers", testPurgeDeletedUsers},
+		{"PurgeExpired", testPurgeExpired},
+		{"DisabledUser", testDisabledUser},
+		{"ListUsers", testListUsers},
+		{"AuditLog", testAuditLog},
+		{"Roles", testRoles},
+		{"Organizations", testOrganizations},
+		{"Invitations", testInvitations},
+		{"TOTP", testTOTP},
+		{"RecoveryCodes", testRecoveryCodes},
+		{"MFAChallenges", testMFAChallenges},
+		{"Passkeys", testPasskeys},
+		{"WebAuthnChallenges", testWebAuthnChallenges},
+		{"VerificationCodes", testVerificationCodes},
+		{"LoginCodes", testLoginCodes},
+		{"RefreshTokens", testRefreshTokens},
+		{"Sessions", testSessions},
+		{"RevokeSessions", testRevokeSessions},
+		{"PasswordResetTokens", testPasswordResetTokens},
+		{"PendingEmailChanges", testPendingEmailChanges},
+		{"AccessTokenDenylist", testAccessTokenDenylist},
+		{"Outbox", testOutbox},
+		{"OutboxDeadLetters", testOutboxDeadLetters},
+		{"WithTx", testWithTx},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func testUsers(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	got, err := repo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.Email != user.Email || got.IsConfirmed || !got.CreatedAt.Equal(user.CreatedAt) {
+		t.Fatalf("GetUserByID = %+v, want %+v", got, user)
+	}
+	if hash, err := repo.GetPasswordHash(ctx, user.ID); err != nil || hash != user.Password {
+		t.Fatalf("GetPasswordHash = %q, %v, want %q", hash, err, user.Password)
+	}
+	if _, err := repo.GetPasswordHash(ctx, uuid.New()); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("GetPasswordHash(unknown): err = %v, want ErrUserNotFound", err)
+	}
+	if got, err := repo.GetUserByEmail(ctx, user.Email); err != nil || got.ID != user.ID {
+		t.Fatalf("GetUserByEmail = %+v, %v", got, err)
+	}
+
+	// Changing the returned copy must not change the stored user
+	got.Email = "changed-" + user.Email
+	if got, _ := repo.GetUserByID(ctx, user.ID); got.Email != user.Email {
+		t.Fatal("repository shares memory with its callers")
+	}
+
+	user.Email = uniqueEmail()
+	user.Password = "new-hash"
+	user.IsConfirmed = true
+	user.UpdatedAt = now().Add(time.Minute)
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	got, err = repo.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail after update: %v", err)
+	}
+	if !got.IsConfirmed || !got.UpdatedAt.Equal(user.UpdatedAt) {
+		t.Fatalf("update was not stored: %+v", got)
+	}
+	if hash, _ := repo.GetPasswordHash(ctx, user.ID); hash != "new-hash" {
+		t.Fatalf("password hash after update = %q, want new-hash", hash)
+	}
+
+	// A user without a hash, like one read from the cache, keeps the stored one
+	user.Password = ""
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("UpdateUser without password: %v", err)
+	}
+	if hash, _ := repo.GetPasswordHash(ctx, user.ID); hash != "new-hash" {
+		t.Fatalf("password hash after update without password = %q, want new-hash", hash)
+	}
+
+	if err := repo.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, user.ID); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("GetUserByID after delete: err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.GetUserByEmail(ctx, user.Email); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("GetUserByEmail after delete: err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testUserEmailUnique(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	first := createUser(t, repo)
+	second := createUser(t, repo)
+
+	_, err := repo.CreateUser(ctx, &domain.User{Email: first.Email, Password: "hash", CreatedAt: now(), UpdatedAt: now()})
+	if !errors.Is(err, domain.ErrEmailTaken) {
+		t.Fatalf("CreateUser with a taken email: err = %v, want ErrEmailTaken", err)
+	}
+
+	second.Email = first.Email
+	if err := repo.UpdateUser(ctx, second); !errors.Is(err, domain.ErrEmailTaken) {
+		t.Fatalf("UpdateUser to a taken email: err = %v, want ErrEmailTaken", err)
+	}
+
+	// Keeping one's own email is not a conflict
+	if err := repo.UpdateUser(ctx, first); err != nil {
+		t.Fatalf("UpdateUser with unchanged email: %v", err)
+	}
+}
+
+func testSoftDelete(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+	signature := uuid.New()
+	err := repo.StoreVerificationCode(ctx, &domain.CodeSignature{Code: "123456", Signature: signature, UserID: user.ID, ExpiresAt: now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("StoreVerificationCode: %v", err)
+	}
+
+	if _, err := repo.GetDeletedUserByEmail(ctx, user.Email); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("GetDeletedUserByEmail(active user): err = %v, want ErrUserNotFound", err)
+	}
+
+	if err := repo.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, user.ID); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("GetUserByID(deleted): err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.GetUserByEmail(ctx, user.Email); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("GetUserByEmail(deleted): err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.GetEmailBySignature(ctx, signature); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetEmailBySignature(deleted): err = %v, want ErrNotFound", err)
+	}
+
+	deleted, err := repo.GetDeletedUserByEmail(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("GetDeletedUserByEmail: %v", err)
+	}
+	if deleted.ID != user.ID || !deleted.DeletedAt.Valid {
+		t.Fatalf("GetDeletedUserByEmail = %+v", deleted)
+	}
+
+	// The email stays taken until the user is purged
+	_, err = repo.CreateUser(ctx, &domain.User{Email: user.Email, Password: "hash", CreatedAt: now(), UpdatedAt: now()})
+	if !errors.Is(err, domain.ErrEmailTaken) {
+		t.Fatalf("CreateUser with a deleted user's email: err = %v, want ErrEmailTaken", err)
+	}
+
+	if ok, err := repo.RestoreUser(ctx, user.ID, now().Add(time.Hour)); err != nil || ok {
+		t.Fatalf("RestoreUser after the grace period = %v, %v", ok, err)
+	}
+	if ok, err := repo.RestoreUser(ctx, user.ID, now().Add(-time.Hour)); err != nil || !ok {
+		t.Fatalf("RestoreUser = %v, %v", ok, err)
+	}
+	if ok, err := repo.RestoreUser(ctx, user.ID, now().Add(-time.Hour)); err != nil || ok {
+		t.Fatalf("RestoreUser(active user) = %v, %v", ok, err)
+	}
+
+	got, err := repo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID(restored): %v", err)
+	}
+	if got.DeletedAt.Valid {
+		t.Fatal("restored user still has deleted_at")
+	}
+}
+
+func testPurgeDeletedUsers(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+	active := createUser(t, repo)
+
+	session := createSession(t, repo, user.ID, now())
+	storeToken(t, repo, user.ID, session.ID)
+	reset := &domain.PasswordResetToken{TokenHash: uuid.NewString(), UserID: user.ID, ExpiresAt: now().Add(time.Hour)}
+	if err := repo.StorePasswordResetToken(ctx, reset); err != nil {
+		t.Fatalf("StorePasswordResetToken: %v", err)
+	}
+	change := &domain.EmailChange{UserID: user.ID, NewEmail: uniqueEmail(), CodeHash: "code-" + uuid.NewString(), ExpiresAt: now().Add(time.Hour)}
+	if err := repo.StorePendingEmailChange(ctx, change); err != nil {
+		t.Fatalf("StorePendingEmailChange: %v", err)
+	}
+
+	if err := repo.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	// Still within the grace period
+	if _, err := repo.PurgeDeletedUsers(ctx, now().Add(-time.Hour), claimAll); err != nil {
+		t.Fatalf("PurgeDeletedUsers: %v", err)
+	}
+	if _, err := repo.GetDeletedUserByEmail(ctx, user.Email); err != nil {
+		t.Fatalf("user purged before the grace period ended: %v", err)
+	}
+
+	n, err := repo.PurgeDeletedUsers(ctx, now().Add(time.Hour), claimAll)
+	if err != nil {
+		t.Fatalf("PurgeDeletedUsers: %v", err)
+	}
+	if n < 1 {
+		t.Fatalf("PurgeDeletedUsers = %d, want at least 1", n)
+	}
+
+	if _, err := repo.GetDeletedUserByEmail(ctx, user.Email); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("GetDeletedUserByEmail(purged): err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := repo.GetSession(ctx, session.ID); !errors.Is(err, domain.ErrSessionNotFound) {
+		t.Fatalf("GetSession(purged user): err = %v, want ErrSessionNotFound", err)
+	}
+	if _, err := repo.GetPasswordResetToken(ctx, reset.TokenHash); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetPasswordResetToken(purged user): err = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetPendingEmailChange(ctx, user.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetPendingEmailChange(purged user): err = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetUserByID(ctx, active.ID); err != nil {
+		t.Fatalf("active user was purged: %v", err)
+	}
+
+	// The email is free again
+	if _, err := repo.CreateUser(ctx, &domain.User{Email: user.Email, Password: "hash", CreatedAt: now(), UpdatedAt: now()}); err != nil {
+		t.Fatalf("CreateUser with a purged user's email: %v", err)
+	}
+}
+
+func testPurgeExpired(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user, other := createUser(t, repo), createUser(t, repo)
+
+	var webauthn, mfa []string
+	for _, expiresAt := range []time.Time{now().Add(-time.Minute), now().Add(time.Hour)} {
+		w := &domain.WebAuthnChallenge{Challenge: "challenge-" + uuid.NewString(), Ceremony: domain.CeremonyLogin, SessionData: []byte(`{}`), ExpiresAt: expiresAt}
+		if err := repo.StoreWebAuthnChallenge(ctx, w); err != nil {
+			t.Fatalf("StoreWebAuthnChallenge: %v", err)
+		}
+		m := &domain.MFAChallenge{TokenHash: "hash-" + uuid.NewString(), UserID: user.ID, ExpiresAt: expiresAt}
+		if err := repo.StoreMFAChallenge(ctx, m); err != nil {
+			t.Fatalf("StoreMFAChallenge: %v", err)
+		}
+		webauthn, mfa = append(webauthn, w.Challenge), append(mfa, m.TokenHash)
+	}
+	// A user has one login code at a time, so the live one belongs to other
+	for _, c := range []*domain.LoginCode{
+		{Signature: uuid.New(), UserID: user.ID, CodeHash: "code-" + uuid.NewString(), LinkHash: "link-" + uuid.NewString(), ExpiresAt: now().Add(-time.Minute)},
+		{Signature: uuid.New(), UserID: other.ID, CodeHash: "code-" + uuid.NewString(), LinkHash: "link-" + uuid.NewString(), ExpiresAt: now().Add(time.Hour)},
+	} {
+		if err := repo.StoreLoginCode(ctx, c); err != nil {
+			t.Fatalf("StoreLoginCode: %v", err)
+		}
+	}
+	jti := uuid.NewString()
+	if err := repo.RevokeAccessToken(ctx, jti, now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeAccessToken: %v", err)
+	}
+	if err := repo.RevokeAccessToken(ctx, uuid.NewString(), now().Add(-time.Minute)); err != nil {
+		t.Fatalf("RevokeAccessToken(expired): %v", err)
+	}
+
+	n, err := repo.PurgeExpired(ctx, now())
+	if err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+	if n < 3 {
+		t.Fatalf("PurgeExpired = %d, want at least 3", n)
+	}
+
+	if _, err := repo.TakeWebAuthnChallenge(ctx, webauthn[0]); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("TakeWebAuthnChallenge(expired): err = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.TakeWebAuthnChallenge(ctx, webauthn[1]); err != nil {
+		t.Fatalf("TakeWebAuthnChallenge(live): %v", err)
+	}
+	if _, err := repo.GetMFAChallenge(ctx, mfa[0]); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetMFAChallenge(expired): err = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetMFAChallenge(ctx, mfa[1]); err != nil {
+		t.Fatalf("GetMFAChallenge(live): %v", err)
+	}
+	if _, err := repo.GetLoginCode(ctx, user.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetLoginCode(expired): err = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetLoginCode(ctx, other.ID); err != nil {
+		t.Fatalf("GetLoginCode(live): %v", err)
+	}
+	if revoked, err := repo.IsAccessTokenRevoked(ctx, jti); err != nil || !revoked {
+		t.Fatalf("IsAccessTokenRevoked(live) = %v, %v, want true", revoked, err)
+	}
+}
+
+func testDisabledUser(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+	if user.DisabledAt.Valid {
+		t.Fatalf("new user = %+v, want not disabled", user)
+	}
+
+	user.DisabledAt = sql.NullTime{Time: now(), Valid: true}
+	if err := repo.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	got, err := repo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if !got.DisabledAt.Valid || !got.DisabledAt.Time.Equal(user.DisabledAt.Time) {
+		t.Fatalf("update was not stored: %+v", got)
+	}
+
+	// FindUserByID sees soft-deleted users as well
+	if err := repo.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if got, err := repo.FindUserByID(ctx, user.ID); err != nil || !got.DeletedAt.Valid {
+		t.Fatalf("FindUserByID(deleted) = %+v, %v", got, err)
+	}
+	if _, err := repo.FindUserByID(ctx, uuid.New()); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("FindUserByID(unknown): err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testListUsers(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+
+	// Every user gets the same prefix, so rows of other tests never match
+	prefix := uuid.NewString() + "_"
+	start := now().Add(-time.Hour)
+	var users []*domain.User
+	for i := 0; i < 5; i++ {
+		user, err := repo.CreateUser(ctx, &domain.User{
+			Email:       fmt.Sprintf("%s%d@example.com", prefix, i),
+			Password:    "hash",
+			CreatedAt:   start.Add(time.Duration(i) * time.Minute),
+			UpdatedAt:   start,
+			IsConfirmed: i%2 == 0,
+		})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		users = append(users, user)
+	}
+	if err := repo.DeleteUser(ctx, users[4].ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	list := func(f domain.UserFilter) []uuid.UUID {
+		t.Helper()
+		f.EmailPrefix = prefix
+		if f.Limit == 0 {
+			f.Limit = 100
+		}
+		got, err := repo.ListUsers(ctx, f)
+		if err != nil {
+			t.Fatalf("ListUsers(%+v): %v", f, err)
+		}
+		ids := make([]uuid.UUID, len(got))
+		for i, u := range got {
+			ids[i] = u.ID
+		}
+		return ids
+	}
+	ids := func(users ...*domain.User) []uuid.UUID {
+		ids := make([]uuid.UUID, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		return ids
+	}
+	yes, no := true, false
+
+	tests := []struct {
+		name   string
+		filter domain.UserFilter
+		want   []uuid.UUID
+	}{
+		{"all", domain.UserFilter{}, ids(users...)},
+		{"limit", domain.UserFilter{Limit: 2}, ids(users[0], users[1])},
+		{"after", domain.UserFilter{After: &domain.UserCursor{CreatedAt: users[1].CreatedAt, ID: users[1].ID}}, ids(users[2], users[3], users[4])},
+		{"confirmed", domain.UserFilter{Confirmed: &yes}, ids(users[0], users[2], users[4])},
+		{"unconfirmed", domain.UserFilter{Confirmed: &no}, ids(users[1], users[3])},
+		{"deleted", domain.UserFilter{Deleted: &yes}, ids(users[4])},
+		{"not deleted", domain.UserFilter{Deleted: &no}, ids(users[0], users[1], users[2], users[3])},
+		{"created range", domain.UserFilter{CreatedAfter: users[1].CreatedAt, CreatedBefore: users[3].CreatedAt}, ids(users[1], users[2])},
+	}
+	for _, tt := range tests {
+		if got := list(tt.filter); !slices.Equal(got, tt.want) {
+			t.Errorf("ListUsers(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// LIKE wildcards in the prefix match only themselves
+	got, err := repo.ListUsers(ctx, domain.UserFilter{EmailPrefix: prefix[:8] + "%", Limit: 100})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("ListUsers with a wildcard prefix returned %d users", len(got))
+	}
+}
+
+func testAuditLog(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	actor := uuid.New()
+	target := uuid.New()
+
+	first := &domain.AuditEntry{ActorID: actor, Action: "GetUser", TargetID: uuid.NullUUID{UUID: target, Valid: true}}
+	second := &domain.AuditEntry{ActorID: actor, Action: "ListUsers", Details: "email_prefix=a", Error: sql.NullString{String: "permission denied", Valid: true}}
+	for _, e := range []*domain.AuditEntry{first, second} {
+		if err := repo.AppendAuditEntry(ctx, e); err != nil {
+			t.Fatalf("AppendAuditEntry: %v", err)
+		}
+		if e.ID == 0 || e.CreatedAt.IsZero() {
+			t.Fatalf("AppendAuditEntry did not assign ID and CreatedAt: %+v", e)
+		}
+	}
+
+	entries, err := repo.ListAuditEntries(ctx, 2)
+	if err != nil {
+		t.Fatalf("ListAuditEntries: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != second.ID || entries[1].ID != first.ID {
+		t.Fatalf("ListAuditEntries = %+v, want the two entries newest first", entries)
+	}
+	if got := entries[1]; got.ActorID != actor || got.TargetID.UUID != target || !got.TargetID.Valid || got.Error.Valid {
+		t.Fatalf("first entry = %+v", got)
+	}
+	if got := entries[0]; got.TargetID.Valid || got.Details != "email_prefix=a" || got.Error.String != "permission denied" {
+		t.Fatalf("second entry = %+v", got)
+	}
+}
+
+func testRoles(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+
+	roles, err := repo.ListRoles(ctx)
+	if err != nil {
+		t.Fatalf("ListRoles: %v", err)
+	}
+	var names []string
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	if !slices.Equal(names, []string{domain.RoleAdmin, domain.RoleSupport}) {
+		t.Fatalf("ListRoles = %v, want the built-in roles", names)
+	}
+	if want := []string{domain.PermRolesManage, domain.PermUsersRead, domain.PermUsersWrite}; !slices.Equal(roles[0].Permissions, want) {
+		t.Fatalf("admin permissions = %v, want %v", roles[0].Permissions, want)
+	}
+
+	user := createUser(t, repo)
+	other := createUser(t, repo)
+	if roles, err := repo.GetUserRoles(ctx, user.ID); err != nil || len(roles) != 0 {
+		t.Fatalf("GetUserRoles(new user) = %v, %v", roles, err)
+	}
+
+	// Assigning is idempotent
+	for _, role := range []string{domain.RoleSupport, domain.RoleAdmin, domain.RoleSupport} {
+		if err := repo.AssignRole(ctx, user.ID, role); err != nil {
+			t.Fatalf("AssignRole(%s): %v", role, err)
+		}
+	}
+	if err := repo.AssignRole(ctx, other.ID, domain.RoleSupport); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if err := repo.AssignRole(ctx, user.ID, "no-such-role"); !errors.Is(err, domain.ErrRoleNotFound) {
+		t.Fatalf("AssignRole(unknown role): err = %v, want ErrRoleNotFound", err)
+	}
+	if err := repo.AssignRole(ctx, uuid.New(), domain.RoleSupport); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("AssignRole(unknown user): err = %v, want ErrUserNotFound", err)
+	}
+
+	got, err := repo.GetUserRoles(ctx, user.ID)
+	if err != nil || !slices.Equal(got, []string{domain.RoleAdmin, domain.RoleSupport}) {
+		t.Fatalf("GetUserRoles = %v, %v", got, err)
+	}
+	// Permissions shared by both roles are listed once
+	got, err = repo.GetUserPermissions(ctx, user.ID)
+	if want := []string{domain.PermRolesManage, domain.PermUsersRead, domain.PermUsersWrite}; err != nil || !slices.Equal(got, want) {
+		t.Fatalf("GetUserPermissions = %v, %v, want %v", got, err, want)
+	}
+
+	if err := repo.RevokeRole(ctx, user.ID, domain.RoleAdmin); err != nil {
+		t.Fatalf("RevokeRole: %v", err)
+	}
+	if err := repo.RevokeRole(ctx, user.ID, domain.RoleAdmin); err != nil {
+		t.Fatalf("RevokeRole twice: %v", err)
+	}
+	got, err = repo.GetUserPermissions(ctx, user.ID)
+	if want := []string{domain.PermUsersRead, domain.PermUsersWrite}; err != nil || !slices.Equal(got, want) {
+		t.Fatalf("GetUserPermissions after RevokeRole = %v, %v, want %v", got, err, want)
+	}
+	if got, err := repo.GetUserRoles(ctx, other.ID); err != nil || !slices.Equal(got, []string{domain.RoleSupport}) {
+		t.Fatalf("GetUserRoles(other) = %v, %v", got, err)
+	}
+
+	// Purging a user drops its roles
+	if err := repo.DeleteUser(ctx, other.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := repo.PurgeDeletedUsers(ctx, now().Add(time.Hour), claimAll); err != nil {
+		t.Fatalf("PurgeDeletedUsers: %v", err)
+	}
+	if got, err := repo.GetUserRoles(ctx, other.ID); err != nil || len(got) != 0 {
+		t.Fatalf("GetUserRoles(purged) = %v, %v", got, err)
+	}
+}
+
+func testOrganizations(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	owner := createUser(t, repo)
+	member := createUser(t, repo)
+
+	org := &domain.Organization{Name: "Acme", CreatedAt: now()}
+	if err := repo.CreateOrganization(ctx, org); err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	if org.ID == uuid.Nil {
+		t.Fatal("CreateOrganization did not assign an ID")
+	}
+	if got, err := repo.GetOrganization(ctx, org.ID); err != nil || got.Name != "Acme" {
+		t.Fatalf("GetOrganization = %+v, %v", got, err)
+	}
+	if _, err := repo.GetOrganization(ctx, uuid.New()); !errors.Is(err, domain.ErrOrganizationNotFound) {
+		t.Fatalf("GetOrganization(unknown): err = %v, want ErrOrganizationNotFound", err)
+	}
+
+	for i, m := range []*domain.Membership{
+		{OrganizationID: org.ID, UserID: owner.ID, Role: domain.OrgRoleOwner},
+		{OrganizationID: org.ID, UserID: member.ID, Role: domain.OrgRoleMember},
+	} {
+		m.CreatedAt = now().Add(time.Duration(i) * time.Second)
+		if err := repo.AddMember(ctx, m); err != nil {
+			t.Fatalf("AddMember: %v", err)
+		}
+	}
+	err := repo.AddMember(ctx, &domain.Membership{OrganizationID: org.ID, UserID: member.ID, Role: domain.OrgRoleAdmin, CreatedAt: now()})
+	if !errors.Is(err, domain.ErrAlreadyMember) {
+		t.Fatalf("AddMember twice: err = %v, want ErrAlreadyMember", err)
+	}
+	err = repo.AddMember(ctx, &domain.Membership{OrganizationID: uuid.New(), UserID: member.ID, Role: domain.OrgRoleMember, CreatedAt: now()})
+	if !errors.Is(err, domain.ErrOrganizationNotFound) {
+		t.Fatalf("AddMember(unknown organization): err = %v, want ErrOrganizationNotFound", err)
+	}
+
+	if m, err := repo.GetMembership(ctx, org.ID, member.ID); err != nil || m.Role != domain.OrgRoleMember {
+		t.Fatalf("GetMembership = %+v, %v", m, err)
+	}
+	members, err := repo.ListMembers(ctx, org.ID)
+	if err != nil || len(members) != 2 || members[0].UserID != owner.ID || members[1].UserID != member.ID {
+		t.Fatalf("ListMembers = %+v, %v, want the owner then the member", members, err)
+	}
+	if list, err := repo.ListMemberships(ctx, member.ID); err != nil || len(list) != 1 || list[0].OrganizationID != org.ID {
+		t.Fatalf("ListMemberships = %+v, %v", list, err)
+	}
+
+	// Removing a member takes its sessions out of the organization
+	session := createSession(t, repo, member.ID, now())
+	orgID := uuid.NullUUID{UUID: org.ID, Valid: true}
+	if err := repo.SetSessionOrganization(ctx, session.ID, orgID); err != nil {
+		t.Fatalf("SetSessionOrganization: %v", err)
+	}
+	if got, err := repo.GetSession(ctx, session.ID); err != nil || got.OrganizationID != orgID {
+		t.Fatalf("GetSession = %+v, %v, want organization %s", got, err, org.ID)
+	}
+	if err := repo.RemoveMember(ctx, org.ID, member.ID); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if _, err := repo.GetMembership(ctx, org.ID, member.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetMembership after RemoveMember: err = %v, want ErrNotFound", err)
+	}
+	if got, err := repo.GetSession(ctx, session.ID); err != nil || got.OrganizationID.Valid {
+		t.Fatalf("session after RemoveMember = %+v, %v, want no organization", got, err)
+	}
+}
+
+func testInvitations(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	inviter := createUser(t, repo)
+	org := &domain.Organization{Name: "Acme", CreatedAt: now()}
+	if err := repo.CreateOrganization(ctx, org); err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+
+	email := uniqueEmail()
+	invite := func(hash string) *domain.Invitation {
+		t.Helper()
+		inv := &domain.Invitation{
+			OrganizationID: org.ID,
+			Email:          email,
+			Role:           domain.OrgRoleMember,
+			TokenHash:      hash,
+			InvitedBy:      inviter.ID,
+			ExpiresAt:      now().Add(time.Hour),
+		}
+		if err := repo.StoreInvitation(ctx, inv); err != nil {
+			t.Fatalf("StoreInvitation: %v", err)
+		}
+		if inv.ID == 0 || inv.CreatedAt.IsZero() {
+			t.Fatalf("StoreInvitation did not assign ID and CreatedAt: %+v", inv)
+		}
+		return inv
+	}
+
+	first := invite("hash-" + uuid.NewString())
+	second := invite("hash-" + uuid.NewString())
+
+	// A new invitation replaces the pending one
+	if _, err := repo.GetInvitation(ctx, first.TokenHash); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetInvitation(replaced): err = %v, want ErrNotFound", err)
+	}
+	got, err := repo.GetInvitation(ctx, second.TokenHash)
+	if err != nil {
+		t.Fatalf("GetInvitation: %v", err)
+	}
+	if got.ID != second.ID || got.Email != email || got.InvitedBy != inviter.ID || got.AcceptedAt.Valid {
+		t.Fatalf("GetInvitation = %+v", got)
+	}
+
+	if ok, err := repo.MarkInvitationAccepted(ctx, second.ID); err != nil || !ok {
+		t.Fatalf("MarkInvitationAccepted = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.MarkInvitationAccepted(ctx, second.ID); err != nil || ok {
+		t.Fatalf("MarkInvitationAccepted twice = %v, %v, want false", ok, err)
+	}
+	if got, err := repo.GetInvitation(ctx, second.TokenHash); err != nil || !got.AcceptedAt.Valid {
+		t.Fatalf("accepted invitation = %+v, %v", got, err)
+	}
+
+	// Accepted invitations are kept when the email is invited again
+	invite("hash-" + uuid.NewString())
+	if _, err := repo.GetInvitation(ctx, second.TokenHash); err != nil {
+		t.Fatalf("GetInvitation(accepted) after a new invitation: %v", err)
+	}
+}
+
+func testTOTP(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	if _, err := repo.GetTOTP(ctx, user.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetTOTP before enrolling: err = %v, want ErrNotFound", err)
+	}
+	if ok, err := repo.UseTOTPStep(ctx, user.ID, 1); err != nil || ok {
+		t.Fatalf("UseTOTPStep before enrolling = %v, %v, want false", ok, err)
+	}
+	if _, err := repo.AddTOTPAttempt(ctx, user.ID, now()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("AddTOTPAttempt before enrolling: err = %v, want ErrNotFound", err)
+	}
+
+	enroll := func(secret string) {
+		t.Helper()
+		err := repo.StoreTOTP(ctx, &domain.TOTPCredential{UserID: user.ID, EncryptedSecret: []byte(secret), CreatedAt: now()})
+		if err != nil {
+			t.Fatalf("StoreTOTP: %v", err)
+		}
+	}
+
+	// Enrolling again replaces an unconfirmed secret, but not its attempts
+	enroll("first")
+	if attempts, err := repo.AddTOTPAttempt(ctx, user.ID, now().Add(-time.Hour)); err != nil || attempts != 1 {
+		t.Fatalf("AddTOTPAttempt = %d, %v, want 1", attempts, err)
+	}
+	enroll("second")
+	got, err := repo.GetTOTP(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if string(got.EncryptedSecret) != "second" || got.ConfirmedAt.Valid || got.Attempts != 1 || !got.LastAttemptAt.Valid {
+		t.Fatalf("GetTOTP = %+v", got)
+	}
+
+	// Attempts add up until one comes after a pause or they are reset
+	if attempts, err := repo.AddTOTPAttempt(ctx, user.ID, now().Add(-time.Hour)); err != nil || attempts != 2 {
+		t.Fatalf("AddTOTPAttempt = %d, %v, want 2", attempts, err)
+	}
+	if attempts, err := repo.AddTOTPAttempt(ctx, user.ID, now().Add(time.Hour)); err != nil || attempts != 1 {
+		t.Fatalf("AddTOTPAttempt after a pause = %d, %v, want 1", attempts, err)
+	}
+	if err := repo.ResetTOTPAttempts(ctx, user.ID); err != nil {
+		t.Fatalf("ResetTOTPAttempts: %v", err)
+	}
+	if got, err := repo.GetTOTP(ctx, user.ID); err != nil || got.Attempts != 0 {
+		t.Fatalf("GetTOTP after ResetTOTPAttempts = %+v, %v", got, err)
+	}
+	if ok, err := repo.UseTOTPStep(ctx, user.ID, 1); err != nil || ok {
+		t.Fatalf("UseTOTPStep before confirming = %v, %v, want false", ok, err)
+	}
+
+	if ok, err := repo.ConfirmTOTP(ctx, user.ID, 100); err != nil || !ok {
+		t.Fatalf("ConfirmTOTP = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.ConfirmTOTP(ctx, user.ID, 101); err != nil || ok {
+		t.Fatalf("ConfirmTOTP twice = %v, %v, want false", ok, err)
+	}
+	got, err = repo.GetTOTP(ctx, user.ID)
+	if err != nil || !got.ConfirmedAt.Valid || got.LastUsedStep != 100 {
+		t.Fatalf("confirmed TOTP = %+v, %v", got, err)
+	}
+
+	err = repo.StoreTOTP(ctx, &domain.TOTPCredential{UserID: user.ID, EncryptedSecret: []byte("third"), CreatedAt: now()})
+	if !errors.Is(err, domain.ErrTOTPAlreadyEnabled) {
+		t.Fatalf("StoreTOTP after confirming: err = %v, want ErrTOTPAlreadyEnabled", err)
+	}
+
+	// A step is accepted once and earlier steps are never accepted again
+	if ok, err := repo.UseTOTPStep(ctx, user.ID, 100); err != nil || ok {
+		t.Fatalf("UseTOTPStep(confirming step) = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.UseTOTPStep(ctx, user.ID, 102); err != nil || !ok {
+		t.Fatalf("UseTOTPStep = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.UseTOTPStep(ctx, user.ID, 101); err != nil || ok {
+		t.Fatalf("UseTOTPStep(earlier step) = %v, %v, want false", ok, err)
+	}
+
+	if err := repo.ReplaceRecoveryCodes(ctx, user.ID, []string{"hash-a"}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+	if err := repo.DeleteTOTP(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteTOTP: %v", err)
+	}
+	if _, err := repo.GetTOTP(ctx, user.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetTOTP after DeleteTOTP: err = %v, want ErrNotFound", err)
+	}
+	if ok, err := repo.UseRecoveryCode(ctx, user.ID, "hash-a"); err != nil || ok {
+		t.Fatalf("UseRecoveryCode after DeleteTOTP = %v, %v, want false", ok, err)
+	}
+
+	// A new enrollment starts over
+	enroll("fourth")
+	if got, err := repo.GetTOTP(ctx, user.ID); err != nil || got.ConfirmedAt.Valid || got.LastUsedStep != 0 {
+		t.Fatalf("GetTOTP after enrolling again = %+v, %v", got, err)
+	}
+}
+
+func testRecoveryCodes(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+	other := createUser(t, repo)
+
+	if err := repo.ReplaceRecoveryCodes(ctx, user.ID, []string{"hash-a", "hash-b"}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+
+	if ok, err := repo.UseRecoveryCode(ctx, other.ID, "hash-a"); err != nil || ok {
+		t.Fatalf("UseRecoveryCode(other user) = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.UseRecoveryCode(ctx, user.ID, "hash-a"); err != nil || !ok {
+		t.Fatalf("UseRecoveryCode = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.UseRecoveryCode(ctx, user.ID, "hash-a"); err != nil || ok {
+		t.Fatalf("UseRecoveryCode twice = %v, %v, want false", ok, err)
+	}
+
+	// New codes replace the old ones, used or not
+	if err := repo.ReplaceRecoveryCodes(ctx, user.ID, []string{"hash-a", "hash-c"}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+	if ok, err := repo.UseRecoveryCode(ctx, user.ID, "hash-b"); err != nil || ok {
+		t.Fatalf("UseRecoveryCode(replaced) = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.UseRecoveryCode(ctx, user.ID, "hash-a"); err != nil || !ok {
+		t.Fatalf("UseRecoveryCode(reissued) = %v, %v, want true", ok, err)
+	}
+}
+
+func testMFAChallenges(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	challenge := &domain.MFAChallenge{
+		TokenHash: "hash-" + uuid.NewString(),
+		UserID:    user.ID,
+		ExpiresAt: now().Add(5 * time.Minute),
+	}
+	if err := repo.StoreMFAChallenge(ctx, challenge); err != nil {
+		t.Fatalf("StoreMFAChallenge: %v", err)
+	}
+	if challenge.ID == 0 || challenge.CreatedAt.IsZero() {
+		t.Fatalf("StoreMFAChallenge did not assign ID and CreatedAt: %+v", challenge)
+	}
+
+	if _, err := repo.GetMFAChallenge(ctx, "unknown"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetMFAChallenge(unknown): err = %v, want ErrNotFound", err)
+	}
+	got, err := repo.GetMFAChallenge(ctx, challenge.TokenHash)
+	if err != nil {
+		t.Fatalf("GetMFAChallenge: %v", err)
+	}
+	if got.ID != challenge.ID || got.UserID != user.ID || got.Attempts != 0 || got.CompletedAt.Valid || !got.ExpiresAt.Equal(challenge.ExpiresAt) {
+		t.Fatalf("GetMFAChallenge = %+v, want %+v", got, challenge)
+	}
+
+	for want := 1; want <= 2; want++ {
+		if attempts, err := repo.AddMFAChallengeAttempt(ctx, challenge.ID); err != nil || attempts != want {
+			t.Fatalf("AddMFAChallengeAttempt = %d, %v, want %d", attempts, err, want)
+		}
+	}
+
+	if ok, err := repo.CompleteMFAChallenge(ctx, challenge.ID, 1); err != nil || ok {
+		t.Fatalf("CompleteMFAChallenge over the attempt limit = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.CompleteMFAChallenge(ctx, challenge.ID, 2); err != nil || !ok {
+		t.Fatalf("CompleteMFAChallenge = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.CompleteMFAChallenge(ctx, challenge.ID, 2); err != nil || ok {
+		t.Fatalf("CompleteMFAChallenge twice = %v, %v, want false", ok, err)
+	}
+	if got, err := repo.GetMFAChallenge(ctx, challenge.TokenHash); err != nil || !got.CompletedAt.Valid || got.Attempts != 2 {
+		t.Fatalf("completed challenge = %+v, %v", got, err)
+	}
+
+	expired := &domain.MFAChallenge{
+		TokenHash: "hash-" + uuid.NewString(),
+		UserID:    user.ID,
+		ExpiresAt: now().Add(-time.Minute),
+	}
+	if err := repo.StoreMFAChallenge(ctx, expired); err != nil {
+		t.Fatalf("StoreMFAChallenge(expired): %v", err)
+	}
+	if ok, err := repo.CompleteMFAChallenge(ctx, expired.ID, 2); err != nil || ok {
+		t.Fatalf("CompleteMFAChallenge(expired) = %v, %v, want false", ok, err)
+	}
+}
+
+func testPasskeys(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	credential := &domain.PasskeyCredential{
+		ID:              []byte("credential-" + uuid.NewString()),
+		UserID:          user.ID,
+		UserHandle:      user.ID[:],
+		PublicKey:       []byte("public-key"),
+		AttestationType: "none",
+		AAGUID:          make([]byte, 16),
+		Transports:      []string{"internal", "hybrid"},
+		SignCount:       5,
+	}
+	if err := repo.CreatePasskey(ctx, credential); err != nil {
+		t.Fatalf("CreatePasskey: %v", err)
+	}
+	if credential.CreatedAt.IsZero() {
+		t.Fatalf("CreatePasskey did not set CreatedAt: %+v", credential)
+	}
+	duplicate := *credential
+	if err := repo.CreatePasskey(ctx, &duplicate); !errors.Is(err, domain.ErrPasskeyExists) {
+		t.Fatalf("CreatePasskey twice: err = %v, want ErrPasskeyExists", err)
+	}
+
+	if _, err := repo.GetPasskey(ctx, []byte("unknown")); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetPasskey(unknown): err = %v, want ErrNotFound", err)
+	}
+	got, err := repo.GetPasskey(ctx, credential.ID)
+	if err != nil {
+		t.Fatalf("GetPasskey: %v", err)
+	}
+	if got.UserID != user.ID || string(got.UserHandle) != string(user.ID[:]) || string(got.PublicKey) != "public-key" ||
+		got.AttestationType != "none" || len(got.AAGUID) != 16 || !slices.Equal(got.Transports, credential.Transports) ||
+		got.SignCount != 5 || got.LastUsedAt.Valid {
+		t.Fatalf("GetPasskey = %+v, want %+v", got, credential)
+	}
+
+	second := &domain.PasskeyCredential{
+		ID:         []byte("credential-" + uuid.NewString()),
+		UserID:     user.ID,
+		UserHandle: user.ID[:],
+		PublicKey:  []byte("other-key"),
+		AAGUID:     make([]byte, 16),
+	}
+	if err := repo.CreatePasskey(ctx, second); err != nil {
+		t.Fatalf("CreatePasskey(second): %v", err)
+	}
+	listed, err := repo.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("ListPasskeys: %v", err)
+	}
+	if len(listed) != 2 || string(listed[0].ID) != string(credential.ID) || string(listed[1].ID) != string(second.ID) || len(listed[1].Transports) != 0 {
+		t.Fatalf("ListPasskeys = %+v", listed)
+	}
+	if listed, err := repo.ListPasskeys(ctx, createUser(t, repo).ID); err != nil || len(listed) != 0 {
+		t.Fatalf("ListPasskeys(other user) = %v, %v, want none", listed, err)
+	}
+
+	// The signature counter only moves forward
+	for _, count := range []uint32{5, 4} {
+		if ok, err := repo.UsePasskey(ctx, credential.ID, count); err != nil || ok {
+			t.Fatalf("UsePasskey(%d) = %v, %v, want false", count, ok, err)
+		}
+	}
+	if ok, err := repo.UsePasskey(ctx, credential.ID, 6); err != nil || !ok {
+		t.Fatalf("UsePasskey(6) = %v, %v, want true", ok, err)
+	}
+	if got, err := repo.GetPasskey(ctx, credential.ID); err != nil || got.SignCount != 6 || !got.LastUsedAt.Valid {
+		t.Fatalf("used passkey = %+v, %v", got, err)
+	}
+
+	// Authenticators without a counter always report 0
+	for i := 0; i < 2; i++ {
+		if ok, err := repo.UsePasskey(ctx, second.ID, 0); err != nil || !ok {
+			t.Fatalf("UsePasskey(0) = %v, %v, want true", ok, err)
+		}
+	}
+	if ok, err := repo.UsePasskey(ctx, []byte("unknown"), 1); err != nil || ok {
+		t.Fatalf("UsePasskey(unknown) = %v, %v, want false", ok, err)
+	}
+}
+
+func testWebAuthnChallenges(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	registration := &domain.WebAuthnChallenge{
+		Challenge:   "challenge-" + uuid.NewString(),
+		Ceremony:    domain.CeremonyRegistration,
+		UserID:      uuid.NullUUID{UUID: user.ID, Valid: true},
+		SessionData: []byte(`{"challenge":"abc"}`),
+		ExpiresAt:   now().Add(5 * time.Minute),
+	}
+	login := &domain.WebAuthnChallenge{
+		Challenge:   "challenge-" + uuid.NewString(),
+		Ceremony:    domain.CeremonyLogin,
+		SessionData: []byte(`{}`),
+		ExpiresAt:   now().Add(5 * time.Minute),
+	}
+	for _, c := range []*domain.WebAuthnChallenge{registration, login} {
+		if err := repo.StoreWebAuthnChallenge(ctx, c); err != nil {
+			t.Fatalf("StoreWebAuthnChallenge: %v", err)
+		}
+		if c.CreatedAt.IsZero() {
+			t.Fatalf("StoreWebAuthnChallenge did not set CreatedAt: %+v", c)
+		}
+	}
+
+	got, err := repo.TakeWebAuthnChallenge(ctx, registration.Challenge)
+	if err != nil {
+		t.Fatalf("TakeWebAuthnChallenge: %v", err)
+	}
+	if got.Ceremony != domain.CeremonyRegistration || got.UserID != registration.UserID ||
+		string(got.SessionData) != string(registration.SessionData) || !got.ExpiresAt.Equal(registration.ExpiresAt) {
+		t.Fatalf("TakeWebAuthnChallenge = %+v, want %+v", got, registration)
+	}
+	if _, err := repo.TakeWebAuthnChallenge(ctx, registration.Challenge); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("TakeWebAuthnChallenge twice: err = %v, want ErrNotFound", err)
+	}
+
+	got, err = repo.TakeWebAuthnChallenge(ctx, login.Challenge)
+	if err != nil {
+		t.Fatalf("TakeWebAuthnChallenge(login): %v", err)
+	}
+	if got.Ceremony != domain.CeremonyLogin || got.UserID.Valid {
+		t.Fatalf("TakeWebAuthnChallenge(login) = %+v", got)
+	}
+}
+
+func testVerificationCodes(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	code := &domain.CodeSignature{
+		Code:      "123456",
+		Signature: uuid.New(),
+		UserID:    user.ID,
+		ExpiresAt: now().Add(time.Hour),
+	}
+	if err := repo.StoreVerificationCode(ctx, code); err != nil {
+		t.Fatalf("StoreVerificationCode: %v", err)
+	}
+
+	got, err := repo.GetVerificationCode(ctx, code.Signature)
+	if err != nil {
+		t.Fatalf("GetVerificationCode: %v", err)
+	}
+	if got.Code != code.Code || got.UserID != user.ID || got.IsUsed || !got.ExpiresAt.Equal(code.ExpiresAt) {
+		t.Fatalf("GetVerificationCode = %+v, want %+v", got, code)
+	}
+	if email, err := repo.GetEmailBySignature(ctx, code.Signature); err != nil || email != user.Email {
+		t.Fatalf("GetEmailBySignature = %q, %v", email, err)
+	}
+
+	for want := 1; want <= 3; want++ {
+		if attempts, err := repo.AddVerificationCodeAttempt(ctx, code.Signature); err != nil || attempts != want {
+			t.Fatalf("AddVerificationCodeAttempt = %d, %v, want %d", attempts, err, want)
+		}
+	}
+	if ok, err := repo.MarkVerificationCodeUsed(ctx, code.Signature, 2); err != nil || ok {
+		t.Fatalf("MarkVerificationCodeUsed over the attempt limit = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.MarkVerificationCodeUsed(ctx, code.Signature, 3); err != nil || !ok {
+		t.Fatalf("MarkVerificationCodeUsed = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.MarkVerificationCodeUsed(ctx, code.Signature, 3); err != nil || ok {
+		t.Fatalf("MarkVerificationCodeUsed twice = %v, %v, want false", ok, err)
+	}
+	if got, _ := repo.GetVerificationCode(ctx, code.Signature); !got.IsUsed || got.Attempts != 3 {
+		t.Fatalf("GetVerificationCode after use = %+v", got)
+	}
+
+	unknown := uuid.New()
+	if _, err := repo.GetVerificationCode(ctx, unknown); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetVerificationCode(unknown): err = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.AddVerificationCodeAttempt(ctx, unknown); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("AddVerificationCodeAttempt(unknown): err = %v, want ErrNotFound", err)
+	}
+	if ok, err := repo.MarkVerificationCodeUsed(ctx, unknown, 3); err != nil || ok {
+		t.Fatalf("MarkVerificationCodeUsed(unknown) = %v, %v, want false", ok, err)
+	}
+	if _, err := repo.GetEmailBySignature(ctx, unknown); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetEmailBySignature(unknown): err = %v, want ErrNotFound", err)
+	}
+}
+
+func testLoginCodes(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	if _, err := repo.GetLoginCode(ctx, user.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetLoginCode before any: err = %v, want ErrNotFound", err)
+	}
+
+	first := &domain.LoginCode{
+		Signature: uuid.New(),
+		UserID:    user.ID,
+		CodeHash:  "code-" + uuid.NewString(),
+		LinkHash:  "link-" + uuid.NewString(),
+		ExpiresAt: now().Add(15 * time.Minute),
+	}
+	if err := repo.StoreLoginCode(ctx, first); err != nil {
+		t.Fatalf("StoreLoginCode: %v", err)
+	}
+	if first.CreatedAt.IsZero() {
+		t.Fatalf("StoreLoginCode did not set CreatedAt: %+v", first)
+	}
+	if attempts, err := repo.AddLoginCodeAttempt(ctx, first.Signature); err != nil || attempts != 1 {
+		t.Fatalf("AddLoginCodeAttempt = %d, %v, want 1", attempts, err)
+	}
+
+	// A new code replaces the previous one together with its link, but not
+	// its attempts
+	second := &domain.LoginCode{
+		Signature: uuid.New(),
+		UserID:    user.ID,
+		CodeHash:  "code-" + uuid.NewString(),
+		LinkHash:  "link-" + uuid.NewString(),
+		ExpiresAt: now().Add(15 * time.Minute),
+	}
+	if err := repo.StoreLoginCode(ctx, second); err != nil {
+		t.Fatalf("StoreLoginCode(second): %v", err)
+	}
+	if _, err := repo.GetLoginCodeByLink(ctx, first.LinkHash); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetLoginCodeByLink(replaced): err = %v, want ErrNotFound", err)
+	}
+
+	got, err := repo.GetLoginCode(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetLoginCode: %v", err)
+	}
+	if got.Signature != second.Signature || got.CodeHash != second.CodeHash || got.LinkHash != second.LinkHash ||
+		got.Attempts != 1 || got.IsUsed || !got.ExpiresAt.Equal(second.ExpiresAt) {
+		t.Fatalf("GetLoginCode = %+v, want %+v with 1 attempt", got, second)
+	}
+	if second.Attempts != 1 {
+		t.Fatalf("StoreLoginCode set Attempts = %d, want 1", second.Attempts)
+	}
+	if got, err := repo.GetLoginCodeByLink(ctx, second.LinkHash); err != nil || got.Signature != second.Signature {
+		t.Fatalf("GetLoginCodeByLink = %+v, %v", got, err)
+	}
+
+	for want := 2; want <= 3; want++ {
+		if attempts, err := repo.AddLoginCodeAttempt(ctx, second.Signature); err != nil || attempts != want {
+			t.Fatalf("AddLoginCodeAttempt = %d, %v, want %d", attempts, err, want)
+		}
+	}
+	if _, err := repo.AddLoginCodeAttempt(ctx, uuid.New()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("AddLoginCodeAttempt(unknown): err = %v, want ErrNotFound", err)
+	}
+
+	if ok, err := repo.UseLoginCode(ctx, second.Signature, 2); err != nil || ok {
+		t.Fatalf("UseLoginCode over the attempt limit = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.UseLoginCode(ctx, second.Signature, 3); err != nil || !ok {
+		t.Fatalf("UseLoginCode = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.UseLoginCode(ctx, second.Signature, 3); err != nil || ok {
+		t.Fatalf("UseLoginCode twice = %v, %v, want false", ok, err)
+	}
+	if got, err := repo.GetLoginCode(ctx, user.ID); err != nil || !got.IsUsed || got.Attempts != 3 {
+		t.Fatalf("used login code = %+v, %v", got, err)
+	}
+
+	// Attempts of a used or expired code do not carry over
+	expired := &domain.LoginCode{
+		Signature: uuid.New(),
+		UserID:    user.ID,
+		CodeHash:  "code-" + uuid.NewString(),
+		LinkHash:  "link-" + uuid.NewString(),
+		ExpiresAt: now().Add(-time.Minute),
+	}
+	if err := repo.StoreLoginCode(ctx, expired); err != nil {
+		t.Fatalf("StoreLoginCode(expired): %v", err)
+	}
+	if expired.Attempts != 0 {
+		t.Fatalf("code after a used one has %d attempts, want 0", expired.Attempts)
+	}
+	if _, err := repo.AddLoginCodeAttempt(ctx, expired.Signature); err != nil {
+		t.Fatalf("AddLoginCodeAttempt(expired): %v", err)
+	}
+	if ok, err := repo.UseLoginCode(ctx, expired.Signature, 3); err != nil || ok {
+		t.Fatalf("UseLoginCode(expired) = %v, %v, want false", ok, err)
+	}
+
+	third := &domain.LoginCode{
+		Signature: uuid.New(),
+		UserID:    user.ID,
+		CodeHash:  "code-" + uuid.NewString(),
+		LinkHash:  "link-" + uuid.NewString(),
+		ExpiresAt: now().Add(15 * time.Minute),
+	}
+	if err := repo.StoreLoginCode(ctx, third); err != nil {
+		t.Fatalf("StoreLoginCode(third): %v", err)
+	}
+	if third.Attempts != 0 {
+		t.Fatalf("code after an expired one has %d attempts, want 0", third.Attempts)
+	}
+}
+
+func testRefreshTokens(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+	session := createSession(t, repo, user.ID, now())
+
+	token := &domain.Token{
+		AccessToken:      uuid.NewString(),
+		RefreshTokenHash: uuid.NewString(),
+		UserID:           user.ID,
+		FamilyID:         session.ID,
+		ExpiresAt:        now().Add(24 * time.Hour),
+	}
+	if err := repo.StoreRefreshToken(ctx, token); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	if token.ID == 0 || token.CreatedAt.IsZero() {
+		t.Fatalf("StoreRefreshToken did not fill ID and CreatedAt: %+v", token)
+	}
+
+	got, err := repo.GetRefreshToken(ctx, token.RefreshTokenHash)
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if got.ID != token.ID || got.AccessToken != token.AccessToken || got.UserID != user.ID ||
+		got.FamilyID != token.FamilyID || !got.ExpiresAt.Equal(token.ExpiresAt) ||
+		got.ConsumedAt.Valid || got.RevokedAt.Valid {
+		t.Fatalf("GetRefreshToken = %+v, want %+v", got, token)
+	}
+
+	// Only tokens issued with the replaced access token are rebound
+	replaced := token.AccessToken
+	token.AccessToken = uuid.NewString()
+	if err := repo.RebindRefreshToken(ctx, session.ID, replaced, token.AccessToken); err != nil {
+		t.Fatalf("RebindRefreshToken: %v", err)
+	}
+	if err := repo.RebindRefreshToken(ctx, session.ID, replaced, uuid.NewString()); err != nil {
+		t.Fatalf("RebindRefreshToken(replaced): %v", err)
+	}
+	if got, _ := repo.GetRefreshToken(ctx, token.RefreshTokenHash); got.AccessToken != token.AccessToken {
+		t.Fatalf("rebound token access token = %q, want %q", got.AccessToken, token.AccessToken)
+	}
+
+	// Consumption succeeds exactly once
+	if ok, err := repo.ConsumeRefreshToken(ctx, token.ID); err != nil || !ok {
+		t.Fatalf("first ConsumeRefreshToken = %v, %v", ok, err)
+	}
+	if ok, err := repo.ConsumeRefreshToken(ctx, token.ID); err != nil || ok {
+		t.Fatalf("second ConsumeRefreshToken = %v, %v", ok, err)
+	}
+	if got, _ := repo.GetRefreshToken(ctx, token.RefreshTokenHash); !got.ConsumedAt.Valid {
+		t.Fatal("consumed token has no consumed_at")
+	}
+
+	if _, err := repo.GetRefreshToken(ctx, uuid.NewString()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetRefreshToken(unknown): err = %v, want ErrNotFound", err)
+	}
+
+	if err := repo.DeleteRefreshToken(ctx, user.Email); err != nil {
+		t.Fatalf("DeleteRefreshToken: %v", err)
+	}
+	if _, err := repo.GetRefreshToken(ctx, token.RefreshTokenHash); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetRefreshToken after delete: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testSessions(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	older := createSession(t, repo, user.ID, now().Add(-time.Hour))
+	newer := createSession(t, repo, user.ID, now())
+
+	got, err := repo.GetSession(ctx, older.ID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.UserID != user.ID || got.ClientInfo != older.ClientInfo || !got.LastUsedAt.Equal(older.LastUsedAt) || got.RevokedAt.Valid {
+		t.Fatalf("GetSession = %+v, want %+v", got, older)
+	}
+	if _, err := repo.GetSession(ctx, uuid.New()); !errors.Is(err, domain.ErrSessionNotFound) {
+		t.Fatalf("GetSession(unknown): err = %v, want ErrSessionNotFound", err)
+	}
+
+	// Most recently used first
+	assertSessions(t, repo, user.ID, newer.ID, older.ID)
+
+	if err := repo.TouchSession(ctx, older.ID, now().Add(time.Minute)); err != nil {
+		t.Fatalf("TouchSession: %v", err)
+	}
+	assertSessions(t, repo, user.ID, older.ID, newer.ID)
+
+	if sessions, err := repo.ListSessions(ctx, uuid.New()); err != nil || len(sessions) != 0 {
+		t.Fatalf("ListSessions(unknown user) = %v, %v", sessions, err)
+	}
+}
+
+func testRevokeSessions(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	var sessions []*domain.Session
+	var tokens []*domain.Token
+	for i := 0; i < 4; i++ {
+		session := createSession(t, repo, user.ID, now().Add(-time.Duration(i)*time.Minute))
+		sessions = append(sessions, session)
+		tokens = append(tokens, storeToken(t, repo, user.ID, session.ID))
+	}
+
+	if err := repo.RevokeSession(ctx, sessions[3].ID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if got, _ := repo.GetSession(ctx, sessions[3].ID); !got.RevokedAt.Valid {
+		t.Fatal("revoked session has no revoked_at")
+	}
+	assertTokenRevoked(t, repo, tokens[3], true)
+	assertSessions(t, repo, user.ID, sessions[0].ID, sessions[1].ID, sessions[2].ID)
+
+	if err := repo.RevokeOtherSessions(ctx, user.ID, sessions[0].ID); err != nil {
+		t.Fatalf("RevokeOtherSessions: %v", err)
+	}
+	assertSessions(t, repo, user.ID, sessions[0].ID)
+	assertTokenRevoked(t, repo, tokens[0], false)
+	assertTokenRevoked(t, repo, tokens[1], true)
+
+	// A revoked token can no longer be consumed
+	if ok, err := repo.ConsumeRefreshToken(ctx, tokens[1].ID); err != nil || ok {
+		t.Fatalf("ConsumeRefreshToken(revoked) = %v, %v", ok, err)
+	}
+
+	if err := repo.RevokeAllSessions(ctx, user.ID); err != nil {
+		t.Fatalf("RevokeAllSessions: %v", err)
+	}
+	assertSessions(t, repo, user.ID)
+	assertTokenRevoked(t, repo, tokens[0], true)
+}
+
+func testPasswordResetTokens(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	token := &domain.PasswordResetToken{
+		TokenHash: uuid.NewString(),
+		UserID:    user.ID,
+		ExpiresAt: now().Add(time.Hour),
+	}
+	if err := repo.StorePasswordResetToken(ctx, token); err != nil {
+		t.Fatalf("StorePasswordResetToken: %v", err)
+	}
+	if token.ID == 0 || token.CreatedAt.IsZero() {
+		t.Fatalf("StorePasswordResetToken did not fill ID and CreatedAt: %+v", token)
+	}
+
+	got, err := repo.GetPasswordResetToken(ctx, token.TokenHash)
+	if err != nil {
+		t.Fatalf("GetPasswordResetToken: %v", err)
+	}
+	if got.ID != token.ID || got.UserID != user.ID || got.IsUsed || !got.ExpiresAt.Equal(token.ExpiresAt) {
+		t.Fatalf("GetPasswordResetToken = %+v, want %+v", got, token)
+	}
+
+	if ok, err := repo.MarkPasswordResetTokenUsed(ctx, token.ID); err != nil || !ok {
+		t.Fatalf("first MarkPasswordResetTokenUsed = %v, %v", ok, err)
+	}
+	if ok, err := repo.MarkPasswordResetTokenUsed(ctx, token.ID); err != nil || ok {
+		t.Fatalf("second MarkPasswordResetTokenUsed = %v, %v", ok, err)
+	}
+	if got, _ := repo.GetPasswordResetToken(ctx, token.TokenHash); !got.IsUsed {
+		t.Fatal("token is not marked as used")
+	}
+
+	if _, err := repo.GetPasswordResetToken(ctx, uuid.NewString()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetPasswordResetToken(unknown): err = %v, want ErrNotFound", err)
+	}
+}
+
+func testPendingEmailChanges(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	user := createUser(t, repo)
+
+	first := &domain.EmailChange{UserID: user.ID, NewEmail: uniqueEmail(), CodeHash: "code-" + uuid.NewString(), ExpiresAt: now().Add(time.Hour)}
+	if err := repo.StorePendingEmailChange(ctx, first); err != nil {
+		t.Fatalf("StorePendingEmailChange: %v", err)
+	}
+	if attempts, err := repo.AddEmailChangeAttempt(ctx, user.ID); err != nil || attempts != 1 {
+		t.Fatalf("AddEmailChangeAttempt = %d, %v, want 1", attempts, err)
+	}
+
+	// A second request replaces the first one, but not its attempts
+	second := &domain.EmailChange{UserID: user.ID, NewEmail: uniqueEmail(), CodeHash: "code-" + uuid.NewString(), ExpiresAt: now().Add(2 * time.Hour)}
+	if err := repo.StorePendingEmailChange(ctx, second); err != nil {
+		t.Fatalf("StorePendingEmailChange (replace): %v", err)
+	}
+	if second.Attempts != 1 {
+		t.Fatalf("StorePendingEmailChange set Attempts = %d, want 1", second.Attempts)
+	}
+
+	got, err := repo.GetPendingEmailChange(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetPendingEmailChange: %v", err)
+	}
+	if got.NewEmail != second.NewEmail || got.CodeHash != second.CodeHash || got.Attempts != 1 || !got.ExpiresAt.Equal(second.ExpiresAt) {
+		t.Fatalf("GetPendingEmailChange = %+v, want %+v", got, second)
+	}
+
+	if attempts, err := repo.AddEmailChangeAttempt(ctx, user.ID); err != nil || attempts != 2 {
+		t.Fatalf("AddEmailChangeAttempt = %d, %v, want 2", attempts, err)
+	}
+	if _, err := repo.AddEmailChangeAttempt(ctx, uuid.New()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("AddEmailChangeAttempt(unknown): err = %v, want ErrNotFound", err)
+	}
+
+	if ok, err := repo.UsePendingEmailChange(ctx, user.ID, first.CodeHash, 2); err != nil || ok {
+		t.Fatalf("UsePendingEmailChange(replaced) = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.UsePendingEmailChange(ctx, user.ID, second.CodeHash, 1); err != nil || ok {
+		t.Fatalf("UsePendingEmailChange over the attempt limit = %v, %v, want false", ok, err)
+	}
+	if ok, err := repo.UsePendingEmailChange(ctx, user.ID, second.CodeHash, 2); err != nil || !ok {
+		t.Fatalf("UsePendingEmailChange = %v, %v, want true", ok, err)
+	}
+	if ok, err := repo.UsePendingEmailChange(ctx, user.ID, second.CodeHash, 2); err != nil || ok {
+		t.Fatalf("UsePendingEmailChange twice = %v, %v, want false", ok, err)
+	}
+	if _, err := repo.GetPendingEmailChange(ctx, user.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("GetPendingEmailChange after use: err = %v, want ErrNotFound", err)
+	}
+
+	// An expired change cannot be used and its attempts do not carry over
+	expired := &domain.EmailChange{UserID: user.ID, NewEmail: uniqueEmail(), CodeHash: "code-" + uuid.NewString(), ExpiresAt: now().Add(-time.Minute)}
+	if err := repo.StorePendingEmailChange(ctx, expired); err != nil {
+		t.Fatalf("StorePendingEmailChange(expired): %v", err)
+	}
+	if _, err := repo.AddEmailChangeAttempt(ctx, user.ID); err != nil {
+		t.Fatalf("AddEmailChangeAttempt(expired): %v", err)
+	}
+	if ok, err := repo.UsePendingEmailChange(ctx, user.ID, expired.CodeHash, 2); err != nil || ok {
+		t.Fatalf("UsePendingEmailChange(expired) = %v, %v, want false", ok, err)
+	}
+
+	third := &domain.EmailChange{UserID: user.ID, NewEmail: uniqueEmail(), CodeHash: "code-" + uuid.NewString(), ExpiresAt: now().Add(time.Hour)}
+	if err := repo.StorePendingEmailChange(ctx, third); err != nil {
+		t.Fatalf("StorePendingEmailChange(third): %v", err)
+	}
+	if third.Attempts != 0 {
+		t.Fatalf("change after an expired one has %d attempts, want 0", third.Attempts)
+	}
+}
+
+func testAccessTokenDenylist(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	jti, expired := uuid.NewString(), uuid.NewString()
+
+	if err := repo.RevokeAccessToken(ctx, jti, now().Add(15*time.Minute)); err != nil {
+		t.Fatalf("RevokeAccessToken: %v", err)
+	}
+	// Revoking twice is not an error
+	if err := repo.RevokeAccessToken(ctx, jti, now().Add(15*time.Minute)); err != nil {
+		t.Fatalf("RevokeAccessToken (again): %v", err)
+	}
+	if revoked, err := repo.IsAccessTokenRevoked(ctx, jti); err != nil || !revoked {
+		t.Fatalf("IsAccessTokenRevoked = %v, %v", revoked, err)
+	}
+	if revoked, err := repo.IsAccessTokenRevoked(ctx, uuid.NewString()); err != nil || revoked {
+		t.Fatalf("IsAccessTokenRevoked(unknown) = %v, %v", revoked, err)
+	}
+
+	// The entry is meaningless once the token itself has expired
+	if err := repo.RevokeAccessToken(ctx, expired, now().Add(-time.Minute)); err != nil {
+		t.Fatalf("RevokeAccessToken(expired): %v", err)
+	}
+	if revoked, err := repo.IsAccessTokenRevoked(ctx, expired); err != nil || revoked {
+		t.Fatalf("IsAccessTokenRevoked(expired) = %v, %v", revoked, err)
+	}
+}
+
+func testOutbox(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	email := enqueueEmail(t, repo)
+
+	if email.ID == 0 || email.Status != domain.OutboxPending || email.Attempts != 0 || email.CreatedAt.IsZero() {
+		t.Fatalf("EnqueueEmail did not fill defaults: %+v", email)
+	}
+
+	lease := now().Add(2 * time.Minute)
+	claimed := claim(t, repo, email.ID, lease)
+	if claimed == nil {
+		t.Fatal("due email was not claimed")
+	}
+	if claimed.Attempts != 1 || claimed.Recipient != email.Recipient || claimed.Subject != email.Subject ||
+		claimed.Body != email.Body || claimed.HTML != email.HTML || !claimed.NextAttemptAt.Equal(lease) {
+		t.Fatalf("claimed %+v", claimed)
+	}
+
+	// The lease hides the email from other workers
+	if claim(t, repo, email.ID, lease) != nil {
+		t.Fatal("leased email was claimed twice")
+	}
+
+	if err := repo.RetryOutboxEmail(ctx, email.ID, "timeout", now().Add(time.Hour)); err != nil {
+		t.Fatalf("RetryOutboxEmail: %v", err)
+	}
+	if claim(t, repo, email.ID, lease) != nil {
+		t.Fatal("email was claimed before its next attempt")
+	}
+
+	if err := repo.RetryOutboxEmail(ctx, email.ID, "timeout", now().Add(-time.Second)); err != nil {
+		t.Fatalf("RetryOutboxEmail: %v", err)
+	}
+	claimed = claim(t, repo, email.ID, lease)
+	if claimed == nil {
+		t.Fatal("email was not claimed for a retry")
+	}
+	if claimed.Attempts != 2 || claimed.LastError.String != "timeout" {
+		t.Fatalf("retried email %+v", claimed)
+	}
+
+	if err := repo.MarkOutboxEmailSent(ctx, email.ID); err != nil {
+		t.Fatalf("MarkOutboxEmailSent: %v", err)
+	}
+	if err := repo.RetryOutboxEmail(ctx, email.ID, "", now().Add(-time.Second)); err != nil {
+		t.Fatalf("RetryOutboxEmail: %v", err)
+	}
+	if claim(t, repo, email.ID, lease) != nil {
+		t.Fatal("sent email was claimed again")
+	}
+}
+
+func testOutboxDeadLetters(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+	email := enqueueEmail(t, repo)
+
+	if claim(t, repo, email.ID, now().Add(time.Minute)) == nil {
+		t.Fatal("due email was not claimed")
+	}
+	if err := repo.DeadLetterOutboxEmail(ctx, email.ID, "mailbox unavailable"); err != nil {
+		t.Fatalf("DeadLetterOutboxEmail: %v", err)
+	}
+
+	dead := findEmail(t, repo, email.ID)
+	if dead == nil {
+		t.Fatal("dead email is not listed")
+	}
+	if dead.Status != domain.OutboxDead || dead.LastError.String != "mailbox unavailable" || dead.Attempts != 1 {
+		t.Fatalf("dead email %+v", dead)
+	}
+
+	if ok, err := repo.RequeueDeadLetter(ctx, email.ID); err != nil || !ok {
+		t.Fatalf("RequeueDeadLetter = %v, %v", ok, err)
+	}
+	if ok, err := repo.RequeueDeadLetter(ctx, email.ID); err != nil || ok {
+		t.Fatalf("RequeueDeadLetter (pending) = %v, %v", ok, err)
+	}
+	if findEmail(t, repo, email.ID) != nil {
+		t.Fatal("requeued email is still listed as dead")
+	}
+
+	// Requeueing resets the attempt counter
+	claimed := claim(t, repo, email.ID, now().Add(time.Minute))
+	if claimed == nil || claimed.Attempts != 1 {
+		t.Fatalf("requeued email claimed as %+v", claimed)
+	}
+}
+
+func testWithTx(t *testing.T, repo repository.UserRepository) {
+	ctx := context.Background()
+
+	var committed *domain.User
+	err := repo.WithTx(ctx, func(tx repository.UserRepository) error {
+		committed = createUser(t, tx)
+
+		// Nested calls join the outer transaction
+		return tx.WithTx(ctx, func(inner repository.UserRepository) error {
+			_, err := inner.GetUserByID(ctx, committed.ID)
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, committed.ID); err != nil {
+		t.Fatalf("committed user: %v", err)
+	}
+
+	errRollback := errors.New("rollback")
+	var rolledBack *domain.User
+	err = repo.WithTx(ctx, func(tx repository.UserRepository) error {
+		rolledBack = createUser(t, tx)
+		committed.IsConfirmed = true
+		if err := tx.UpdateUser(ctx, committed); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx: err = %v, want the error returned by fn", err)
+	}
+	if _, err := repo.GetUserByID(ctx, rolledBack.ID); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("user created in a rolled back transaction: err = %v, want ErrUserNotFound", err)
+	}
+	if got, _ := repo.GetUserByID(ctx, committed.ID); got.IsConfirmed {
+		t.Fatal("update from a rolled back transaction was kept")
+	}
+}
+
+// now is truncated to the precision Postgres stores
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+func uniqueEmail() string {
+	return uuid.NewString() + "@example.com"
+}
+
+func createUser(t *testing.T, repo repository.UserRepository) *domain.User {
+	t.Helper()
+
+	user, err := repo.CreateUser(context.Background(), &domain.User{
+		Email:     uniqueEmail(),
+		Password:  "hash",
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if user.ID == uuid.Nil {
+		t.Fatal("CreateUser did not assign an ID")
+	}
+	return user
+}
+
+func createSession(t *testing.T, repo repository.UserRepository, userID uuid.UUID, lastUsedAt time.Time) *domain.Session {
+	t.Helper()
+
+	session := &domain.Session{
+		ID:     uuid.New(),
+		UserID: userID,
+		ClientInfo: domain.ClientInfo{
+			UserAgent:  "repositorytest",
+			ClientIP:   "127.0.0.1",
+			DeviceName: "test",
+		},
+		CreatedAt:  lastUsedAt,
+		LastUsedAt: lastUsedAt,
+	}
+	if err := repo.CreateSession(context.Background(), session); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	return session
+}
+
+func storeToken(t *testing.T, repo repository.UserRepository, userID, familyID uuid.UUID) *domain.Token {
+	t.Helper()
+
+	token := &domain.Token{
+		AccessToken:      uuid.NewString(),
+		RefreshTokenHash: uuid.NewString(),
+		UserID:           userID,
+		FamilyID:         familyID,
+		ExpiresAt:        now().Add(time.Hour),
+	}
+	if err := repo.StoreRefreshToken(context.Background(), token); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	return token
+}
+
+func enqueueEmail(t *testing.T, repo repository.UserRepository) *domain.OutboxEmail {
+	t.Helper()
+
+	email := &domain.OutboxEmail{
+		Recipient: uniqueEmail(),
+		Subject:   "Subject",
+		Body:      "Body",
+		HTML:      "<p>Body</p>",
+	}
+	if err := repo.EnqueueEmail(context.Background(), email); err != nil {
+		t.Fatalf("EnqueueEmail: %v", err)
+	}
+	return email
+}
+
+// claim claims every due email and returns the one with the given id, if any
+func claim(t *testing.T, repo repository.UserRepository, id int64, lease time.Time) *domain.OutboxEmail {
+	t.Helper()
+
+	emails, err := repo.ClaimOutboxEmails(context.Background(), claimAll, lease)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEmails: %v", err)
+	}
+	for _, e := range emails {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// findEmail returns the dead email with the given id, if any
+func findEmail(t *testing.T, repo repository.UserRepository, id int64) *domain.OutboxEmail {
+	t.Helper()
+
+	emails, err := repo.ListDeadLetters(context.Background(), claimAll)
+	if err != nil {
+		t.Fatalf("ListDeadLetters: %v", err)
+	}
+	for _, e := range emails {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+func assertSessions(t *testing.T, repo repository.UserRepository, userID uuid.UUID, want ...uuid.UUID) {
+	t.Helper()
+
+	sessions, err := repo.ListSessions(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	got := make([]uuid.UUID, len(sessions))
+	for i, s := range sessions {
+		got[i] = s.ID
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ListSessions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ListSessions = %v, want %v", got, want)
+		}
+	}
+}
+
+func assertTokenRevoked(t *testing.T, repo repository.UserRepository, token *domain.Token, want bool) {
+	t.Helper()
+
+	got, err := repo.GetRefreshToken(context.Background(), token.RefreshTokenHash)
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if got.RevokedAt.Valid != want {
+		t.Fatalf("token of session %s: revoked = %v, want %v", token.FamilyID, got.RevokedAt.Valid, want)
+	}
+}
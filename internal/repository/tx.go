@@ -0,0 +1,29 @@
+package repository
+
+import "database/sql"
+
+// TxOption configures a transaction started by UserRepository.WithTx
+type TxOption func(*sql.TxOptions)
+
+// WithIsolation sets the isolation level of the transaction
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *sql.TxOptions) {
+		o.Isolation = level
+	}
+}
+
+// ReadOnly starts a read-only transaction
+func ReadOnly() TxOption {
+	return func(o *sql.TxOptions) {
+		o.ReadOnly = true
+	}
+}
+
+// NewTxOptions applies opts on top of the driver defaults
+func NewTxOptions(opts ...TxOption) *sql.TxOptions {
+	o := &sql.TxOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/Olegnemlii/test123/internal/domain" // Замените 'insta' на имя вашего модуля
 
@@ -9,18 +10,176 @@ import (
 )
 
 type UserRepository interface {
+	// WithTx runs fn in a transaction and commits it if fn returns nil. The
+	// repository passed to fn works inside that transaction. Serialization
+	// failures are retried, so fn must not have side effects outside repo.
+	// Calling WithTx on a repository that is already in a transaction runs fn
+	// in the existing one.
+	WithTx(ctx context.Context, fn func(repo UserRepository) error, opts ...TxOption) error
 	CreateUser(ctx context.Context, user *domain.User) (*domain.User, error)
+	// GetUserByID, GetUserByEmail and GetEmailBySignature do not return soft-deleted users
 	GetUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error)
 	GetUserByEmail(ctx context.Context, email string) (*domain.User, error)
+	// GetDeletedUserByEmail returns the user only if it is soft-deleted
+	GetDeletedUserByEmail(ctx context.Context, email string) (*domain.User, error)
+	// FindUserByID returns the user whether or not it is soft-deleted
+	FindUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error)
+	// GetPasswordHash returns the password hash of a user whether or not it is
+	// soft-deleted. Users read through CachedUserRepository come without it.
+	GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error)
+	// ListUsers returns up to filter.Limit users matching filter. Soft-deleted
+	// users are included unless filter.Deleted says otherwise
+	ListUsers(ctx context.Context, filter domain.UserFilter) ([]*domain.User, error)
+	// UpdateUser keeps the stored password hash if user.Password is empty
 	UpdateUser(ctx context.Context, user *domain.User) error
+	// DeleteUser soft-deletes the user; the email stays taken until it is purged
 	DeleteUser(ctx context.Context, id uuid.UUID) error
+	// RestoreUser undoes DeleteUser and reports false unless the user was deleted after deletedAfter
+	RestoreUser(ctx context.Context, id uuid.UUID, deletedAfter time.Time) (bool, error)
+	// PurgeDeletedUsers permanently removes up to limit users deleted before
+	// deletedBefore together with their codes, tokens and sessions
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+	// PurgeExpired removes WebAuthn and MFA challenges, login codes and
+	// revoked access tokens that expired before expiredBefore and returns
+	// how many were removed
+	PurgeExpired(ctx context.Context, expiredBefore time.Time) (int, error)
 	GetEmailBySignature(ctx context.Context, signature uuid.UUID) (string, error)
-	UpdateUserSignature(ctx context.Context, userID uuid.UUID, signature uuid.UUID) error
-	StoreVerificationCode(ctx context.Context, email string, code string) error
-	GetVerificationCode(ctx context.Context, email string) (string, error)
-	DeleteVerificationCode(ctx context.Context, email string) error
-	StoreRefreshToken(ctx context.Context, email string, refreshToken string) error
-	GetRefreshToken(ctx context.Context, email string) (string, error)
+	StoreVerificationCode(ctx context.Context, code *domain.CodeSignature) error
+	GetVerificationCode(ctx context.Context, signature uuid.UUID) (*domain.CodeSignature, error)
+	// AddVerificationCodeAttempt counts an entered code and returns the attempts made so far
+	AddVerificationCodeAttempt(ctx context.Context, signature uuid.UUID) (int, error)
+	// MarkVerificationCodeUsed reports false if the code was already used,
+	// has expired or had more than maxAttempts attempts
+	MarkVerificationCodeUsed(ctx context.Context, signature uuid.UUID, maxAttempts int) (bool, error)
+	// StoreLoginCode replaces the user's earlier login codes, so only the
+	// latest email can be used to sign in. The attempts of a replaced code
+	// that is unused and unexpired carry over to the new one.
+	StoreLoginCode(ctx context.Context, code *domain.LoginCode) error
+	// GetLoginCode returns the user's current login code or ErrNotFound
+	GetLoginCode(ctx context.Context, userID uuid.UUID) (*domain.LoginCode, error)
+	// GetLoginCodeByLink returns ErrNotFound for an unknown magic link
+	GetLoginCodeByLink(ctx context.Context, linkHash string) (*domain.LoginCode, error)
+	// AddLoginCodeAttempt counts an entered code and returns the attempts made so far
+	AddLoginCodeAttempt(ctx context.Context, signature uuid.UUID) (int, error)
+	// UseLoginCode reports false if the code was already used, has expired
+	// or had more than maxAttempts attempts
+	UseLoginCode(ctx context.Context, signature uuid.UUID, maxAttempts int) (bool, error)
+	StoreRefreshToken(ctx context.Context, token *domain.Token) error
+	GetRefreshToken(ctx context.Context, refreshTokenHash string) (*domain.Token, error)
+	// ConsumeRefreshToken marks the token as used and reports false if it had already been consumed
+	ConsumeRefreshToken(ctx context.Context, id int) (bool, error)
+	// RebindRefreshToken moves the refresh tokens of a session issued with
+	// one access token over to the access token that replaced it
+	RebindRefreshToken(ctx context.Context, familyID uuid.UUID, oldAccessTokenID, newAccessTokenID string) error
 	DeleteRefreshToken(ctx context.Context, email string) error
+	CreateSession(ctx context.Context, session *domain.Session) error
+	GetSession(ctx context.Context, id uuid.UUID) (*domain.Session, error)
+	ListSessions(ctx context.Context, userID uuid.UUID) ([]*domain.Session, error)
+	TouchSession(ctx context.Context, id uuid.UUID, lastUsedAt time.Time) error
+	// RevokeSession revokes the session together with all of its refresh tokens
+	RevokeSession(ctx context.Context, id uuid.UUID) error
+	RevokeOtherSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) error
+	RevokeAllSessions(ctx context.Context, userID uuid.UUID) error
+	StorePasswordResetToken(ctx context.Context, token *domain.PasswordResetToken) error
+	GetPasswordResetToken(ctx context.Context, tokenHash string) (*domain.PasswordResetToken, error)
+	// MarkPasswordResetTokenUsed reports false if the token had already been used
+	MarkPasswordResetTokenUsed(ctx context.Context, id int) (bool, error)
+	// StorePendingEmailChange replaces any earlier pending change of the same
+	// user and keeps the attempts of one that has not expired yet
+	StorePendingEmailChange(ctx context.Context, change *domain.EmailChange) error
+	GetPendingEmailChange(ctx context.Context, userID uuid.UUID) (*domain.EmailChange, error)
+	// AddEmailChangeAttempt counts an entered code and returns the attempts made so far
+	AddEmailChangeAttempt(ctx context.Context, userID uuid.UUID) (int, error)
+	// UsePendingEmailChange deletes the change with the code and reports false
+	// if it was replaced, has expired or had more than maxAttempts attempts
+	UsePendingEmailChange(ctx context.Context, userID uuid.UUID, codeHash string, maxAttempts int) (bool, error)
+	RevokeAccessToken(ctx context.Context, accessTokenID string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, accessTokenID string) (bool, error)
+	EnqueueEmail(ctx context.Context, email *domain.OutboxEmail) error
+	// ClaimOutboxEmails locks up to limit due pending emails, counts an attempt
+	// and hides them from other workers until leaseUntil
+	ClaimOutboxEmails(ctx context.Context, limit int, leaseUntil time.Time) ([]*domain.OutboxEmail, error)
+	MarkOutboxEmailSent(ctx context.Context, id int64) error
+	RetryOutboxEmail(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error
+	DeadLetterOutboxEmail(ctx context.Context, id int64, lastError string) error
+	ListDeadLetters(ctx context.Context, limit int) ([]*domain.OutboxEmail, error)
+	// RequeueDeadLetter reports false if there is no dead email with this id
+	RequeueDeadLetter(ctx context.Context, id int64) (bool, error)
+	AppendAuditEntry(ctx context.Context, entry *domain.AuditEntry) error
+	// ListAuditEntries returns up to limit entries, newest first
+	ListAuditEntries(ctx context.Context, limit int) ([]*domain.AuditEntry, error)
+	// ListRoles returns all roles with their permissions, ordered by name
+	ListRoles(ctx context.Context) ([]*domain.Role, error)
+	// AssignRole grants the role to the user; granting it again is a no-op.
+	// Returns ErrRoleNotFound or ErrUserNotFound if either does not exist
+	AssignRole(ctx context.Context, userID uuid.UUID, role string) error
+	// RevokeRole takes the role away from the user; revoking a role the user does not have is a no-op
+	RevokeRole(ctx context.Context, userID uuid.UUID, role string) error
+	// GetUserRoles returns the names of the user's roles, sorted
+	GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+	// GetUserPermissions returns the distinct permissions of all of the user's roles, sorted
+	GetUserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error)
+	// CreateOrganization assigns the organization an ID and stores it
+	CreateOrganization(ctx context.Context, org *domain.Organization) error
+	GetOrganization(ctx context.Context, id uuid.UUID) (*domain.Organization, error)
+	// AddMember returns ErrAlreadyMember if the user already belongs to the organization
+	AddMember(ctx context.Context, member *domain.Membership) error
+	// GetMembership returns ErrNotFound if the user is not a member
+	GetMembership(ctx context.Context, orgID, userID uuid.UUID) (*domain.Membership, error)
+	// ListMembers returns the members of the organization, oldest first
+	ListMembers(ctx context.Context, orgID uuid.UUID) ([]*domain.Membership, error)
+	// ListMemberships returns the organizations the user belongs to, oldest first
+	ListMemberships(ctx context.Context, userID uuid.UUID) ([]*domain.Membership, error)
+	// RemoveMember also clears the organization from the sessions of the user that act in it
+	RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error
+	// StoreInvitation replaces any pending invitation of the same email to the same organization
+	StoreInvitation(ctx context.Context, invitation *domain.Invitation) error
+	GetInvitation(ctx context.Context, tokenHash string) (*domain.Invitation, error)
+	// MarkInvitationAccepted reports false if the invitation had already been accepted
+	MarkInvitationAccepted(ctx context.Context, id int64) (bool, error)
+	// SetSessionOrganization sets the organization the session acts in; an invalid orgID clears it
+	SetSessionOrganization(ctx context.Context, sessionID uuid.UUID, orgID uuid.NullUUID) error
+	// StoreTOTP replaces an unconfirmed enrollment of the user and returns
+	// ErrTOTPAlreadyEnabled if the user has confirmed TOTP
+	StoreTOTP(ctx context.Context, credential *domain.TOTPCredential) error
+	// GetTOTP returns ErrNotFound if the user has not enrolled
+	GetTOTP(ctx context.Context, userID uuid.UUID) (*domain.TOTPCredential, error)
+	// ConfirmTOTP confirms the enrollment with a code of step and reports false if it was already confirmed
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	// UseTOTPStep records a code of step as used and reports false if a code
+	// of this or a later step was accepted before
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	// AddTOTPAttempt counts an entered second-factor code and returns the
+	// attempts in a row; the count starts over if the previous attempt was
+	// before since. Returns ErrNotFound if the user has not enrolled
+	AddTOTPAttempt(ctx context.Context, userID uuid.UUID, since time.Time) (int, error)
+	ResetTOTPAttempts(ctx context.Context, userID uuid.UUID) error
+	// DeleteTOTP removes the enrollment together with the recovery codes
+	DeleteTOTP(ctx context.Context, userID uuid.UUID) error
+	// ReplaceRecoveryCodes discards the user's recovery codes and stores the new hashes
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	// UseRecoveryCode marks the code as used and reports false if it is unknown or was already used
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error)
+	StoreMFAChallenge(ctx context.Context, challenge *domain.MFAChallenge) error
+	GetMFAChallenge(ctx context.Context, tokenHash string) (*domain.MFAChallenge, error)
+	// AddMFAChallengeAttempt counts an entered code and returns the attempts made so far
+	AddMFAChallengeAttempt(ctx context.Context, id int64) (int, error)
+	// CompleteMFAChallenge reports false if the challenge had already been
+	// completed, has expired or had more than maxAttempts attempts
+	CompleteMFAChallenge(ctx context.Context, id int64, maxAttempts int) (bool, error)
+	// CreatePasskey returns ErrPasskeyExists if the credential ID is already registered
+	CreatePasskey(ctx context.Context, credential *domain.PasskeyCredential) error
+	// GetPasskey returns ErrNotFound for an unknown credential ID
+	GetPasskey(ctx context.Context, credentialID []byte) (*domain.PasskeyCredential, error)
+	// ListPasskeys returns the user's passkeys, oldest first
+	ListPasskeys(ctx context.Context, userID uuid.UUID) ([]*domain.PasskeyCredential, error)
+	// UsePasskey stores the signature counter of a successful login and reports
+	// false if it is not above the stored one. A counter of 0 on both sides
+	// means the authenticator does not count and is accepted.
+	UsePasskey(ctx context.Context, credentialID []byte, signCount uint32) (bool, error)
+	StoreWebAuthnChallenge(ctx context.Context, challenge *domain.WebAuthnChallenge) error
+	// TakeWebAuthnChallenge deletes and returns the challenge, so it can be
+	// used once, and returns ErrNotFound if it is unknown
+	TakeWebAuthnChallenge(ctx context.Context, challenge string) (*domain.WebAuthnChallenge, error)
 	// Добавьте другие методы, которые вам нужны для работы с User
 }
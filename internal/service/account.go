@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+)
+
+const (
+	// emailChangeTTL is how long the code sent to a new address stays valid
+	emailChangeTTL = time.Hour
+	// emailChangeAttempts is how many codes can be entered for a change.
+	// Requesting another change does not reset the count until the current
+	// one expires or is confirmed.
+	emailChangeAttempts = 5
+)
+
+// Смена пароля: проверяется текущий пароль, остальные сессии отзываются
+func (s *UserService) ChangePassword(ctx context.Context, accessToken, oldPassword, newPassword string) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+
+	if !s.CheckPassword(ctx, user, oldPassword) {
+		return domain.ErrInvalidCredentials
+	}
+
+	if err := s.validatePassword("new_password", newPassword, user.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hashPassword(newPassword)
+	if err != nil {
+		log.Printf("error hashing password: %v", err)
+		return err
+	}
+
+	user.Password = hashedPassword
+	user.UpdatedAt = time.Now().UTC()
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		if err := repo.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		return repo.RevokeOtherSessions(ctx, user.ID, principal.SessionID)
+	})
+	if err != nil {
+		log.Printf("error changing password: %v", err)
+		return err
+	}
+
+	if err := s.mail.SendPasswordChanged(user.Email); err != nil {
+		log.Printf("error sending password change notification: %v", err)
+	}
+
+	return nil
+}
+
+// Запрос на смену почты: код отправляется на новый адрес, старый адрес уведомляется
+func (s *UserService) ChangeEmail(ctx context.Context, accessToken, newEmail, password string) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+
+	if !s.CheckPassword(ctx, user, password) {
+		return domain.ErrInvalidCredentials
+	}
+
+	if err := s.ensureEmailFree(ctx, newEmail); err != nil {
+		return err
+	}
+
+	code, err := generateRandomCode(6)
+	if err != nil {
+		log.Printf("error generating email change code: %v", err)
+		return err
+	}
+
+	msg, err := s.mail.EmailChangeCodeMessage(newEmail, code)
+	if err != nil {
+		log.Printf("error rendering email change code: %v", err)
+		return err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		err := repo.StorePendingEmailChange(ctx, &domain.EmailChange{
+			UserID:    user.ID,
+			NewEmail:  newEmail,
+			CodeHash:  hashToken(code),
+			ExpiresAt: time.Now().UTC().Add(emailChangeTTL),
+		})
+		if err != nil {
+			return err
+		}
+		return repo.EnqueueEmail(ctx, outboxEmail(msg))
+	})
+	if err != nil {
+		log.Printf("error storing pending email change: %v", err)
+		return err
+	}
+
+	if err := s.mail.SendEmailChangeRequested(user.Email, newEmail); err != nil {
+		log.Printf("error sending email change notification: %v", err)
+	}
+
+	return nil
+}
+
+// Подтверждение смены почты кодом, отправленным на новый адрес. После
+// emailChangeAttempts неверных кодов запрос перестаёт действовать
+func (s *UserService) ConfirmEmailChange(ctx context.Context, accessToken, code string) (*domain.User, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := s.userRepo.GetPendingEmailChange(ctx, principal.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidCode
+		}
+		log.Printf("error getting pending email change: %v", err)
+		return nil, err
+	}
+
+	if change.Attempts >= emailChangeAttempts {
+		return nil, domain.ErrInvalidCode
+	}
+	if time.Now().UTC().After(change.ExpiresAt) {
+		return nil, domain.ErrCodeExpired
+	}
+
+	// The attempt is counted before the code is compared, so concurrent
+	// guesses cannot all pass the check above
+	attempts, err := s.userRepo.AddEmailChangeAttempt(ctx, principal.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidCode
+		}
+		log.Printf("error counting email change attempt: %v", err)
+		return nil, err
+	}
+	if attempts > emailChangeAttempts {
+		return nil, domain.ErrInvalidCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(change.CodeHash), []byte(hashToken(code))) != 1 {
+		return nil, domain.ErrInvalidCode
+	}
+
+	if err := s.ensureEmailFree(ctx, change.NewEmail); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return nil, err
+	}
+
+	oldEmail := user.Email
+	user.Email = change.NewEmail
+	user.UpdatedAt = time.Now().UTC()
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		// Of two concurrent confirmations only one succeeds
+		used, err := repo.UsePendingEmailChange(ctx, user.ID, change.CodeHash, emailChangeAttempts)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return domain.ErrInvalidCode
+		}
+		return repo.UpdateUser(ctx, user)
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidCode) {
+			log.Printf("error updating email: %v", err)
+		}
+		return nil, err
+	}
+
+	if err := s.mail.SendEmailChanged(oldEmail, user.Email); err != nil {
+		log.Printf("error sending email change notification: %v", err)
+	}
+
+	return user, nil
+}
+
+func (s *UserService) ensureEmailFree(ctx context.Context, email string) error {
+	_, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err == nil {
+		return domain.ErrEmailTaken
+	}
+	if !errors.Is(err, domain.ErrUserNotFound) {
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+	return nil
+}
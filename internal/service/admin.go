@@ -0,0 +1,429 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// Page sizes of AdminService.ListUsers
+const (
+	defaultUsersPageSize = 50
+	maxUsersPageSize     = 500
+)
+
+// AdminService backs the Admin gRPC service used by support staff. Every
+// method requires an access token with the permission of the method and is
+// written to the audit log.
+type AdminService struct {
+	users    *UserService
+	roles    *RoleService
+	userRepo repository.UserRepository
+	mail     *MailService
+	appURL   string
+}
+
+func NewAdminService(users *UserService, roles *RoleService, userRepo repository.UserRepository, mail *MailService, cfg config.Config) *AdminService {
+	return &AdminService{
+		users:    users,
+		roles:    roles,
+		userRepo: userRepo,
+		mail:     mail,
+		appURL:   strings.TrimRight(cfg.AppURL, "/"),
+	}
+}
+
+// Список пользователей с фильтрами; второй результат - токен следующей страницы
+func (s *AdminService) ListUsers(ctx context.Context, accessToken string, filter domain.UserFilter, pageToken string) ([]*domain.User, string, error) {
+	var users []*domain.User
+	var nextPageToken string
+
+	err := s.call(ctx, accessToken, domain.PermUsersRead, "ListUsers", uuid.Nil, describeFilter(filter, pageToken), func(*Principal) error {
+		if pageToken != "" {
+			cursor, err := decodeUserCursor(pageToken)
+			if err != nil {
+				return err
+			}
+			filter.After = cursor
+		}
+
+		switch {
+		case filter.Limit <= 0:
+			filter.Limit = defaultUsersPageSize
+		case filter.Limit > maxUsersPageSize:
+			filter.Limit = maxUsersPageSize
+		}
+		pageSize := filter.Limit
+
+		// One extra row tells whether there is a next page
+		filter.Limit++
+		var err error
+		users, err = s.userRepo.ListUsers(ctx, filter)
+		if err != nil {
+			log.Printf("error listing users: %v", err)
+			return err
+		}
+
+		if len(users) > pageSize {
+			users = users[:pageSize]
+			nextPageToken = encodeUserCursor(users[pageSize-1])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return users, nextPageToken, nil
+}
+
+// Получение пользователя, в том числе удалённого, вместе с его ролями
+func (s *AdminService) GetUser(ctx context.Context, accessToken string, id uuid.UUID) (*domain.User, []string, error) {
+	var user *domain.User
+	var roles []string
+
+	err := s.call(ctx, accessToken, domain.PermUsersRead, "GetUser", id, "", func(*Principal) error {
+		var err error
+		user, err = s.userRepo.FindUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		roles, err = s.roles.UserRoles(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, roles, nil
+}
+
+// Подтверждение почты пользователя без кода
+func (s *AdminService) ForceConfirmEmail(ctx context.Context, accessToken string, id uuid.UUID) (*domain.User, error) {
+	var user *domain.User
+
+	err := s.call(ctx, accessToken, domain.PermUsersWrite, "ForceConfirmEmail", id, "", func(*Principal) error {
+		var err error
+		user, err = s.userRepo.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user.IsConfirmed {
+			return nil
+		}
+
+		user.IsConfirmed = true
+		user.UpdatedAt = time.Now().UTC()
+		if err := s.userRepo.UpdateUser(ctx, user); err != nil {
+			log.Printf("error confirming user: %v", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Блокировка пользователя: все сессии отзываются, вход запрещается до EnableUser
+func (s *AdminService) DisableUser(ctx context.Context, accessToken string, id uuid.UUID, reason string) (*domain.User, error) {
+	var user *domain.User
+
+	err := s.call(ctx, accessToken, domain.PermUsersWrite, "DisableUser", id, reason, func(admin *Principal) error {
+		if id == admin.UserID {
+			var v domain.ValidationError
+			v.Add("user_id", "must not be the caller")
+			return v.Err()
+		}
+
+		var err error
+		user, err = s.userRepo.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user.DisabledAt.Valid {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		user.DisabledAt = sql.NullTime{Time: now, Valid: true}
+		user.UpdatedAt = now
+		err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+			if err := repo.UpdateUser(ctx, user); err != nil {
+				return err
+			}
+			return repo.RevokeAllSessions(ctx, user.ID)
+		})
+		if err != nil {
+			log.Printf("error disabling user: %v", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Разблокировка пользователя
+func (s *AdminService) EnableUser(ctx context.Context, accessToken string, id uuid.UUID) (*domain.User, error) {
+	var user *domain.User
+
+	err := s.call(ctx, accessToken, domain.PermUsersWrite, "EnableUser", id, "", func(*Principal) error {
+		var err error
+		user, err = s.userRepo.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !user.DisabledAt.Valid {
+			return nil
+		}
+
+		user.DisabledAt = sql.NullTime{}
+		user.UpdatedAt = time.Now().UTC()
+		if err := s.userRepo.UpdateUser(ctx, user); err != nil {
+			log.Printf("error enabling user: %v", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Принудительный выход пользователя из всех сессий
+func (s *AdminService) ForceLogout(ctx context.Context, accessToken string, id uuid.UUID) error {
+	return s.call(ctx, accessToken, domain.PermUsersWrite, "ForceLogout", id, "", func(*Principal) error {
+		if _, err := s.userRepo.GetUserByID(ctx, id); err != nil {
+			return err
+		}
+
+		if err := s.userRepo.RevokeAllSessions(ctx, id); err != nil {
+			log.Printf("error revoking all sessions: %v", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// Повторная отправка письма с кодом подтверждения. Письмо содержит ссылку,
+// так как подписи из Register у пользователя может уже не быть
+func (s *AdminService) ResendVerification(ctx context.Context, accessToken string, id uuid.UUID) error {
+	return s.call(ctx, accessToken, domain.PermUsersWrite, "ResendVerification", id, "", func(*Principal) error {
+		user, err := s.userRepo.GetUserByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user.IsConfirmed {
+			return domain.ErrAlreadyConfirmed
+		}
+
+		code, err := generateRandomCode(6)
+		if err != nil {
+			log.Printf("error generating verification code: %v", err)
+			return err
+		}
+
+		signature, err := uuid.NewRandom()
+		if err != nil {
+			log.Printf("error generating signature: %v", err)
+			return err
+		}
+
+		link := s.appURL + "/verify-email?" + url.Values{
+			"signature": {signature.String()},
+			"code":      {code},
+		}.Encode()
+		msg, err := s.mail.VerificationCodeMessage(user.Email, code, link)
+		if err != nil {
+			log.Printf("error rendering verification email: %v", err)
+			return err
+		}
+
+		err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+			err := repo.StoreVerificationCode(ctx, &domain.CodeSignature{
+				Code:      code,
+				Signature: signature,
+				UserID:    user.ID,
+				ExpiresAt: time.Now().UTC().Add(verificationCodeTTL),
+			})
+			if err != nil {
+				return err
+			}
+			return repo.EnqueueEmail(ctx, outboxEmail(msg))
+		})
+		if err != nil {
+			log.Printf("error resending verification email: %v", err)
+			return err
+		}
+		return nil
+	})
+}
+
+// Список ролей с их правами
+func (s *AdminService) ListRoles(ctx context.Context, accessToken string) ([]*domain.Role, error) {
+	var roles []*domain.Role
+
+	err := s.call(ctx, accessToken, domain.PermRolesManage, "ListRoles", uuid.Nil, "", func(*Principal) error {
+		var err error
+		roles, err = s.roles.ListRoles(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Назначение роли пользователю
+func (s *AdminService) AssignRole(ctx context.Context, accessToken string, id uuid.UUID, role string) error {
+	return s.call(ctx, accessToken, domain.PermRolesManage, "AssignRole", id, role, func(*Principal) error {
+		return s.roles.AssignRole(ctx, id, role)
+	})
+}
+
+// Отзыв роли у пользователя. Свои роли отозвать нельзя, чтобы не остаться без администратора
+func (s *AdminService) RevokeRole(ctx context.Context, accessToken string, id uuid.UUID, role string) error {
+	return s.call(ctx, accessToken, domain.PermRolesManage, "RevokeRole", id, role, func(admin *Principal) error {
+		if id == admin.UserID {
+			var v domain.ValidationError
+			v.Add("user_id", "must not be the caller")
+			return v.Err()
+		}
+		return s.roles.RevokeRole(ctx, id, role)
+	})
+}
+
+// call authorizes the caller, runs fn and records the call in the audit log.
+// Calls with an invalid token have no actor and are only logged.
+func (s *AdminService) call(ctx context.Context, accessToken, permission, action string, target uuid.UUID, details string, fn func(admin *Principal) error) error {
+	admin, err := s.authorize(ctx, accessToken, permission)
+	if admin == nil {
+		log.Printf("admin %s: unauthenticated call: %v", action, err)
+		return err
+	}
+	if err == nil {
+		err = fn(admin)
+	}
+
+	entry := &domain.AuditEntry{
+		ActorID:  admin.UserID,
+		Action:   action,
+		TargetID: uuid.NullUUID{UUID: target, Valid: target != uuid.Nil},
+		Details:  details,
+	}
+	if err != nil {
+		entry.Error = sql.NullString{String: err.Error(), Valid: true}
+	}
+	// The entry is written even if the client has gone away
+	if auditErr := s.userRepo.AppendAuditEntry(context.WithoutCancel(ctx), entry); auditErr != nil {
+		log.Printf("error writing audit entry for %s by %s: %v", action, admin.UserID, auditErr)
+	}
+
+	return err
+}
+
+// authorize authenticates the caller and checks that it has the permission.
+// The principal is returned with ErrPermissionDenied, so the denial can be audited.
+func (s *AdminService) authorize(ctx context.Context, accessToken, permission string) (*Principal, error) {
+	principal, err := s.users.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if !principal.Can(permission) {
+		return principal, domain.ErrPermissionDenied
+	}
+
+	// The permissions in the token may be outdated, the stored ones decide
+	admin, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return principal, domain.ErrPermissionDenied
+		}
+		log.Printf("error getting user: %v", err)
+		return principal, err
+	}
+	if admin.DisabledAt.Valid {
+		return principal, domain.ErrPermissionDenied
+	}
+	permissions, err := s.roles.UserPermissions(ctx, principal.UserID)
+	if err != nil {
+		return principal, err
+	}
+	if !slices.Contains(permissions, permission) {
+		return principal, domain.ErrPermissionDenied
+	}
+
+	return principal, nil
+}
+
+// encodeUserCursor returns the page token that continues after user
+func encodeUserCursor(user *domain.User) string {
+	raw := user.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + user.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeUserCursor(pageToken string) (*domain.UserCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(pageToken)
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+
+	var cursor domain.UserCursor
+	if err == nil && ok {
+		cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt)
+	}
+	if err == nil && ok {
+		cursor.ID, err = uuid.Parse(id)
+	}
+	if err != nil || !ok {
+		var v domain.ValidationError
+		v.Add("page_token", "is malformed")
+		return nil, v.Err()
+	}
+
+	return &cursor, nil
+}
+
+// describeFilter renders the set filter fields for the audit log
+func describeFilter(f domain.UserFilter, pageToken string) string {
+	var parts []string
+	if f.EmailPrefix != "" {
+		parts = append(parts, fmt.Sprintf("email_prefix=%q", f.EmailPrefix))
+	}
+	if f.Confirmed != nil {
+		parts = append(parts, fmt.Sprintf("confirmed=%t", *f.Confirmed))
+	}
+	if f.Deleted != nil {
+		parts = append(parts, fmt.Sprintf("deleted=%t", *f.Deleted))
+	}
+	if !f.CreatedAfter.IsZero() {
+		parts = append(parts, "created_after="+f.CreatedAfter.UTC().Format(time.RFC3339))
+	}
+	if !f.CreatedBefore.IsZero() {
+		parts = append(parts, "created_before="+f.CreatedBefore.UTC().Format(time.RFC3339))
+	}
+	if pageToken != "" {
+		parts = append(parts, "page_token="+pageToken)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+)
+
+// accountPurgeBatchSize is how many accounts AccountPurger removes per query
+const accountPurgeBatchSize = 100
+
+// Удаление аккаунта: пользователь помечается удалённым, все сессии отзываются.
+// До истечения deletionGrace аккаунт можно восстановить через RestoreAccount
+func (s *UserService) DeleteAccount(ctx context.Context, accessToken, password string) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+
+	if !s.CheckPassword(ctx, user, password) {
+		return domain.ErrInvalidCredentials
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		if err := repo.DeleteUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return repo.RevokeAllSessions(ctx, user.ID)
+	})
+	if err != nil {
+		log.Printf("error deleting account: %v", err)
+		return err
+	}
+
+	restoreBefore := time.Now().UTC().Add(s.deletionGrace)
+	if err := s.mail.SendAccountDeleted(user.Email, restoreBefore); err != nil {
+		log.Printf("error sending account deletion notification: %v", err)
+	}
+
+	return nil
+}
+
+// Восстановление удалённого аккаунта по email и паролю. Вход завершается
+// как после пароля в Login, с вторым шагом при включённом TOTP
+func (s *UserService) RestoreAccount(ctx context.Context, email, password string) (*domain.User, error) {
+	user, err := s.userRepo.GetDeletedUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return nil, domain.ErrInvalidCredentials
+		}
+		log.Printf("error getting deleted user: %v", err)
+		return nil, err
+	}
+
+	if !s.CheckPassword(ctx, user, password) {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	restored, err := s.userRepo.RestoreUser(ctx, user.ID, time.Now().UTC().Add(-s.deletionGrace))
+	if err != nil {
+		log.Printf("error restoring user: %v", err)
+		return nil, err
+	}
+	if !restored {
+		return nil, domain.ErrRestoreExpired
+	}
+	user.DeletedAt = sql.NullTime{}
+
+	return user, nil
+}
+
+// AccountPurger permanently removes accounts whose deletion grace period is
+// over, together with expired challenges, login codes and revoked access tokens
+type AccountPurger struct {
+	userRepo repository.UserRepository
+	grace    time.Duration
+	interval time.Duration
+	now      func() time.Time
+}
+
+func NewAccountPurger(userRepo repository.UserRepository, cfg config.Config) *AccountPurger {
+	return &AccountPurger{
+		userRepo: userRepo,
+		grace:    cfg.AccountDeletionGrace,
+		interval: cfg.AccountPurgeInterval,
+		now:      time.Now,
+	}
+}
+
+// Run purges expired accounts and rows every interval until ctx is cancelled
+func (p *AccountPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.Purge(ctx)
+		if err != nil {
+			log.Printf("error purging deleted accounts: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d deleted accounts", n)
+		}
+		if n, err := p.PurgeExpired(ctx); err != nil {
+			log.Printf("error purging expired rows: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d expired challenges, codes and revoked tokens", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes every account deleted more than the grace period ago and
+// returns how many were removed
+func (p *AccountPurger) Purge(ctx context.Context) (int, error) {
+	deletedBefore := p.now().UTC().Add(-p.grace)
+
+	total := 0
+	for {
+		n, err := p.userRepo.PurgeDeletedUsers(ctx, deletedBefore, accountPurgeBatchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < accountPurgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// PurgeExpired removes the challenges, login codes and revoked access tokens
+// that have expired and returns how many were removed. Unauthenticated calls
+// like BeginPasskeyLogin create such rows, so they must not pile up.
+func (p *AccountPurger) PurgeExpired(ctx context.Context) (int, error) {
+	return p.userRepo.PurgeExpired(ctx, p.now().UTC())
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository/memory"
+)
+
+func TestAccountPurger(t *testing.T) {
+	repo := memory.NewUserRepository()
+	ctx := context.Background()
+
+	var deleted []*domain.User
+	for i := 0; i < accountPurgeBatchSize+5; i++ {
+		user, err := repo.CreateUser(ctx, &domain.User{Email: fmt.Sprintf("user%d@example.com", i)})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if err := repo.DeleteUser(ctx, user.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		deleted = append(deleted, user)
+	}
+	active, err := repo.CreateUser(ctx, &domain.User{Email: "active@example.com"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	now := time.Now()
+	purger := NewAccountPurger(repo, config.Config{AccountDeletionGrace: 24 * time.Hour})
+	purger.now = func() time.Time { return now }
+
+	if n, err := purger.Purge(ctx); err != nil || n != 0 {
+		t.Fatalf("Purge within the grace period = %d, %v", n, err)
+	}
+
+	purger.now = func() time.Time { return now.Add(25 * time.Hour) }
+	if n, err := purger.Purge(ctx); err != nil || n != len(deleted) {
+		t.Fatalf("Purge = %d, %v, want %d", n, err, len(deleted))
+	}
+	if _, err := repo.GetDeletedUserByEmail(ctx, deleted[0].Email); !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatalf("deleted user survived the purge: err = %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, active.ID); err != nil {
+		t.Fatalf("active user was purged: %v", err)
+	}
+}
+
+func TestAccountPurgerPurgeExpired(t *testing.T) {
+	repo := memory.NewUserRepository()
+	ctx := context.Background()
+
+	now := time.Now()
+	challenge := &domain.WebAuthnChallenge{Challenge: "challenge", Ceremony: domain.CeremonyLogin, ExpiresAt: now.Add(5 * time.Minute)}
+	if err := repo.StoreWebAuthnChallenge(ctx, challenge); err != nil {
+		t.Fatalf("StoreWebAuthnChallenge: %v", err)
+	}
+
+	purger := NewAccountPurger(repo, config.Config{})
+	purger.now = func() time.Time { return now }
+	if n, err := purger.PurgeExpired(ctx); err != nil || n != 0 {
+		t.Fatalf("PurgeExpired before expiry = %d, %v", n, err)
+	}
+
+	purger.now = func() time.Time { return now.Add(10 * time.Minute) }
+	if n, err := purger.PurgeExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("PurgeExpired = %d, %v, want 1", n, err)
+	}
+	if _, err := repo.TakeWebAuthnChallenge(ctx, challenge.Challenge); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expired challenge survived the purge: err = %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// loginCodeTTL is how long an emailed sign-in code and link stay valid
+	loginCodeTTL = 15 * time.Minute
+	// loginCodeAttempts is how many codes can be entered for a sign-in email.
+	// Requesting another email does not reset the count until the current
+	// one expires or is used.
+	loginCodeAttempts = 5
+)
+
+// Запрос входа без пароля: на почту отправляется код и ссылка для входа.
+// Ответ не зависит от того, существует ли пользователь
+func (s *UserService) RequestLoginCode(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return nil
+		}
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+
+	code, err := generateRandomCode(6)
+	if err != nil {
+		log.Printf("error generating login code: %v", err)
+		return err
+	}
+
+	linkToken, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("error generating login link: %v", err)
+		return err
+	}
+
+	signature, err := uuid.NewRandom()
+	if err != nil {
+		log.Printf("error generating signature: %v", err)
+		return err
+	}
+
+	link := s.appURL + "/login/link?token=" + url.QueryEscape(linkToken)
+	msg, err := s.mail.LoginCodeMessage(user.Email, code, link)
+	if err != nil {
+		log.Printf("error rendering login code email: %v", err)
+		return err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		err := repo.StoreLoginCode(ctx, &domain.LoginCode{
+			Signature: signature,
+			UserID:    user.ID,
+			CodeHash:  hashToken(code),
+			LinkHash:  hashToken(linkToken),
+			ExpiresAt: time.Now().UTC().Add(loginCodeTTL),
+		})
+		if err != nil {
+			return err
+		}
+		return repo.EnqueueEmail(ctx, outboxEmail(msg))
+	})
+	if err != nil {
+		log.Printf("error storing login code: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Вход по коду из письма. После loginCodeAttempts неверных кодов письмо
+// перестаёт действовать вместе со ссылкой
+func (s *UserService) LoginWithCode(ctx context.Context, email, code string) (*domain.User, error) {
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return nil, domain.ErrInvalidCode
+		}
+		log.Printf("error getting user: %v", err)
+		return nil, err
+	}
+
+	stored, err := s.userRepo.GetLoginCode(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidCode
+		}
+		log.Printf("error getting login code: %v", err)
+		return nil, err
+	}
+
+	if err := checkLoginCode(stored); err != nil {
+		return nil, err
+	}
+
+	// The attempt is counted before the code is compared, so concurrent
+	// guesses cannot all pass the check above
+	attempts, err := s.userRepo.AddLoginCodeAttempt(ctx, stored.Signature)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidCode
+		}
+		log.Printf("error counting login code attempt: %v", err)
+		return nil, err
+	}
+	if attempts > loginCodeAttempts {
+		return nil, domain.ErrInvalidCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored.CodeHash), []byte(hashToken(code))) != 1 {
+		return nil, domain.ErrInvalidCode
+	}
+
+	if err := s.useLoginCode(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Вход по ссылке из письма
+func (s *UserService) LoginWithLink(ctx context.Context, linkToken string) (*domain.User, error) {
+	stored, err := s.userRepo.GetLoginCodeByLink(ctx, hashToken(linkToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidCode
+		}
+		log.Printf("error getting login code: %v", err)
+		return nil, err
+	}
+
+	if err := checkLoginCode(stored); err != nil {
+		return nil, err
+	}
+
+	if err := s.useLoginCode(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// checkLoginCode rejects a code that was used, guessed at too often or has expired
+func checkLoginCode(code *domain.LoginCode) error {
+	if code.IsUsed || code.Attempts >= loginCodeAttempts {
+		return domain.ErrInvalidCode
+	}
+	if time.Now().UTC().After(code.ExpiresAt) {
+		return domain.ErrCodeExpired
+	}
+	return nil
+}
+
+// useLoginCode marks the code as used; of two concurrent logins only one succeeds
+func (s *UserService) useLoginCode(ctx context.Context, code *domain.LoginCode) error {
+	used, err := s.userRepo.UseLoginCode(ctx, code.Signature, loginCodeAttempts)
+	if err != nil {
+		log.Printf("error using login code: %v", err)
+		return err
+	}
+	if !used {
+		return domain.ErrInvalidCode
+	}
+	return nil
+}
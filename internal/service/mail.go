@@ -0,0 +1,243 @@
+package service
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/mailpost"
+)
+
+// Each template file defines "subject", "text" and "html" blocks
+//
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+// Email templates available to MailService
+const (
+	TemplateVerification         = "verification"
+	TemplatePasswordReset        = "password_reset"
+	TemplateNewDeviceLogin       = "new_device_login"
+	TemplateEmailChange          = "email_change"
+	TemplateEmailChangeRequested = "email_change_requested"
+	TemplateEmailChanged         = "email_changed"
+	TemplatePasswordChanged      = "password_changed"
+	TemplateAccountDeleted       = "account_deleted"
+	TemplateInvitation           = "invitation"
+	TemplateLoginCode            = "login_code"
+)
+
+type mailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// MailService renders the embedded templates and sends them through a mailpost.Mailer
+type MailService struct {
+	sender    mailpost.Mailer
+	templates map[string]mailTemplate
+}
+
+func NewMailService(sender mailpost.Mailer) (*MailService, error) {
+	files, err := fs.Glob(templatesFS, "templates/*.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list email templates: %w", err)
+	}
+
+	templates := make(map[string]mailTemplate, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+
+		text, err := texttemplate.ParseFS(templatesFS, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse email template %s: %w", name, err)
+		}
+		html, err := htmltemplate.ParseFS(templatesFS, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse email template %s: %w", name, err)
+		}
+
+		for _, block := range []string{"subject", "text", "html"} {
+			if text.Lookup(block) == nil {
+				return nil, fmt.Errorf("email template %s has no %q block", name, block)
+			}
+		}
+
+		templates[name] = mailTemplate{text: text, html: html}
+	}
+
+	return &MailService{sender: sender, templates: templates}, nil
+}
+
+// Send renders the named template with data and sends it to the recipient
+func (m *MailService) Send(to, name string, data any) error {
+	msg, err := m.Render(name, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+
+	return m.deliver(name, msg)
+}
+
+func (m *MailService) deliver(name string, msg mailpost.Message) error {
+	if err := m.sender.Send(msg); err != nil {
+		return fmt.Errorf("failed to send %s email: %w", name, err)
+	}
+	return nil
+}
+
+// Render builds the message for the named template without sending it
+func (m *MailService) Render(name string, data any) (mailpost.Message, error) {
+	tmpl, ok := m.templates[name]
+	if !ok {
+		return mailpost.Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return mailpost.Message{}, fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return mailpost.Message{}, fmt.Errorf("failed to render %s text: %w", name, err)
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "html", data); err != nil {
+		return mailpost.Message{}, fmt.Errorf("failed to render %s html: %w", name, err)
+	}
+
+	return mailpost.Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimSpace(text.String()),
+		HTML:    strings.TrimSpace(html.String()),
+	}, nil
+}
+
+// Письмо с кодом подтверждения регистрации
+func (m *MailService) SendVerificationCode(to, code string) error {
+	msg, err := m.VerificationCodeMessage(to, code, "")
+	if err != nil {
+		return err
+	}
+	return m.deliver(TemplateVerification, msg)
+}
+
+// VerificationCodeMessage renders the registration email without sending it,
+// so it can be stored in the outbox together with the new user. The link is
+// optional and lets users confirm without the signature returned by Register.
+func (m *MailService) VerificationCodeMessage(to, code, link string) (mailpost.Message, error) {
+	msg, err := m.Render(TemplateVerification, map[string]any{
+		"Code": code,
+		"Link": link,
+		"TTL":  formatTTL(verificationCodeTTL),
+	})
+	msg.To = to
+	return msg, err
+}
+
+// PasswordResetMessage renders the reset link without sending it, so it can
+// be stored in the outbox together with the token
+func (m *MailService) PasswordResetMessage(to, link string) (mailpost.Message, error) {
+	msg, err := m.Render(TemplatePasswordReset, map[string]any{
+		"Link": link,
+		"TTL":  formatTTL(passwordResetTTL),
+	})
+	msg.To = to
+	return msg, err
+}
+
+// Уведомление о входе с нового устройства
+func (m *MailService) SendNewDeviceLogin(to string, client domain.ClientInfo, at time.Time) error {
+	return m.Send(to, TemplateNewDeviceLogin, map[string]any{
+		"Client": client,
+		"Time":   at,
+	})
+}
+
+// EmailChangeCodeMessage renders the code for a new email address without
+// sending it, so it can be stored in the outbox together with the request
+func (m *MailService) EmailChangeCodeMessage(to, code string) (mailpost.Message, error) {
+	msg, err := m.Render(TemplateEmailChange, map[string]any{
+		"Code": code,
+		"TTL":  formatTTL(emailChangeTTL),
+	})
+	msg.To = to
+	return msg, err
+}
+
+// Уведомление старого адреса о запросе смены почты
+func (m *MailService) SendEmailChangeRequested(to, newEmail string) error {
+	return m.Send(to, TemplateEmailChangeRequested, map[string]any{
+		"NewEmail": newEmail,
+	})
+}
+
+// Уведомление старого адреса о смене почты
+func (m *MailService) SendEmailChanged(to, newEmail string) error {
+	return m.Send(to, TemplateEmailChanged, map[string]any{
+		"NewEmail": newEmail,
+	})
+}
+
+// Уведомление о смене пароля
+func (m *MailService) SendPasswordChanged(to string) error {
+	return m.Send(to, TemplatePasswordChanged, nil)
+}
+
+// Уведомление об удалении аккаунта со сроком, до которого его можно восстановить
+func (m *MailService) SendAccountDeleted(to string, restoreBefore time.Time) error {
+	return m.Send(to, TemplateAccountDeleted, map[string]any{
+		"RestoreBefore": restoreBefore,
+	})
+}
+
+// InvitationMessage renders an invitation to an organization without sending
+// it, so it can be stored in the outbox together with the invitation
+func (m *MailService) InvitationMessage(to, organization, inviter, role, link string) (mailpost.Message, error) {
+	msg, err := m.Render(TemplateInvitation, map[string]any{
+		"Organization": organization,
+		"Inviter":      inviter,
+		"Role":         role,
+		"Link":         link,
+		"TTL":          formatTTL(invitationTTL),
+	})
+	msg.To = to
+	return msg, err
+}
+
+// LoginCodeMessage renders a passwordless sign-in email without sending it,
+// so it can be stored in the outbox together with the code
+func (m *MailService) LoginCodeMessage(to, code, link string) (mailpost.Message, error) {
+	msg, err := m.Render(TemplateLoginCode, map[string]any{
+		"Code": code,
+		"Link": link,
+		"TTL":  formatTTL(loginCodeTTL),
+	})
+	msg.To = to
+	return msg, err
+}
+
+// formatTTL renders durations like "24 hours" or "15 minutes" for email texts
+func formatTTL(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return plural(int(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return plural(int(d/time.Minute), "minute")
+	default:
+		return d.String()
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/mailpost"
+)
+
+// fakeMailopost is an httptest stand-in for the Mailopost messages API
+type fakeMailopost struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	messages []mailpost.Message
+}
+
+func newFakeMailopost(t *testing.T) *fakeMailopost {
+	t.Helper()
+
+	f := &fakeMailopost{status: http.StatusOK}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg mailpost.Message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("failed to decode message: %v", err)
+		}
+
+		f.mu.Lock()
+		f.requests = append(f.requests, r)
+		f.messages = append(f.messages, msg)
+		status := f.status
+		f.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeMailopost) last(t *testing.T) (*http.Request, mailpost.Message) {
+	t.Helper()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.messages) == 0 {
+		t.Fatal("no message was sent")
+	}
+	return f.requests[len(f.requests)-1], f.messages[len(f.messages)-1]
+}
+
+func newTestMailService(t *testing.T, api *fakeMailopost) *MailService {
+	t.Helper()
+
+	mail, err := NewMailService(mailpost.NewClient(api.URL+"/v1", "test-key"))
+	if err != nil {
+		t.Fatalf("NewMailService: %v", err)
+	}
+	return mail
+}
+
+func TestMailServiceTemplates(t *testing.T) {
+	api := newFakeMailopost(t)
+	mail := newTestMailService(t, api)
+
+	at := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		send    func() error
+		subject string
+		want    []string
+	}{
+		{
+			name:    "verification",
+			send:    func() error { return mail.SendVerificationCode("user@example.com", "123456") },
+			subject: "Confirm your email",
+			want:    []string{"123456", "24 hours"},
+		},
+		{
+			name: "password reset",
+			send: func() error {
+				msg, err := mail.PasswordResetMessage("user@example.com", "https://app/reset?token=abc")
+				if err != nil {
+					return err
+				}
+				return mail.deliver(TemplatePasswordReset, msg)
+			},
+			subject: "Password reset",
+			want:    []string{"https://app/reset?token=abc", "1 hour"},
+		},
+		{
+			name: "new device login",
+			send: func() error {
+				return mail.SendNewDeviceLogin("user@example.com", domain.ClientInfo{
+					UserAgent:  "grpc-go/1.62.1",
+					ClientIP:   "10.0.0.1",
+					DeviceName: "Pixel 8",
+				}, at)
+			},
+			subject: "New sign-in to your account",
+			want:    []string{"Pixel 8", "grpc-go/1.62.1", "10.0.0.1", "2025-03-01 12:30 UTC"},
+		},
+		{
+			name: "email change",
+			send: func() error {
+				msg, err := mail.EmailChangeCodeMessage("new@example.com", "654321")
+				if err != nil {
+					return err
+				}
+				return mail.deliver(TemplateEmailChange, msg)
+			},
+			subject: "Confirm your new email",
+			want:    []string{"654321", "1 hour"},
+		},
+		{
+			name:    "email change requested",
+			send:    func() error { return mail.SendEmailChangeRequested("user@example.com", "new@example.com") },
+			subject: "Email change requested",
+			want:    []string{"new@example.com"},
+		},
+		{
+			name:    "email changed",
+			send:    func() error { return mail.SendEmailChanged("user@example.com", "new@example.com") },
+			subject: "Your email was changed",
+			want:    []string{"new@example.com"},
+		},
+		{
+			name: "invitation",
+			send: func() error {
+				msg, err := mail.InvitationMessage("new@example.com", "Acme", "owner@example.com", "admin", "https://app/invitations/accept?token=abc")
+				if err != nil {
+					return err
+				}
+				return mail.deliver(TemplateInvitation, msg)
+			},
+			subject: "You are invited to Acme",
+			want:    []string{"owner@example.com", "admin", "https://app/invitations/accept?token=abc", "72 hours"},
+		},
+		{
+			name: "login code",
+			send: func() error {
+				msg, err := mail.LoginCodeMessage("user@example.com", "123456", "https://app/login/link?token=abc")
+				if err != nil {
+					return err
+				}
+				return mail.deliver(TemplateLoginCode, msg)
+			},
+			subject: "Your sign-in code",
+			want:    []string{"123456", "https://app/login/link?token=abc", "15 minutes"},
+		},
+		{
+			name:    "password changed",
+			send:    func() error { return mail.SendPasswordChanged("user@example.com") },
+			subject: "Your password was changed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.send(); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+
+			req, msg := api.last(t)
+			if req.URL.Path != "/v1/messages" {
+				t.Errorf("path = %q, want /v1/messages", req.URL.Path)
+			}
+			if got := req.Header.Get("Authorization"); got != "Bearer test-key" {
+				t.Errorf("Authorization = %q, want Bearer test-key", got)
+			}
+			if msg.Subject != tt.subject {
+				t.Errorf("subject = %q, want %q", msg.Subject, tt.subject)
+			}
+			if msg.Body == "" || msg.HTML == "" {
+				t.Fatalf("expected both text and html parts, got text=%q html=%q", msg.Body, msg.HTML)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(msg.Body, want) {
+					t.Errorf("text part does not contain %q:\n%s", want, msg.Body)
+				}
+				if !strings.Contains(msg.HTML, want) {
+					t.Errorf("html part does not contain %q:\n%s", want, msg.HTML)
+				}
+			}
+		})
+	}
+}
+
+func TestMailServiceEscapesHTML(t *testing.T) {
+	api := newFakeMailopost(t)
+	mail := newTestMailService(t, api)
+
+	if err := mail.SendEmailChanged("user@example.com", "<b>x</b>@example.com"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	_, msg := api.last(t)
+	if strings.Contains(msg.HTML, "<b>x</b>") {
+		t.Errorf("html part is not escaped:\n%s", msg.HTML)
+	}
+	if !strings.Contains(msg.Body, "<b>x</b>@example.com") {
+		t.Errorf("text part must not be escaped:\n%s", msg.Body)
+	}
+}
+
+func TestMailServiceAPIError(t *testing.T) {
+	api := newFakeMailopost(t)
+	api.status = http.StatusInternalServerError
+	mail := newTestMailService(t, api)
+
+	if err := mail.SendVerificationCode("user@example.com", "123456"); err == nil {
+		t.Fatal("expected an error when Mailopost responds with 500")
+	}
+}
+
+func TestMailServiceUnknownTemplate(t *testing.T) {
+	api := newFakeMailopost(t)
+	mail := newTestMailService(t, api)
+
+	if err := mail.Send("user@example.com", "missing", nil); err == nil {
+		t.Fatal("expected an error for an unknown template")
+	}
+}
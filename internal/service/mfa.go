@@ -0,0 +1,433 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+	"github.com/Olegnemlii/test123/pkg/totp"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// mfaChallengeTTL is how long the second step of a login can be completed
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeAttempts is how many codes can be entered for a login challenge
+	mfaChallengeAttempts = 5
+	// mfaAttempts is how many second-factor codes a user can enter in a row
+	// across login challenges, ConfirmTOTP and DisableTOTP. The count starts
+	// over after a correct code or mfaLockout without attempts.
+	mfaAttempts = 10
+	mfaLockout  = 15 * time.Minute
+	// totpSkew is how many time-steps a code may be off to allow for clock drift
+	totpSkew = 1
+	// recoveryCodeCount is how many recovery codes are issued when TOTP is confirmed
+	recoveryCodeCount = 10
+)
+
+// Подключение TOTP: новый секрет сохраняется неподтверждённым до ConfirmTOTP.
+// Возвращает секрет и otpauth:// URI для приложения-аутентификатора
+func (s *UserService) EnrollTOTP(ctx context.Context, accessToken string) (string, string, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return "", "", err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("error generating TOTP secret: %v", err)
+		return "", "", err
+	}
+
+	encrypted, err := s.sealTOTPSecret(user.ID, secret)
+	if err != nil {
+		log.Printf("error encrypting TOTP secret: %v", err)
+		return "", "", err
+	}
+
+	err = s.userRepo.StoreTOTP(ctx, &domain.TOTPCredential{
+		UserID:          user.ID,
+		EncryptedSecret: encrypted,
+		CreatedAt:       time.Now().UTC(),
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrTOTPAlreadyEnabled) {
+			log.Printf("error storing TOTP secret: %v", err)
+		}
+		return "", "", err
+	}
+
+	return totp.EncodeSecret(secret), totp.URI(s.totpIssuer, user.Email, secret), nil
+}
+
+// Подтверждение TOTP первым кодом из приложения. Возвращает одноразовые коды
+// восстановления; они показываются только один раз
+func (s *UserService) ConfirmTOTP(ctx context.Context, accessToken, code string) ([]string, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.totpCredential(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if credential.ConfirmedAt.Valid {
+		return nil, domain.ErrTOTPAlreadyEnabled
+	}
+
+	if err := s.addMFAAttempt(ctx, principal.UserID); err != nil {
+		return nil, err
+	}
+	step, err := s.validateTOTP(credential, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("error generating recovery codes: %v", err)
+		return nil, err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		confirmed, err := repo.ConfirmTOTP(ctx, principal.UserID, step)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return domain.ErrTOTPAlreadyEnabled
+		}
+		if err := repo.ResetTOTPAttempts(ctx, principal.UserID); err != nil {
+			return err
+		}
+		return repo.ReplaceRecoveryCodes(ctx, principal.UserID, hashes)
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrTOTPAlreadyEnabled) {
+			log.Printf("error confirming TOTP: %v", err)
+		}
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Отключение TOTP по паролю и действующему коду из приложения или коду восстановления
+func (s *UserService) DisableTOTP(ctx context.Context, accessToken, password, code string) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+
+	if !s.CheckPassword(ctx, user, password) {
+		return domain.ErrInvalidCredentials
+	}
+
+	credential, err := s.totpCredential(ctx, principal.UserID)
+	if err != nil {
+		return err
+	}
+	if !credential.ConfirmedAt.Valid {
+		return domain.ErrTOTPNotEnabled
+	}
+
+	if err := s.addMFAAttempt(ctx, principal.UserID); err != nil {
+		return err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		if err := s.useSecondFactor(ctx, repo, credential, code); err != nil {
+			return err
+		}
+		return repo.DeleteTOTP(ctx, principal.UserID)
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidMFACode) {
+			log.Printf("error disabling TOTP: %v", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Второй шаг входа. Если у пользователя включён TOTP, вместо сессии
+// выдаётся короткоживущий токен для VerifyMFA; иначе возвращается nil
+func (s *UserService) MFAChallenge(ctx context.Context, user *domain.User) (*domain.IssuedToken, error) {
+	if user.DisabledAt.Valid {
+		return nil, domain.ErrUserDisabled
+	}
+
+	credential, err := s.userRepo.GetTOTP(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil
+		}
+		log.Printf("error getting TOTP: %v", err)
+		return nil, err
+	}
+	if !credential.ConfirmedAt.Valid {
+		return nil, nil
+	}
+
+	challengeToken, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("error generating MFA challenge: %v", err)
+		return nil, err
+	}
+
+	expiresAt := time.Now().UTC().Add(mfaChallengeTTL)
+	err = s.userRepo.StoreMFAChallenge(ctx, &domain.MFAChallenge{
+		TokenHash: hashToken(challengeToken),
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("error storing MFA challenge: %v", err)
+		return nil, err
+	}
+
+	return &domain.IssuedToken{Data: challengeToken, ExpiresAt: expiresAt}, nil
+}
+
+// Завершение входа кодом из приложения или кодом восстановления
+func (s *UserService) VerifyMFA(ctx context.Context, challengeToken, code string, client domain.ClientInfo) (*domain.User, *domain.TokenPair, error) {
+	challenge, err := s.userRepo.GetMFAChallenge(ctx, hashToken(challengeToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil, domain.ErrInvalidMFAChallenge
+		}
+		log.Printf("error getting MFA challenge: %v", err)
+		return nil, nil, err
+	}
+
+	if challenge.CompletedAt.Valid || challenge.Attempts >= mfaChallengeAttempts || time.Now().UTC().After(challenge.ExpiresAt) {
+		return nil, nil, domain.ErrInvalidMFAChallenge
+	}
+
+	credential, err := s.userRepo.GetTOTP(ctx, challenge.UserID)
+	if err != nil {
+		// TOTP was disabled after the password step
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil, domain.ErrInvalidMFAChallenge
+		}
+		log.Printf("error getting TOTP: %v", err)
+		return nil, nil, err
+	}
+	if !credential.ConfirmedAt.Valid {
+		return nil, nil, domain.ErrInvalidMFAChallenge
+	}
+
+	// The attempt is counted before the code is checked, so concurrent
+	// guesses cannot all pass the check above
+	attempts, err := s.userRepo.AddMFAChallengeAttempt(ctx, challenge.ID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil, domain.ErrInvalidMFAChallenge
+		}
+		log.Printf("error counting MFA challenge attempt: %v", err)
+		return nil, nil, err
+	}
+	if attempts > mfaChallengeAttempts {
+		return nil, nil, domain.ErrInvalidMFAChallenge
+	}
+	// New challenges do not give the user more guesses
+	if err := s.addMFAAttempt(ctx, challenge.UserID); err != nil {
+		return nil, nil, err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		if err := s.useSecondFactor(ctx, repo, credential, code); err != nil {
+			return err
+		}
+		completed, err := repo.CompleteMFAChallenge(ctx, challenge.ID, mfaChallengeAttempts)
+		if err != nil {
+			return err
+		}
+		if !completed {
+			return domain.ErrInvalidMFAChallenge
+		}
+		return repo.ResetTOTPAttempts(ctx, challenge.UserID)
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidMFACode) && !errors.Is(err, domain.ErrInvalidMFAChallenge) {
+			log.Printf("error verifying MFA: %v", err)
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, challenge.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return nil, nil, err
+	}
+
+	tokens, err := s.IssueTokens(ctx, user, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, tokens, nil
+}
+
+// totpCredential returns the enrollment of the user or ErrTOTPNotEnabled
+func (s *UserService) totpCredential(ctx context.Context, userID uuid.UUID) (*domain.TOTPCredential, error) {
+	credential, err := s.userRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrTOTPNotEnabled
+		}
+		log.Printf("error getting TOTP: %v", err)
+		return nil, err
+	}
+	return credential, nil
+}
+
+// addMFAAttempt counts a second-factor code of the user before it is checked
+// and rejects it once the user entered mfaAttempts codes in a row
+func (s *UserService) addMFAAttempt(ctx context.Context, userID uuid.UUID) error {
+	attempts, err := s.userRepo.AddTOTPAttempt(ctx, userID, time.Now().UTC().Add(-mfaLockout))
+	if err != nil {
+		// TOTP was disabled in the meantime
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.ErrInvalidMFACode
+		}
+		log.Printf("error counting MFA attempt: %v", err)
+		return err
+	}
+	if attempts > mfaAttempts {
+		return domain.ErrInvalidMFACode
+	}
+	return nil
+}
+
+// validateTOTP checks a code from the authenticator app and returns its time-step
+func (s *UserService) validateTOTP(credential *domain.TOTPCredential, code string) (int64, error) {
+	secret, err := s.openTOTPSecret(credential.UserID, credential.EncryptedSecret)
+	if err != nil {
+		log.Printf("error decrypting TOTP secret: %v", err)
+		return 0, err
+	}
+
+	step, ok := totp.Validate(secret, strings.TrimSpace(code), time.Now(), totpSkew)
+	if !ok {
+		return 0, domain.ErrInvalidMFACode
+	}
+	return step, nil
+}
+
+// useSecondFactor accepts a TOTP code of a time-step that was not used yet
+// or an unused recovery code, and marks it as used
+func (s *UserService) useSecondFactor(ctx context.Context, repo repository.UserRepository, credential *domain.TOTPCredential, code string) error {
+	code = normalizeRecoveryCode(code)
+	if len(code) == totp.Digits && strings.Trim(code, "0123456789") == "" {
+		step, err := s.validateTOTP(credential, code)
+		if err != nil {
+			return err
+		}
+		used, err := repo.UseTOTPStep(ctx, credential.UserID, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return domain.ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := repo.UseRecoveryCode(ctx, credential.UserID, hashToken(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return domain.ErrInvalidMFACode
+	}
+	return nil
+}
+
+// sealTOTPSecret encrypts the secret with AES-GCM. The user ID is
+// authenticated along with it, so a secret cannot be moved to another user.
+func (s *UserService) sealTOTPSecret(userID uuid.UUID, secret []byte) ([]byte, error) {
+	aead, err := s.totpAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, secret, userID[:]), nil
+}
+
+func (s *UserService) openTOTPSecret(userID uuid.UUID, encrypted []byte) ([]byte, error) {
+	aead, err := s.totpAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encrypted) < aead.NonceSize() {
+		return nil, errors.New("encrypted TOTP secret is too short")
+	}
+	nonce, ciphertext := encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, userID[:])
+}
+
+func (s *UserService) totpAEAD() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.totpKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// recoveryCodeAlphabet leaves out characters that are easy to mistake for each other
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// generateRecoveryCodes returns recovery codes formatted as xxxxx-xxxxx
+// together with the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 10)
+		for j := range buf {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryCodeAlphabet))))
+			if err != nil {
+				return nil, nil, err
+			}
+			buf[j] = recoveryCodeAlphabet[n.Int64()]
+		}
+		codes[i] = string(buf[:5]) + "-" + string(buf[5:])
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode drops separators and case, so codes can be typed loosely
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
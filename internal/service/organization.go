@@ -0,0 +1,372 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// invitationTTL is how long an emailed invitation to an organization stays valid
+const invitationTTL = 72 * time.Hour
+
+// Создание организации; создатель становится её владельцем
+func (s *UserService) CreateOrganization(ctx context.Context, accessToken, name string) (*domain.Organization, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	org := &domain.Organization{Name: name, CreatedAt: now}
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		if err := repo.CreateOrganization(ctx, org); err != nil {
+			return err
+		}
+		return repo.AddMember(ctx, &domain.Membership{
+			OrganizationID: org.ID,
+			UserID:         principal.UserID,
+			Role:           domain.OrgRoleOwner,
+			CreatedAt:      now,
+		})
+	})
+	if err != nil {
+		log.Printf("error creating organization: %v", err)
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// Приглашение в организацию по почте. Приглашать могут владельцы и
+// администраторы, владельцев - только владельцы
+func (s *UserService) InviteMember(ctx context.Context, accessToken string, orgID uuid.UUID, email, role string) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	caller, err := s.membership(ctx, orgID, principal.UserID)
+	if err != nil {
+		return err
+	}
+	if !canManageMember(caller.Role, role) {
+		return domain.ErrPermissionDenied
+	}
+
+	// Existing members are not invited again
+	if user, err := s.userRepo.GetUserByEmail(ctx, email); err == nil {
+		if _, err := s.userRepo.GetMembership(ctx, orgID, user.ID); err == nil {
+			return domain.ErrAlreadyMember
+		} else if !errors.Is(err, domain.ErrNotFound) {
+			log.Printf("error getting membership: %v", err)
+			return err
+		}
+	} else if !errors.Is(err, domain.ErrUserNotFound) {
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+
+	org, err := s.userRepo.GetOrganization(ctx, orgID)
+	if err != nil {
+		log.Printf("error getting organization: %v", err)
+		return err
+	}
+	inviter, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+
+	invitationToken, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("error generating invitation token: %v", err)
+		return err
+	}
+
+	link := s.appURL + "/invitations/accept?token=" + url.QueryEscape(invitationToken)
+	msg, err := s.mail.InvitationMessage(email, org.Name, inviter.Email, role, link)
+	if err != nil {
+		log.Printf("error rendering invitation email: %v", err)
+		return err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		err := repo.StoreInvitation(ctx, &domain.Invitation{
+			OrganizationID: orgID,
+			Email:          email,
+			Role:           role,
+			TokenHash:      hashToken(invitationToken),
+			InvitedBy:      principal.UserID,
+			ExpiresAt:      time.Now().UTC().Add(invitationTTL),
+		})
+		if err != nil {
+			return err
+		}
+		return repo.EnqueueEmail(ctx, outboxEmail(msg))
+	})
+	if err != nil {
+		log.Printf("error inviting member: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Принятие приглашения пользователем, на почту которого оно отправлено
+func (s *UserService) AcceptInvitation(ctx context.Context, accessToken, invitationToken string) (*domain.Organization, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return nil, err
+	}
+
+	invitation, err := s.invitationFor(ctx, invitationToken, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		return joinOrganization(ctx, repo, invitation, user.ID)
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidInvitation) && !errors.Is(err, domain.ErrAlreadyMember) {
+			log.Printf("error accepting invitation: %v", err)
+		}
+		return nil, err
+	}
+
+	return s.userRepo.GetOrganization(ctx, invitation.OrganizationID)
+}
+
+// Регистрация по приглашению. Ссылка из письма подтверждает почту, поэтому
+// пользователь сразу становится подтверждённым участником и входит в систему
+func (s *UserService) RegisterWithInvitation(ctx context.Context, email, password, invitationToken string, client domain.ClientInfo) (*domain.User, *domain.TokenPair, error) {
+	if err := s.validatePassword("password", password, email); err != nil {
+		return nil, nil, err
+	}
+
+	invitation, err := s.invitationFor(ctx, invitationToken, email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashedPassword, err := s.hashPassword(password)
+	if err != nil {
+		log.Printf("error hashing password: %v", err)
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	user := &domain.User{
+		Email:       email,
+		Password:    hashedPassword,
+		IsConfirmed: true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		if _, err := repo.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		return joinOrganization(ctx, repo, invitation, user.ID)
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidInvitation) && !errors.Is(err, domain.ErrEmailTaken) {
+			log.Printf("error registering with invitation: %v", err)
+		}
+		return nil, nil, err
+	}
+
+	tokens, err := s.IssueTokens(ctx, user, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, tokens, nil
+}
+
+// Удаление участника из организации. Участник может выйти сам; других
+// удаляют владельцы и администраторы. Последнего владельца удалить нельзя
+func (s *UserService) RemoveMember(ctx context.Context, accessToken string, orgID, userID uuid.UUID) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	caller, err := s.membership(ctx, orgID, principal.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		target, err := repo.GetMembership(ctx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if userID != principal.UserID && !canManageMember(caller.Role, target.Role) {
+			return domain.ErrPermissionDenied
+		}
+
+		if target.Role == domain.OrgRoleOwner {
+			members, err := repo.ListMembers(ctx, orgID)
+			if err != nil {
+				return err
+			}
+			owners := 0
+			for _, m := range members {
+				if m.Role == domain.OrgRoleOwner {
+					owners++
+				}
+			}
+			if owners == 1 {
+				return domain.ErrLastOwner
+			}
+		}
+
+		return repo.RemoveMember(ctx, orgID, userID)
+	}, repository.WithIsolation(sql.LevelSerializable))
+	if err != nil {
+		if !errors.Is(err, domain.ErrNotFound) && !errors.Is(err, domain.ErrPermissionDenied) && !errors.Is(err, domain.ErrLastOwner) {
+			log.Printf("error removing member: %v", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Смена организации, в которой работает сессия. Нулевой orgID возвращает
+// сессию в личный контекст. Текущий access токен отзывается, refresh токен
+// продолжает работать и дальше выдаёт токены с новой организацией
+func (s *UserService) SwitchOrganization(ctx context.Context, accessToken string, orgID uuid.NullUUID) (domain.IssuedToken, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return domain.IssuedToken{}, err
+	}
+
+	if orgID.Valid {
+		if _, err := s.membership(ctx, orgID.UUID, principal.UserID); err != nil {
+			return domain.IssuedToken{}, err
+		}
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return domain.IssuedToken{}, err
+	}
+
+	err = s.userRepo.SetSessionOrganization(ctx, principal.SessionID, orgID)
+	if err != nil {
+		log.Printf("error setting session organization: %v", err)
+		return domain.IssuedToken{}, err
+	}
+
+	session, err := s.userRepo.GetSession(ctx, principal.SessionID)
+	if err != nil {
+		log.Printf("error getting session: %v", err)
+		return domain.IssuedToken{}, err
+	}
+
+	issued, err := s.newAccessToken(ctx, user, session)
+	if err != nil {
+		return domain.IssuedToken{}, err
+	}
+
+	// The session's refresh token now rotates together with the new access token
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		err := repo.RebindRefreshToken(ctx, principal.SessionID, principal.TokenID, issued.ID)
+		if err != nil {
+			log.Printf("error rebinding refresh token: %v", err)
+			return err
+		}
+
+		err = repo.RevokeAccessToken(ctx, principal.TokenID, principal.ExpiresAt)
+		if err != nil {
+			log.Printf("error revoking access token: %v", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.IssuedToken{}, err
+	}
+
+	return issued, nil
+}
+
+// membership returns the caller's membership and hides organizations the
+// caller does not belong to
+func (s *UserService) membership(ctx context.Context, orgID, userID uuid.UUID) (*domain.Membership, error) {
+	m, err := s.userRepo.GetMembership(ctx, orgID, userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrOrganizationNotFound
+		}
+		log.Printf("error getting membership: %v", err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// invitationFor returns the pending invitation behind the token if it was sent to email
+func (s *UserService) invitationFor(ctx context.Context, invitationToken, email string) (*domain.Invitation, error) {
+	invitation, err := s.userRepo.GetInvitation(ctx, hashToken(invitationToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidInvitation
+		}
+		log.Printf("error getting invitation: %v", err)
+		return nil, err
+	}
+
+	if invitation.AcceptedAt.Valid || invitation.Email != email || time.Now().UTC().After(invitation.ExpiresAt) {
+		return nil, domain.ErrInvalidInvitation
+	}
+
+	return invitation, nil
+}
+
+// joinOrganization accepts the invitation on behalf of the user
+func joinOrganization(ctx context.Context, repo repository.UserRepository, invitation *domain.Invitation, userID uuid.UUID) error {
+	accepted, err := repo.MarkInvitationAccepted(ctx, invitation.ID)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return domain.ErrInvalidInvitation
+	}
+
+	return repo.AddMember(ctx, &domain.Membership{
+		OrganizationID: invitation.OrganizationID,
+		UserID:         userID,
+		Role:           invitation.Role,
+		CreatedAt:      time.Now().UTC(),
+	})
+}
+
+// canManageMember reports whether a member with callerRole may invite or
+// remove members with targetRole
+func canManageMember(callerRole, targetRole string) bool {
+	switch callerRole {
+	case domain.OrgRoleOwner:
+		return true
+	case domain.OrgRoleAdmin:
+		return targetRole != domain.OrgRoleOwner
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/mailpost"
+	"github.com/Olegnemlii/test123/internal/repository"
+)
+
+const (
+	// outboxBatchSize is how many emails a worker claims per poll
+	outboxBatchSize = 20
+	// outboxLease hides claimed emails from other workers while they are being sent;
+	// if the worker dies the emails become due again once it expires
+	outboxLease = 2 * time.Minute
+	// outboxBaseBackoff and outboxMaxBackoff bound the delay between retries
+	outboxBaseBackoff = 30 * time.Second
+	outboxMaxBackoff  = 6 * time.Hour
+)
+
+// OutboxMailer implements mailpost.Mailer by storing messages in email_outbox
+// for OutboxWorker to deliver
+type OutboxMailer struct {
+	userRepo repository.UserRepository
+}
+
+func NewOutboxMailer(userRepo repository.UserRepository) *OutboxMailer {
+	return &OutboxMailer{userRepo: userRepo}
+}
+
+func (m *OutboxMailer) Send(msg mailpost.Message) error {
+	return m.userRepo.EnqueueEmail(context.Background(), outboxEmail(msg))
+}
+
+func outboxEmail(msg mailpost.Message) *domain.OutboxEmail {
+	return &domain.OutboxEmail{
+		Recipient: msg.To,
+		Subject:   msg.Subject,
+		Body:      msg.Body,
+		HTML:      msg.HTML,
+	}
+}
+
+// OutboxWorker delivers queued emails, retrying failures with exponential
+// backoff and dead-lettering them after maxAttempts
+type OutboxWorker struct {
+	userRepo     repository.UserRepository
+	mailer       mailpost.Mailer
+	pollInterval time.Duration
+	maxAttempts  int
+	now          func() time.Time
+}
+
+func NewOutboxWorker(userRepo repository.UserRepository, mailer mailpost.Mailer, cfg config.Config) *OutboxWorker {
+	return &OutboxWorker{
+		userRepo:     userRepo,
+		mailer:       mailer,
+		pollInterval: cfg.OutboxPollInterval,
+		maxAttempts:  cfg.OutboxMaxAttempts,
+		now:          time.Now,
+	}
+}
+
+// Run polls the outbox until ctx is cancelled
+func (w *OutboxWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the queue before waiting for the next tick
+		for {
+			n, err := w.ProcessBatch(ctx)
+			if err != nil {
+				log.Printf("error processing email outbox: %v", err)
+			}
+			if err != nil || n < outboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessBatch claims due emails and tries to send each of them once.
+// It returns the number of claimed emails.
+func (w *OutboxWorker) ProcessBatch(ctx context.Context) (int, error) {
+	emails, err := w.userRepo.ClaimOutboxEmails(ctx, outboxBatchSize, w.now().Add(outboxLease))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, email := range emails {
+		w.deliver(ctx, email)
+	}
+	return len(emails), nil
+}
+
+func (w *OutboxWorker) deliver(ctx context.Context, email *domain.OutboxEmail) {
+	err := w.mailer.Send(mailpost.Message{
+		To:      email.Recipient,
+		Subject: email.Subject,
+		Body:    email.Body,
+		HTML:    email.HTML,
+	})
+	if err == nil {
+		if err := w.userRepo.MarkOutboxEmailSent(ctx, email.ID); err != nil {
+			log.Printf("error marking outbox email %d as sent: %v", email.ID, err)
+		}
+		return
+	}
+
+	// Attempts already includes the one that just failed
+	if email.Attempts >= w.maxAttempts {
+		log.Printf("giving up on outbox email %d after %d attempts: %v", email.ID, email.Attempts, err)
+		if err := w.userRepo.DeadLetterOutboxEmail(ctx, email.ID, err.Error()); err != nil {
+			log.Printf("error dead-lettering outbox email %d: %v", email.ID, err)
+		}
+		return
+	}
+
+	next := w.now().Add(outboxBackoff(email.Attempts))
+	log.Printf("error sending outbox email %d (attempt %d), retrying at %s: %v", email.ID, email.Attempts, next.Format(time.RFC3339), err)
+	if err := w.userRepo.RetryOutboxEmail(ctx, email.ID, err.Error(), next); err != nil {
+		log.Printf("error rescheduling outbox email %d: %v", email.ID, err)
+	}
+}
+
+// outboxBackoff returns the delay after the given failed attempt: 30s, 1m, 2m, ... capped at outboxMaxBackoff
+func outboxBackoff(attempt int) time.Duration {
+	d := outboxBaseBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return d
+}
+
+// ListDeadLetters returns emails that exhausted their delivery attempts
+func (w *OutboxWorker) ListDeadLetters(ctx context.Context, limit int) ([]*domain.OutboxEmail, error) {
+	return w.userRepo.ListDeadLetters(ctx, limit)
+}
+
+// RequeueDeadLetter puts a dead email back into the queue with a fresh attempt budget
+func (w *OutboxWorker) RequeueDeadLetter(ctx context.Context, id int64) (bool, error) {
+	return w.userRepo.RequeueDeadLetter(ctx, id)
+}
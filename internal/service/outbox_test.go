@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/mailpost"
+	"github.com/Olegnemlii/test123/internal/repository"
+)
+
+// fakeOutboxRepo keeps the outbox in memory; other repository methods are not used
+type fakeOutboxRepo struct {
+	repository.UserRepository
+
+	emails map[int64]*domain.OutboxEmail
+	now    func() time.Time
+}
+
+func (r *fakeOutboxRepo) ClaimOutboxEmails(ctx context.Context, limit int, leaseUntil time.Time) ([]*domain.OutboxEmail, error) {
+	var claimed []*domain.OutboxEmail
+	for _, e := range r.emails {
+		if len(claimed) == limit {
+			break
+		}
+		if e.Status == domain.OutboxPending && !e.NextAttemptAt.After(r.now()) {
+			e.Attempts++
+			e.NextAttemptAt = leaseUntil
+			c := *e
+			claimed = append(claimed, &c)
+		}
+	}
+	return claimed, nil
+}
+
+func (r *fakeOutboxRepo) MarkOutboxEmailSent(ctx context.Context, id int64) error {
+	r.emails[id].Status = domain.OutboxSent
+	return nil
+}
+
+func (r *fakeOutboxRepo) RetryOutboxEmail(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	r.emails[id].LastError.String, r.emails[id].LastError.Valid = lastError, true
+	r.emails[id].NextAttemptAt = nextAttemptAt
+	return nil
+}
+
+func (r *fakeOutboxRepo) DeadLetterOutboxEmail(ctx context.Context, id int64, lastError string) error {
+	r.emails[id].Status = domain.OutboxDead
+	r.emails[id].LastError.String, r.emails[id].LastError.Valid = lastError, true
+	return nil
+}
+
+type flakyMailer struct {
+	failures int
+	sent     []mailpost.Message
+}
+
+func (m *flakyMailer) Send(msg mailpost.Message) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("mailopost is down")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func newTestOutbox(failures, maxAttempts int) (*OutboxWorker, *fakeOutboxRepo, *flakyMailer, *time.Time) {
+	now := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	repo := &fakeOutboxRepo{
+		emails: map[int64]*domain.OutboxEmail{
+			1: {ID: 1, Recipient: "user@example.com", Subject: "Hi", Body: "hello", Status: domain.OutboxPending, NextAttemptAt: now},
+		},
+		now: clock,
+	}
+	mailer := &flakyMailer{failures: failures}
+	worker := &OutboxWorker{userRepo: repo, mailer: mailer, maxAttempts: maxAttempts, now: clock}
+	return worker, repo, mailer, &now
+}
+
+func TestOutboxWorkerRetriesWithBackoff(t *testing.T) {
+	worker, repo, mailer, now := newTestOutbox(2, 5)
+	start := *now
+	ctx := context.Background()
+
+	// First attempt fails and is retried after the base backoff
+	if _, err := worker.ProcessBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.emails[1].NextAttemptAt.Sub(start); got != outboxBaseBackoff {
+		t.Fatalf("first retry after %s, want %s", got, outboxBaseBackoff)
+	}
+
+	// Not due yet
+	if n, _ := worker.ProcessBatch(ctx); n != 0 {
+		t.Fatalf("claimed %d emails before they were due", n)
+	}
+
+	// Second attempt fails, the delay doubles
+	*now = repo.emails[1].NextAttemptAt
+	if _, err := worker.ProcessBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.emails[1].NextAttemptAt.Sub(*now); got != 2*outboxBaseBackoff {
+		t.Fatalf("second retry after %s, want %s", got, 2*outboxBaseBackoff)
+	}
+
+	// Third attempt succeeds
+	*now = repo.emails[1].NextAttemptAt
+	if _, err := worker.ProcessBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if repo.emails[1].Status != domain.OutboxSent {
+		t.Fatalf("status = %s, want sent", repo.emails[1].Status)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].To != "user@example.com" {
+		t.Fatalf("unexpected sent messages: %+v", mailer.sent)
+	}
+}
+
+func TestOutboxWorkerDeadLetters(t *testing.T) {
+	worker, repo, mailer, now := newTestOutbox(10, 3)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := worker.ProcessBatch(ctx); err != nil {
+			t.Fatal(err)
+		}
+		*now = repo.emails[1].NextAttemptAt
+	}
+
+	email := repo.emails[1]
+	if email.Status != domain.OutboxDead {
+		t.Fatalf("status = %s after %d attempts, want dead", email.Status, email.Attempts)
+	}
+	if email.LastError.String != "mailopost is down" {
+		t.Fatalf("last error = %q", email.LastError.String)
+	}
+	if len(mailer.sent) != 0 {
+		t.Fatalf("dead email was sent")
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, outboxMaxBackoff},
+		{100, outboxMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempt); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// passkeyCeremonyTTL is how long a passkey registration or login can be completed
+const passkeyCeremonyTTL = 5 * time.Minute
+
+// Начало регистрации ключа доступа. Возвращает PublicKeyCredentialCreationOptions
+// в JSON для navigator.credentials.create()
+func (s *UserService) BeginPasskeyRegistration(ctx context.Context, accessToken string) ([]byte, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.passkeyUser(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	// Passkeys are discoverable and verify the user, so they replace the password
+	// and second factor alike. Authenticators already registered are excluded.
+	exclusions := make([]protocol.CredentialDescriptor, len(user.credentials))
+	for i, c := range user.credentials {
+		exclusions[i] = c.Descriptor()
+	}
+	creation, session, err := rp.BeginRegistration(user,
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			UserVerification:   protocol.VerificationRequired,
+		}),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		log.Printf("error beginning passkey registration: %v", err)
+		return nil, err
+	}
+
+	if err := s.storeWebAuthnChallenge(ctx, domain.CeremonyRegistration, uuid.NullUUID{UUID: user.ID, Valid: true}, session); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(creation)
+}
+
+// Завершение регистрации ключа доступа ответом navigator.credentials.create()
+func (s *UserService) FinishPasskeyRegistration(ctx context.Context, accessToken string, response []byte) (*domain.PasskeyCredential, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, domain.ErrInvalidPasskey
+	}
+
+	session, err := s.takeWebAuthnChallenge(ctx, domain.CeremonyRegistration, parsed.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID.UUID != principal.UserID {
+		return nil, domain.ErrInvalidPasskey
+	}
+
+	user, err := s.passkeyUser(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := rp.CreateCredential(user, session.data, parsed)
+	if err != nil {
+		return nil, domain.ErrInvalidPasskey
+	}
+
+	transports := make([]string, len(created.Transport))
+	for i, t := range created.Transport {
+		transports[i] = string(t)
+	}
+	credential := &domain.PasskeyCredential{
+		ID:              created.ID,
+		UserID:          user.ID,
+		UserHandle:      user.WebAuthnID(),
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		AAGUID:          created.Authenticator.AAGUID,
+		Transports:      transports,
+		SignCount:       created.Authenticator.SignCount,
+	}
+	if err := s.userRepo.CreatePasskey(ctx, credential); err != nil {
+		if !errors.Is(err, domain.ErrPasskeyExists) {
+			log.Printf("error creating passkey: %v", err)
+		}
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// Начало входа по ключу доступа. Пользователь не указывается: его определяет
+// выбранный в браузере ключ. Возвращает PublicKeyCredentialRequestOptions в JSON
+func (s *UserService) BeginPasskeyLogin(ctx context.Context) ([]byte, error) {
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, session, err := rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		log.Printf("error beginning passkey login: %v", err)
+		return nil, err
+	}
+
+	if err := s.storeWebAuthnChallenge(ctx, domain.CeremonyLogin, uuid.NullUUID{}, session); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(assertion)
+}
+
+// Завершение входа ответом navigator.credentials.get(). Ключ доступа проверяет
+// пользователя сам, поэтому второй фактор не запрашивается
+func (s *UserService) FinishPasskeyLogin(ctx context.Context, response []byte, client domain.ClientInfo) (*domain.User, *domain.TokenPair, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, nil, domain.ErrInvalidPasskey
+	}
+
+	session, err := s.takeWebAuthnChallenge(ctx, domain.CeremonyLogin, parsed.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var user *webAuthnUser
+	credential, err := rp.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		stored, err := s.userRepo.GetPasskey(ctx, rawID)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(stored.UserHandle, userHandle) {
+			return nil, errors.New("user handle does not match the credential")
+		}
+		user, err = s.passkeyUser(ctx, stored.UserID)
+		return user, err
+	}, session.data, parsed)
+	if err != nil {
+		return nil, nil, domain.ErrInvalidPasskey
+	}
+
+	// A counter that does not grow means two copies of the key may exist
+	if credential.Authenticator.CloneWarning {
+		log.Printf("passkey sign count went backwards for user %s", user.ID)
+		return nil, nil, domain.ErrPasskeyCloned
+	}
+	used, err := s.userRepo.UsePasskey(ctx, credential.ID, credential.Authenticator.SignCount)
+	if err != nil {
+		log.Printf("error updating passkey sign count: %v", err)
+		return nil, nil, err
+	}
+	if !used {
+		// Another login with this counter value won the race
+		log.Printf("passkey sign count went backwards for user %s", user.ID)
+		return nil, nil, domain.ErrPasskeyCloned
+	}
+
+	tokens, err := s.IssueTokens(ctx, user.User, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user.User, tokens, nil
+}
+
+// webAuthnUser adapts a user and its passkeys to the WebAuthn library
+type webAuthnUser struct {
+	*domain.User
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID is the user handle; a UUID carries no personal data
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.ID[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.Email
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// passkeyUser loads the user together with its registered passkeys
+func (s *UserService) passkeyUser(ctx context.Context, userID uuid.UUID) (*webAuthnUser, error) {
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return nil, err
+	}
+
+	stored, err := s.userRepo.ListPasskeys(ctx, userID)
+	if err != nil {
+		log.Printf("error listing passkeys: %v", err)
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, len(stored))
+	for i, c := range stored {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for j, t := range c.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+		credentials[i] = webauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+
+	return &webAuthnUser{User: user, credentials: credentials}, nil
+}
+
+// webAuthnSession is a stored ceremony decoded back into the library's state
+type webAuthnSession struct {
+	*domain.WebAuthnChallenge
+	data webauthn.SessionData
+}
+
+func (s *UserService) storeWebAuthnChallenge(ctx context.Context, ceremony string, userID uuid.NullUUID, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepo.StoreWebAuthnChallenge(ctx, &domain.WebAuthnChallenge{
+		Challenge:   session.Challenge,
+		Ceremony:    ceremony,
+		UserID:      userID,
+		SessionData: data,
+		ExpiresAt:   time.Now().UTC().Add(passkeyCeremonyTTL),
+	})
+	if err != nil {
+		log.Printf("error storing WebAuthn challenge: %v", err)
+		return err
+	}
+	return nil
+}
+
+// takeWebAuthnChallenge uses up the ceremony the client signed the challenge
+// of. An unknown, expired or mismatched ceremony is ErrInvalidPasskey.
+func (s *UserService) takeWebAuthnChallenge(ctx context.Context, ceremony, challenge string) (*webAuthnSession, error) {
+	stored, err := s.userRepo.TakeWebAuthnChallenge(ctx, challenge)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidPasskey
+		}
+		log.Printf("error getting WebAuthn challenge: %v", err)
+		return nil, err
+	}
+
+	if stored.Ceremony != ceremony || time.Now().UTC().After(stored.ExpiresAt) {
+		return nil, domain.ErrInvalidPasskey
+	}
+
+	session := &webAuthnSession{WebAuthnChallenge: stored}
+	if err := json.Unmarshal(stored.SessionData, &session.data); err != nil {
+		log.Printf("error decoding WebAuthn challenge: %v", err)
+		return nil, err
+	}
+	return session, nil
+}
+
+// relyingParty returns the WebAuthn library configured for this service
+func (s *UserService) relyingParty() (*webauthn.WebAuthn, error) {
+	cfg := s.webAuthn
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyCeremonyTTL, TimeoutUVD: passkeyCeremonyTTL}
+	cfg.Timeouts = webauthn.TimeoutsConfig{Login: timeout, Registration: timeout}
+	return webauthn.New(&cfg)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+)
+
+// passwordResetTTL is how long an emailed password reset link stays valid
+const passwordResetTTL = time.Hour
+
+// Запрос на сброс пароля. Ответ не зависит от того, существует ли пользователь:
+// токен и письмо готовятся в обоих случаях, а отправляет их outbox.
+func (s *UserService) RequestPasswordReset(ctx context.Context, email string) error {
+	resetToken, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("error generating password reset token: %v", err)
+		return err
+	}
+
+	link := s.appURL + "/reset-password?token=" + url.QueryEscape(resetToken)
+	msg, err := s.mail.PasswordResetMessage(email, link)
+	if err != nil {
+		log.Printf("error rendering password reset email: %v", err)
+		return err
+	}
+
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			return nil
+		}
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+	msg.To = user.Email
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		err := repo.StorePasswordResetToken(ctx, &domain.PasswordResetToken{
+			TokenHash: hashToken(resetToken),
+			UserID:    user.ID,
+			ExpiresAt: time.Now().UTC().Add(passwordResetTTL),
+		})
+		if err != nil {
+			return err
+		}
+		return repo.EnqueueEmail(ctx, outboxEmail(msg))
+	})
+	if err != nil {
+		log.Printf("error storing password reset token: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Сброс пароля по токену из письма; все сессии пользователя отзываются
+func (s *UserService) ResetPassword(ctx context.Context, resetToken, newPassword string) error {
+	stored, err := s.userRepo.GetPasswordResetToken(ctx, hashToken(resetToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.ErrInvalidResetToken
+		}
+		log.Printf("error getting password reset token: %v", err)
+		return err
+	}
+
+	if stored.IsUsed || time.Now().UTC().After(stored.ExpiresAt) {
+		return domain.ErrInvalidResetToken
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return err
+	}
+	if err := s.validatePassword("new_password", newPassword, user.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hashPassword(newPassword)
+	if err != nil {
+		log.Printf("error hashing password: %v", err)
+		return err
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		marked, err := repo.MarkPasswordResetTokenUsed(ctx, stored.ID)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return domain.ErrInvalidResetToken
+		}
+
+		user, err := repo.GetUserByID(ctx, stored.UserID)
+		if err != nil {
+			return err
+		}
+
+		user.Password = hashedPassword
+		user.UpdatedAt = time.Now().UTC()
+		if err := repo.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+
+		return repo.RevokeAllSessions(ctx, user.ID)
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidResetToken) {
+			log.Printf("error resetting password: %v", err)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// RoleService assigns and revokes roles. It does not authorize the caller;
+// AdminService and the command line do that. Role changes reach access
+// tokens when they are next issued or refreshed.
+type RoleService struct {
+	userRepo repository.UserRepository
+}
+
+func NewRoleService(userRepo repository.UserRepository) *RoleService {
+	return &RoleService{userRepo: userRepo}
+}
+
+// Список ролей с их правами
+func (s *RoleService) ListRoles(ctx context.Context) ([]*domain.Role, error) {
+	roles, err := s.userRepo.ListRoles(ctx)
+	if err != nil {
+		log.Printf("error listing roles: %v", err)
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Роли пользователя
+func (s *RoleService) UserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	roles, err := s.userRepo.GetUserRoles(ctx, userID)
+	if err != nil {
+		log.Printf("error getting user roles: %v", err)
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Права пользователя по всем его ролям
+func (s *RoleService) UserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	permissions, err := s.userRepo.GetUserPermissions(ctx, userID)
+	if err != nil {
+		log.Printf("error getting user permissions: %v", err)
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// Назначение роли пользователю; повторное назначение ничего не меняет
+func (s *RoleService) AssignRole(ctx context.Context, userID uuid.UUID, role string) error {
+	if _, err := s.userRepo.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := s.userRepo.AssignRole(ctx, userID, role); err != nil {
+		log.Printf("error assigning role: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Отзыв роли у пользователя; отзыв отсутствующей роли ничего не меняет
+func (s *RoleService) RevokeRole(ctx context.Context, userID uuid.UUID, role string) error {
+	if _, err := s.userRepo.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+
+	roles, err := s.ListRoles(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(roles, func(r *domain.Role) bool { return r.Name == role }) {
+		return domain.ErrRoleNotFound
+	}
+
+	if err := s.userRepo.RevokeRole(ctx, userID, role); err != nil {
+		log.Printf("error revoking role: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/token"
+
+	"github.com/google/uuid"
+)
+
+// Principal is the caller identified by a verified access token
+type Principal struct {
+	UserID    uuid.UUID
+	SessionID uuid.UUID
+	TokenID   string
+	ExpiresAt time.Time
+	// Roles and Permissions are the ones the user had when the token was issued
+	Roles       []string
+	Permissions []string
+	// OrganizationID and OrgRole are set when the session acts in an organization
+	OrganizationID uuid.NullUUID
+	OrgRole        string
+}
+
+// Can reports whether the token grants the permission
+func (p *Principal) Can(permission string) bool {
+	return slices.Contains(p.Permissions, permission)
+}
+
+// Проверка access токена: подпись, чёрный список и состояние сессии
+func (s *UserService) Authenticate(ctx context.Context, accessToken string) (*Principal, error) {
+	claims, err := s.verifier.Verify(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed subject", token.ErrInvalidToken)
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed session ID", token.ErrInvalidToken)
+	}
+
+	revoked, err := s.userRepo.IsAccessTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		log.Printf("error checking revoked access token: %v", err)
+		return nil, err
+	}
+	if revoked {
+		return nil, domain.ErrTokenRevoked
+	}
+
+	session, err := s.userRepo.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, domain.ErrSessionNotFound) {
+			return nil, domain.ErrTokenRevoked
+		}
+		log.Printf("error getting session: %v", err)
+		return nil, err
+	}
+	if session.RevokedAt.Valid || session.UserID != userID {
+		return nil, domain.ErrTokenRevoked
+	}
+
+	principal := &Principal{
+		UserID:      userID,
+		SessionID:   sessionID,
+		TokenID:     claims.ID,
+		ExpiresAt:   claims.ExpiresAt.Time,
+		Roles:       claims.Roles,
+		Permissions: strings.Fields(claims.Scope),
+		OrgRole:     claims.OrgRole,
+	}
+	if claims.OrgID != "" {
+		orgID, err := uuid.Parse(claims.OrgID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed organization ID", token.ErrInvalidToken)
+		}
+		principal.OrganizationID = uuid.NullUUID{UUID: orgID, Valid: true}
+	}
+
+	return principal, nil
+}
+
+// Список активных сессий пользователя; второй результат - ID текущей сессии
+func (s *UserService) ListSessions(ctx context.Context, accessToken string) ([]*domain.Session, uuid.UUID, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	sessions, err := s.userRepo.ListSessions(ctx, principal.UserID)
+	if err != nil {
+		log.Printf("error listing sessions: %v", err)
+		return nil, uuid.Nil, err
+	}
+
+	return sessions, principal.SessionID, nil
+}
+
+// Отзыв одной из сессий пользователя
+func (s *UserService) RevokeSession(ctx context.Context, accessToken string, sessionID uuid.UUID) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	session, err := s.userRepo.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, domain.ErrSessionNotFound) {
+			return domain.ErrSessionNotFound
+		}
+		log.Printf("error getting session: %v", err)
+		return err
+	}
+	if session.UserID != principal.UserID {
+		return domain.ErrSessionNotFound
+	}
+
+	err = s.userRepo.RevokeSession(ctx, sessionID)
+	if err != nil {
+		log.Printf("error revoking session: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Отзыв всех сессий пользователя, кроме текущей
+func (s *UserService) RevokeAllOtherSessions(ctx context.Context, accessToken string) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepo.RevokeOtherSessions(ctx, principal.UserID, principal.SessionID)
+	if err != nil {
+		log.Printf("error revoking other sessions: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/token"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// TokenIssuer mints signed access tokens and opaque refresh tokens
+type TokenIssuer struct {
+	key        ed25519.PrivateKey
+	issuer     string
+	audience   string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+func NewTokenIssuer(key ed25519.PrivateKey, cfg config.Config) *TokenIssuer {
+	return &TokenIssuer{
+		key:        key,
+		issuer:     cfg.JWTIssuer,
+		audience:   cfg.JWTAudience,
+		accessTTL:  cfg.AccessTokenTTL,
+		refreshTTL: cfg.RefreshTokenTTL,
+		now:        time.Now,
+	}
+}
+
+// Verifier возвращает верификатор для токенов, выпущенных этим issuer'ом
+func (i *TokenIssuer) Verifier() *token.Verifier {
+	return token.NewVerifier(i.key.Public().(ed25519.PublicKey), i.issuer, i.audience)
+}
+
+// AccessGrants is what an access token grants besides the identity of the user
+type AccessGrants struct {
+	Roles       []string
+	Permissions []string
+	// Membership is set when the session acts in an organization
+	Membership *domain.Membership
+}
+
+// Выпуск access токена с ролями, правами и организацией пользователя
+func (i *TokenIssuer) NewAccessToken(user *domain.User, sessionID uuid.UUID, grants AccessGrants) (domain.IssuedToken, error) {
+	now := i.now().UTC()
+	expiresAt := now.Add(i.accessTTL)
+
+	tokenID := uuid.New().String()
+	claims := token.Claims{
+		Email:     user.Email,
+		SessionID: sessionID.String(),
+		Roles:     grants.Roles,
+		Scope:     strings.Join(grants.Permissions, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   user.ID.String(),
+			Issuer:    i.issuer,
+			Audience:  jwt.ClaimStrings{i.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	if m := grants.Membership; m != nil {
+		claims.OrgID = m.OrganizationID.String()
+		claims.OrgRole = m.Role
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(i.key)
+	if err != nil {
+		return domain.IssuedToken{}, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	return domain.IssuedToken{ID: tokenID, Data: signed, ExpiresAt: expiresAt}, nil
+}
+
+// Выпуск refresh токена
+func (i *TokenIssuer) NewRefreshToken() (domain.IssuedToken, error) {
+	data, err := newOpaqueToken()
+	if err != nil {
+		return domain.IssuedToken{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	return domain.IssuedToken{
+		Data:      data,
+		ExpiresAt: i.now().UTC().Add(i.refreshTTL),
+	}, nil
+}
+
+// newOpaqueToken generates a random URL-safe token for refresh and one-time links
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the form in which opaque tokens are stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
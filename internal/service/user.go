@@ -2,149 +2,523 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"math/big"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Olegnemlii/test123/internal/config"
 	"github.com/Olegnemlii/test123/internal/domain"
 	"github.com/Olegnemlii/test123/internal/repository"
+	"github.com/Olegnemlii/test123/pkg/password"
+	"github.com/Olegnemlii/test123/pkg/token"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// verificationCodeTTL is how long a code sent on registration stays valid
+	verificationCodeTTL = 24 * time.Hour
+	// verificationCodeAttempts is how many codes can be entered for a signature
+	verificationCodeAttempts = 5
 )
 
 type UserService struct {
 	userRepo repository.UserRepository
+	tokens   *TokenIssuer
+	verifier *token.Verifier
+	mail     *MailService
+	appURL   string
+	// deletionGrace is how long a deleted account can be restored
+	deletionGrace time.Duration
+	// totpKey encrypts TOTP secrets; totpIssuer is shown in authenticator apps
+	totpKey    []byte
+	totpIssuer string
+	// webAuthn is the relying party passkeys are registered with
+	webAuthn webauthn.Config
+	// hasher hashes new passwords; dummyHash is checked for unknown emails
+	hasher    password.Hasher
+	dummyHash func() string
+	// passwordPolicy decides which new passwords are accepted
+	passwordPolicy password.Policy
 }
 
-func NewUserService(userRepo repository.UserRepository) *UserService {
-	return &UserService{userRepo: userRepo}
+func NewUserService(userRepo repository.UserRepository, tokens *TokenIssuer, mail *MailService, cfg config.Config) *UserService {
+	hasher := newPasswordHasher(cfg)
+	return &UserService{
+		userRepo:      userRepo,
+		tokens:        tokens,
+		verifier:      tokens.Verifier(),
+		mail:          mail,
+		appURL:        strings.TrimRight(cfg.AppURL, "/"),
+		deletionGrace: cfg.AccountDeletionGrace,
+		totpKey:       cfg.TOTPEncryptionKey,
+		totpIssuer:    cfg.TOTPIssuer,
+		webAuthn: webauthn.Config{
+			RPID:          cfg.WebAuthnRPID,
+			RPDisplayName: cfg.WebAuthnRPName,
+			RPOrigins:     cfg.WebAuthnOrigins,
+		},
+		hasher:         hasher,
+		passwordPolicy: cfg.PasswordPolicy,
+		dummyHash: sync.OnceValue(func() string {
+			hash, err := hasher.Hash("")
+			if err != nil {
+				log.Printf("error hashing dummy password: %v", err)
+			}
+			return hash
+		}),
+	}
 }
 
-// Генерация подписи
-func (s *UserService) CreateSignature(ctx context.Context) (uuid.UUID, error) {
-	newUUID, err := uuid.NewRandom()
-	if err != nil {
-		log.Printf("error generating uuid: %v", err)
-		return uuid.Nil, err
+// newPasswordHasher returns the hasher for the configured algorithm
+func newPasswordHasher(cfg config.Config) password.Hasher {
+	if cfg.PasswordHashAlgorithm == password.AlgorithmBcrypt {
+		return password.Bcrypt{Cost: cfg.BcryptCost}
 	}
-	return newUUID, nil
+	return cfg.Argon2id
 }
 
-// Верификация кода
-func (s *UserService) VerifyCode(ctx context.Context, email string, code string) (bool, error) {
-	storedCode, err := s.userRepo.GetVerificationCode(ctx, email)
+// Подтверждение почты по подписи и коду. Каждая попытка учитывается до
+// сравнения кода, после verificationCodeAttempts попыток код недействителен.
+// Токены не выдаются: вход завершает обработчик, с учётом второго фактора
+func (s *UserService) VerifyCode(ctx context.Context, signature uuid.UUID, code string) (*domain.User, error) {
+	storedCode, err := s.userRepo.GetVerificationCode(ctx, signature)
 	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidCode
+		}
 		log.Printf("error getting verification code: %v", err)
-		return false, err
+		return nil, err
 	}
 
-	if storedCode != code {
-		log.Printf("verification code does not match")
-		return false, fmt.Errorf("invalid verification code")
+	if storedCode.IsUsed || storedCode.Attempts >= verificationCodeAttempts {
+		return nil, domain.ErrInvalidCode
+	}
+	if time.Now().UTC().After(storedCode.ExpiresAt) {
+		return nil, domain.ErrCodeExpired
 	}
 
-	err = s.userRepo.DeleteVerificationCode(ctx, email)
+	attempts, err := s.userRepo.AddVerificationCodeAttempt(ctx, signature)
 	if err != nil {
-		log.Printf("error deleting verification code: %v", err)
-		return false, err
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, domain.ErrInvalidCode
+		}
+		log.Printf("error counting verification code attempt: %v", err)
+		return nil, err
+	}
+	if attempts > verificationCodeAttempts || subtle.ConstantTimeCompare([]byte(storedCode.Code), []byte(code)) != 1 {
+		return nil, domain.ErrInvalidCode
 	}
-	return true, nil
+
+	var user *domain.User
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		// The attempt limit and single use are checked again by the update,
+		// so concurrent requests cannot redeem a code twice or guess past the limit
+		marked, err := repo.MarkVerificationCodeUsed(ctx, signature, verificationCodeAttempts)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return domain.ErrInvalidCode
+		}
+
+		user, err = repo.GetUserByID(ctx, storedCode.UserID)
+		if err != nil {
+			return err
+		}
+
+		user.IsConfirmed = true
+		user.UpdatedAt = time.Now().UTC()
+		return repo.UpdateUser(ctx, user)
+	})
+	if err != nil {
+		if !errors.Is(err, domain.ErrInvalidCode) {
+			log.Printf("error confirming user: %v", err)
+		}
+		return nil, err
+	}
+
+	return user, nil
 }
 
-// Создание пользователя
-func (s *UserService) CreateUser(ctx context.Context, user *domain.User) (*domain.User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+// Регистрация пользователя. Пользователь, код подтверждения и письмо с ним
+// сохраняются в одной транзакции, письмо доставляет OutboxWorker
+func (s *UserService) Register(ctx context.Context, email, password string) (uuid.UUID, error) {
+	if err := s.validatePassword("password", password, email); err != nil {
+		return uuid.Nil, err
+	}
+
+	hashedPassword, err := s.hashPassword(password)
 	if err != nil {
 		log.Printf("error hashing password: %v", err)
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return uuid.Nil, err
 	}
-	user.Password = string(hashedPassword)
-	user.CreatedAt = time.Now().UTC()
-	user.UpdatedAt = time.Now().UTC()
 
-	return s.userRepo.CreateUser(ctx, user)
-}
+	code, err := generateRandomCode(6)
+	if err != nil {
+		log.Printf("error generating verification code: %v", err)
+		return uuid.Nil, err
+	}
+
+	signature, err := uuid.NewRandom()
+	if err != nil {
+		log.Printf("error generating signature: %v", err)
+		return uuid.Nil, err
+	}
+
+	msg, err := s.mail.VerificationCodeMessage(email, code, "")
+	if err != nil {
+		log.Printf("error rendering verification email: %v", err)
+		return uuid.Nil, err
+	}
+
+	now := time.Now().UTC()
+	user := &domain.User{
+		Email:     email,
+		Password:  hashedPassword,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	verification := &domain.CodeSignature{
+		Code:      code,
+		Signature: signature,
+		ExpiresAt: now.Add(verificationCodeTTL),
+	}
+
+	err = s.userRepo.WithTx(ctx, func(repo repository.UserRepository) error {
+		created, err := repo.CreateUser(ctx, user)
+		if err != nil {
+			return err
+		}
 
-// Получение пользователя по ID
-func (s *UserService) GetUserByID(ctx context.Context, id uuid.UUID) (*domain.User, error) {
-	return s.userRepo.GetUserByID(ctx, id)
+		verification.UserID = created.ID
+		if err := repo.StoreVerificationCode(ctx, verification); err != nil {
+			return err
+		}
+
+		return repo.EnqueueEmail(ctx, outboxEmail(msg))
+	})
+	if err != nil {
+		log.Printf("error registering user: %v", err)
+		return uuid.Nil, err
+	}
+
+	return signature, nil
 }
 
-// Получение пользователя по Email
-func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
-	return s.userRepo.GetUserByEmail(ctx, email)
+// Вход по почте и паролю. Хэш пароля, созданный другим алгоритмом или с
+// другими параметрами, заменяется хэшем текущего
+func (s *UserService) LoginWithPassword(ctx context.Context, email, plain string) (*domain.User, error) {
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			// A hash is checked anyway, otherwise the response time would
+			// reveal that the address is not registered
+			s.CheckPassword(ctx, &domain.User{Password: s.dummyHash()}, plain)
+			return nil, domain.ErrInvalidCredentials
+		}
+		log.Printf("error getting user: %v", err)
+		return nil, err
+	}
+
+	if !s.CheckPassword(ctx, user, plain) {
+		return nil, domain.ErrInvalidCredentials
+	}
+
+	if s.hasher.NeedsRehash(user.Password) {
+		// The login succeeds even if the old hash cannot be replaced now
+		hashedPassword, err := s.hashPassword(plain)
+		if err != nil {
+			log.Printf("error rehashing password: %v", err)
+			return user, nil
+		}
+		user.Password = hashedPassword
+		user.UpdatedAt = time.Now().UTC()
+		if err := s.userRepo.UpdateUser(ctx, user); err != nil {
+			log.Printf("error updating password hash: %v", err)
+		}
+	}
+
+	return user, nil
 }
 
-// Обновление пользователя
-func (s *UserService) UpdateUser(ctx context.Context, user *domain.User) error {
-	return s.userRepo.UpdateUser(ctx, user)
+// Проверка пароля пользователя. Пользователь из кэша приходит без хэша,
+// тогда хэш читается из хранилища
+func (s *UserService) CheckPassword(ctx context.Context, user *domain.User, plain string) bool {
+	if user.Password == "" {
+		hash, err := s.userRepo.GetPasswordHash(ctx, user.ID)
+		if err != nil {
+			log.Printf("error getting password hash of user %s: %v", user.ID, err)
+			return false
+		}
+		user.Password = hash
+	}
+
+	ok, err := password.Verify(user.Password, plain)
+	if err != nil {
+		log.Printf("error verifying password of user %s: %v", user.ID, err)
+	}
+	return ok
 }
 
-// Удаление пользователя
-func (s *UserService) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	return s.userRepo.DeleteUser(ctx, id)
+// Хэширование пароля для хранения в users.password
+func (s *UserService) hashPassword(plain string) (string, error) {
+	hashedPassword, err := s.hasher.Hash(plain)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return hashedPassword, nil
 }
 
-// Получение почты по подписи
-func (s *UserService) GetEmailBySignature(ctx context.Context, signature uuid.UUID) (string, error) {
-	return s.userRepo.GetEmailBySignature(ctx, signature)
+// Проверка нового пароля по политике паролей. Нарушения возвращаются как
+// ValidationError поля field
+func (s *UserService) validatePassword(field, plain, email string) error {
+	var v domain.ValidationError
+	for _, violation := range s.passwordPolicy.Check(plain, email) {
+		v.Add(field, violation)
+	}
+	return v.Err()
 }
 
-// Обновление подписи пользователя
-func (s *UserService) UpdateUserSignature(ctx context.Context, userID uuid.UUID, signature uuid.UUID) error {
-	return s.userRepo.UpdateUserSignature(ctx, userID, signature)
+// Выпуск пары access/refresh токенов для новой сессии
+func (s *UserService) IssueTokens(ctx context.Context, user *domain.User, client domain.ClientInfo) (*domain.TokenPair, error) {
+	if user.DisabledAt.Valid {
+		return nil, domain.ErrUserDisabled
+	}
+
+	sessions, err := s.userRepo.ListSessions(ctx, user.ID)
+	if err != nil {
+		log.Printf("error listing sessions: %v", err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	session := &domain.Session{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		ClientInfo: client,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	err = s.userRepo.CreateSession(ctx, session)
+	if err != nil {
+		log.Printf("error creating session: %v", err)
+		return nil, err
+	}
+
+	if isNewDevice(sessions, client) {
+		if err := s.mail.SendNewDeviceLogin(user.Email, client, now); err != nil {
+			log.Printf("error sending new device notification: %v", err)
+		}
+	}
+
+	return s.issueTokens(ctx, user, session)
 }
 
-// Хранение кода подтверждения
-func (s *UserService) StoreRefreshToken(ctx context.Context, email, refreshToken string) error {
-	return s.userRepo.StoreRefreshToken(ctx, email, refreshToken)
+// isNewDevice reports whether the user already has sessions, none of them from this client
+func isNewDevice(sessions []*domain.Session, client domain.ClientInfo) bool {
+	if len(sessions) == 0 {
+		return false
+	}
+	for _, session := range sessions {
+		if session.UserAgent == client.UserAgent && session.DeviceName == client.DeviceName {
+			return false
+		}
+	}
+	return true
 }
 
-// Получение кода подтверждения
-func (s *UserService) GetRefreshToken(ctx context.Context, email string) (string, error) {
-	return s.userRepo.GetRefreshToken(ctx, email)
+func (s *UserService) issueTokens(ctx context.Context, user *domain.User, session *domain.Session) (*domain.TokenPair, error) {
+	accessToken, err := s.newAccessToken(ctx, user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := s.tokens.NewRefreshToken()
+	if err != nil {
+		log.Printf("error issuing refresh token: %v", err)
+		return nil, err
+	}
+
+	err = s.userRepo.StoreRefreshToken(ctx, &domain.Token{
+		AccessToken:      accessToken.ID,
+		RefreshTokenHash: hashToken(refreshToken.Data),
+		UserID:           user.ID,
+		FamilyID:         session.ID,
+		ExpiresAt:        refreshToken.ExpiresAt,
+	})
+	if err != nil {
+		log.Printf("error storing refresh token: %v", err)
+		return nil, err
+	}
+
+	return &domain.TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
 }
 
-// Удаление кода подтверждения
-func (s *UserService) DeleteRefreshToken(ctx context.Context, email string) error {
-	return s.userRepo.DeleteRefreshToken(ctx, email)
+// newAccessToken issues an access token with the current roles of the user
+// and the organization the session acts in
+func (s *UserService) newAccessToken(ctx context.Context, user *domain.User, session *domain.Session) (domain.IssuedToken, error) {
+	var grants AccessGrants
+	var err error
+
+	grants.Roles, err = s.userRepo.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		log.Printf("error getting user roles: %v", err)
+		return domain.IssuedToken{}, err
+	}
+	grants.Permissions, err = s.userRepo.GetUserPermissions(ctx, user.ID)
+	if err != nil {
+		log.Printf("error getting user permissions: %v", err)
+		return domain.IssuedToken{}, err
+	}
+
+	if session.OrganizationID.Valid {
+		grants.Membership, err = s.userRepo.GetMembership(ctx, session.OrganizationID.UUID, user.ID)
+		// A user that left the organization gets a token without it
+		if err != nil && !errors.Is(err, domain.ErrNotFound) {
+			log.Printf("error getting membership: %v", err)
+			return domain.IssuedToken{}, err
+		}
+	}
+
+	accessToken, err := s.tokens.NewAccessToken(user, session.ID, grants)
+	if err != nil {
+		log.Printf("error issuing access token: %v", err)
+		return domain.IssuedToken{}, err
+	}
+
+	return accessToken, nil
 }
 
-// StoreVerificationCode - сохраняет код верификации
-func (s *UserService) StoreVerificationCode(ctx context.Context, email string, code string) error {
-	return s.userRepo.StoreVerificationCode(ctx, email, code)
+// Ротация refresh токена: старый токен гасится, выдаётся новая пара в той же сессии.
+// Повторное предъявление уже использованного токена отзывает всю сессию.
+func (s *UserService) RefreshTokens(ctx context.Context, accessToken, refreshToken string) (*domain.User, *domain.TokenPair, error) {
+	stored, err := s.userRepo.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil, domain.ErrInvalidRefreshToken
+		}
+		log.Printf("error getting refresh token: %v", err)
+		return nil, nil, err
+	}
+
+	if stored.RevokedAt.Valid {
+		return nil, nil, domain.ErrInvalidRefreshToken
+	}
+	if stored.ConsumedAt.Valid {
+		return nil, nil, s.revokeReusedSession(ctx, stored)
+	}
+	if time.Now().UTC().After(stored.ExpiresAt) {
+		return nil, nil, domain.ErrInvalidRefreshToken
+	}
+
+	claims, err := s.verifier.VerifyIgnoringExpiry(accessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The refresh token only rotates together with the access token it was issued with
+	if claims.Subject != stored.UserID.String() || claims.ID != stored.AccessToken {
+		return nil, nil, domain.ErrInvalidRefreshToken
+	}
+
+	consumed, err := s.userRepo.ConsumeRefreshToken(ctx, stored.ID)
+	if err != nil {
+		log.Printf("error consuming refresh token: %v", err)
+		return nil, nil, err
+	}
+	if !consumed {
+		// Another request rotated the same token first
+		return nil, nil, s.revokeReusedSession(ctx, stored)
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		log.Printf("error getting user: %v", err)
+		return nil, nil, err
+	}
+	if user.DisabledAt.Valid {
+		return nil, nil, domain.ErrUserDisabled
+	}
+
+	err = s.userRepo.TouchSession(ctx, stored.FamilyID, time.Now().UTC())
+	if err != nil {
+		log.Printf("error touching session: %v", err)
+		return nil, nil, err
+	}
+
+	session, err := s.userRepo.GetSession(ctx, stored.FamilyID)
+	if err != nil {
+		log.Printf("error getting session: %v", err)
+		return nil, nil, err
+	}
+
+	tokens, err := s.issueTokens(ctx, user, session)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, tokens, nil
 }
 
-// GenerateVerificationCode - генерирует код верификации
-func (s *UserService) GenerateVerificationCode(ctx context.Context, email string) (string, error) {
-	code := generateRandomCode(6) // Generate a 6-digit code
-	err := s.userRepo.StoreVerificationCode(ctx, email, code)
+func (s *UserService) revokeReusedSession(ctx context.Context, stored *domain.Token) error {
+	log.Printf("refresh token reuse detected for user %s, revoking session %s", stored.UserID, stored.FamilyID)
+	err := s.userRepo.RevokeSession(ctx, stored.FamilyID)
 	if err != nil {
-		log.Printf("error storing verification code: %v", err)
-		return "", err
+		log.Printf("error revoking session: %v", err)
+		return err
 	}
-	return code, nil
+	return domain.ErrRefreshTokenReused
 }
 
-// Функция для генерации случайной подписи
-func generateRandomCode(length int) string {
-	rand.Seed(time.Now().UnixNano())
-	const digits = "0123456789"
-	code := make([]byte, length)
-	for i := range code {
-		code[i] = digits[rand.Intn(len(digits))]
+// Получение текущего пользователя по access токену
+func (s *UserService) GetMe(ctx context.Context, accessToken string) (*domain.User, error) {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return nil, err
 	}
-	return string(code)
+
+	return s.userRepo.GetUserByID(ctx, principal.UserID)
 }
 
-// GetVerificationCode
-func (s *UserService) GetVerificationCode(ctx context.Context, email string) (string, error) {
-	return s.userRepo.GetVerificationCode(ctx, email)
+// Выход: отзыв текущей сессии и access токена до истечения его срока
+func (s *UserService) LogOut(ctx context.Context, accessToken string) error {
+	principal, err := s.Authenticate(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepo.RevokeSession(ctx, principal.SessionID)
+	if err != nil {
+		log.Printf("error revoking session: %v", err)
+		return err
+	}
+
+	err = s.userRepo.RevokeAccessToken(ctx, principal.TokenID, principal.ExpiresAt)
+	if err != nil {
+		log.Printf("error revoking access token: %v", err)
+		return err
+	}
+
+	return nil
 }
 
-// DeleteVerificationCode
-func (s *UserService) DeleteVerificationCode(ctx context.Context, email string) error {
-	return s.userRepo.DeleteVerificationCode(ctx, email)
+// Функция для генерации случайного цифрового кода
+func generateRandomCode(length int) (string, error) {
+	const digits = "0123456789"
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(digits))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = digits[n.Int64()]
+	}
+	return string(code), nil
 }
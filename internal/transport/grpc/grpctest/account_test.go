@@ -0,0 +1,198 @@
+package grpctest_test
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/grpctest"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+)
+
+var resetLinkPattern = regexp.MustCompile(`http://app\.test/reset-password\?token=\S+`)
+
+func TestPasswordReset(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	// Unknown addresses look the same to the caller and get no email
+	if _, err := s.Client.RequestPasswordReset(ctx, &pb.RequestPasswordResetRequest{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("RequestPasswordReset(unknown): %v", err)
+	}
+	if emails := s.Emails(t, "nobody@example.com"); len(emails) != 0 {
+		t.Fatalf("unknown address got %d emails", len(emails))
+	}
+
+	if _, err := s.Client.RequestPasswordReset(ctx, &pb.RequestPasswordResetRequest{Email: a.email}); err != nil {
+		t.Fatalf("RequestPasswordReset: %v", err)
+	}
+	link := resetLinkPattern.FindString(s.LastEmail(t, a.email).Body)
+	if link == "" {
+		t.Fatalf("no reset link in the email to %s", a.email)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	token := u.Query().Get("token")
+
+	const newPassword = "N3w-passw0rd!x"
+	if _, err := s.Client.ResetPassword(ctx, &pb.ResetPasswordRequest{Token: token, NewPassword: newPassword}); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	_, err = s.Client.ResetPassword(ctx, &pb.ResetPasswordRequest{Token: token, NewPassword: newPassword})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_RESET_TOKEN")
+
+	if _, err := s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: newPassword}); err != nil {
+		t.Fatalf("Login with new password: %v", err)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	newEmail := "new-" + a.email
+
+	_, err := s.Client.ChangeEmail(ctx, &pb.ChangeEmailRequest{AccessToken: a.access, NewEmail: newEmail, Password: "wrong"})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_CREDENTIALS")
+
+	if _, err := s.Client.ChangeEmail(ctx, &pb.ChangeEmailRequest{AccessToken: a.access, NewEmail: newEmail, Password: password}); err != nil {
+		t.Fatalf("ChangeEmail: %v", err)
+	}
+	code := s.Code(t, newEmail)
+
+	_, err = s.Client.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{AccessToken: a.access, Code: wrongCode(code)})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+
+	confirmed, err := s.Client.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{AccessToken: a.access, Code: code})
+	if err != nil {
+		t.Fatalf("ConfirmEmailChange: %v", err)
+	}
+	if confirmed.GetUser().GetEmail() != newEmail {
+		t.Fatalf("confirmed email = %q, want %q", confirmed.GetUser().GetEmail(), newEmail)
+	}
+
+	// The code is single-use
+	_, err = s.Client.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{AccessToken: a.access, Code: code})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+}
+
+func TestChangeEmailAttempts(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	newEmail := "new-" + a.email
+
+	if _, err := s.Client.ChangeEmail(ctx, &pb.ChangeEmailRequest{AccessToken: a.access, NewEmail: newEmail, Password: password}); err != nil {
+		t.Fatalf("ChangeEmail: %v", err)
+	}
+	code := s.Code(t, newEmail)
+
+	for range 5 {
+		_, err := s.Client.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{AccessToken: a.access, Code: wrongCode(code)})
+		assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	}
+
+	// Requesting the change again does not reset the attempts
+	if _, err := s.Client.ChangeEmail(ctx, &pb.ChangeEmailRequest{AccessToken: a.access, NewEmail: newEmail, Password: password}); err != nil {
+		t.Fatalf("ChangeEmail again: %v", err)
+	}
+	_, err := s.Client.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{AccessToken: a.access, Code: s.Code(t, newEmail)})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+
+	if stored, err := s.Repo.GetPendingEmailChange(ctx, uuid.MustParse(userID(t, s, a.email))); err != nil || stored.CodeHash == s.Code(t, newEmail) {
+		t.Fatalf("pending change = %+v, %v, want a hashed code", stored, err)
+	}
+}
+
+func TestDeleteAndRestoreAccount(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	_, err := s.Client.DeleteAccount(ctx, &pb.DeleteAccountRequest{AccessToken: a.access, Password: "wrong"})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_CREDENTIALS")
+
+	if _, err := s.Client.DeleteAccount(ctx, &pb.DeleteAccountRequest{AccessToken: a.access, Password: password}); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if msg := s.LastEmail(t, a.email); msg.Subject != "Your account was deleted" {
+		t.Fatalf("last email subject = %q", msg.Subject)
+	}
+
+	// Every session is signed out and the account can no longer sign in
+	_, err = s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: a.access})
+	assertStatus(t, err, codes.Unauthenticated, "TOKEN_REVOKED")
+	_, err = s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: a.access, RefreshToken: a.refresh})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_REFRESH_TOKEN")
+	_, err = s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: password})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_CREDENTIALS")
+	_, err = s.Client.Register(ctx, &pb.RegisterRequest{Email: a.email, Password: password})
+	assertStatus(t, err, codes.AlreadyExists, "EMAIL_TAKEN")
+
+	_, err = s.Client.RestoreAccount(ctx, &pb.RestoreAccountRequest{Email: a.email, Password: "wrong"})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_CREDENTIALS")
+
+	restored, err := s.Client.RestoreAccount(ctx, &pb.RestoreAccountRequest{Email: a.email, Password: password})
+	if err != nil {
+		t.Fatalf("RestoreAccount: %v", err)
+	}
+	if _, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: restored.GetAccessToken()}); err != nil {
+		t.Fatalf("GetMe after restore: %v", err)
+	}
+	if _, err := s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: password}); err != nil {
+		t.Fatalf("Login after restore: %v", err)
+	}
+
+	// Restoring an active account is indistinguishable from a wrong password
+	_, err = s.Client.RestoreAccount(ctx, &pb.RestoreAccountRequest{Email: a.email, Password: password})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_CREDENTIALS")
+}
+
+func TestRestoreAccountWithTOTP(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	_, _, recovery := enableTOTP(t, s, a)
+
+	if _, err := s.Client.DeleteAccount(ctx, &pb.DeleteAccountRequest{AccessToken: a.access, Password: password}); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+
+	// Like Login, the password alone only yields the second step
+	restored, err := s.Client.RestoreAccount(ctx, &pb.RestoreAccountRequest{Email: a.email, Password: password})
+	if err != nil {
+		t.Fatalf("RestoreAccount: %v", err)
+	}
+	if restored.GetMfaChallenge() == nil || restored.GetAccessToken() != nil || restored.GetRefreshToken() != nil {
+		t.Fatalf("RestoreAccount with TOTP = %v, want only a challenge", restored)
+	}
+
+	verified, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: restored.GetMfaChallenge(), Code: recovery[0]})
+	if err != nil {
+		t.Fatalf("VerifyMFA: %v", err)
+	}
+	if _, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: verified.GetAccessToken()}); err != nil {
+		t.Fatalf("GetMe after restore: %v", err)
+	}
+}
+
+func TestRestoreAccountAfterGracePeriod(t *testing.T) {
+	s := grpctest.New(t, func(cfg *config.Config) { cfg.AccountDeletionGrace = 0 })
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	if _, err := s.Client.DeleteAccount(ctx, &pb.DeleteAccountRequest{AccessToken: a.access, Password: password}); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+
+	_, err := s.Client.RestoreAccount(ctx, &pb.RestoreAccountRequest{Email: a.email, Password: password})
+	assertStatus(t, err, codes.FailedPrecondition, "RESTORE_EXPIRED")
+}
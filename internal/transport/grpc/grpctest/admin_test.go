@@ -0,0 +1,313 @@
+package grpctest_test
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/grpctest"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+)
+
+// signUpAdmin signs up a user with the admin role
+func signUpAdmin(t *testing.T, s *grpctest.Server) *account {
+	return signUpWithRole(t, s, domain.RoleAdmin)
+}
+
+// signUpWithRole signs up a user, grants it the role and signs in again, so
+// the access token carries the role's permissions
+func signUpWithRole(t *testing.T, s *grpctest.Server, role string) *account {
+	t.Helper()
+	ctx := context.Background()
+
+	a := signUp(t, s)
+	if err := s.Repo.AssignRole(ctx, uuid.MustParse(userID(t, s, a.email)), role); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	signIn(t, s, a)
+	return a
+}
+
+// signIn replaces the tokens of a with those of a new session
+func signIn(t *testing.T, s *grpctest.Server, a *account) {
+	t.Helper()
+
+	login, err := s.Client.Login(context.Background(), &pb.LoginRequest{Email: a.email, Password: password})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	a.access, a.refresh = login.GetAccessToken(), login.GetRefreshToken()
+}
+
+func userID(t *testing.T, s *grpctest.Server, email string) string {
+	t.Helper()
+
+	user, err := s.Repo.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	return user.ID.String()
+}
+
+func TestAdminRequiresAdminRole(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	id := userID(t, s, a.email)
+
+	_, err := s.Admin.GetUser(ctx, &pb.GetUserRequest{AccessToken: a.access, UserId: id})
+	assertStatus(t, err, codes.PermissionDenied, "PERMISSION_DENIED")
+	_, err = s.Admin.ListUsers(ctx, &pb.ListUsersRequest{AccessToken: &pb.Token{Data: "garbage"}})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_TOKEN")
+
+	// Revoking the role takes effect before the admin's token expires
+	admin := signUpAdmin(t, s)
+	if _, err := s.Admin.GetUser(ctx, &pb.GetUserRequest{AccessToken: admin.access, UserId: id}); err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	user, _ := s.Repo.GetUserByEmail(ctx, admin.email)
+	if err := s.Repo.RevokeRole(ctx, user.ID, domain.RoleAdmin); err != nil {
+		t.Fatalf("RevokeRole: %v", err)
+	}
+	_, err = s.Admin.GetUser(ctx, &pb.GetUserRequest{AccessToken: admin.access, UserId: id})
+	assertStatus(t, err, codes.PermissionDenied, "PERMISSION_DENIED")
+
+	// Calls without the permission in the token are rejected before they reach
+	// the service; the denial of an outdated token is audited
+	entries, err := s.Repo.ListAuditEntries(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListAuditEntries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d audit entries, want 2", len(entries))
+	}
+	if e := entries[0]; e.Action != "GetUser" || e.ActorID != user.ID || e.TargetID.UUID.String() != id || e.Error.String != domain.ErrPermissionDenied.Error() {
+		t.Fatalf("audit entry = %+v", e)
+	}
+	if e := entries[1]; e.ActorID != user.ID || e.Error.Valid {
+		t.Fatalf("audit entry of an allowed call = %+v", e)
+	}
+}
+
+func TestAdminListUsers(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	admin := signUpAdmin(t, s)
+
+	var emails []string
+	for i := 0; i < 5; i++ {
+		email := "listed-" + uuid.NewString() + "@example.com"
+		if _, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: email, Password: password}); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+		emails = append(emails, email)
+	}
+
+	var got []string
+	var pages int
+	req := &pb.ListUsersRequest{AccessToken: admin.access, EmailPrefix: "listed-", PageSize: 2}
+	for {
+		resp, err := s.Admin.ListUsers(ctx, req)
+		if err != nil {
+			t.Fatalf("ListUsers: %v", err)
+		}
+		pages++
+		for _, u := range resp.GetUsers() {
+			got = append(got, u.GetEmail())
+		}
+		if resp.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	if pages != 3 || len(got) != len(emails) {
+		t.Fatalf("listed %d users in %d pages, want %d in 3", len(got), pages, len(emails))
+	}
+	seen := make(map[string]bool)
+	for _, email := range got {
+		seen[email] = true
+	}
+	for _, email := range emails {
+		if !seen[email] {
+			t.Fatalf("%s was not listed", email)
+		}
+	}
+
+	confirmed := true
+	resp, err := s.Admin.ListUsers(ctx, &pb.ListUsersRequest{AccessToken: admin.access, Confirmed: &confirmed})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(resp.GetUsers()) != 1 || resp.GetUsers()[0].GetEmail() != admin.email {
+		t.Fatalf("confirmed users = %v, want only the admin", resp.GetUsers())
+	}
+
+	_, err = s.Admin.ListUsers(ctx, &pb.ListUsersRequest{AccessToken: admin.access, PageToken: "bogus"})
+	assertStatus(t, err, codes.InvalidArgument, "")
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	admin := signUpAdmin(t, s)
+	a := signUp(t, s)
+	id := userID(t, s, a.email)
+
+	_, err := s.Admin.DisableUser(ctx, &pb.DisableUserRequest{AccessToken: admin.access, UserId: userID(t, s, admin.email)})
+	assertStatus(t, err, codes.InvalidArgument, "")
+
+	disabled, err := s.Admin.DisableUser(ctx, &pb.DisableUserRequest{AccessToken: admin.access, UserId: id, Reason: "spam"})
+	if err != nil {
+		t.Fatalf("DisableUser: %v", err)
+	}
+	if disabled.GetUser().GetDisabledAt() == 0 {
+		t.Fatal("DisableUser did not set disabled_at")
+	}
+
+	_, err = s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: a.access})
+	assertStatus(t, err, codes.Unauthenticated, "TOKEN_REVOKED")
+	_, err = s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: password})
+	assertStatus(t, err, codes.PermissionDenied, "USER_DISABLED")
+
+	if _, err := s.Admin.EnableUser(ctx, &pb.EnableUserRequest{AccessToken: admin.access, UserId: id}); err != nil {
+		t.Fatalf("EnableUser: %v", err)
+	}
+	login, err := s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: password})
+	if err != nil {
+		t.Fatalf("Login after EnableUser: %v", err)
+	}
+
+	if _, err := s.Admin.ForceLogout(ctx, &pb.ForceLogoutRequest{AccessToken: admin.access, UserId: id}); err != nil {
+		t.Fatalf("ForceLogout: %v", err)
+	}
+	_, err = s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: login.GetAccessToken()})
+	assertStatus(t, err, codes.Unauthenticated, "TOKEN_REVOKED")
+
+	entries, err := s.Repo.ListAuditEntries(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListAuditEntries: %v", err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	want := []string{"ForceLogout", "EnableUser", "DisableUser", "DisableUser"}
+	if len(actions) != len(want) {
+		t.Fatalf("audited actions = %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("audited actions = %v, want %v", actions, want)
+		}
+	}
+	if entries[2].Details != "spam" {
+		t.Fatalf("DisableUser audit details = %q, want the reason", entries[2].Details)
+	}
+}
+
+var linkPattern = regexp.MustCompile(`http://app\.test/verify-email\?\S+`)
+
+func TestAdminVerification(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	admin := signUpAdmin(t, s)
+
+	email := uuid.NewString() + "@example.com"
+	if _, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: email, Password: password}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	id := userID(t, s, email)
+
+	if _, err := s.Admin.ResendVerification(ctx, &pb.ResendVerificationRequest{AccessToken: admin.access, UserId: id}); err != nil {
+		t.Fatalf("ResendVerification: %v", err)
+	}
+	link := linkPattern.FindString(s.LastEmail(t, email).Body)
+	if link == "" {
+		t.Fatal("no verification link in the resent email")
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	q := u.Query()
+	if _, err := s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: q.Get("signature"), Code: q.Get("code")}); err != nil {
+		t.Fatalf("VerifyCode with the resent code: %v", err)
+	}
+
+	_, err = s.Admin.ResendVerification(ctx, &pb.ResendVerificationRequest{AccessToken: admin.access, UserId: id})
+	assertStatus(t, err, codes.FailedPrecondition, "ALREADY_CONFIRMED")
+
+	other := uuid.NewString() + "@example.com"
+	if _, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: other, Password: password}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	confirmed, err := s.Admin.ForceConfirmEmail(ctx, &pb.ForceConfirmEmailRequest{AccessToken: admin.access, UserId: userID(t, s, other)})
+	if err != nil {
+		t.Fatalf("ForceConfirmEmail: %v", err)
+	}
+	if !confirmed.GetUser().GetConfirmed() {
+		t.Fatal("ForceConfirmEmail did not confirm the user")
+	}
+
+	_, err = s.Admin.GetUser(ctx, &pb.GetUserRequest{AccessToken: admin.access, UserId: uuid.NewString()})
+	assertStatus(t, err, codes.NotFound, "USER_NOT_FOUND")
+}
+
+func TestAdminRoles(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	admin := signUpAdmin(t, s)
+	support := signUpWithRole(t, s, domain.RoleSupport)
+	a := signUp(t, s)
+	id := userID(t, s, a.email)
+
+	// Support staff manage users but not roles
+	if _, err := s.Admin.ListUsers(ctx, &pb.ListUsersRequest{AccessToken: support.access}); err != nil {
+		t.Fatalf("ListUsers as support: %v", err)
+	}
+	_, err := s.Admin.AssignRole(ctx, &pb.AssignRoleRequest{AccessToken: support.access, UserId: id, Role: domain.RoleAdmin})
+	assertStatus(t, err, codes.PermissionDenied, "PERMISSION_DENIED")
+
+	roles, err := s.Admin.ListRoles(ctx, &pb.ListRolesRequest{AccessToken: admin.access})
+	if err != nil {
+		t.Fatalf("ListRoles: %v", err)
+	}
+	if len(roles.GetRoles()) != 2 || roles.GetRoles()[1].GetName() != domain.RoleSupport {
+		t.Fatalf("ListRoles = %v, want the built-in roles", roles.GetRoles())
+	}
+
+	if _, err := s.Admin.AssignRole(ctx, &pb.AssignRoleRequest{AccessToken: admin.access, UserId: id, Role: domain.RoleSupport}); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	_, err = s.Admin.AssignRole(ctx, &pb.AssignRoleRequest{AccessToken: admin.access, UserId: id, Role: "owner"})
+	assertStatus(t, err, codes.NotFound, "ROLE_NOT_FOUND")
+	got, err := s.Admin.GetUser(ctx, &pb.GetUserRequest{AccessToken: admin.access, UserId: id})
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if r := got.GetRoles(); len(r) != 1 || r[0] != domain.RoleSupport {
+		t.Fatalf("roles = %v, want [support]", r)
+	}
+
+	// The old token does not carry the new role, the next one does
+	_, err = s.Admin.ListUsers(ctx, &pb.ListUsersRequest{AccessToken: a.access})
+	assertStatus(t, err, codes.PermissionDenied, "PERMISSION_DENIED")
+	signIn(t, s, a)
+	if _, err := s.Admin.ListUsers(ctx, &pb.ListUsersRequest{AccessToken: a.access}); err != nil {
+		t.Fatalf("ListUsers after AssignRole: %v", err)
+	}
+
+	if _, err := s.Admin.RevokeRole(ctx, &pb.RevokeRoleRequest{AccessToken: admin.access, UserId: id, Role: domain.RoleSupport}); err != nil {
+		t.Fatalf("RevokeRole: %v", err)
+	}
+	_, err = s.Admin.ListUsers(ctx, &pb.ListUsersRequest{AccessToken: a.access})
+	assertStatus(t, err, codes.PermissionDenied, "PERMISSION_DENIED")
+
+	_, err = s.Admin.RevokeRole(ctx, &pb.RevokeRoleRequest{AccessToken: admin.access, UserId: userID(t, s, admin.email), Role: domain.RoleAdmin})
+	assertStatus(t, err, codes.InvalidArgument, "")
+}
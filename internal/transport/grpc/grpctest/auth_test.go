@@ -0,0 +1,396 @@
+package grpctest_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/transport/grpc/grpctest"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const password = "correct horse battery staple"
+
+// account is a registered user with a confirmed email and a signed-in session
+type account struct {
+	email     string
+	signature string
+	access    *pb.Token
+	refresh   *pb.Token
+}
+
+func signUp(t *testing.T, s *grpctest.Server) *account {
+	t.Helper()
+	ctx := context.Background()
+
+	email := uuid.NewString() + "@example.com"
+	reg, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: email, Password: password})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	verified, err := s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: reg.GetSignature(), Code: s.Code(t, email)})
+	if err != nil {
+		t.Fatalf("VerifyCode: %v", err)
+	}
+
+	return &account{
+		email:     email,
+		signature: reg.GetSignature(),
+		access:    verified.GetAccessToken(),
+		refresh:   verified.GetRefreshToken(),
+	}
+}
+
+func TestAuthFlow(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	email := "flow@example.com"
+
+	reg, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: email, Password: password})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if msg := s.LastEmail(t, email); msg.Subject != "Confirm your email" {
+		t.Fatalf("verification email subject = %q", msg.Subject)
+	}
+
+	verified, err := s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: reg.GetSignature(), Code: s.Code(t, email)})
+	if err != nil {
+		t.Fatalf("VerifyCode: %v", err)
+	}
+	if verified.GetUser().GetEmail() != email {
+		t.Fatalf("VerifyCode user = %v", verified.GetUser())
+	}
+
+	login, err := s.Client.Login(ctx, &pb.LoginRequest{Email: email, Password: password})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	refreshed, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{
+		AccessToken:  login.GetAccessToken(),
+		RefreshToken: login.GetRefreshToken(),
+	})
+	if err != nil {
+		t.Fatalf("RefreshTokens: %v", err)
+	}
+	if refreshed.GetRefreshToken().GetData() == login.GetRefreshToken().GetData() {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	me, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: refreshed.GetAccessToken()})
+	if err != nil {
+		t.Fatalf("GetMe: %v", err)
+	}
+	if me.GetUser().GetId() != verified.GetUser().GetId() || me.GetUser().GetEmail() != email {
+		t.Fatalf("GetMe user = %v", me.GetUser())
+	}
+
+	if _, err := s.Client.LogOut(ctx, &pb.LogOutRequest{AccessToken: refreshed.GetAccessToken()}); err != nil {
+		t.Fatalf("LogOut: %v", err)
+	}
+	_, err = s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: refreshed.GetAccessToken()})
+	assertStatus(t, err, codes.Unauthenticated, "TOKEN_REVOKED")
+
+	// The session that registration signed in is independent of the logged out one
+	if _, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: verified.GetAccessToken()}); err != nil {
+		t.Fatalf("GetMe with the other session: %v", err)
+	}
+}
+
+func TestAuthScenarios(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    func(ctx context.Context, s *grpctest.Server, a *account) error
+		code   codes.Code
+		reason string
+	}{
+		{
+			name: "login",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: password})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "login with wrong password",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: "wrong"})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "INVALID_CREDENTIALS",
+		},
+		{
+			name: "login with unknown email",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.Login(ctx, &pb.LoginRequest{Email: "nobody@example.com", Password: password})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "INVALID_CREDENTIALS",
+		},
+		{
+			name: "login without password",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.Login(ctx, &pb.LoginRequest{Email: a.email})
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "register taken email",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: a.email, Password: password})
+				return err
+			},
+			code:   codes.AlreadyExists,
+			reason: "EMAIL_TAKEN",
+		},
+		{
+			name: "register without email",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.Register(ctx, &pb.RegisterRequest{Password: password})
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "verify wrong code",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				reg, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: "new@example.com", Password: password})
+				if err != nil {
+					return err
+				}
+				_, err = s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: reg.GetSignature(), Code: "wrong"})
+				return err
+			},
+			code:   codes.InvalidArgument,
+			reason: "INVALID_CODE",
+		},
+		{
+			name: "verify used code",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				// The email is already confirmed by signUp
+				_, err := s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: a.signature, Code: "000000"})
+				return err
+			},
+			code:   codes.InvalidArgument,
+			reason: "INVALID_CODE",
+		},
+		{
+			name: "verify malformed signature",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: "not-a-uuid", Code: "123456"})
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "refresh",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: a.access, RefreshToken: a.refresh})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "refresh unknown token",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: a.access, RefreshToken: &pb.Token{Data: "unknown"}})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "INVALID_REFRESH_TOKEN",
+		},
+		{
+			name: "refresh with another session's access token",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				login, err := s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: password})
+				if err != nil {
+					return err
+				}
+				_, err = s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: login.GetAccessToken(), RefreshToken: a.refresh})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "INVALID_REFRESH_TOKEN",
+		},
+		{
+			name: "refresh reused token",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				if _, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: a.access, RefreshToken: a.refresh}); err != nil {
+					return err
+				}
+				_, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: a.access, RefreshToken: a.refresh})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "REFRESH_TOKEN_REUSED",
+		},
+		{
+			name: "refresh after reuse revoked the session",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				rotated, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: a.access, RefreshToken: a.refresh})
+				if err != nil {
+					return err
+				}
+				s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: a.access, RefreshToken: a.refresh})
+				_, err = s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: rotated.GetAccessToken(), RefreshToken: rotated.GetRefreshToken()})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "INVALID_REFRESH_TOKEN",
+		},
+		{
+			name: "refresh after logout",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				if _, err := s.Client.LogOut(ctx, &pb.LogOutRequest{AccessToken: a.access}); err != nil {
+					return err
+				}
+				_, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: a.access, RefreshToken: a.refresh})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "INVALID_REFRESH_TOKEN",
+		},
+		{
+			name: "get me",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: a.access})
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "get me with forged token",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: &pb.Token{Data: a.access.GetData() + "x"}})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "INVALID_TOKEN",
+		},
+		{
+			name: "get me without token",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				_, err := s.Client.GetMe(ctx, &pb.GetMeRequest{})
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "log out twice",
+			run: func(ctx context.Context, s *grpctest.Server, a *account) error {
+				if _, err := s.Client.LogOut(ctx, &pb.LogOutRequest{AccessToken: a.access}); err != nil {
+					return err
+				}
+				_, err := s.Client.LogOut(ctx, &pb.LogOutRequest{AccessToken: a.access})
+				return err
+			},
+			code:   codes.Unauthenticated,
+			reason: "TOKEN_REVOKED",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := grpctest.New(t)
+			err := tt.run(context.Background(), s, signUp(t, s))
+			assertStatus(t, err, tt.code, tt.reason)
+		})
+	}
+}
+
+func TestVerifyCodeAttempts(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+
+	email := uuid.NewString() + "@example.com"
+	reg, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: email, Password: password})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	code := s.Code(t, email)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: reg.GetSignature(), Code: wrong})
+		assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	}
+
+	// The signature is used up, the right code no longer confirms the email
+	_, err = s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: reg.GetSignature(), Code: code})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	if user, _ := s.Repo.GetUserByEmail(ctx, email); user.IsConfirmed {
+		t.Fatal("email was confirmed after the attempt limit")
+	}
+}
+
+func TestPasswordRehash(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	// An account from before argon2id still has a bcrypt hash
+	user, err := s.Repo.GetUserByEmail(ctx, a.email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if !strings.HasPrefix(user.Password, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("new password hash = %q, want argon2id", user.Password)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	user.Password = string(legacy)
+	if err := s.Repo.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	_, err = s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: "wrong password"})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_CREDENTIALS")
+	if user, _ = s.Repo.GetUserByEmail(ctx, a.email); user.Password != string(legacy) {
+		t.Fatalf("password hash changed after a failed login")
+	}
+
+	signIn(t, s, a)
+	if user, _ = s.Repo.GetUserByEmail(ctx, a.email); !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Fatalf("password hash after login = %q, want argon2id", user.Password)
+	}
+	signIn(t, s, a)
+}
+
+// assertStatus checks the status code and, if reason is set, the ErrorInfo reason
+func assertStatus(t *testing.T, err error, code codes.Code, reason string) {
+	t.Helper()
+
+	st := status.Convert(err)
+	if st.Code() != code {
+		t.Fatalf("code = %s (%s), want %s", st.Code(), st.Message(), code)
+	}
+	if reason == "" {
+		return
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			if info.GetReason() != reason {
+				t.Fatalf("reason = %q, want %q", info.GetReason(), reason)
+			}
+			return
+		}
+	}
+	t.Fatalf("no ErrorInfo in status %v, want reason %q", st, reason)
+}
@@ -0,0 +1,175 @@
+package grpctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// Authenticator is a software passkey. It answers the options returned by
+// BeginPasskeyRegistration and BeginPasskeyLogin the way a browser with a
+// platform authenticator would, using a P-256 key and packed self attestation.
+type Authenticator struct {
+	// Origin is reported to the server in the client data
+	Origin string
+	// SignCount is the signature counter; every signature increments it
+	// first. Tests lower it to simulate a cloned authenticator.
+	SignCount uint32
+
+	credentialID []byte
+	userHandle   []byte
+	key          *ecdsa.PrivateKey
+}
+
+// NewAuthenticator returns an authenticator without a credential; Register creates one
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Register creates a credential for the creation options and returns the
+// PublicKeyCredential JSON for FinishPasskeyRegistration
+func (a *Authenticator) Register(options string) (string, error) {
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal([]byte(options), &creation); err != nil {
+		return "", fmt.Errorf("decode creation options: %w", err)
+	}
+	userID, ok := creation.Response.User.ID.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected user.id %v", creation.Response.User.ID)
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(userID)
+	if err != nil {
+		return "", fmt.Errorf("decode user.id: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	credentialID := make([]byte, 32)
+	if _, err := rand.Read(credentialID); err != nil {
+		return "", err
+	}
+	a.key, a.credentialID, a.userHandle = key, credentialID, userHandle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: key.X.FillBytes(make([]byte, 32)),
+		YCoord: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Attested credential data: AAGUID, credential ID length, ID and public key
+	attested := make([]byte, 16, 16+2+len(credentialID)+len(publicKey))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(credentialID)))
+	attested = append(append(attested, credentialID...), publicKey...)
+	authData := a.authenticatorData(creation.Response.RelyingParty.ID, protocol.FlagAttestedCredentialData, attested)
+
+	clientData, err := a.clientData("webauthn.create", creation.Response.Challenge)
+	if err != nil {
+		return "", err
+	}
+	sig, err := a.sign(authData, clientData)
+	if err != nil {
+		return "", err
+	}
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "packed",
+		"attStmt":  map[string]any{"alg": int64(webauthncose.AlgES256), "sig": sig},
+		"authData": authData,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return a.credential(map[string]any{
+		"clientDataJSON":    b64(clientData),
+		"attestationObject": b64(attestation),
+		"transports":        []string{"internal", "hybrid"},
+	})
+}
+
+// Login signs the challenge of the request options with the registered
+// credential and returns the PublicKeyCredential JSON for FinishPasskeyLogin
+func (a *Authenticator) Login(options string) (string, error) {
+	if a.key == nil {
+		return "", fmt.Errorf("authenticator has no credential")
+	}
+
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal([]byte(options), &assertion); err != nil {
+		return "", fmt.Errorf("decode request options: %w", err)
+	}
+
+	authData := a.authenticatorData(assertion.Response.RelyingPartyID, 0, nil)
+	clientData, err := a.clientData("webauthn.get", assertion.Response.Challenge)
+	if err != nil {
+		return "", err
+	}
+	sig, err := a.sign(authData, clientData)
+	if err != nil {
+		return "", err
+	}
+
+	return a.credential(map[string]any{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(sig),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+// authenticatorData increments the counter and returns the RP ID hash,
+// flags with the user present and verified, the counter and extra data
+func (a *Authenticator) authenticatorData(rpID string, flags protocol.AuthenticatorFlags, extra []byte) []byte {
+	a.SignCount++
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], byte(flags|protocol.FlagUserPresent|protocol.FlagUserVerified))
+	data = binary.BigEndian.AppendUint32(data, a.SignCount)
+	return append(data, extra...)
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge protocol.URLEncodedBase64) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"type":      ceremony,
+		"challenge": b64(challenge),
+		"origin":    a.Origin,
+	})
+}
+
+// sign signs the authenticator data together with the hash of the client data
+func (a *Authenticator) sign(authData, clientData []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	return ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+}
+
+func (a *Authenticator) credential(response map[string]any) (string, error) {
+	body, err := json.Marshal(map[string]any{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	return string(body), err
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,189 @@
+// Package grpctest runs the complete gRPC server in process over bufconn.
+// The server is wired exactly like cmd/main.go, except that it uses the
+// in-memory repository and a mailbox that records delivered emails.
+package grpctest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/mailpost"
+	"github.com/Olegnemlii/test123/internal/repository"
+	"github.com/Olegnemlii/test123/internal/repository/memory"
+	"github.com/Olegnemlii/test123/internal/service"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/handler"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/server"
+	"github.com/Olegnemlii/test123/pkg/password"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1 << 20
+
+// Server is a running in-process server and a client connected to it
+type Server struct {
+	Client pb.AuthClient
+	Orgs   pb.OrganizationsClient
+	Admin  pb.AdminClient
+	Repo   repository.UserRepository
+	Config config.Config
+
+	outbox  *service.OutboxWorker
+	mailbox *Mailbox
+}
+
+// Option changes the configuration the server is built with
+type Option func(cfg *config.Config)
+
+// New starts a server that is stopped when the test finishes
+func New(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+
+	cfg := config.Config{
+		AppURL:               "http://app.test",
+		JWTIssuer:            "grpctest",
+		JWTAudience:          "grpctest",
+		AccessTokenTTL:       15 * time.Minute,
+		RefreshTokenTTL:      24 * time.Hour,
+		OutboxMaxAttempts:    3,
+		AccountDeletionGrace: 24 * time.Hour,
+		TOTPEncryptionKey:    make([]byte, 32),
+		TOTPIssuer:           "grpctest",
+		WebAuthnRPID:         "app.test",
+		WebAuthnRPName:       "grpctest",
+		WebAuthnOrigins:      []string{"http://app.test"},
+		// Cheap parameters keep the tests fast
+		PasswordHashAlgorithm: password.AlgorithmArgon2id,
+		BcryptCost:            bcrypt.MinCost,
+		Argon2id:              password.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		PasswordPolicy:        password.Policy{MinLength: 8, MaxLength: password.BcryptMaxLength, RejectEmail: true},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+
+	repo := memory.NewUserRepository()
+	mailbox := &Mailbox{}
+	mailService, err := service.NewMailService(service.NewOutboxMailer(repo))
+	if err != nil {
+		t.Fatalf("create mail service: %v", err)
+	}
+	tokenIssuer := service.NewTokenIssuer(key, cfg)
+	authService := service.NewUserService(repo, tokenIssuer, mailService, cfg)
+	roleService := service.NewRoleService(repo)
+	authHandler := handler.NewAuthHandler(authService, mailService, cfg)
+	orgHandler := handler.NewOrganizationHandler(authService)
+	adminHandler := handler.NewAdminHandler(service.NewAdminService(authService, roleService, repo, mailService, cfg))
+
+	lis := bufconn.Listen(bufSize)
+	srv := server.NewServer(tokenIssuer.Verifier(), authHandler, orgHandler, adminHandler)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &Server{
+		Client:  pb.NewAuthClient(conn),
+		Orgs:    pb.NewOrganizationsClient(conn),
+		Admin:   pb.NewAdminClient(conn),
+		Repo:    repo,
+		Config:  cfg,
+		outbox:  service.NewOutboxWorker(repo, mailbox, cfg),
+		mailbox: mailbox,
+	}
+}
+
+// Emails delivers the queued outbox and returns every email sent to the
+// recipient so far, oldest first
+func (s *Server) Emails(t *testing.T, to string) []mailpost.Message {
+	t.Helper()
+
+	for {
+		n, err := s.outbox.ProcessBatch(context.Background())
+		if err != nil {
+			t.Fatalf("deliver outbox: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return s.mailbox.To(to)
+}
+
+// LastEmail returns the newest email sent to the recipient
+func (s *Server) LastEmail(t *testing.T, to string) mailpost.Message {
+	t.Helper()
+
+	emails := s.Emails(t, to)
+	if len(emails) == 0 {
+		t.Fatalf("no email was sent to %s", to)
+	}
+	return emails[len(emails)-1]
+}
+
+var codePattern = regexp.MustCompile(`code is (\w+)`)
+
+// Code extracts the one-time code from the newest email sent to the recipient
+func (s *Server) Code(t *testing.T, to string) string {
+	t.Helper()
+
+	msg := s.LastEmail(t, to)
+	m := codePattern.FindStringSubmatch(msg.Body)
+	if m == nil {
+		t.Fatalf("no code in email %q to %s", msg.Subject, to)
+	}
+	return m[1]
+}
+
+// Mailbox is a mailpost.Mailer that keeps every message in memory
+type Mailbox struct {
+	mu       sync.Mutex
+	messages []mailpost.Message
+}
+
+func (m *Mailbox) Send(msg mailpost.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// To returns the messages sent to the recipient, oldest first
+func (m *Mailbox) To(to string) []mailpost.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var messages []mailpost.Message
+	for _, msg := range m.messages {
+		if msg.To == to {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
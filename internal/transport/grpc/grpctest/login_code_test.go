@@ -0,0 +1,172 @@
+package grpctest_test
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/transport/grpc/grpctest"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"google.golang.org/grpc/codes"
+)
+
+var loginLinkPattern = regexp.MustCompile(`http://app\.test/login/link\?token=\S+`)
+
+// requestLoginCode asks for a sign-in email and returns its code and link token
+func requestLoginCode(t *testing.T, s *grpctest.Server, email string) (string, string) {
+	t.Helper()
+
+	if _, err := s.Client.RequestLoginCode(context.Background(), &pb.RequestLoginCodeRequest{Email: email}); err != nil {
+		t.Fatalf("RequestLoginCode: %v", err)
+	}
+
+	code := s.Code(t, email)
+	link := loginLinkPattern.FindString(s.LastEmail(t, email).Body)
+	if link == "" {
+		t.Fatalf("no login link in the email to %s", email)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	return code, u.Query().Get("token")
+}
+
+func TestLoginWithCode(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	// Unknown addresses look the same to the caller
+	if _, err := s.Client.RequestLoginCode(ctx, &pb.RequestLoginCodeRequest{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("RequestLoginCode(unknown): %v", err)
+	}
+	if emails := s.Emails(t, "nobody@example.com"); len(emails) != 0 {
+		t.Fatalf("sent %d emails to an unknown address", len(emails))
+	}
+	_, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: "nobody@example.com", Code: "123456"})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+
+	code, link := requestLoginCode(t, s, a.email)
+
+	login, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: code})
+	if err != nil {
+		t.Fatalf("LoginWithCode: %v", err)
+	}
+	if login.GetUser().GetEmail() != a.email || login.GetMfaChallenge() != nil {
+		t.Fatalf("LoginWithCode = %v", login)
+	}
+	if _, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: login.GetAccessToken()}); err != nil {
+		t.Fatalf("GetMe: %v", err)
+	}
+
+	// The code and the link of an email work once between them
+	_, err = s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: code})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	_, err = s.Client.LoginWithLink(ctx, &pb.LoginWithLinkRequest{Token: link})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+
+	// Only the newest email can be used
+	oldCode, _ := requestLoginCode(t, s, a.email)
+	newCode, _ := requestLoginCode(t, s, a.email)
+	if oldCode != newCode {
+		_, err = s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: oldCode})
+		assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	}
+	if _, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: newCode}); err != nil {
+		t.Fatalf("LoginWithCode with the newest code: %v", err)
+	}
+}
+
+func TestLoginWithLink(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	_, link := requestLoginCode(t, s, a.email)
+
+	_, err := s.Client.LoginWithLink(ctx, &pb.LoginWithLinkRequest{Token: "unknown"})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+
+	login, err := s.Client.LoginWithLink(ctx, &pb.LoginWithLinkRequest{Token: link})
+	if err != nil {
+		t.Fatalf("LoginWithLink: %v", err)
+	}
+	if login.GetUser().GetEmail() != a.email || login.GetAccessToken() == nil {
+		t.Fatalf("LoginWithLink = %v", login)
+	}
+
+	_, err = s.Client.LoginWithLink(ctx, &pb.LoginWithLinkRequest{Token: link})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+}
+
+func TestLoginCodeAttempts(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	code, link := requestLoginCode(t, s, a.email)
+	for i := 0; i < 5; i++ {
+		_, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: wrongCode(code)})
+		assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	}
+
+	// The email is used up, code and link alike
+	_, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: code})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	_, err = s.Client.LoginWithLink(ctx, &pb.LoginWithLinkRequest{Token: link})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+}
+
+func TestLoginCodeAttemptsSurviveNewEmail(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	code, _ := requestLoginCode(t, s, a.email)
+	for i := 0; i < 3; i++ {
+		_, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: wrongCode(code)})
+		assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	}
+
+	// Requesting another email keeps the attempts made on the previous one
+	code, link := requestLoginCode(t, s, a.email)
+	for i := 0; i < 2; i++ {
+		_, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: wrongCode(code)})
+		assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	}
+	_, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: code})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+	_, err = s.Client.LoginWithLink(ctx, &pb.LoginWithLinkRequest{Token: link})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_CODE")
+}
+
+// wrongCode returns a six-digit code other than code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestLoginCodeWithTOTP(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	_, _, recovery := enableTOTP(t, s, a)
+
+	code, _ := requestLoginCode(t, s, a.email)
+	login, err := s.Client.LoginWithCode(ctx, &pb.LoginWithCodeRequest{Email: a.email, Code: code})
+	if err != nil {
+		t.Fatalf("LoginWithCode: %v", err)
+	}
+	if login.GetMfaChallenge() == nil || login.GetAccessToken() != nil {
+		t.Fatalf("LoginWithCode with TOTP = %v, want only a challenge", login)
+	}
+
+	if _, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: login.GetMfaChallenge(), Code: recovery[0]}); err != nil {
+		t.Fatalf("VerifyMFA: %v", err)
+	}
+}
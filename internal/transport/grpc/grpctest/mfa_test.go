@@ -0,0 +1,231 @@
+package grpctest_test
+
+import (
+	"context"
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/grpctest"
+	"github.com/Olegnemlii/test123/pkg/pb"
+	"github.com/Olegnemlii/test123/pkg/totp"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+)
+
+// enableTOTP enrolls and confirms TOTP for a and returns the secret, the
+// time-step used to confirm it and the recovery codes
+func enableTOTP(t *testing.T, s *grpctest.Server, a *account) ([]byte, int64, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrolled, err := s.Client.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{AccessToken: a.access})
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	if !strings.HasPrefix(enrolled.GetUri(), "otpauth://totp/grpctest:"+a.email+"?") {
+		t.Fatalf("EnrollTOTP uri = %q", enrolled.GetUri())
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrolled.GetSecret())
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+
+	step := totp.Step(time.Now())
+	confirmed, err := s.Client.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{AccessToken: a.access, Code: totp.Code(secret, step)})
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	return secret, step, confirmed.GetRecoveryCodes()
+}
+
+// challenge signs a in with the password and returns the MFA challenge
+func challenge(t *testing.T, s *grpctest.Server, a *account) *pb.Token {
+	t.Helper()
+
+	login, err := s.Client.Login(context.Background(), &pb.LoginRequest{Email: a.email, Password: password})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if login.GetMfaChallenge() == nil || login.GetAccessToken() != nil || login.GetRefreshToken() != nil {
+		t.Fatalf("Login with TOTP = %v, want only a challenge", login)
+	}
+	return login.GetMfaChallenge()
+}
+
+func TestTOTPLogin(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	_, err := s.Client.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{AccessToken: a.access, Code: "123456"})
+	assertStatus(t, err, codes.FailedPrecondition, "TOTP_NOT_ENABLED")
+
+	// An unconfirmed enrollment does not change the login
+	if _, err := s.Client.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{AccessToken: a.access}); err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	signIn(t, s, a)
+
+	secret, step, recovery := enableTOTP(t, s, a)
+	if len(recovery) != 10 {
+		t.Fatalf("got %d recovery codes, want 10", len(recovery))
+	}
+
+	_, err = s.Client.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{AccessToken: a.access})
+	assertStatus(t, err, codes.FailedPrecondition, "TOTP_ALREADY_ENABLED")
+
+	// The code used to confirm cannot be replayed
+	mfa := challenge(t, s, a)
+	_, err = s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: mfa, Code: totp.Code(secret, step)})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+
+	verified, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: mfa, Code: totp.Code(secret, step+1)})
+	if err != nil {
+		t.Fatalf("VerifyMFA: %v", err)
+	}
+	if verified.GetUser().GetEmail() != a.email {
+		t.Fatalf("VerifyMFA user = %v", verified.GetUser())
+	}
+	if _, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: verified.GetAccessToken()}); err != nil {
+		t.Fatalf("GetMe: %v", err)
+	}
+
+	_, err = s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: mfa, Code: recovery[0]})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_MFA_CHALLENGE")
+
+	// Recovery codes work once, typed in any case
+	if _, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: challenge(t, s, a), Code: strings.ToUpper(recovery[0])}); err != nil {
+		t.Fatalf("VerifyMFA with a recovery code: %v", err)
+	}
+	_, err = s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: challenge(t, s, a), Code: recovery[0]})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+
+	_, err = s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: &pb.Token{Data: "unknown"}, Code: recovery[1]})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_MFA_CHALLENGE")
+}
+
+func TestVerifyCodeWithTOTP(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	_, _, recovery := enableTOTP(t, s, a)
+
+	// A verification code is a first factor like the password
+	code := &domain.CodeSignature{
+		Code:      "123456",
+		Signature: uuid.New(),
+		UserID:    uuid.MustParse(userID(t, s, a.email)),
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+	}
+	if err := s.Repo.StoreVerificationCode(ctx, code); err != nil {
+		t.Fatalf("StoreVerificationCode: %v", err)
+	}
+	verified, err := s.Client.VerifyCode(ctx, &pb.VerifyCodeRequest{Signature: code.Signature.String(), Code: code.Code})
+	if err != nil {
+		t.Fatalf("VerifyCode: %v", err)
+	}
+	if verified.GetMfaChallenge() == nil || verified.GetAccessToken() != nil || verified.GetRefreshToken() != nil {
+		t.Fatalf("VerifyCode with TOTP = %v, want only a challenge", verified)
+	}
+
+	if _, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: verified.GetMfaChallenge(), Code: recovery[0]}); err != nil {
+		t.Fatalf("VerifyMFA: %v", err)
+	}
+}
+
+func TestMFAChallengeAttempts(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	_, _, recovery := enableTOTP(t, s, a)
+
+	mfa := challenge(t, s, a)
+	for i := 0; i < 5; i++ {
+		_, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: mfa, Code: "000000"})
+		assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+	}
+
+	// The challenge is used up, even with a valid code
+	_, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: mfa, Code: recovery[0]})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_MFA_CHALLENGE")
+
+	if _, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: challenge(t, s, a), Code: recovery[0]}); err != nil {
+		t.Fatalf("VerifyMFA with a new challenge: %v", err)
+	}
+}
+
+func TestMFAAttemptsAcrossChallenges(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	_, _, recovery := enableTOTP(t, s, a)
+
+	// wrongCodes enters n wrong codes, starting a new challenge whenever the
+	// current one is used up
+	wrongCodes := func(n int) {
+		t.Helper()
+		var mfa *pb.Token
+		for i := range n {
+			if i%5 == 0 {
+				mfa = challenge(t, s, a)
+			}
+			_, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: mfa, Code: "000000"})
+			assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+		}
+	}
+
+	// A correct code starts the count over
+	wrongCodes(9)
+	if _, err := s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: challenge(t, s, a), Code: recovery[0]}); err != nil {
+		t.Fatalf("VerifyMFA after 9 wrong codes: %v", err)
+	}
+
+	// Wrong codes at DisableTOTP count as well
+	wrongCodes(9)
+	_, err := s.Client.DisableTOTP(ctx, &pb.DisableTOTPRequest{AccessToken: a.access, Password: password, Code: "000000"})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+
+	// Now even valid codes are rejected, whatever the path
+	_, err = s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: challenge(t, s, a), Code: recovery[1]})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+	_, err = s.Client.DisableTOTP(ctx, &pb.DisableTOTPRequest{AccessToken: a.access, Password: password, Code: recovery[1]})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+}
+
+func TestDisableTOTP(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	_, _, recovery := enableTOTP(t, s, a)
+
+	_, err := s.Client.DisableTOTP(ctx, &pb.DisableTOTPRequest{AccessToken: a.access, Code: recovery[0]})
+	assertStatus(t, err, codes.InvalidArgument, "")
+	_, err = s.Client.DisableTOTP(ctx, &pb.DisableTOTPRequest{AccessToken: a.access, Password: "wrong", Code: recovery[0]})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_CREDENTIALS")
+	_, err = s.Client.DisableTOTP(ctx, &pb.DisableTOTPRequest{AccessToken: a.access, Password: password, Code: "not-a-code"})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+
+	if _, err := s.Client.DisableTOTP(ctx, &pb.DisableTOTPRequest{AccessToken: a.access, Password: password, Code: recovery[0]}); err != nil {
+		t.Fatalf("DisableTOTP: %v", err)
+	}
+
+	login, err := s.Client.Login(ctx, &pb.LoginRequest{Email: a.email, Password: password})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if login.GetAccessToken() == nil || login.GetMfaChallenge() != nil {
+		t.Fatalf("Login after DisableTOTP = %v", login)
+	}
+
+	_, err = s.Client.DisableTOTP(ctx, &pb.DisableTOTPRequest{AccessToken: a.access, Password: password, Code: recovery[1]})
+	assertStatus(t, err, codes.FailedPrecondition, "TOTP_NOT_ENABLED")
+
+	// Recovery codes of the old enrollment are gone
+	enableTOTP(t, s, a)
+	_, err = s.Client.VerifyMFA(ctx, &pb.VerifyMFARequest{MfaChallenge: challenge(t, s, a), Code: recovery[1]})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_MFA_CODE")
+}
@@ -0,0 +1,242 @@
+package grpctest_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/grpctest"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+)
+
+var invitationPattern = regexp.MustCompile(`http://app\.test/invitations/accept\?\S+`)
+
+// invitationToken extracts the token from the newest invitation sent to the recipient
+func invitationToken(t *testing.T, s *grpctest.Server, to string) string {
+	t.Helper()
+
+	link := invitationPattern.FindString(s.LastEmail(t, to).Body)
+	if link == "" {
+		t.Fatalf("no invitation link in the email to %s", to)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse link: %v", err)
+	}
+	return u.Query().Get("token")
+}
+
+// orgClaims decodes the organization claims of an access token without verifying it
+func orgClaims(t *testing.T, tok *pb.Token) (org, role string) {
+	t.Helper()
+
+	parts := strings.Split(tok.GetData(), ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed access token %q", tok.GetData())
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decode token payload: %v", err)
+	}
+	var claims struct {
+		Org     string `json:"org"`
+		OrgRole string `json:"org_role"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("unmarshal token payload: %v", err)
+	}
+	return claims.Org, claims.OrgRole
+}
+
+func TestOrganizationInvitations(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	owner := signUp(t, s)
+
+	created, err := s.Orgs.CreateOrganization(ctx, &pb.CreateOrganizationRequest{AccessToken: owner.access, Name: "Acme"})
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	orgID := created.GetOrganization().GetId()
+
+	// An existing user accepts the invitation from their account
+	member := signUp(t, s)
+	if _, err := s.Orgs.InviteMember(ctx, &pb.InviteMemberRequest{AccessToken: owner.access, OrganizationId: orgID, Email: member.email, Role: domain.OrgRoleMember}); err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+	if msg := s.LastEmail(t, member.email); msg.Subject != "You are invited to Acme" {
+		t.Fatalf("invitation subject = %q", msg.Subject)
+	}
+	tok := invitationToken(t, s, member.email)
+
+	_, err = s.Orgs.AcceptInvitation(ctx, &pb.AcceptInvitationRequest{AccessToken: owner.access, InvitationToken: tok})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_INVITATION")
+
+	accepted, err := s.Orgs.AcceptInvitation(ctx, &pb.AcceptInvitationRequest{AccessToken: member.access, InvitationToken: tok})
+	if err != nil {
+		t.Fatalf("AcceptInvitation: %v", err)
+	}
+	if accepted.GetOrganization().GetId() != orgID {
+		t.Fatalf("accepted organization = %v", accepted.GetOrganization())
+	}
+
+	_, err = s.Orgs.AcceptInvitation(ctx, &pb.AcceptInvitationRequest{AccessToken: member.access, InvitationToken: tok})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_INVITATION")
+
+	_, err = s.Orgs.InviteMember(ctx, &pb.InviteMemberRequest{AccessToken: owner.access, OrganizationId: orgID, Email: member.email, Role: domain.OrgRoleAdmin})
+	assertStatus(t, err, codes.AlreadyExists, "ALREADY_MEMBER")
+
+	// Members cannot invite, and outsiders do not see the organization
+	_, err = s.Orgs.InviteMember(ctx, &pb.InviteMemberRequest{AccessToken: member.access, OrganizationId: orgID, Email: "someone@example.com", Role: domain.OrgRoleMember})
+	assertStatus(t, err, codes.PermissionDenied, "PERMISSION_DENIED")
+
+	outsider := signUp(t, s)
+	_, err = s.Orgs.InviteMember(ctx, &pb.InviteMemberRequest{AccessToken: outsider.access, OrganizationId: orgID, Email: "someone@example.com", Role: domain.OrgRoleMember})
+	assertStatus(t, err, codes.NotFound, "ORGANIZATION_NOT_FOUND")
+
+	// A new user signs up through the invitation and is signed in right away
+	email := uuid.NewString() + "@example.com"
+	if _, err := s.Orgs.InviteMember(ctx, &pb.InviteMemberRequest{AccessToken: owner.access, OrganizationId: orgID, Email: email, Role: domain.OrgRoleAdmin}); err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+	tok = invitationToken(t, s, email)
+
+	_, err = s.Client.Register(ctx, &pb.RegisterRequest{Email: "other@example.com", Password: password, InvitationToken: tok})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_INVITATION")
+
+	reg, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: email, Password: password, InvitationToken: tok})
+	if err != nil {
+		t.Fatalf("Register with invitation: %v", err)
+	}
+	if reg.GetUser().GetEmail() != email || reg.GetAccessToken() == nil || reg.GetRefreshToken() == nil {
+		t.Fatalf("Register with invitation = %v", reg)
+	}
+	if _, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: reg.GetAccessToken()}); err != nil {
+		t.Fatalf("GetMe: %v", err)
+	}
+
+	membership, err := s.Repo.GetMembership(ctx, uuid.MustParse(orgID), uuid.MustParse(userID(t, s, email)))
+	if err != nil {
+		t.Fatalf("GetMembership: %v", err)
+	}
+	if membership.Role != domain.OrgRoleAdmin {
+		t.Fatalf("invited role = %q, want %q", membership.Role, domain.OrgRoleAdmin)
+	}
+}
+
+func TestSwitchOrganization(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	created, err := s.Orgs.CreateOrganization(ctx, &pb.CreateOrganizationRequest{AccessToken: a.access, Name: "Acme"})
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	orgID := created.GetOrganization().GetId()
+
+	if org, _ := orgClaims(t, a.access); org != "" {
+		t.Fatalf("token before switching has organization %q", org)
+	}
+
+	switched, err := s.Orgs.SwitchOrganization(ctx, &pb.SwitchOrganizationRequest{AccessToken: a.access, OrganizationId: orgID})
+	if err != nil {
+		t.Fatalf("SwitchOrganization: %v", err)
+	}
+	if org, role := orgClaims(t, switched.GetAccessToken()); org != orgID || role != domain.OrgRoleOwner {
+		t.Fatalf("switched token organization = %q (%q), want %q (owner)", org, role, orgID)
+	}
+
+	_, err = s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: a.access})
+	assertStatus(t, err, codes.Unauthenticated, "TOKEN_REVOKED")
+
+	// Refreshing keeps the session in the organization
+	refreshed, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: switched.GetAccessToken(), RefreshToken: a.refresh})
+	if err != nil {
+		t.Fatalf("RefreshTokens: %v", err)
+	}
+	if org, _ := orgClaims(t, refreshed.GetAccessToken()); org != orgID {
+		t.Fatalf("refreshed token organization = %q, want %q", org, orgID)
+	}
+
+	// Switching back to the personal context drops the claim
+	personal, err := s.Orgs.SwitchOrganization(ctx, &pb.SwitchOrganizationRequest{AccessToken: refreshed.GetAccessToken()})
+	if err != nil {
+		t.Fatalf("SwitchOrganization: %v", err)
+	}
+	if org, _ := orgClaims(t, personal.GetAccessToken()); org != "" {
+		t.Fatalf("personal token organization = %q", org)
+	}
+
+	_, err = s.Orgs.SwitchOrganization(ctx, &pb.SwitchOrganizationRequest{AccessToken: personal.GetAccessToken(), OrganizationId: uuid.NewString()})
+	assertStatus(t, err, codes.NotFound, "ORGANIZATION_NOT_FOUND")
+}
+
+func TestRemoveMember(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	owner := signUp(t, s)
+
+	created, err := s.Orgs.CreateOrganization(ctx, &pb.CreateOrganizationRequest{AccessToken: owner.access, Name: "Acme"})
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	orgID := created.GetOrganization().GetId()
+
+	join := func(role string) *account {
+		t.Helper()
+		a := signUp(t, s)
+		if _, err := s.Orgs.InviteMember(ctx, &pb.InviteMemberRequest{AccessToken: owner.access, OrganizationId: orgID, Email: a.email, Role: role}); err != nil {
+			t.Fatalf("InviteMember: %v", err)
+		}
+		if _, err := s.Orgs.AcceptInvitation(ctx, &pb.AcceptInvitationRequest{AccessToken: a.access, InvitationToken: invitationToken(t, s, a.email)}); err != nil {
+			t.Fatalf("AcceptInvitation: %v", err)
+		}
+		return a
+	}
+	admin := join(domain.OrgRoleAdmin)
+	member := join(domain.OrgRoleMember)
+	ownerID := userID(t, s, owner.email)
+
+	_, err = s.Orgs.RemoveMember(ctx, &pb.RemoveMemberRequest{AccessToken: member.access, OrganizationId: orgID, UserId: userID(t, s, admin.email)})
+	assertStatus(t, err, codes.PermissionDenied, "PERMISSION_DENIED")
+
+	_, err = s.Orgs.RemoveMember(ctx, &pb.RemoveMemberRequest{AccessToken: admin.access, OrganizationId: orgID, UserId: ownerID})
+	assertStatus(t, err, codes.PermissionDenied, "PERMISSION_DENIED")
+
+	_, err = s.Orgs.RemoveMember(ctx, &pb.RemoveMemberRequest{AccessToken: owner.access, OrganizationId: orgID, UserId: ownerID})
+	assertStatus(t, err, codes.FailedPrecondition, "LAST_OWNER")
+
+	// The removed member's session leaves the organization
+	switched, err := s.Orgs.SwitchOrganization(ctx, &pb.SwitchOrganizationRequest{AccessToken: member.access, OrganizationId: orgID})
+	if err != nil {
+		t.Fatalf("SwitchOrganization: %v", err)
+	}
+	if _, err := s.Orgs.RemoveMember(ctx, &pb.RemoveMemberRequest{AccessToken: admin.access, OrganizationId: orgID, UserId: userID(t, s, member.email)}); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	refreshed, err := s.Client.RefreshTokens(ctx, &pb.RefreshTokensRequest{AccessToken: switched.GetAccessToken(), RefreshToken: member.refresh})
+	if err != nil {
+		t.Fatalf("RefreshTokens: %v", err)
+	}
+	if org, _ := orgClaims(t, refreshed.GetAccessToken()); org != "" {
+		t.Fatalf("removed member's token organization = %q", org)
+	}
+	_, err = s.Orgs.SwitchOrganization(ctx, &pb.SwitchOrganizationRequest{AccessToken: refreshed.GetAccessToken(), OrganizationId: orgID})
+	assertStatus(t, err, codes.NotFound, "ORGANIZATION_NOT_FOUND")
+
+	// Members may leave on their own
+	if _, err := s.Orgs.RemoveMember(ctx, &pb.RemoveMemberRequest{AccessToken: admin.access, OrganizationId: orgID, UserId: userID(t, s, admin.email)}); err != nil {
+		t.Fatalf("RemoveMember self: %v", err)
+	}
+	_, err = s.Orgs.RemoveMember(ctx, &pb.RemoveMemberRequest{AccessToken: admin.access, OrganizationId: orgID, UserId: ownerID})
+	assertStatus(t, err, codes.NotFound, "ORGANIZATION_NOT_FOUND")
+}
@@ -0,0 +1,168 @@
+package grpctest_test
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/transport/grpc/grpctest"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"google.golang.org/grpc/codes"
+)
+
+// registerPasskey registers a software passkey for a
+func registerPasskey(t *testing.T, s *grpctest.Server, a *account) *grpctest.Authenticator {
+	t.Helper()
+	ctx := context.Background()
+
+	authenticator := grpctest.NewAuthenticator("http://app.test")
+	begin, err := s.Client.BeginPasskeyRegistration(ctx, &pb.BeginPasskeyRegistrationRequest{AccessToken: a.access})
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	credential, err := authenticator.Register(begin.GetOptions())
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, err := s.Client.FinishPasskeyRegistration(ctx, &pb.FinishPasskeyRegistrationRequest{AccessToken: a.access, Credential: credential}); err != nil {
+		t.Fatalf("FinishPasskeyRegistration: %v", err)
+	}
+	return authenticator
+}
+
+// passkeyLogin runs a login ceremony with the authenticator
+func passkeyLogin(t *testing.T, s *grpctest.Server, authenticator *grpctest.Authenticator) (*pb.FinishPasskeyLoginResponse, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	begin, err := s.Client.BeginPasskeyLogin(ctx, &pb.BeginPasskeyLoginRequest{})
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	credential, err := authenticator.Login(begin.GetOptions())
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return s.Client.FinishPasskeyLogin(ctx, &pb.FinishPasskeyLoginRequest{Credential: credential})
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	authenticator := grpctest.NewAuthenticator("http://app.test")
+	begin, err := s.Client.BeginPasskeyRegistration(ctx, &pb.BeginPasskeyRegistrationRequest{AccessToken: a.access})
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	credential, err := authenticator.Register(begin.GetOptions())
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	// The ceremony belongs to the user that started it
+	b := signUp(t, s)
+	_, err = s.Client.FinishPasskeyRegistration(ctx, &pb.FinishPasskeyRegistrationRequest{AccessToken: b.access, Credential: credential})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_PASSKEY")
+
+	begin, err = s.Client.BeginPasskeyRegistration(ctx, &pb.BeginPasskeyRegistrationRequest{AccessToken: a.access})
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	if credential, err = authenticator.Register(begin.GetOptions()); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	finished, err := s.Client.FinishPasskeyRegistration(ctx, &pb.FinishPasskeyRegistrationRequest{AccessToken: a.access, Credential: credential})
+	if err != nil {
+		t.Fatalf("FinishPasskeyRegistration: %v", err)
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(finished.GetPasskey().GetId()); err != nil || len(finished.GetPasskey().GetTransports()) != 2 {
+		t.Fatalf("FinishPasskeyRegistration passkey = %v", finished.GetPasskey())
+	}
+
+	// A challenge can be answered once
+	_, err = s.Client.FinishPasskeyRegistration(ctx, &pb.FinishPasskeyRegistrationRequest{AccessToken: a.access, Credential: credential})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_PASSKEY")
+
+	// Responses from another origin are rejected
+	phished := grpctest.NewAuthenticator("http://evil.test")
+	begin, err = s.Client.BeginPasskeyRegistration(ctx, &pb.BeginPasskeyRegistrationRequest{AccessToken: a.access})
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	if credential, err = phished.Register(begin.GetOptions()); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	_, err = s.Client.FinishPasskeyRegistration(ctx, &pb.FinishPasskeyRegistrationRequest{AccessToken: a.access, Credential: credential})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_PASSKEY")
+
+	_, err = s.Client.FinishPasskeyRegistration(ctx, &pb.FinishPasskeyRegistrationRequest{AccessToken: a.access, Credential: "{}"})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_PASSKEY")
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+	authenticator := registerPasskey(t, s, a)
+
+	// A passkey verifies the user, so TOTP is not asked for
+	enableTOTP(t, s, a)
+
+	begin, err := s.Client.BeginPasskeyLogin(ctx, &pb.BeginPasskeyLoginRequest{})
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	credential, err := authenticator.Login(begin.GetOptions())
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	login, err := s.Client.FinishPasskeyLogin(ctx, &pb.FinishPasskeyLoginRequest{Credential: credential})
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin: %v", err)
+	}
+	if login.GetUser().GetEmail() != a.email {
+		t.Fatalf("FinishPasskeyLogin user = %v", login.GetUser())
+	}
+	if _, err := s.Client.GetMe(ctx, &pb.GetMeRequest{AccessToken: login.GetAccessToken()}); err != nil {
+		t.Fatalf("GetMe: %v", err)
+	}
+
+	// The signed challenge cannot be replayed
+	_, err = s.Client.FinishPasskeyLogin(ctx, &pb.FinishPasskeyLoginRequest{Credential: credential})
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_PASSKEY")
+
+	// A passkey the server does not know
+	unknown := grpctest.NewAuthenticator("http://app.test")
+	registration, err := s.Client.BeginPasskeyRegistration(ctx, &pb.BeginPasskeyRegistrationRequest{AccessToken: a.access})
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	if _, err := unknown.Register(registration.GetOptions()); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	_, err = passkeyLogin(t, s, unknown)
+	assertStatus(t, err, codes.InvalidArgument, "INVALID_PASSKEY")
+}
+
+func TestPasskeySignCountRegression(t *testing.T) {
+	s := grpctest.New(t)
+	a := signUp(t, s)
+	authenticator := registerPasskey(t, s, a)
+
+	if _, err := passkeyLogin(t, s, authenticator); err != nil {
+		t.Fatalf("FinishPasskeyLogin: %v", err)
+	}
+
+	// A copy of the key signs with a counter the server has already seen
+	authenticator.SignCount = 1
+	_, err := passkeyLogin(t, s, authenticator)
+	assertStatus(t, err, codes.Unauthenticated, "PASSKEY_CLONED")
+
+	authenticator.SignCount = 10
+	if _, err := passkeyLogin(t, s, authenticator); err != nil {
+		t.Fatalf("FinishPasskeyLogin with a higher counter: %v", err)
+	}
+}
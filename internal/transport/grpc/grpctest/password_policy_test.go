@@ -0,0 +1,108 @@
+package grpctest_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/config"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/grpctest"
+	passwords "github.com/Olegnemlii/test123/pkg/password"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// withStrictPolicy requires a digit and rejects the breached passwords
+func withStrictPolicy(t *testing.T, breached ...string) grpctest.Option {
+	t.Helper()
+
+	var list strings.Builder
+	for _, p := range breached {
+		hash := sha1.Sum([]byte(p))
+		list.WriteString(hex.EncodeToString(hash[:]) + ":1\n")
+	}
+	loaded, err := passwords.ReadBreachedList(strings.NewReader(list.String()))
+	if err != nil {
+		t.Fatalf("ReadBreachedList: %v", err)
+	}
+
+	return func(cfg *config.Config) {
+		cfg.PasswordPolicy.Breached = loaded
+		cfg.PasswordPolicy.RequiredClasses = []string{passwords.ClassDigit}
+	}
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	s := grpctest.New(t, withStrictPolicy(t, "sunshine1"))
+	ctx := context.Background()
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"short1", []string{"must be at least 8 characters"}},
+		{"no digits here", []string{"must contain a digit"}},
+		{"sunshine1", []string{"appears in a list of breached passwords"}},
+		{"marguerite-2024", []string{"must not contain the email address"}},
+		{strings.Repeat("9", 73), []string{"must be at most 72 bytes"}},
+	}
+
+	for _, tt := range tests {
+		_, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: "marguerite@example.com", Password: tt.password})
+		assertViolations(t, err, "password", tt.want...)
+	}
+
+	if _, err := s.Client.Register(ctx, &pb.RegisterRequest{Email: "marguerite@example.com", Password: "sunshine12"}); err != nil {
+		t.Fatalf("Register with a valid password: %v", err)
+	}
+}
+
+func TestChangePasswordPolicy(t *testing.T) {
+	s := grpctest.New(t)
+	ctx := context.Background()
+	a := signUp(t, s)
+
+	_, err := s.Client.ChangePassword(ctx, &pb.ChangePasswordRequest{AccessToken: a.access, OldPassword: password, NewPassword: "short"})
+	assertViolations(t, err, "new_password", "must be at least 8 characters")
+
+	// The current password is checked first
+	_, err = s.Client.ChangePassword(ctx, &pb.ChangePasswordRequest{AccessToken: a.access, OldPassword: "wrong", NewPassword: "short"})
+	assertStatus(t, err, codes.Unauthenticated, "INVALID_CREDENTIALS")
+
+	if _, err := s.Client.ChangePassword(ctx, &pb.ChangePasswordRequest{AccessToken: a.access, OldPassword: password, NewPassword: "a much better passphrase"}); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+}
+
+// assertViolations checks for InvalidArgument with exactly these BadRequest
+// violations of field
+func assertViolations(t *testing.T, err error, field string, descriptions ...string) {
+	t.Helper()
+
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("code = %s (%s), want InvalidArgument", st.Code(), st.Message())
+	}
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			var got []string
+			for _, v := range br.GetFieldViolations() {
+				if v.GetField() != field {
+					t.Fatalf("violation of %q, want %q", v.GetField(), field)
+				}
+				got = append(got, v.GetDescription())
+			}
+			if !slices.Equal(got, descriptions) {
+				t.Fatalf("violations = %q, want %q", got, descriptions)
+			}
+			return
+		}
+	}
+	t.Fatalf("no BadRequest in status %v", st)
+}
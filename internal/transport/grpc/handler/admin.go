@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/service"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"github.com/google/uuid"
+)
+
+// AdminHandler implements pb.AdminServer. Authorization and the audit log
+// are handled by service.AdminService.
+type AdminHandler struct {
+	adminService *service.AdminService
+	pb.UnimplementedAdminServer
+}
+
+func NewAdminHandler(adminService *service.AdminService) *AdminHandler {
+	return &AdminHandler{adminService: adminService}
+}
+
+// Список пользователей
+func (s *AdminHandler) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	if req.GetPageSize() < 0 {
+		v.Add("page_size", "must not be negative")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	filter := domain.UserFilter{
+		EmailPrefix:   req.GetEmailPrefix(),
+		Confirmed:     req.Confirmed,
+		Deleted:       req.Deleted,
+		CreatedAfter:  fromUnix(req.GetCreatedAfter()),
+		CreatedBefore: fromUnix(req.GetCreatedBefore()),
+		Limit:         int(req.GetPageSize()),
+	}
+	users, nextPageToken, err := s.adminService.ListUsers(ctx, accessToken, filter, req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListUsersResponse{NextPageToken: nextPageToken}
+	for _, user := range users {
+		resp.Users = append(resp.Users, toPBUserDetails(user))
+	}
+
+	return resp, nil
+}
+
+// Получение пользователя
+func (s *AdminHandler) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	accessToken, userID, err := requireAdminTarget(req.GetAccessToken(), req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	user, roles, err := s.adminService.GetUser(ctx, accessToken, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetUserResponse{User: toPBUserDetails(user), Roles: roles}, nil
+}
+
+// Подтверждение почты пользователя без кода
+func (s *AdminHandler) ForceConfirmEmail(ctx context.Context, req *pb.ForceConfirmEmailRequest) (*pb.ForceConfirmEmailResponse, error) {
+	accessToken, userID, err := requireAdminTarget(req.GetAccessToken(), req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.adminService.ForceConfirmEmail(ctx, accessToken, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ForceConfirmEmailResponse{User: toPBUserDetails(user)}, nil
+}
+
+// Блокировка пользователя
+func (s *AdminHandler) DisableUser(ctx context.Context, req *pb.DisableUserRequest) (*pb.DisableUserResponse, error) {
+	accessToken, userID, err := requireAdminTarget(req.GetAccessToken(), req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.adminService.DisableUser(ctx, accessToken, userID, req.GetReason())
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.DisableUserResponse{User: toPBUserDetails(user)}, nil
+}
+
+// Разблокировка пользователя
+func (s *AdminHandler) EnableUser(ctx context.Context, req *pb.EnableUserRequest) (*pb.EnableUserResponse, error) {
+	accessToken, userID, err := requireAdminTarget(req.GetAccessToken(), req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.adminService.EnableUser(ctx, accessToken, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.EnableUserResponse{User: toPBUserDetails(user)}, nil
+}
+
+// Выход пользователя из всех сессий
+func (s *AdminHandler) ForceLogout(ctx context.Context, req *pb.ForceLogoutRequest) (*pb.ForceLogoutResponse, error) {
+	accessToken, userID, err := requireAdminTarget(req.GetAccessToken(), req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.adminService.ForceLogout(ctx, accessToken, userID); err != nil {
+		return nil, err
+	}
+
+	return &pb.ForceLogoutResponse{Success: true}, nil
+}
+
+// Повторная отправка письма с кодом подтверждения
+func (s *AdminHandler) ResendVerification(ctx context.Context, req *pb.ResendVerificationRequest) (*pb.ResendVerificationResponse, error) {
+	accessToken, userID, err := requireAdminTarget(req.GetAccessToken(), req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.adminService.ResendVerification(ctx, accessToken, userID); err != nil {
+		return nil, err
+	}
+
+	return &pb.ResendVerificationResponse{Success: true}, nil
+}
+
+// Список ролей
+func (s *AdminHandler) ListRoles(ctx context.Context, req *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	roles, err := s.adminService.ListRoles(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListRolesResponse{}
+	for _, role := range roles {
+		resp.Roles = append(resp.Roles, &pb.Role{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		})
+	}
+
+	return resp, nil
+}
+
+// Назначение роли пользователю
+func (s *AdminHandler) AssignRole(ctx context.Context, req *pb.AssignRoleRequest) (*pb.AssignRoleResponse, error) {
+	accessToken, userID, err := requireRoleTarget(req.GetAccessToken(), req.GetUserId(), req.GetRole())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.adminService.AssignRole(ctx, accessToken, userID, req.GetRole()); err != nil {
+		return nil, err
+	}
+
+	return &pb.AssignRoleResponse{Success: true}, nil
+}
+
+// Отзыв роли у пользователя
+func (s *AdminHandler) RevokeRole(ctx context.Context, req *pb.RevokeRoleRequest) (*pb.RevokeRoleResponse, error) {
+	accessToken, userID, err := requireRoleTarget(req.GetAccessToken(), req.GetUserId(), req.GetRole())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.adminService.RevokeRole(ctx, accessToken, userID, req.GetRole()); err != nil {
+		return nil, err
+	}
+
+	return &pb.RevokeRoleResponse{Success: true}, nil
+}
+
+// requireAdminTarget validates the fields shared by requests about one user
+func requireAdminTarget(token *pb.Token, userID string) (string, uuid.UUID, error) {
+	accessToken := token.GetData()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	id := parseUUID(&v, "user_id", userID)
+	if err := v.Err(); err != nil {
+		return "", uuid.Nil, err
+	}
+
+	return accessToken, id, nil
+}
+
+// requireRoleTarget validates the fields shared by requests about a role of one user
+func requireRoleTarget(token *pb.Token, userID, role string) (string, uuid.UUID, error) {
+	accessToken := token.GetData()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	id := parseUUID(&v, "user_id", userID)
+	v.Require("role", role)
+	if err := v.Err(); err != nil {
+		return "", uuid.Nil, err
+	}
+
+	return accessToken, id, nil
+}
+
+// fromUnix treats 0 as an unset timestamp
+func fromUnix(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+func toPBUserDetails(user *domain.User) *pb.UserDetails {
+	details := &pb.UserDetails{
+		Id:        user.ID.String(),
+		Email:     user.Email,
+		Confirmed: user.IsConfirmed,
+		CreatedAt: user.CreatedAt.Unix(),
+		UpdatedAt: user.UpdatedAt.Unix(),
+	}
+	if user.DeletedAt.Valid {
+		details.DeletedAt = user.DeletedAt.Time.Unix()
+	}
+	if user.DisabledAt.Valid {
+		details.DisabledAt = user.DisabledAt.Time.Unix()
+	}
+	return details
+}
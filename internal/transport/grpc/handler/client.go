@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// deviceNameHeader is the metadata key clients use to name the device they sign in from
+const deviceNameHeader = "x-device-name"
+
+// clientInfo collects the user agent, client IP and device name of the caller.
+// The IP is taken from X-Forwarded-For when the call came through a proxy,
+// otherwise from the gRPC peer address.
+func clientInfo(ctx context.Context) domain.ClientInfo {
+	var info domain.ClientInfo
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	info.UserAgent = firstValue(md, "user-agent")
+	info.DeviceName = firstValue(md, deviceNameHeader)
+
+	if forwarded := firstValue(md, "x-forwarded-for"); forwarded != "" {
+		info.ClientIP = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		info.ClientIP = host
+	}
+
+	return info
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
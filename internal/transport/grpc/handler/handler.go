@@ -2,7 +2,6 @@ package handler
 
 import (
 	"context"
-	"log"
 
 	"github.com/Olegnemlii/test123/internal/config"
 	"github.com/Olegnemlii/test123/internal/domain"
@@ -10,19 +9,21 @@ import (
 	"github.com/Olegnemlii/test123/pkg/pb"
 
 	"github.com/google/uuid"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
+// AuthHandler implements pb.AuthServer. Errors are returned as domain errors
+// and converted to gRPC statuses by interceptor.Errors.
 type AuthHandler struct {
-	authService service.UserService
+	authService *service.UserService
+	mailService *service.MailService
 	cfg         config.Config
 	pb.UnimplementedAuthServer
 }
 
-func NewAuthHandler(authService service.UserService, cfg config.Config) *AuthHandler {
+func NewAuthHandler(authService *service.UserService, mailService *service.MailService, cfg config.Config) *AuthHandler {
 	return &AuthHandler{
 		authService: authService,
+		mailService: mailService,
 		cfg:         cfg,
 	}
 }
@@ -32,134 +33,413 @@ func (s *AuthHandler) Register(ctx context.Context, req *pb.RegisterRequest) (*p
 	email := req.GetEmail()
 	password := req.GetPassword()
 
-	if email == "" || password == "" {
-		return nil, status.Errorf(codes.InvalidArgument, "email and password are required")
+	var v domain.ValidationError
+	v.Require("email", email)
+	v.Require("password", password)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
 
-	user := &domain.User{
-		Email:       email,
-		Password:    password,
-		IsConfirmed: false,
+	if invitationToken := req.GetInvitationToken(); invitationToken != "" {
+		user, tokens, err := s.authService.RegisterWithInvitation(ctx, email, password, invitationToken, clientInfo(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		return &pb.RegisterResponse{
+			AccessToken:  toPBToken(tokens.AccessToken),
+			RefreshToken: toPBToken(tokens.RefreshToken),
+			User:         toPBUser(user),
+		}, nil
 	}
 
-	createdUser, err := s.authService.CreateUser(ctx, user)
+	signature, err := s.authService.Register(ctx, email, password)
 	if err != nil {
-		log.Printf("error creating user: %v", err)
-		return nil, status.Errorf(codes.Internal, "failed to create user")
+		return nil, err
 	}
 
-	// Generate verification code and send it (omitted for brevity)
-	code, err := s.authService.GenerateVerificationCode(ctx, email)
+	return &pb.RegisterResponse{Signature: signature.String()}, nil
+}
+
+// Подтверждение почты по подписи из Register
+func (s *AuthHandler) VerifyCode(ctx context.Context, req *pb.VerifyCodeRequest) (*pb.VerifyCodeResponse, error) {
+	code := req.GetCode()
+
+	var v domain.ValidationError
+	v.Require("code", code)
+	signature := parseUUID(&v, "signature", req.GetSignature())
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	user, err := s.authService.VerifyCode(ctx, signature, code)
 	if err != nil {
-		log.Printf("error generating verification code: %v", err)
-		return nil, status.Errorf(codes.Internal, "failed to generate verification code")
+		return nil, err
 	}
 
-	log.Printf("Verification code for %s: %s", email, code)
+	tokens, challenge, err := s.signIn(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &pb.VerifyCodeResponse{MfaChallenge: toPBToken(*challenge)}, nil
+	}
 
-	//TODO: create MailopostService and call it here for sending email
-	//mailopostService := service.NewMailopostService(s.cfg.MailopostApiKey, s.cfg.MailopostURL)
-	//err = mailopostService.SendVerificationEmail(email, code)
-	//if err != nil {
-	//	log.Printf("error sending verification email: %v", err)
-	//	return nil, status.Errorf(codes.Internal, "failed to send verification email")
-	//}
+	return &pb.VerifyCodeResponse{
+		AccessToken:  toPBToken(tokens.AccessToken),
+		RefreshToken: toPBToken(tokens.RefreshToken),
+		User:         toPBUser(user),
+	}, nil
+}
 
-	signature, err := s.authService.CreateSignature(ctx)
+// Авторизация пользователя
+func (s *AuthHandler) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	email := req.GetEmail()
+	password := req.GetPassword()
 
+	var v domain.ValidationError
+	v.Require("email", email)
+	v.Require("password", password)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	// Unknown email and wrong password are indistinguishable to the client
+	user, err := s.authService.LoginWithPassword(ctx, email, password)
 	if err != nil {
-		log.Printf("error creating signature: %v", err)
-		return nil, status.Errorf(codes.Internal, "failed to create signature")
+		return nil, err
 	}
 
-	err = s.authService.UpdateUserSignature(ctx, createdUser.ID, signature)
+	tokens, challenge, err := s.signIn(ctx, user)
 	if err != nil {
-		log.Printf("error updating user signature: %v", err)
-		return nil, status.Errorf(codes.Internal, "failed to update user signature")
+		return nil, err
+	}
+	if challenge != nil {
+		return &pb.LoginResponse{MfaChallenge: toPBToken(*challenge)}, nil
 	}
 
-	return &pb.RegisterResponse{Signature: signature.String()}, nil
+	return &pb.LoginResponse{
+		AccessToken:  toPBToken(tokens.AccessToken),
+		RefreshToken: toPBToken(tokens.RefreshToken),
+		User:         toPBUser(user),
+	}, nil
 }
 
-// Подтверждение почты
-func (s *AuthHandler) ConfirmEmail(ctx context.Context, req *pb.ConfirmEmailRequest) (*pb.ConfirmEmailResponse, error) {
-	email := req.GetEmail()
-	code := req.GetCode()
+// signIn finishes a login by the first factor. Users with TOTP enabled get
+// only a challenge and finish the login with VerifyMFA.
+func (s *AuthHandler) signIn(ctx context.Context, user *domain.User) (*domain.TokenPair, *domain.IssuedToken, error) {
+	challenge, err := s.authService.MFAChallenge(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	if challenge != nil {
+		return nil, challenge, nil
+	}
+
+	tokens, err := s.authService.IssueTokens(ctx, user, clientInfo(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, nil, nil
+}
 
-	if email == "" || code == "" {
-		return nil, status.Errorf(codes.InvalidArgument, "email and code are required")
+// Обновление токенов
+func (s *AuthHandler) RefreshTokens(ctx context.Context, req *pb.RefreshTokensRequest) (*pb.RefreshTokensResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	refreshToken := req.GetRefreshToken().GetData()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("refresh_token", refreshToken)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
 
-	isValid, err := s.authService.VerifyCode(ctx, email, code)
+	user, tokens, err := s.authService.RefreshTokens(ctx, accessToken, refreshToken)
 	if err != nil {
-		log.Printf("error verifying code: %v", err)
-		return nil, status.Errorf(codes.Internal, "failed to verify code")
+		return nil, err
+	}
+
+	return &pb.RefreshTokensResponse{
+		AccessToken:  toPBToken(tokens.AccessToken),
+		RefreshToken: toPBToken(tokens.RefreshToken),
+		User:         toPBUser(user),
+	}, nil
+}
+
+// Получение текущего пользователя
+func (s *AuthHandler) GetMe(ctx context.Context, req *pb.GetMeRequest) (*pb.GetMeResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	if err := requireAccessToken(accessToken); err != nil {
+		return nil, err
 	}
 
-	if !isValid {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid code")
+	user, err := s.authService.GetMe(ctx, accessToken)
+	if err != nil {
+		return nil, err
 	}
 
-	return &pb.ConfirmEmailResponse{Success: true}, nil
+	return &pb.GetMeResponse{User: toPBUser(user)}, nil
 }
 
-// Авторизация пользователя
-func (s *AuthHandler) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+// Выход из текущей сессии
+func (s *AuthHandler) LogOut(ctx context.Context, req *pb.LogOutRequest) (*pb.LogOutResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	if err := requireAccessToken(accessToken); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.LogOut(ctx, accessToken); err != nil {
+		return nil, err
+	}
+
+	return &pb.LogOutResponse{Success: true}, nil
+}
+
+// Список активных сессий пользователя
+func (s *AuthHandler) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	if err := requireAccessToken(accessToken); err != nil {
+		return nil, err
+	}
+
+	sessions, currentID, err := s.authService.ListSessions(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListSessionsResponse{}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &pb.Session{
+			Id:         session.ID.String(),
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastUsedAt: session.LastUsedAt.Unix(),
+			UserAgent:  session.UserAgent,
+			ClientIp:   session.ClientIP,
+			DeviceName: session.DeviceName,
+			Current:    session.ID == currentID,
+		})
+	}
+
+	return resp, nil
+}
+
+// Отзыв сессии
+func (s *AuthHandler) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	sessionID := parseUUID(&v, "session_id", req.GetSessionId())
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.RevokeSession(ctx, accessToken, sessionID); err != nil {
+		return nil, err
+	}
+
+	return &pb.RevokeSessionResponse{Success: true}, nil
+}
+
+// Отзыв всех сессий, кроме текущей
+func (s *AuthHandler) RevokeAllOtherSessions(ctx context.Context, req *pb.RevokeAllOtherSessionsRequest) (*pb.RevokeAllOtherSessionsResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	if err := requireAccessToken(accessToken); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.RevokeAllOtherSessions(ctx, accessToken); err != nil {
+		return nil, err
+	}
+
+	return &pb.RevokeAllOtherSessionsResponse{Success: true}, nil
+}
+
+// Запрос письма для сброса пароля
+func (s *AuthHandler) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
 	email := req.GetEmail()
+
+	var v domain.ValidationError
+	v.Require("email", email)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.RequestPasswordReset(ctx, email); err != nil {
+		return nil, err
+	}
+
+	return &pb.RequestPasswordResetResponse{Success: true}, nil
+}
+
+// Сброс пароля по токену из письма
+func (s *AuthHandler) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
+	resetToken := req.GetToken()
+	newPassword := req.GetNewPassword()
+
+	var v domain.ValidationError
+	v.Require("token", resetToken)
+	v.Require("new_password", newPassword)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.ResetPassword(ctx, resetToken, newPassword); err != nil {
+		return nil, err
+	}
+
+	return &pb.ResetPasswordResponse{Success: true}, nil
+}
+
+// Смена пароля
+func (s *AuthHandler) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	oldPassword := req.GetOldPassword()
+	newPassword := req.GetNewPassword()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("old_password", oldPassword)
+	v.Require("new_password", newPassword)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.ChangePassword(ctx, accessToken, oldPassword, newPassword); err != nil {
+		return nil, err
+	}
+
+	return &pb.ChangePasswordResponse{Success: true}, nil
+}
+
+// Запрос на смену почты
+func (s *AuthHandler) ChangeEmail(ctx context.Context, req *pb.ChangeEmailRequest) (*pb.ChangeEmailResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	newEmail := req.GetNewEmail()
 	password := req.GetPassword()
 
-	if email == "" || password == "" {
-		return nil, status.Errorf(codes.InvalidArgument, "email and password are required")
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("new_email", newEmail)
+	v.Require("password", password)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
 
-	user, err := s.authService.GetUserByEmail(ctx, email)
-	if err != nil {
-		log.Printf("error getting user: %v", err)
-		return nil, status.Errorf(codes.NotFound, "user not found")
+	if err := s.authService.ChangeEmail(ctx, accessToken, newEmail, password); err != nil {
+		return nil, err
 	}
 
-	//TODO:: реализовать сравнение паролей
-	if user.Password != password {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid credentials")
+	return &pb.ChangeEmailResponse{Success: true}, nil
+}
+
+// Подтверждение смены почты
+func (s *AuthHandler) ConfirmEmailChange(ctx context.Context, req *pb.ConfirmEmailChangeRequest) (*pb.ConfirmEmailChangeResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	code := req.GetCode()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("code", code)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
 
-	// Store refresh token
-	refreshToken := uuid.New().String()
-	err = s.authService.StoreRefreshToken(ctx, email, refreshToken)
+	user, err := s.authService.ConfirmEmailChange(ctx, accessToken, code)
 	if err != nil {
-		log.Printf("error storing refresh token: %v", err)
-		return nil, status.Errorf(codes.Internal, "failed to store refresh token")
+		return nil, err
 	}
 
-	return &pb.LoginResponse{
-		AccessToken:  "accessToken",
-		RefreshToken: refreshToken,
-	}, nil
+	return &pb.ConfirmEmailChangeResponse{User: toPBUser(user)}, nil
 }
 
-// Обновление токена
-func (s *AuthHandler) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+// Удаление аккаунта с подтверждением паролем
+func (s *AuthHandler) DeleteAccount(ctx context.Context, req *pb.DeleteAccountRequest) (*pb.DeleteAccountResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	password := req.GetPassword()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("password", password)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.DeleteAccount(ctx, accessToken, password); err != nil {
+		return nil, err
+	}
+
+	return &pb.DeleteAccountResponse{Success: true}, nil
+}
+
+// Восстановление удалённого аккаунта
+func (s *AuthHandler) RestoreAccount(ctx context.Context, req *pb.RestoreAccountRequest) (*pb.RestoreAccountResponse, error) {
 	email := req.GetEmail()
-	refreshToken := req.GetRefreshToken()
+	password := req.GetPassword()
 
-	if email == "" || refreshToken == "" {
-		return nil, status.Errorf(codes.InvalidArgument, "email and refresh token are required")
+	var v domain.ValidationError
+	v.Require("email", email)
+	v.Require("password", password)
+	if err := v.Err(); err != nil {
+		return nil, err
 	}
 
-	storedRefreshToken, err := s.authService.GetRefreshToken(ctx, email)
+	user, err := s.authService.RestoreAccount(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
 
+	tokens, challenge, err := s.signIn(ctx, user)
 	if err != nil {
-		log.Printf("error while get refresh token %v", err)
-		return nil, status.Errorf(codes.Internal, "error while get refresh token")
+		return nil, err
 	}
+	if challenge != nil {
+		return &pb.RestoreAccountResponse{MfaChallenge: toPBToken(*challenge)}, nil
+	}
+
+	return &pb.RestoreAccountResponse{
+		AccessToken:  toPBToken(tokens.AccessToken),
+		RefreshToken: toPBToken(tokens.RefreshToken),
+		User:         toPBUser(user),
+	}, nil
+}
+
+func requireAccessToken(accessToken string) error {
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	return v.Err()
+}
 
-	if storedRefreshToken != refreshToken {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid refresh token")
+// parseUUID parses a required UUID field, recording a violation on failure
+func parseUUID(v *domain.ValidationError, field, value string) uuid.UUID {
+	if value == "" {
+		v.Require(field, value)
+		return uuid.Nil
 	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		v.Add(field, "must be a UUID")
+		return uuid.Nil
+	}
+	return id
+}
 
-	newAccessToken := uuid.New().String()
+func toPBToken(t domain.IssuedToken) *pb.Token {
+	return &pb.Token{
+		Data:      t.Data,
+		ExpiresAt: t.ExpiresAt.Unix(),
+	}
+}
 
-	return &pb.RefreshTokenResponse{
-		AccessToken: newAccessToken,
-	}, nil
+// toPBUser never copies the password hash into the response
+func toPBUser(user *domain.User) *pb.User {
+	return &pb.User{
+		Id:    user.ID.String(),
+		Email: user.Email,
+	}
 }
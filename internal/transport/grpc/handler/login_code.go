@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/pb"
+)
+
+// Запрос кода для входа без пароля
+func (s *AuthHandler) RequestLoginCode(ctx context.Context, req *pb.RequestLoginCodeRequest) (*pb.RequestLoginCodeResponse, error) {
+	email := req.GetEmail()
+
+	var v domain.ValidationError
+	v.Require("email", email)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.RequestLoginCode(ctx, email); err != nil {
+		return nil, err
+	}
+
+	return &pb.RequestLoginCodeResponse{Success: true}, nil
+}
+
+// Вход по коду из письма
+func (s *AuthHandler) LoginWithCode(ctx context.Context, req *pb.LoginWithCodeRequest) (*pb.LoginWithCodeResponse, error) {
+	email := req.GetEmail()
+	code := req.GetCode()
+
+	var v domain.ValidationError
+	v.Require("email", email)
+	v.Require("code", code)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	user, err := s.authService.LoginWithCode(ctx, email, code)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, challenge, err := s.signIn(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &pb.LoginWithCodeResponse{MfaChallenge: toPBToken(*challenge)}, nil
+	}
+
+	return &pb.LoginWithCodeResponse{
+		AccessToken:  toPBToken(tokens.AccessToken),
+		RefreshToken: toPBToken(tokens.RefreshToken),
+		User:         toPBUser(user),
+	}, nil
+}
+
+// Вход по ссылке из письма
+func (s *AuthHandler) LoginWithLink(ctx context.Context, req *pb.LoginWithLinkRequest) (*pb.LoginWithLinkResponse, error) {
+	token := req.GetToken()
+
+	var v domain.ValidationError
+	v.Require("token", token)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	user, err := s.authService.LoginWithLink(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, challenge, err := s.signIn(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &pb.LoginWithLinkResponse{MfaChallenge: toPBToken(*challenge)}, nil
+	}
+
+	return &pb.LoginWithLinkResponse{
+		AccessToken:  toPBToken(tokens.AccessToken),
+		RefreshToken: toPBToken(tokens.RefreshToken),
+		User:         toPBUser(user),
+	}, nil
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/pb"
+)
+
+// Подключение TOTP
+func (s *AuthHandler) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	if err := requireAccessToken(accessToken); err != nil {
+		return nil, err
+	}
+
+	secret, uri, err := s.authService.EnrollTOTP(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.EnrollTOTPResponse{Secret: secret, Uri: uri}, nil
+}
+
+// Подтверждение TOTP первым кодом
+func (s *AuthHandler) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	code := req.GetCode()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("code", code)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := s.authService.ConfirmTOTP(ctx, accessToken, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ConfirmTOTPResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// Отключение TOTP
+func (s *AuthHandler) DisableTOTP(ctx context.Context, req *pb.DisableTOTPRequest) (*pb.DisableTOTPResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	password := req.GetPassword()
+	code := req.GetCode()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("password", password)
+	v.Require("code", code)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.DisableTOTP(ctx, accessToken, password, code); err != nil {
+		return nil, err
+	}
+
+	return &pb.DisableTOTPResponse{Success: true}, nil
+}
+
+// Второй шаг входа
+func (s *AuthHandler) VerifyMFA(ctx context.Context, req *pb.VerifyMFARequest) (*pb.VerifyMFAResponse, error) {
+	challenge := req.GetMfaChallenge().GetData()
+	code := req.GetCode()
+
+	var v domain.ValidationError
+	v.Require("mfa_challenge", challenge)
+	v.Require("code", code)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	user, tokens, err := s.authService.VerifyMFA(ctx, challenge, code, clientInfo(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.VerifyMFAResponse{
+		AccessToken:  toPBToken(tokens.AccessToken),
+		RefreshToken: toPBToken(tokens.RefreshToken),
+		User:         toPBUser(user),
+	}, nil
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/service"
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"github.com/google/uuid"
+)
+
+// OrganizationHandler implements pb.OrganizationsServer
+type OrganizationHandler struct {
+	authService *service.UserService
+	pb.UnimplementedOrganizationsServer
+}
+
+func NewOrganizationHandler(authService *service.UserService) *OrganizationHandler {
+	return &OrganizationHandler{authService: authService}
+}
+
+// Создание организации
+func (s *OrganizationHandler) CreateOrganization(ctx context.Context, req *pb.CreateOrganizationRequest) (*pb.CreateOrganizationResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	name := req.GetName()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("name", name)
+	if len(name) > 255 {
+		v.Add("name", "must be at most 255 bytes")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	org, err := s.authService.CreateOrganization(ctx, accessToken, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CreateOrganizationResponse{Organization: toPBOrganization(org)}, nil
+}
+
+// Приглашение участника по почте
+func (s *OrganizationHandler) InviteMember(ctx context.Context, req *pb.InviteMemberRequest) (*pb.InviteMemberResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	email := req.GetEmail()
+	role := req.GetRole()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	orgID := parseUUID(&v, "organization_id", req.GetOrganizationId())
+	v.Require("email", email)
+	if !domain.ValidOrgRole(role) {
+		v.Add("role", "must be owner, admin or member")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.InviteMember(ctx, accessToken, orgID, email, role); err != nil {
+		return nil, err
+	}
+
+	return &pb.InviteMemberResponse{Success: true}, nil
+}
+
+// Принятие приглашения
+func (s *OrganizationHandler) AcceptInvitation(ctx context.Context, req *pb.AcceptInvitationRequest) (*pb.AcceptInvitationResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	invitationToken := req.GetInvitationToken()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("invitation_token", invitationToken)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	org, err := s.authService.AcceptInvitation(ctx, accessToken, invitationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AcceptInvitationResponse{Organization: toPBOrganization(org)}, nil
+}
+
+// Удаление участника из организации
+func (s *OrganizationHandler) RemoveMember(ctx context.Context, req *pb.RemoveMemberRequest) (*pb.RemoveMemberResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	orgID := parseUUID(&v, "organization_id", req.GetOrganizationId())
+	userID := parseUUID(&v, "user_id", req.GetUserId())
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.RemoveMember(ctx, accessToken, orgID, userID); err != nil {
+		return nil, err
+	}
+
+	return &pb.RemoveMemberResponse{Success: true}, nil
+}
+
+// Смена организации текущей сессии
+func (s *OrganizationHandler) SwitchOrganization(ctx context.Context, req *pb.SwitchOrganizationRequest) (*pb.SwitchOrganizationResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	var orgID uuid.NullUUID
+	if req.GetOrganizationId() != "" {
+		orgID.UUID = parseUUID(&v, "organization_id", req.GetOrganizationId())
+		orgID.Valid = true
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	issued, err := s.authService.SwitchOrganization(ctx, accessToken, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SwitchOrganizationResponse{AccessToken: toPBToken(issued)}, nil
+}
+
+func toPBOrganization(org *domain.Organization) *pb.Organization {
+	return &pb.Organization{
+		Id:        org.ID.String(),
+		Name:      org.Name,
+		CreatedAt: org.CreatedAt.Unix(),
+	}
+}
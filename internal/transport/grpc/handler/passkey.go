@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/pb"
+)
+
+// Начало регистрации ключа доступа
+func (s *AuthHandler) BeginPasskeyRegistration(ctx context.Context, req *pb.BeginPasskeyRegistrationRequest) (*pb.BeginPasskeyRegistrationResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	if err := requireAccessToken(accessToken); err != nil {
+		return nil, err
+	}
+
+	options, err := s.authService.BeginPasskeyRegistration(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.BeginPasskeyRegistrationResponse{Options: string(options)}, nil
+}
+
+// Завершение регистрации ключа доступа
+func (s *AuthHandler) FinishPasskeyRegistration(ctx context.Context, req *pb.FinishPasskeyRegistrationRequest) (*pb.FinishPasskeyRegistrationResponse, error) {
+	accessToken := req.GetAccessToken().GetData()
+	credential := req.GetCredential()
+
+	var v domain.ValidationError
+	v.Require("access_token", accessToken)
+	v.Require("credential", credential)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	passkey, err := s.authService.FinishPasskeyRegistration(ctx, accessToken, []byte(credential))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.FinishPasskeyRegistrationResponse{Passkey: toPBPasskey(passkey)}, nil
+}
+
+// Начало входа по ключу доступа
+func (s *AuthHandler) BeginPasskeyLogin(ctx context.Context, req *pb.BeginPasskeyLoginRequest) (*pb.BeginPasskeyLoginResponse, error) {
+	options, err := s.authService.BeginPasskeyLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.BeginPasskeyLoginResponse{Options: string(options)}, nil
+}
+
+// Завершение входа по ключу доступа
+func (s *AuthHandler) FinishPasskeyLogin(ctx context.Context, req *pb.FinishPasskeyLoginRequest) (*pb.FinishPasskeyLoginResponse, error) {
+	credential := req.GetCredential()
+
+	var v domain.ValidationError
+	v.Require("credential", credential)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	user, tokens, err := s.authService.FinishPasskeyLogin(ctx, []byte(credential), clientInfo(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.FinishPasskeyLoginResponse{
+		AccessToken:  toPBToken(tokens.AccessToken),
+		RefreshToken: toPBToken(tokens.RefreshToken),
+		User:         toPBUser(user),
+	}, nil
+}
+
+func toPBPasskey(c *domain.PasskeyCredential) *pb.Passkey {
+	return &pb.Passkey{
+		Id:         base64.RawURLEncoding.EncodeToString(c.ID),
+		Transports: c.Transports,
+		CreatedAt:  c.CreatedAt.Unix(),
+	}
+}
@@ -0,0 +1,54 @@
+package interceptor
+
+import (
+	"context"
+	"log"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/pb"
+	"github.com/Olegnemlii/test123/pkg/token"
+
+	"google.golang.org/grpc"
+)
+
+// Public marks methods of the permission table that need no permission. They
+// may still authenticate the caller themselves.
+const Public = ""
+
+// Authorize checks every call against permissions, which maps full method
+// names to the permission the access token in the request must carry.
+// Methods missing from the table are rejected, so a new RPC cannot be exposed
+// by accident. The token is only verified offline; revocation is left to the
+// services. Chain it after Errors, so its errors become statuses.
+func Authorize(verifier *token.Verifier, permissions map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		permission, ok := permissions[info.FullMethod]
+		if !ok {
+			log.Printf("%s: no permission declared, rejecting the call", info.FullMethod)
+			return nil, domain.ErrPermissionDenied
+		}
+		if permission == Public {
+			return handler(ctx, req)
+		}
+
+		var accessToken string
+		if r, ok := req.(interface{ GetAccessToken() *pb.Token }); ok {
+			accessToken = r.GetAccessToken().GetData()
+		}
+		var v domain.ValidationError
+		v.Require("access_token", accessToken)
+		if err := v.Err(); err != nil {
+			return nil, err
+		}
+
+		claims, err := verifier.Verify(accessToken)
+		if err != nil {
+			return nil, err
+		}
+		if !claims.HasScope(permission) {
+			return nil, domain.ErrPermissionDenied
+		}
+
+		return handler(ctx, req)
+	}
+}
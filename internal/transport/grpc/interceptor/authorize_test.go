@@ -0,0 +1,79 @@
+package interceptor
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/pb"
+	"github.com/Olegnemlii/test123/pkg/token"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+)
+
+func TestAuthorize(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	sign := func(scope string) *pb.Token {
+		now := time.Now()
+		claims := token.Claims{
+			Scope: scope,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "auth",
+				Audience:  jwt.ClaimStrings{"api"},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			},
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		return &pb.Token{Data: signed}
+	}
+
+	authorize := Authorize(token.NewVerifier(pub, "auth", "api"), map[string]string{
+		"/test/Public": Public,
+		"/test/Read":   domain.PermUsersRead,
+	})
+	ok := func(context.Context, any) (any, error) { return "ok", nil }
+
+	tests := []struct {
+		name    string
+		method  string
+		req     any
+		wantErr error
+	}{
+		{"public", "/test/Public", &pb.RegisterRequest{}, nil},
+		{"granted", "/test/Read", &pb.ListUsersRequest{AccessToken: sign("users.write users.read")}, nil},
+		{"not granted", "/test/Read", &pb.ListUsersRequest{AccessToken: sign("users.write")}, domain.ErrPermissionDenied},
+		{"bad token", "/test/Read", &pb.ListUsersRequest{AccessToken: &pb.Token{Data: "garbage"}}, token.ErrInvalidToken},
+		{"undeclared method", "/test/Write", &pb.ListUsersRequest{AccessToken: sign("users.write")}, domain.ErrPermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := authorize(context.Background(), tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, ok)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && resp != "ok" {
+				t.Fatalf("handler was not called")
+			}
+		})
+	}
+
+	// A request without a token is a validation error
+	_, err = authorize(context.Background(), &pb.RegisterRequest{}, &grpc.UnaryServerInfo{FullMethod: "/test/Read"}, ok)
+	var validation *domain.ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("err = %v, want a ValidationError", err)
+	}
+}
@@ -0,0 +1,118 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/token"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// errorDomain is reported in ErrorInfo.Domain
+const errorDomain = "auth"
+
+// errorMappings is checked in order, so more specific errors go first
+var errorMappings = []struct {
+	err    error
+	code   codes.Code
+	reason string
+}{
+	{domain.ErrUserNotFound, codes.NotFound, "USER_NOT_FOUND"},
+	{domain.ErrSessionNotFound, codes.NotFound, "SESSION_NOT_FOUND"},
+	{domain.ErrRoleNotFound, codes.NotFound, "ROLE_NOT_FOUND"},
+	{domain.ErrOrganizationNotFound, codes.NotFound, "ORGANIZATION_NOT_FOUND"},
+	{domain.ErrNotFound, codes.NotFound, "NOT_FOUND"},
+	{domain.ErrEmailTaken, codes.AlreadyExists, "EMAIL_TAKEN"},
+	{domain.ErrAlreadyMember, codes.AlreadyExists, "ALREADY_MEMBER"},
+	{domain.ErrPasskeyExists, codes.AlreadyExists, "PASSKEY_EXISTS"},
+	{domain.ErrInvalidCode, codes.InvalidArgument, "INVALID_CODE"},
+	{domain.ErrCodeExpired, codes.InvalidArgument, "CODE_EXPIRED"},
+	{domain.ErrInvalidResetToken, codes.InvalidArgument, "INVALID_RESET_TOKEN"},
+	{domain.ErrInvalidInvitation, codes.InvalidArgument, "INVALID_INVITATION"},
+	{domain.ErrInvalidMFACode, codes.InvalidArgument, "INVALID_MFA_CODE"},
+	{domain.ErrInvalidPasskey, codes.InvalidArgument, "INVALID_PASSKEY"},
+	{domain.ErrInvalidCredentials, codes.Unauthenticated, "INVALID_CREDENTIALS"},
+	{domain.ErrInvalidRefreshToken, codes.Unauthenticated, "INVALID_REFRESH_TOKEN"},
+	{domain.ErrRefreshTokenReused, codes.Unauthenticated, "REFRESH_TOKEN_REUSED"},
+	{domain.ErrTokenRevoked, codes.Unauthenticated, "TOKEN_REVOKED"},
+	{domain.ErrInvalidMFAChallenge, codes.Unauthenticated, "INVALID_MFA_CHALLENGE"},
+	{domain.ErrPasskeyCloned, codes.Unauthenticated, "PASSKEY_CLONED"},
+	{domain.ErrRestoreExpired, codes.FailedPrecondition, "RESTORE_EXPIRED"},
+	{domain.ErrAlreadyConfirmed, codes.FailedPrecondition, "ALREADY_CONFIRMED"},
+	{domain.ErrLastOwner, codes.FailedPrecondition, "LAST_OWNER"},
+	{domain.ErrTOTPAlreadyEnabled, codes.FailedPrecondition, "TOTP_ALREADY_ENABLED"},
+	{domain.ErrTOTPNotEnabled, codes.FailedPrecondition, "TOTP_NOT_ENABLED"},
+	{domain.ErrUserDisabled, codes.PermissionDenied, "USER_DISABLED"},
+	{domain.ErrPermissionDenied, codes.PermissionDenied, "PERMISSION_DENIED"},
+	{token.ErrInvalidToken, codes.Unauthenticated, "INVALID_TOKEN"},
+}
+
+// Errors converts errors returned by handlers into gRPC statuses. Handlers may
+// return domain errors directly; anything unknown becomes Internal and is
+// logged instead of being sent to the client.
+func Errors() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		st := ToStatus(err)
+		if st.Code() == codes.Internal {
+			log.Printf("%s: %v", info.FullMethod, err)
+		}
+		return resp, st.Err()
+	}
+}
+
+// ToStatus maps err to a gRPC status with ErrorInfo or BadRequest details
+func ToStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+
+	var validation *domain.ValidationError
+	if errors.As(err, &validation) {
+		br := &errdetails.BadRequest{}
+		for _, v := range validation.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		return withDetails(status.New(codes.InvalidArgument, validation.Error()), br)
+	}
+
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return withDetails(status.New(m.code, m.err.Error()), &errdetails.ErrorInfo{
+				Reason: m.reason,
+				Domain: errorDomain,
+			})
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.New(codes.Canceled, "request canceled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.New(codes.DeadlineExceeded, "deadline exceeded")
+	}
+
+	return status.New(codes.Internal, "internal error")
+}
+
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) *status.Status {
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
@@ -0,0 +1,98 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/pkg/token"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{"user not found", domain.ErrUserNotFound, codes.NotFound, "USER_NOT_FOUND"},
+		{"wrapped", fmt.Errorf("get user: %w", domain.ErrUserNotFound), codes.NotFound, "USER_NOT_FOUND"},
+		{"role not found", domain.ErrRoleNotFound, codes.NotFound, "ROLE_NOT_FOUND"},
+		{"organization not found", domain.ErrOrganizationNotFound, codes.NotFound, "ORGANIZATION_NOT_FOUND"},
+		{"email taken", domain.ErrEmailTaken, codes.AlreadyExists, "EMAIL_TAKEN"},
+		{"already member", domain.ErrAlreadyMember, codes.AlreadyExists, "ALREADY_MEMBER"},
+		{"invalid invitation", domain.ErrInvalidInvitation, codes.InvalidArgument, "INVALID_INVITATION"},
+		{"last owner", domain.ErrLastOwner, codes.FailedPrecondition, "LAST_OWNER"},
+		{"invalid mfa code", domain.ErrInvalidMFACode, codes.InvalidArgument, "INVALID_MFA_CODE"},
+		{"invalid mfa challenge", domain.ErrInvalidMFAChallenge, codes.Unauthenticated, "INVALID_MFA_CHALLENGE"},
+		{"totp already enabled", domain.ErrTOTPAlreadyEnabled, codes.FailedPrecondition, "TOTP_ALREADY_ENABLED"},
+		{"invalid passkey", domain.ErrInvalidPasskey, codes.InvalidArgument, "INVALID_PASSKEY"},
+		{"passkey exists", domain.ErrPasskeyExists, codes.AlreadyExists, "PASSKEY_EXISTS"},
+		{"passkey cloned", domain.ErrPasskeyCloned, codes.Unauthenticated, "PASSKEY_CLONED"},
+		{"invalid code", domain.ErrInvalidCode, codes.InvalidArgument, "INVALID_CODE"},
+		{"code expired", domain.ErrCodeExpired, codes.InvalidArgument, "CODE_EXPIRED"},
+		{"invalid credentials", domain.ErrInvalidCredentials, codes.Unauthenticated, "INVALID_CREDENTIALS"},
+		{"revoked", domain.ErrTokenRevoked, codes.Unauthenticated, "TOKEN_REVOKED"},
+		{"permission denied", domain.ErrPermissionDenied, codes.PermissionDenied, "PERMISSION_DENIED"},
+		{"user disabled", domain.ErrUserDisabled, codes.PermissionDenied, "USER_DISABLED"},
+		{"invalid access token", fmt.Errorf("%w: expired", token.ErrInvalidToken), codes.Unauthenticated, "INVALID_TOKEN"},
+		{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded, ""},
+		{"status passthrough", status.Error(codes.PermissionDenied, "nope"), codes.PermissionDenied, ""},
+		{"unknown", errors.New("pq: connection refused"), codes.Internal, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := ToStatus(tt.err)
+			if st.Code() != tt.code {
+				t.Fatalf("code = %s, want %s", st.Code(), tt.code)
+			}
+
+			var reason string
+			for _, d := range st.Details() {
+				if info, ok := d.(*errdetails.ErrorInfo); ok {
+					reason = info.Reason
+				}
+			}
+			if reason != tt.reason {
+				t.Errorf("reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestToStatusHidesInternalErrors(t *testing.T) {
+	st := ToStatus(errors.New("pq: password authentication failed for user \"auth\""))
+	if st.Message() != "internal error" {
+		t.Errorf("message = %q, internal details leaked", st.Message())
+	}
+}
+
+func TestToStatusValidation(t *testing.T) {
+	var v domain.ValidationError
+	v.Require("email", "")
+	v.Add("signature", "must be a UUID")
+
+	st := ToStatus(v.Err())
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("code = %s, want InvalidArgument", st.Code())
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("got %d details, want 1", len(st.Details()))
+	}
+
+	br, ok := st.Details()[0].(*errdetails.BadRequest)
+	if !ok {
+		t.Fatalf("detail is %T, want BadRequest", st.Details()[0])
+	}
+	got := br.GetFieldViolations()
+	if len(got) != 2 || got[0].GetField() != "email" || got[1].GetField() != "signature" {
+		t.Errorf("unexpected field violations: %v", got)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/Olegnemlii/test123/internal/domain"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/interceptor"
+	"github.com/Olegnemlii/test123/pkg/pb"
+)
+
+// permissions declares what every RPC needs. Calls to methods that are not
+// listed here are rejected, so new RPCs must be added.
+var permissions = map[string]string{
+	pb.Auth_Register_FullMethodName:                  interceptor.Public,
+	pb.Auth_Login_FullMethodName:                     interceptor.Public,
+	pb.Auth_VerifyCode_FullMethodName:                interceptor.Public,
+	pb.Auth_RefreshTokens_FullMethodName:             interceptor.Public,
+	pb.Auth_LogOut_FullMethodName:                    interceptor.Public,
+	pb.Auth_GetMe_FullMethodName:                     interceptor.Public,
+	pb.Auth_ListSessions_FullMethodName:              interceptor.Public,
+	pb.Auth_RevokeSession_FullMethodName:             interceptor.Public,
+	pb.Auth_RevokeAllOtherSessions_FullMethodName:    interceptor.Public,
+	pb.Auth_RequestPasswordReset_FullMethodName:      interceptor.Public,
+	pb.Auth_ResetPassword_FullMethodName:             interceptor.Public,
+	pb.Auth_ChangePassword_FullMethodName:            interceptor.Public,
+	pb.Auth_ChangeEmail_FullMethodName:               interceptor.Public,
+	pb.Auth_ConfirmEmailChange_FullMethodName:        interceptor.Public,
+	pb.Auth_DeleteAccount_FullMethodName:             interceptor.Public,
+	pb.Auth_RestoreAccount_FullMethodName:            interceptor.Public,
+	pb.Auth_EnrollTOTP_FullMethodName:                interceptor.Public,
+	pb.Auth_ConfirmTOTP_FullMethodName:               interceptor.Public,
+	pb.Auth_DisableTOTP_FullMethodName:               interceptor.Public,
+	pb.Auth_VerifyMFA_FullMethodName:                 interceptor.Public,
+	pb.Auth_BeginPasskeyRegistration_FullMethodName:  interceptor.Public,
+	pb.Auth_FinishPasskeyRegistration_FullMethodName: interceptor.Public,
+	pb.Auth_BeginPasskeyLogin_FullMethodName:         interceptor.Public,
+	pb.Auth_FinishPasskeyLogin_FullMethodName:        interceptor.Public,
+	pb.Auth_RequestLoginCode_FullMethodName:          interceptor.Public,
+	pb.Auth_LoginWithCode_FullMethodName:             interceptor.Public,
+	pb.Auth_LoginWithLink_FullMethodName:             interceptor.Public,
+
+	// Organization roles are checked by the service
+	pb.Organizations_CreateOrganization_FullMethodName: interceptor.Public,
+	pb.Organizations_InviteMember_FullMethodName:       interceptor.Public,
+	pb.Organizations_AcceptInvitation_FullMethodName:   interceptor.Public,
+	pb.Organizations_RemoveMember_FullMethodName:       interceptor.Public,
+	pb.Organizations_SwitchOrganization_FullMethodName: interceptor.Public,
+
+	pb.Admin_ListUsers_FullMethodName:          domain.PermUsersRead,
+	pb.Admin_GetUser_FullMethodName:            domain.PermUsersRead,
+	pb.Admin_ForceConfirmEmail_FullMethodName:  domain.PermUsersWrite,
+	pb.Admin_DisableUser_FullMethodName:        domain.PermUsersWrite,
+	pb.Admin_EnableUser_FullMethodName:         domain.PermUsersWrite,
+	pb.Admin_ForceLogout_FullMethodName:        domain.PermUsersWrite,
+	pb.Admin_ResendVerification_FullMethodName: domain.PermUsersWrite,
+	pb.Admin_ListRoles_FullMethodName:          domain.PermRolesManage,
+	pb.Admin_AssignRole_FullMethodName:         domain.PermRolesManage,
+	pb.Admin_RevokeRole_FullMethodName:         domain.PermRolesManage,
+}
@@ -7,21 +7,35 @@ import (
 
 	"github.com/Olegnemlii/test123/internal/config"
 	"github.com/Olegnemlii/test123/internal/transport/grpc/handler"
+	"github.com/Olegnemlii/test123/internal/transport/grpc/interceptor"
 	"github.com/Olegnemlii/test123/pkg/pb"
+	"github.com/Olegnemlii/test123/pkg/token"
 
 	"google.golang.org/grpc"
 )
 
+// NewServer builds the gRPC server with the interceptors and services
+// registered. verifier checks the access tokens of RPCs that need a permission.
+func NewServer(verifier *token.Verifier, authHandler *handler.AuthHandler, orgHandler *handler.OrganizationHandler, adminHandler *handler.AdminHandler) *grpc.Server {
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		interceptor.Errors(),
+		interceptor.Authorize(verifier, permissions),
+	))
+	pb.RegisterAuthServer(s, authHandler)
+	pb.RegisterOrganizationsServer(s, orgHandler)
+	pb.RegisterAdminServer(s, adminHandler)
+	return s
+}
+
 // StartGRPCServer starts the gRPC server
-func StartGRPCServer(cfg *config.Config, authHandler *handler.AuthHandler) error {
+func StartGRPCServer(cfg *config.Config, verifier *token.Verifier, authHandler *handler.AuthHandler, orgHandler *handler.OrganizationHandler, adminHandler *handler.AdminHandler) error {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.Port))
 	if err != nil {
 		log.Printf("failed to listen: %v", err)
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	s := grpc.NewServer()
-	pb.RegisterAuthServer(s, authHandler)
+	s := NewServer(verifier, authHandler, orgHandler, adminHandler)
 
 	log.Printf("gRPC server listening on: %s", lis.Addr().String())
 	if err := s.Serve(lis); err != nil {
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/Olegnemlii/test123/pkg/pb"
+
+	"google.golang.org/grpc"
+)
+
+// Every RPC must declare its permission, or the interceptor rejects it
+func TestPermissionsCoverAllMethods(t *testing.T) {
+	declared := make(map[string]bool)
+	for _, desc := range []grpc.ServiceDesc{pb.Auth_ServiceDesc, pb.Organizations_ServiceDesc, pb.Admin_ServiceDesc} {
+		for _, m := range desc.Methods {
+			method := "/" + desc.ServiceName + "/" + m.MethodName
+			declared[method] = true
+			if _, ok := permissions[method]; !ok {
+				t.Errorf("%s has no entry in the permission table", method)
+			}
+		}
+	}
+	for method := range permissions {
+		if !declared[method] {
+			t.Errorf("permission table lists unknown method %s", method)
+		}
+	}
+}
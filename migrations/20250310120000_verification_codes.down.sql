@@ -0,0 +1,9 @@
+ALTER TABLE codes_signatures DROP CONSTRAINT codes_signatures_pkey;
+
+DELETE FROM codes_signatures WHERE code !~ '^[0-9a-fA-F-]{36}$';
+
+ALTER TABLE codes_signatures ALTER COLUMN code TYPE UUID USING code::uuid;
+
+ALTER TABLE codes_signatures ALTER COLUMN code SET DEFAULT uuid_generate_v4();
+
+ALTER TABLE codes_signatures ADD PRIMARY KEY (code);
@@ -0,0 +1,7 @@
+ALTER TABLE codes_signatures DROP CONSTRAINT codes_signatures_pkey;
+
+ALTER TABLE codes_signatures ALTER COLUMN code DROP DEFAULT;
+
+ALTER TABLE codes_signatures ALTER COLUMN code TYPE VARCHAR(16) USING code::text;
+
+ALTER TABLE codes_signatures ADD PRIMARY KEY (signature);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tokens_access_token_idx;
+
+DROP TABLE revoked_access_tokens;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS revoked_access_tokens (
+    token_id VARCHAR PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS tokens_access_token_idx ON tokens (access_token);
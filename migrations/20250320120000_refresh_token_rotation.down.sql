@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS tokens_family_id_idx;
+
+DROP INDEX IF EXISTS tokens_refresh_token_hash_idx;
+
+DELETE FROM tokens;
+
+ALTER TABLE tokens
+    DROP COLUMN created_at,
+    DROP COLUMN revoked_at,
+    DROP COLUMN consumed_at,
+    DROP COLUMN expires_at,
+    DROP COLUMN family_id;
+
+ALTER TABLE tokens RENAME COLUMN refresh_token_hash TO refresh_token;
@@ -0,0 +1,15 @@
+-- Refresh tokens were stored in plaintext, existing sessions have to log in again
+DELETE FROM tokens;
+
+ALTER TABLE tokens RENAME COLUMN refresh_token TO refresh_token_hash;
+
+ALTER TABLE tokens
+    ADD COLUMN family_id UUID NOT NULL,
+    ADD COLUMN expires_at TIMESTAMPTZ NOT NULL,
+    ADD COLUMN consumed_at TIMESTAMPTZ,
+    ADD COLUMN revoked_at TIMESTAMPTZ,
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE UNIQUE INDEX IF NOT EXISTS tokens_refresh_token_hash_idx ON tokens (refresh_token_hash);
+
+CREATE INDEX IF NOT EXISTS tokens_family_id_idx ON tokens (family_id);
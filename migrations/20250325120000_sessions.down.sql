@@ -0,0 +1,3 @@
+ALTER TABLE tokens DROP CONSTRAINT tokens_family_id_fkey;
+
+DROP TABLE sessions;
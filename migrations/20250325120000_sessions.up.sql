@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id),
+    user_agent VARCHAR NOT NULL DEFAULT '',
+    client_ip VARCHAR NOT NULL DEFAULT '',
+    device_name VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+-- Every existing token family becomes a session
+INSERT INTO sessions (id, user_id, created_at, last_used_at, revoked_at)
+SELECT family_id, user_id, MIN(created_at), MAX(created_at), MAX(revoked_at)
+FROM tokens
+GROUP BY family_id, user_id;
+
+ALTER TABLE tokens
+    ADD CONSTRAINT tokens_family_id_fkey FOREIGN KEY (family_id) REFERENCES sessions(id);
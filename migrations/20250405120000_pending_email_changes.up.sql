@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS pending_email_changes (
+    user_id UUID PRIMARY KEY REFERENCES users(id),
+    new_email VARCHAR(255) NOT NULL,
+    code VARCHAR(16) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
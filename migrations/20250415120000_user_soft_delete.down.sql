@@ -0,0 +1,25 @@
+DROP INDEX IF EXISTS users_deleted_at_idx;
+
+ALTER TABLE pending_email_changes
+    DROP CONSTRAINT pending_email_changes_user_id_fkey,
+    ADD CONSTRAINT pending_email_changes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE password_reset_tokens
+    DROP CONSTRAINT password_reset_tokens_user_id_fkey,
+    ADD CONSTRAINT password_reset_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE sessions
+    DROP CONSTRAINT sessions_user_id_fkey,
+    ADD CONSTRAINT sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_family_id_fkey,
+    ADD CONSTRAINT tokens_family_id_fkey FOREIGN KEY (family_id) REFERENCES sessions(id);
+
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_user_id_fkey,
+    ADD CONSTRAINT tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE codes_signatures
+    DROP CONSTRAINT codes_signatures_user_id_fkey,
+    ADD CONSTRAINT codes_signatures_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
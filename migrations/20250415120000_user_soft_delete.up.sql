@@ -0,0 +1,26 @@
+-- Purging a soft-deleted user removes everything that belongs to it
+ALTER TABLE codes_signatures
+    DROP CONSTRAINT codes_signatures_user_id_fkey,
+    ADD CONSTRAINT codes_signatures_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_user_id_fkey,
+    ADD CONSTRAINT tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE tokens
+    DROP CONSTRAINT tokens_family_id_fkey,
+    ADD CONSTRAINT tokens_family_id_fkey FOREIGN KEY (family_id) REFERENCES sessions(id) ON DELETE CASCADE;
+
+ALTER TABLE sessions
+    DROP CONSTRAINT sessions_user_id_fkey,
+    ADD CONSTRAINT sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE password_reset_tokens
+    DROP CONSTRAINT password_reset_tokens_user_id_fkey,
+    ADD CONSTRAINT password_reset_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE pending_email_changes
+    DROP CONSTRAINT pending_email_changes_user_id_fkey,
+    ADD CONSTRAINT pending_email_changes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS admin_audit_log;
+
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS roles;
+
+DROP INDEX IF EXISTS users_created_at_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS disabled_at;
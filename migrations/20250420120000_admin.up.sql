@@ -0,0 +1,35 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at, id);
+
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(64) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+-- A user without rows here is a regular user
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role VARCHAR(64) NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS user_roles_role_idx ON user_roles (role);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access to the Admin service')
+ON CONFLICT DO NOTHING;
+
+-- No foreign keys: entries must survive the purge of the users they mention
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id UUID NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    target_id UUID,
+    details TEXT NOT NULL DEFAULT '',
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_target_id_idx ON admin_audit_log (target_id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+
+-- Removes the role from its users as well
+DELETE FROM roles WHERE name = 'support';
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(64) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(64) NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    permission VARCHAR(64) NOT NULL REFERENCES permissions (name) ON DELETE CASCADE,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users.read', 'List and view user accounts'),
+    ('users.write', 'Confirm, disable, enable and sign out user accounts'),
+    ('roles.manage', 'Assign and revoke roles')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles (name, description) VALUES
+    ('support', 'Manages user accounts but not roles')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'users.read'),
+    ('admin', 'users.write'),
+    ('admin', 'roles.manage'),
+    ('support', 'users.read'),
+    ('support', 'users.write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS credentials (
+    id BYTEA PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_handle BYTEA NOT NULL,
+    -- COSE-encoded public key of the authenticator
+    public_key BYTEA NOT NULL,
+    attestation_type VARCHAR NOT NULL,
+    aaguid BYTEA NOT NULL,
+    transports VARCHAR[] NOT NULL DEFAULT '{}',
+    -- Last signature counter seen, to detect cloned authenticators
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS credentials_user_id_idx ON credentials (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    challenge VARCHAR PRIMARY KEY,
+    ceremony VARCHAR NOT NULL,
+    -- Empty for a discoverable login, where the user is not known up front
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE,
+    session_data BYTEA NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
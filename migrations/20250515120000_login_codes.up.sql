@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS login_codes (
+    signature UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash VARCHAR NOT NULL,
+    link_hash VARCHAR NOT NULL UNIQUE,
+    -- Wrong codes entered; the code is invalidated after too many
+    attempts INTEGER NOT NULL DEFAULT 0,
+    is_used BOOLEAN NOT NULL DEFAULT false,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_codes_user_id_idx ON login_codes (user_id);
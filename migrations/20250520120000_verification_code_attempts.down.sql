@@ -0,0 +1 @@
+ALTER TABLE codes_signatures DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,2 @@
+-- Codes entered for the signature; the code is invalidated after too many
+ALTER TABLE codes_signatures ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
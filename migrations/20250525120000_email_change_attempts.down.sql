@@ -0,0 +1,5 @@
+-- Hashed codes cannot be restored, so pending changes have to be requested again
+DELETE FROM pending_email_changes;
+ALTER TABLE pending_email_changes DROP COLUMN IF EXISTS attempts;
+ALTER TABLE pending_email_changes ALTER COLUMN code_hash TYPE VARCHAR(16);
+ALTER TABLE pending_email_changes RENAME COLUMN code_hash TO code;
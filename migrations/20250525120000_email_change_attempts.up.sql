@@ -0,0 +1,7 @@
+-- Codes are kept as SHA-256 hashes; pending codes are hashed in place
+ALTER TABLE pending_email_changes RENAME COLUMN code TO code_hash;
+ALTER TABLE pending_email_changes ALTER COLUMN code_hash TYPE VARCHAR;
+UPDATE pending_email_changes SET code_hash = encode(sha256(convert_to(code_hash, 'UTF8')), 'hex');
+
+-- Codes entered for the change; the change is invalidated after too many
+ALTER TABLE pending_email_changes ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Second-factor codes entered in a row at login and when managing TOTP; the
+-- count starts over after a correct code or a pause
+ALTER TABLE user_totp
+    ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_attempt_at TIMESTAMPTZ;
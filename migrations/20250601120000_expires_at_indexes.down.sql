@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS revoked_access_tokens_expires_at_idx;
+DROP INDEX IF EXISTS login_codes_expires_at_idx;
+DROP INDEX IF EXISTS mfa_challenges_expires_at_idx;
+DROP INDEX IF EXISTS webauthn_challenges_expires_at_idx;
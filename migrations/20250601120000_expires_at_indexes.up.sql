@@ -0,0 +1,6 @@
+-- Expired challenges, login codes and revoked access tokens are purged in
+-- the background by expires_at
+CREATE INDEX IF NOT EXISTS webauthn_challenges_expires_at_idx ON webauthn_challenges (expires_at);
+CREATE INDEX IF NOT EXISTS mfa_challenges_expires_at_idx ON mfa_challenges (expires_at);
+CREATE INDEX IF NOT EXISTS login_codes_expires_at_idx ON login_codes (expires_at);
+CREATE INDEX IF NOT EXISTS revoked_access_tokens_expires_at_idx ON revoked_access_tokens (expires_at);
@@ -0,0 +1,8 @@
+package migrations
+
+import "embed"
+
+// FS holds the SQL migrations so the binary does not depend on the working directory
+//
+//go:embed *.sql
+var FS embed.FS
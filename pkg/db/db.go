@@ -0,0 +1,36 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Database wraps the connection pool to Postgres
+type Database struct {
+	db *sql.DB
+}
+
+// NewDatabase opens a connection pool and checks that the database is reachable
+func NewDatabase(databaseURL string) (*Database, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &Database{db: db}, nil
+}
+
+// GetDB returns the underlying *sql.DB
+func (d *Database) GetDB() *sql.DB {
+	return d.db
+}
+
+// Close closes the connection pool
+func (d *Database) Close() error {
+	return d.db.Close()
+}
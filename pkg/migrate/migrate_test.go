@@ -0,0 +1,365 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"2_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"1_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+		"1_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+		"README.md":         {Data: []byte("not a migration")},
+		"10_tenth.up.sql":   {Data: []byte("CREATE TABLE c ();")},
+		"10_tenth.down.sql": {Data: []byte("DROP TABLE c;")},
+	}
+
+	migrations, err := load(fsys)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	var got []string
+	for _, m := range migrations {
+		got = append(got, fmt.Sprintf("%d_%s", m.Version, m.Name))
+	}
+	if strings.Join(got, " ") != "1_first 2_second 10_tenth" {
+		t.Fatalf("migrations = %v, want them in version order", got)
+	}
+	if migrations[0].Down != "DROP TABLE a;" || migrations[1].Down != "" || migrations[0].Checksum == migrations[2].Checksum {
+		t.Fatalf("migrations = %+v", migrations)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"no direction", fstest.MapFS{"1_first.sql": {}}},
+		{"no version", fstest.MapFS{"first.up.sql": {}}},
+		{"no up file", fstest.MapFS{"1_first.down.sql": {}}},
+		{"duplicate version", fstest.MapFS{"1_first.up.sql": {Data: []byte("SELECT 1")}, "1_other.up.sql": {Data: []byte("SELECT 1")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := load(tt.fsys); err == nil {
+				t.Fatal("load succeeded")
+			}
+		})
+	}
+}
+
+// testMigrations create one table each and can be rolled back
+var testMigrations = fstest.MapFS{
+	"1_first.up.sql":    {Data: []byte("CREATE TABLE first (id INT);")},
+	"1_first.down.sql":  {Data: []byte("DROP TABLE first;")},
+	"2_second.up.sql":   {Data: []byte("CREATE TABLE second (id INT);")},
+	"2_second.down.sql": {Data: []byte("DROP TABLE second;")},
+	"3_third.up.sql":    {Data: []byte("CREATE TABLE third (id INT);")},
+	"3_third.down.sql":  {Data: []byte("DROP TABLE third;")},
+}
+
+func TestUpDown(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	m := newMigrator(t, db, testMigrations)
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	assertApplied(t, m, true, true, true)
+	assertTables(t, db, "first", "second", "third")
+
+	// Applied migrations are not run again
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up twice: %v", err)
+	}
+
+	if err := m.Down(ctx, 0); err != nil {
+		t.Fatalf("Down(0): %v", err)
+	}
+	assertApplied(t, m, true, true, true)
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatalf("Down(1): %v", err)
+	}
+	assertApplied(t, m, true, true, false)
+	assertTables(t, db, "first", "second")
+
+	// Rolling back more migrations than are applied stops at the first one
+	if err := m.Down(ctx, 10); err != nil {
+		t.Fatalf("Down(10): %v", err)
+	}
+	assertApplied(t, m, false, false, false)
+	assertTables(t, db)
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatalf("Down with nothing applied: %v", err)
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up after Down: %v", err)
+	}
+	assertApplied(t, m, true, true, true)
+}
+
+func TestDownSkipsPending(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	if err := newMigrator(t, db, subset(testMigrations, "1_", "3_")).Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// 2 is pending, so the two applied migrations are 3 and 1
+	m := newMigrator(t, db, testMigrations)
+	assertApplied(t, m, true, false, true)
+	if err := m.Down(ctx, 2); err != nil {
+		t.Fatalf("Down(2): %v", err)
+	}
+	assertApplied(t, m, false, false, false)
+	assertTables(t, db)
+}
+
+func TestDownWithoutDownFile(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	fsys := subset(testMigrations, "1_", "2_")
+	fsys["3_third.up.sql"] = testMigrations["3_third.up.sql"]
+	m := newMigrator(t, db, fsys)
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if err := m.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "no down file") {
+		t.Fatalf("Down err = %v, want missing down file", err)
+	}
+	assertApplied(t, m, true, true, true)
+}
+
+func TestDrift(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	if err := newMigrator(t, db, subset(testMigrations, "1_", "2_")).Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// The applied second migration is edited and a third one is added
+	edited := subset(testMigrations, "1_", "2_", "3_")
+	edited["2_second.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE second (id BIGINT);")}
+	m := newMigrator(t, db, edited)
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if statuses[0].Drifted || !statuses[1].Drifted || statuses[2].Drifted {
+		t.Fatalf("Status drift = %v %v %v, want only the second", statuses[0].Drifted, statuses[1].Drifted, statuses[2].Drifted)
+	}
+
+	if err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "2_second was modified") {
+		t.Fatalf("Up err = %v, want drift of 2_second", err)
+	}
+	// Nothing is applied while a migration has drifted
+	assertApplied(t, m, true, true, false)
+	assertTables(t, db, "first", "second")
+}
+
+func TestMissingFile(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	if err := newMigrator(t, db, testMigrations).Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	m := newMigrator(t, db, subset(testMigrations, "1_", "3_"))
+	if _, err := m.Status(ctx); err == nil || !strings.Contains(err.Error(), "have no files: 2") {
+		t.Fatalf("Status err = %v, want migration 2 without files", err)
+	}
+	if err := m.Up(ctx); err == nil {
+		t.Fatal("Up succeeded with an applied migration missing")
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	fsys := subset(testMigrations, "1_", "3_")
+	fsys["2_broken.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE broken (id INT); SELECT * FROM missing;")}
+	m := newMigrator(t, db, fsys)
+
+	if err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "2_broken") {
+		t.Fatalf("Up err = %v, want failure of 2_broken", err)
+	}
+	// The failed migration is rolled back as a whole and later ones do not run
+	assertApplied(t, m, true, false, false)
+	assertTables(t, db, "first")
+}
+
+func TestConcurrentUp(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	// CREATE TABLE fails if a migration is applied twice
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		m := newMigrator(t, db, testMigrations)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.Up(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Up %d: %v", i, err)
+		}
+	}
+	assertApplied(t, newMigrator(t, db, testMigrations), true, true, true)
+}
+
+// testDB connects to DATABASE_URL with a new empty schema first in the
+// search path; the schema is dropped when the test finishes
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "migrate_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(t, dsn, schema))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// withSearchPath adds search_path to a URL or key=value connection string;
+// lib/pq sends it to the server as a run-time parameter
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	t.Helper()
+
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("parse DATABASE_URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func newMigrator(t *testing.T, db *sql.DB, fsys fstest.MapFS) *Migrator {
+	t.Helper()
+
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return m
+}
+
+// subset returns the files of fsys whose names start with one of prefixes
+func subset(fsys fstest.MapFS, prefixes ...string) fstest.MapFS {
+	out := fstest.MapFS{}
+	for name, file := range fsys {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				out[name] = file
+			}
+		}
+	}
+	return out
+}
+
+// assertApplied checks the applied state of every migration of m in order
+func assertApplied(t *testing.T, m *Migrator, want ...bool) {
+	t.Helper()
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	got := make([]bool, len(statuses))
+	for i, st := range statuses {
+		got[i] = st.Applied
+		if st.Applied && st.AppliedAt.IsZero() {
+			t.Errorf("migration %d_%s has no applied_at", st.Version, st.Name)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("applied = %v, want %v", got, want)
+	}
+}
+
+// assertTables checks which of the test tables exist in the test schema
+func assertTables(t *testing.T, db *sql.DB, want ...string) {
+	t.Helper()
+
+	rows, err := db.Query(`
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'
+		ORDER BY table_name
+	`)
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("scan table: %v", err)
+		}
+		got = append(got, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+
+	want = slices.Sorted(slices.Values(want))
+	if !slices.Equal(got, want) {
+		t.Fatalf("tables = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// BreachedList is a set of SHA-1 hashes of passwords known from data breaches
+type BreachedList struct {
+	// hashes is sorted for binary search; 20 bytes per entry keeps a list
+	// of millions of passwords in memory
+	hashes [][sha1.Size]byte
+}
+
+// LoadBreachedList reads a list in the Pwned Passwords format from a file
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadBreachedList(f)
+}
+
+// ReadBreachedList reads one hex SHA-1 hash per line, optionally followed by
+// ":count" as in the Pwned Passwords downloads. Blank lines are skipped and
+// the lines need not be sorted.
+func ReadBreachedList(r io.Reader) (*BreachedList, error) {
+	var list BreachedList
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		hexHash, _, _ := strings.Cut(text, ":")
+
+		hash, err := hex.DecodeString(hexHash)
+		if err != nil || len(hash) != sha1.Size {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash: %q", line, hexHash)
+		}
+		list.hashes = append(list.hashes, [sha1.Size]byte(hash))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(list.hashes, compareHashes)
+	list.hashes = slices.Compact(list.hashes)
+	return &list, nil
+}
+
+// Len returns the number of distinct hashes in the list
+func (l *BreachedList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.hashes)
+}
+
+// Contains reports whether the password is in the list; a nil list is empty
+func (l *BreachedList) Contains(password string) bool {
+	if l == nil {
+		return false
+	}
+
+	hash := sha1.Sum([]byte(password))
+	_, found := slices.BinarySearchFunc(l.hashes, hash, compareHashes)
+	return found
+}
+
+func compareHashes(a, b [sha1.Size]byte) int {
+	return bytes.Compare(a[:], b[:])
+}
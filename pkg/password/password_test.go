@@ -0,0 +1,118 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap keeps the tests fast; the format does not depend on the cost
+var cheap = Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2id(t *testing.T) {
+	hash, err := cheap.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("Hash = %q, want PHC argon2id", hash)
+	}
+	if again, _ := cheap.Hash("secret"); again == hash {
+		t.Fatalf("two hashes of a password are equal, the salt is not random")
+	}
+
+	if ok, err := Verify(hash, "secret"); !ok || err != nil {
+		t.Fatalf("Verify(correct) = %v, %v", ok, err)
+	}
+	if ok, err := Verify(hash, "Secret"); ok || err != nil {
+		t.Fatalf("Verify(wrong) = %v, %v", ok, err)
+	}
+
+	if cheap.NeedsRehash(hash) {
+		t.Fatalf("NeedsRehash with the same parameters")
+	}
+	stronger := cheap
+	stronger.Iterations = 2
+	if !stronger.NeedsRehash(hash) {
+		t.Fatalf("NeedsRehash after the iterations changed = false")
+	}
+	longer := cheap
+	longer.KeyLength = 64
+	if !longer.NeedsRehash(hash) {
+		t.Fatalf("NeedsRehash after the key length changed = false")
+	}
+}
+
+// TestArgon2idReference checks a hash made by the reference implementation:
+// echo -n password | argon2 somesalt -id -t 2 -m 16 -p 4 -l 24 -e
+func TestArgon2idReference(t *testing.T) {
+	const hash = "$argon2id$v=19$m=65536,t=2,p=4$c29tZXNhbHQ$GpZ3sK/oH9p7VIiV56G/64Zo/8GaUw434IimaPqxwCo"
+
+	if ok, err := Verify(hash, "password"); !ok || err != nil {
+		t.Fatalf("Verify(reference) = %v, %v", ok, err)
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	h := Bcrypt{Cost: bcrypt.MinCost}
+	hash, err := h.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	if ok, err := Verify(hash, "secret"); !ok || err != nil {
+		t.Fatalf("Verify(correct) = %v, %v", ok, err)
+	}
+	if ok, err := Verify(hash, "Secret"); ok || err != nil {
+		t.Fatalf("Verify(wrong) = %v, %v", ok, err)
+	}
+
+	if h.NeedsRehash(hash) {
+		t.Fatalf("NeedsRehash with the same cost")
+	}
+	if !(Bcrypt{Cost: bcrypt.MinCost + 1}).NeedsRehash(hash) {
+		t.Fatalf("NeedsRehash after the cost changed = false")
+	}
+}
+
+func TestAlgorithmChange(t *testing.T) {
+	legacy, err := Bcrypt{Cost: bcrypt.MinCost}.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	current, err := cheap.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	if !cheap.NeedsRehash(legacy) {
+		t.Fatalf("argon2id NeedsRehash(bcrypt) = false")
+	}
+	if !(Bcrypt{Cost: bcrypt.MinCost}).NeedsRehash(current) {
+		t.Fatalf("bcrypt NeedsRehash(argon2id) = false")
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	tests := []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+	}
+
+	for _, hash := range tests {
+		if ok, err := Verify(hash, "secret"); ok || err == nil {
+			t.Errorf("Verify(%q) = %v, %v, want an error", hash, ok, err)
+		}
+	}
+	if _, err := Verify("plaintext", "plaintext"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("Verify(plaintext) err = %v, want ErrUnsupportedHash", err)
+	}
+}
@@ -0,0 +1,92 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BcryptMaxLength is the number of bytes bcrypt hashes; the rest of a longer
+// password is ignored, so a policy for bcrypt must not allow more
+const BcryptMaxLength = 72
+
+// Character classes a policy can require
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// minEmailPart is the shortest local part of an email that a password must
+// not contain; shorter ones occur in ordinary words
+const minEmailPart = 4
+
+// Policy decides which new passwords are accepted
+type Policy struct {
+	// MinLength is counted in characters, MaxLength in bytes
+	MinLength int
+	MaxLength int
+	// RequiredClasses lists the character classes a password must contain
+	RequiredClasses []string
+	// RejectEmail rejects passwords that contain the email address or its
+	// local part
+	RejectEmail bool
+	// Breached rejects passwords from known breaches when set
+	Breached *BreachedList
+}
+
+// Check returns a description of every rule the password breaks, or nil
+func (p Policy) Check(password, email string) []string {
+	var violations []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes", p.MaxLength))
+	}
+
+	for _, class := range p.RequiredClasses {
+		if !strings.ContainsFunc(password, classes[class].contains) {
+			violations = append(violations, "must contain "+classes[class].name)
+		}
+	}
+
+	if p.RejectEmail && containsEmail(password, email) {
+		violations = append(violations, "must not contain the email address")
+	}
+
+	if p.Breached.Contains(password) {
+		violations = append(violations, "appears in a list of breached passwords")
+	}
+
+	return violations
+}
+
+// ValidClass reports whether class can be used in Policy.RequiredClasses
+func ValidClass(class string) bool {
+	_, ok := classes[class]
+	return ok
+}
+
+var classes = map[string]struct {
+	name     string
+	contains func(rune) bool
+}{
+	ClassLower:  {"a lowercase letter", unicode.IsLower},
+	ClassUpper:  {"an uppercase letter", unicode.IsUpper},
+	ClassDigit:  {"a digit", unicode.IsDigit},
+	ClassSymbol: {"a symbol", func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }},
+}
+
+func containsEmail(password, email string) bool {
+	password, email = strings.ToLower(password), strings.ToLower(email)
+	if email == "" {
+		return false
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+	return strings.Contains(password, email) || len(local) >= minEmailPart && strings.Contains(password, local)
+}
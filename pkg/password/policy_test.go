@@ -0,0 +1,112 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	breached, err := ReadBreachedList(strings.NewReader(sha1Hex("password123") + ":2389787\n"))
+	if err != nil {
+		t.Fatalf("ReadBreachedList: %v", err)
+	}
+	policy := Policy{
+		MinLength:       8,
+		MaxLength:       BcryptMaxLength,
+		RequiredClasses: []string{ClassLower, ClassUpper, ClassDigit, ClassSymbol},
+		RejectEmail:     true,
+		Breached:        breached,
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"valid", "Tr0ub4dor&3", nil},
+		{"short", "Aa1!", []string{"must be at least 8 characters"}},
+		{"characters not bytes", "Пароль1!", nil},
+		{"long", "Aa1!" + strings.Repeat("x", BcryptMaxLength), []string{"must be at most 72 bytes"}},
+		{"multibyte long", "Aa1!" + strings.Repeat("я", 35), []string{"must be at most 72 bytes"}},
+		{"classes", "abcdefgh", []string{"must contain an uppercase letter", "must contain a digit", "must contain a symbol"}},
+		{"space is a symbol", "Abc defg1", nil},
+		{"email local part", "Jane.Doe-2024!", []string{"must not contain the email address"}},
+		{"email", "X1!jane.doe@example.com", []string{"must not contain the email address"}},
+		{"breached", "password123", []string{"must contain an uppercase letter", "must contain a symbol", "appears in a list of breached passwords"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Check(tt.password, "jane.doe@example.com"); !slices.Equal(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyShortEmail(t *testing.T) {
+	policy := Policy{RejectEmail: true}
+
+	// A short local part is a common word fragment and is allowed
+	if got := policy.Check("bannister", "ann@example.com"); got != nil {
+		t.Errorf("Check = %q, want no violations", got)
+	}
+	if got := policy.Check("ann@example.com", "ann@example.com"); got == nil {
+		t.Errorf("Check(email) = nil, want a violation")
+	}
+	if got := (Policy{}).Check("ann@example.com", "ann@example.com"); got != nil {
+		t.Errorf("Check without RejectEmail = %q", got)
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := strings.Join([]string{
+		strings.ToUpper(sha1Hex("qwerty")) + ":3946737",
+		"",
+		sha1Hex("letmein"),
+		sha1Hex("qwerty") + ":1",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedList: %v", err)
+	}
+	if list.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", list.Len())
+	}
+	for _, p := range []string{"qwerty", "letmein"} {
+		if !list.Contains(p) {
+			t.Errorf("Contains(%q) = false", p)
+		}
+	}
+	if list.Contains("Qwerty") {
+		t.Errorf("Contains(Qwerty) = true")
+	}
+
+	var empty *BreachedList
+	if empty.Contains("qwerty") || empty.Len() != 0 {
+		t.Errorf("nil list is not empty")
+	}
+}
+
+func TestBreachedListMalformed(t *testing.T) {
+	for _, line := range []string{"qwerty", sha1Hex("qwerty")[:39], sha1Hex("qwerty") + "00", strings.Repeat("zz", sha1.Size)} {
+		if _, err := ReadBreachedList(strings.NewReader(line)); err == nil {
+			t.Errorf("ReadBreachedList(%q) succeeded", line)
+		}
+	}
+}
+
+func sha1Hex(password string) string {
+	hash := sha1.Sum([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
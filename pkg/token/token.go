@@ -0,0 +1,114 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken is returned when a token fails signature or claims validation
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the claims carried by an access token issued by the auth service
+type Claims struct {
+	Email     string   `json:"email"`
+	SessionID string   `json:"sid"`
+	Roles     []string `json:"roles,omitempty"`
+	// Scope lists the permissions of the roles, separated by spaces
+	Scope string `json:"scope,omitempty"`
+	// OrgID and OrgRole describe the organization the session acts in, if any
+	OrgID   string `json:"org,omitempty"`
+	OrgRole string `json:"org_role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// HasScope reports whether the token was issued with the permission
+func (c *Claims) HasScope(permission string) bool {
+	return slices.Contains(strings.Fields(c.Scope), permission)
+}
+
+// Verifier validates access tokens offline using the issuer's public key
+type Verifier struct {
+	key      ed25519.PublicKey
+	issuer   string
+	audience string
+}
+
+// NewVerifier creates a new Verifier
+func NewVerifier(key ed25519.PublicKey, issuer, audience string) *Verifier {
+	return &Verifier{key: key, issuer: issuer, audience: audience}
+}
+
+// Verify checks the signature, issuer, audience and lifetime of the token
+func (v *Verifier) Verify(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, v.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return claims, nil
+}
+
+// VerifyIgnoringExpiry checks the token like Verify, but accepts tokens that
+// have already expired. It is meant for flows such as token refresh, where
+// the caller presents the access token it is about to replace.
+func (v *Verifier) VerifyIgnoringExpiry(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, v.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithoutClaimsValidation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: token has no iat claim", ErrInvalidToken)
+	}
+
+	// Validate the remaining claims as of the moment the token was issued
+	validator := jwt.NewValidator(
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(func() time.Time { return claims.IssuedAt.Time }),
+	)
+	if err := validator.Validate(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) keyFunc(*jwt.Token) (interface{}, error) {
+	return v.key, nil
+}
+
+// ParsePrivateKey parses a PEM-encoded (PKCS #8) Ed25519 private key
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return key.(ed25519.PrivateKey), nil
+}
+
+// ParsePublicKey parses a PEM-encoded (PKIX) Ed25519 public key
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	key, err := jwt.ParseEdPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return key.(ed25519.PublicKey), nil
+}
@@ -0,0 +1,173 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	issuer   = "auth"
+	audience = "api"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return public, private
+}
+
+// claims returns valid claims of a token issued at issuedAt for ttl
+func claims(issuedAt time.Time, ttl time.Duration) *Claims {
+	return &Claims{
+		Email:     "user@example.com",
+		SessionID: "session",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user",
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(ttl)),
+		},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, c *Claims) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(method, c).SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestVerify(t *testing.T) {
+	public, private := newKey(t)
+	otherPublic, otherPrivate := newKey(t)
+	v := NewVerifier(public, issuer, audience)
+	now := time.Now()
+
+	valid := sign(t, jwt.SigningMethodEdDSA, private, claims(now, time.Minute))
+	got, err := v.Verify(valid)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got.Email != "user@example.com" || got.SessionID != "session" || got.Subject != "user" {
+		t.Fatalf("Verify claims = %+v", got)
+	}
+
+	wrongIssuer := claims(now, time.Minute)
+	wrongIssuer.Issuer = "other"
+	wrongAudience := claims(now, time.Minute)
+	wrongAudience.Audience = jwt.ClaimStrings{"other"}
+	noExpiry := claims(now, time.Minute)
+	noExpiry.ExpiresAt = nil
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong key", sign(t, jwt.SigningMethodEdDSA, otherPrivate, claims(now, time.Minute))},
+		{"alg none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(now, time.Minute))},
+		{"HS256 keyed with the public key", sign(t, jwt.SigningMethodHS256, []byte(public), claims(now, time.Minute))},
+		{"HS256 keyed with another public key", sign(t, jwt.SigningMethodHS256, []byte(otherPublic), claims(now, time.Minute))},
+		{"expired", sign(t, jwt.SigningMethodEdDSA, private, claims(now.Add(-time.Hour), time.Minute))},
+		{"issued in the future", sign(t, jwt.SigningMethodEdDSA, private, claims(now.Add(time.Hour), time.Hour))},
+		{"no expiry", sign(t, jwt.SigningMethodEdDSA, private, noExpiry)},
+		{"wrong issuer", sign(t, jwt.SigningMethodEdDSA, private, wrongIssuer)},
+		{"wrong audience", sign(t, jwt.SigningMethodEdDSA, private, wrongAudience)},
+		{"tampered payload", tamper(t, valid)},
+		{"tampered signature", valid[:len(valid)-4] + "AAAA"},
+		{"malformed", "not.a.jwt"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Verify(tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Verify err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyIgnoringExpiry(t *testing.T) {
+	public, private := newKey(t)
+	_, otherPrivate := newKey(t)
+	v := NewVerifier(public, issuer, audience)
+	now := time.Now()
+
+	expired := sign(t, jwt.SigningMethodEdDSA, private, claims(now.Add(-time.Hour), time.Minute))
+	if _, err := v.Verify(expired); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify(expired) err = %v, want ErrInvalidToken", err)
+	}
+	got, err := v.VerifyIgnoringExpiry(expired)
+	if err != nil {
+		t.Fatalf("VerifyIgnoringExpiry(expired): %v", err)
+	}
+	if got.SessionID != "session" {
+		t.Fatalf("VerifyIgnoringExpiry claims = %+v", got)
+	}
+
+	noIssuedAt := claims(now, time.Minute)
+	noIssuedAt.IssuedAt = nil
+	wrongAudience := claims(now.Add(-time.Hour), time.Minute)
+	wrongAudience.Audience = jwt.ClaimStrings{"other"}
+	// Expiring before it was issued is never valid
+	backwards := claims(now, -time.Minute)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong key", sign(t, jwt.SigningMethodEdDSA, otherPrivate, claims(now.Add(-time.Hour), time.Minute))},
+		{"alg none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(now.Add(-time.Hour), time.Minute))},
+		{"HS256 keyed with the public key", sign(t, jwt.SigningMethodHS256, []byte(public), claims(now.Add(-time.Hour), time.Minute))},
+		{"no iat", sign(t, jwt.SigningMethodEdDSA, private, noIssuedAt)},
+		{"wrong audience", sign(t, jwt.SigningMethodEdDSA, private, wrongAudience)},
+		{"expires before issued", sign(t, jwt.SigningMethodEdDSA, private, backwards)},
+		{"tampered payload", tamper(t, expired)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.VerifyIgnoringExpiry(tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("VerifyIgnoringExpiry err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+// tamper replaces the email in the payload and keeps the original signature
+func tamper(t *testing.T, signed string) string {
+	t.Helper()
+
+	parts := strings.Split(signed, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		t.Fatalf("unmarshal payload: %v", err)
+	}
+	fields["email"] = "admin@example.com"
+	payload, err = json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// secret is the SHA1 key of the RFC 6238 test vectors
+var secret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(secret, Step(time.Unix(tt.unix, 0))); got != tt.code {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+
+	if got, ok := Validate(secret, Code(secret, step-1), now, 1); !ok || got != step-1 {
+		t.Fatalf("Validate(previous step) = %d, %v, want %d", got, ok, step-1)
+	}
+	if _, ok := Validate(secret, Code(secret, step+2), now, 1); ok {
+		t.Fatal("Validate accepted a code outside the skew")
+	}
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Fatal("Validate accepted a short code")
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Acme", "user@example.com", secret))
+	if err != nil {
+		t.Fatalf("parse URI: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Acme:user@example.com" {
+		t.Fatalf("URI = %s", u)
+	}
+	if got := u.Query().Get("secret"); got != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Fatalf("secret = %s", got)
+	}
+}